@@ -1,22 +1,33 @@
 package main
 
 import (
-	"log"
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
 
 	"github.com/foryouandyourcustomers/azapim/internal/cli"
-	ucli "github.com/urfave/cli/v2"
 )
 
 func main() {
-	app := &ucli.App{
-		Name:     "azapim",
-		Usage:    "Helper functions for Azure API management service",
-		Flags:    cli.GlobalFlags,
-		Before:   cli.BeforeFunction,
-		Commands: cli.Collection,
-	}
-	err := app.Run(os.Args)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the first signal cancels the running command, which reports the step in progress.
+	// a second signal terminates immediately
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-signals
+		log.Warnf("Received %s, canceling the command. send again to terminate immediately", s)
+		cancel()
+		<-signals
+		os.Exit(130)
+	}()
+
+	err := cli.NewApp().RunContext(ctx, os.Args)
 	if err != nil {
 		log.Fatal(err)
 	}
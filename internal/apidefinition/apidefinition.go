@@ -1,14 +1,38 @@
 package apidefinition
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"strconv"
 	"strings"
 
-	"github.com/Azure/azure-sdk-for-go/profiles/latest/apimanagement/mgmt/apimanagement"
-	"github.com/prometheus/common/log"
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
 )
 
+// DefaultXMLPolicy is the policy of apis without an xml policy, it only inherits the policies of the service
+const DefaultXMLPolicy = `<policies>
+<inbound>
+<base />
+</inbound>
+<backend>
+<base />
+</backend>
+<outbound>
+<base />
+</outbound>
+<on-error>
+<base />
+</on-error>
+</policies>`
+
+// GraphQLSchema is the format of graphql schemas. they can't be imported, the schema is uploaded after the api is created
+const GraphQLSchema apimanagement.ContentFormat = "graphql"
+
 // Definition allows to set all required values for regsitering and updating an API
 // in the api management service
 type Definition struct {
@@ -31,31 +55,114 @@ type Definition struct {
 	APIProductsRaw      string
 	APIProducts         []string
 
-	APIProtocols         []apimanagement.Protocol
-	SubscriptionRequired bool
+	APIProtocols []apimanagement.Protocol
+	// SubscriptionRequired requires a subscription key for calls of the api, true if nil
+	SubscriptionRequired *bool
+	// Metadata are the optional properties of the api
+	Metadata Metadata
+
+	// Transactional restores the prior state of the version set, api, policy and product
+	// assignments if a step of the deployment fails
+	Transactional bool
+	// FailOnBreaking compares the spec with the deployed version and refuses breaking changes
+	FailOnBreaking bool
+
+	// Fetch defines how specs and policies at http(s) urls are imported, FetchLink if empty
+	Fetch FetchMode
+	// FetchFallback imports the link if the download fails in FetchLocal mode
+	FetchFallback bool
+	// Downloader downloads specs and policies in FetchLocal mode, without headers if nil
+	Downloader *Downloader
+
+	// Transform modifies the loaded openapi spec before the import
+	Transform Transform
 }
 
-// SetDefaults depending on the given values
+// SetDefaults depending on the given values. calling it multiple times is safe
 func (api *Definition) SetDefaults() {
-	api.APIProtocols = append(api.APIProtocols, apimanagement.ProtocolHTTPS)
+	if api.Metadata.APIType == "" {
+		api.Metadata.APIType = apimanagement.APITypeHTTP
+	}
+	// apis are exposed with the encrypted protocol of their type by default, https or wss
+	if len(api.APIProtocols) == 0 && len(Protocols[api.Metadata.APIType]) > 0 {
+		api.APIProtocols = append(api.APIProtocols, Protocols[api.Metadata.APIType][0])
+	}
 	api.APIVersioningScheme = apimanagement.VersioningSchemeSegment
-	api.SubscriptionRequired = true
-	api.APIRevision = "1"
+	if api.SubscriptionRequired == nil {
+		required := true
+		api.SubscriptionRequired = &required
+	}
+	// new apis are created as revision 1
+	if api.APIRevision == "" {
+		api.APIRevision = "1"
+	}
 	api.APIUniqueID = fmt.Sprintf("%s-%s", api.APIID, api.APIVersion)
-	if len(api.APIProductsRaw) > 0 {
+	if len(api.APIProductsRaw) > 0 && len(api.APIProducts) == 0 {
 		api.APIProducts = strings.Split(strings.TrimSpace(api.APIProductsRaw), ",")
 	}
 }
 
-// GetOpenAPISpec retrieves the openapi spec file either from file or url. if unable to load spec throws an exception
-func (api *Definition) GetOpenAPISpec() {
+// RevisionID returns the id of the deployed revision. revision 1 is the api itself, other revisions
+// are named {api};rev={revision}
+func (api *Definition) RevisionID() string {
+	if api.APIRevision == "" || api.APIRevision == "1" {
+		return api.APIUniqueID
+	}
+	return fmt.Sprintf("%s;rev=%s", api.APIUniqueID, api.APIRevision)
+}
+
+// ValidateRevision checks that the revision is a positive number
+func (api *Definition) ValidateRevision() error {
+	if api.APIRevision == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(api.APIRevision); err != nil || n < 1 || strconv.Itoa(n) != api.APIRevision {
+		return fmt.Errorf("invalid revision '%s', expected a positive number", api.APIRevision)
+	}
+	return nil
+}
+
+// GetOpenAPISpec retrieves the openapi spec file either from file or url and applies the transform. if unable to load spec
+// returns a SpecNotFoundError. if no path is given but the spec is already set inline it is used as is
+func (api *Definition) GetOpenAPISpec(ctx context.Context) error {
+	if api.Metadata.APIType == apimanagement.APITypeWebsocket {
+		if api.OpenAPISpecPath != "" || api.OpenAPISpec != "" {
+			return errors.New("websocket apis are created without a spec, don't set one")
+		}
+		return nil
+	}
+	if api.OpenAPISpecPath == "" && api.OpenAPISpec == "" {
+		return &SpecNotFoundError{Err: errors.New("no spec given")}
+	}
+	if err := api.loadOpenAPISpec(ctx); err != nil {
+		return err
+	}
+	return api.transformOpenAPISpec(ctx)
+}
+
+func (api *Definition) loadOpenAPISpec(ctx context.Context) error {
+	if api.OpenAPISpecPath == "" && api.OpenAPISpec != "" {
+		if api.OpenAPIFormat == "" {
+			api.OpenAPIFormat = apimanagement.ContentFormatOpenapijson
+			api.importFormat()
+		}
+		return nil
+	}
+
+	if isURL(api.OpenAPISpecPath) && api.Fetch == FetchLocal {
+		err := api.downloadOpenAPISpec(ctx)
+		if err == nil || !api.FetchFallback || ctx.Err() != nil {
+			return err
+		}
+		api.logger(ctx).Warnf("Unable to download the openapi spec, fall back to the link: %s", err)
+	}
 
-	if strings.HasPrefix(api.OpenAPISpecPath, "https://") || strings.HasPrefix(api.OpenAPISpecPath, "http://") {
-		log.Infof("OpenApi Spec will be downloaded by APIM during create/update from '%s'", api.OpenAPISpecPath)
-		api.OpenAPIFormat = apimanagement.OpenapijsonLink
+	if isURL(api.OpenAPISpecPath) {
+		api.logger(ctx).Infof("OpenApi Spec will be downloaded by APIM during create/update from '%s'", api.OpenAPISpecPath)
+		api.OpenAPIFormat = apimanagement.ContentFormatOpenapijsonLink
 		api.OpenAPISpec = api.OpenAPISpecPath
 	} else {
-		log.Infof("Load openapi spec from file: %s", api.OpenAPISpecPath)
+		api.logger(ctx).Infof("Load openapi spec from file: %s", api.OpenAPISpecPath)
 
 		f := api.OpenAPISpecPath
 		if strings.HasPrefix(f, "file://") {
@@ -63,39 +170,62 @@ func (api *Definition) GetOpenAPISpec() {
 		}
 		file, err := ioutil.ReadFile(f)
 		if err != nil {
-			log.Fatal(err)
+			return &SpecNotFoundError{Path: api.OpenAPISpecPath, Err: err}
 		}
 		api.OpenAPISpec = string(file)
-		api.OpenAPIFormat = apimanagement.Openapijson
+		api.OpenAPIFormat = apimanagement.ContentFormatOpenapijson
 	}
+	api.importFormat()
+	return nil
 }
 
-// GetXMLPolicy retrives the xml policy either from file or from url. if not specified loads default, empty xml policy
-func (api *Definition) GetXMLPolicy() {
+// importFormat imports the spec of soap apis as wsdl. graphql apis are imported from the endpoint
+// at the link or get the schema uploaded after they are created
+func (api *Definition) importFormat() {
+	formats := map[apimanagement.APIType][2]apimanagement.ContentFormat{
+		apimanagement.APITypeSoap:    {apimanagement.ContentFormatWsdlLink, apimanagement.ContentFormatWsdl},
+		apimanagement.APITypeGraphql: {apimanagement.ContentFormatGraphqlLink, GraphQLSchema},
+	}
+	f, ok := formats[api.Metadata.APIType]
+	if !ok {
+		return
+	}
+	switch api.OpenAPIFormat {
+	case apimanagement.ContentFormatOpenapijsonLink:
+		api.OpenAPIFormat = f[0]
+	case apimanagement.ContentFormatOpenapijson:
+		api.OpenAPIFormat = f[1]
+	}
+}
+
+// GetXMLPolicy retrives the xml policy either from file or from url. if not specified loads default, empty xml policy.
+// if unable to load the policy returns a PolicyReadError
+func (api *Definition) GetXMLPolicy(ctx context.Context) error {
+	if api.XMLPolicyPath == "" && api.XMLPolicy != "" {
+		if api.XMLPolicyFormat == "" {
+			api.XMLPolicyFormat = apimanagement.PolicyContentFormatXML
+		}
+		return nil
+	}
+	if isURL(api.XMLPolicyPath) && api.Fetch == FetchLocal {
+		err := api.downloadXMLPolicy(ctx)
+		if err == nil || !api.FetchFallback || ctx.Err() != nil {
+			return err
+		}
+		api.logger(ctx).Warnf("Unable to download the xml policy, fall back to the link: %s", err)
+	}
+
 	if api.XMLPolicyPath == "" {
-		log.Info("No xml policy given, load default policy")
-		api.XMLPolicyFormat = apimanagement.XML
+		api.logger(ctx).Info("No xml policy given, load default policy")
+		api.XMLPolicyFormat = apimanagement.PolicyContentFormatXML
 		api.XMLPolicyPath = "none (default policy)"
-		api.XMLPolicy = `<policies>
-<inbound>
-<base />
-</inbound>
-<backend>
-<base />
-</backend>
-<outbound>
-<base />
-</outbound>
-<on-error>
-<base />
-</on-error>
-</policies>`
-	} else if strings.HasPrefix(api.XMLPolicyPath, "https://") || strings.HasPrefix(api.XMLPolicyPath, "http://") {
-		log.Infof("Xml Policy will be downloaded by APIM during create/update from '%s'", api.XMLPolicyPath)
-		api.XMLPolicyFormat = apimanagement.XMLLink
+		api.XMLPolicy = DefaultXMLPolicy
+	} else if isURL(api.XMLPolicyPath) {
+		api.logger(ctx).Infof("Xml Policy will be downloaded by APIM during create/update from '%s'", api.XMLPolicyPath)
+		api.XMLPolicyFormat = apimanagement.PolicyContentFormatXMLLink
 		api.XMLPolicy = api.XMLPolicyPath
 	} else {
-		log.Infof("Load XML policy from file: %s", api.XMLPolicyPath)
+		api.logger(ctx).Infof("Load XML policy from file: %s", api.XMLPolicyPath)
 
 		f := api.XMLPolicyPath
 		if strings.HasPrefix(f, "file://") {
@@ -103,9 +233,76 @@ func (api *Definition) GetXMLPolicy() {
 		}
 		file, err := ioutil.ReadFile(f)
 		if err != nil {
-			log.Fatal(err)
+			return &PolicyReadError{Path: api.XMLPolicyPath, Err: err}
 		}
 		api.XMLPolicy = string(file)
-		api.XMLPolicyFormat = apimanagement.XML
+		api.XMLPolicyFormat = apimanagement.PolicyContentFormatXML
+	}
+	return nil
+}
+
+// downloadOpenAPISpec downloads and validates the spec to import it inline
+func (api *Definition) downloadOpenAPISpec(ctx context.Context) error {
+	api.logger(ctx).Infof("Download openapi spec from '%s'", api.OpenAPISpecPath)
+	b, err := api.Downloader.Download(ctx, api.OpenAPISpecPath)
+	if err != nil {
+		return &SpecNotFoundError{Path: api.OpenAPISpecPath, Err: err}
+	}
+	var format apimanagement.ContentFormat
+	switch api.Metadata.APIType {
+	case apimanagement.APITypeSoap:
+		format, err = apimanagement.ContentFormatWsdl, validateXML(b)
+	case apimanagement.APITypeGraphql:
+		// the service validates the schema when it is uploaded
+		format = GraphQLSchema
+	default:
+		format, err = specFormat(b)
+	}
+	if err != nil {
+		return &SpecNotFoundError{Path: api.OpenAPISpecPath, Err: err}
+	}
+	api.OpenAPISpec = string(b)
+	api.OpenAPIFormat = format
+	return nil
+}
+
+// downloadXMLPolicy downloads and validates the policy to import it inline
+func (api *Definition) downloadXMLPolicy(ctx context.Context) error {
+	api.logger(ctx).Infof("Download xml policy from '%s'", api.XMLPolicyPath)
+	b, err := api.Downloader.Download(ctx, api.XMLPolicyPath)
+	if err != nil {
+		return &PolicyReadError{Path: api.XMLPolicyPath, Err: err}
 	}
+	if err := validateXML(b); err != nil {
+		return &PolicyReadError{Path: api.XMLPolicyPath, Err: err}
+	}
+	api.XMLPolicy = string(b)
+	api.XMLPolicyFormat = apimanagement.PolicyContentFormatXML
+	return nil
+}
+
+// DownloadLinks downloads the spec and policy imported from links and imports them inline instead, e.g. to record
+// the deployed content instead of the link. graphql apis imported from a link keep the link to their endpoint
+func (api *Definition) DownloadLinks(ctx context.Context) error {
+	switch api.OpenAPIFormat {
+	case apimanagement.ContentFormatOpenapijsonLink, apimanagement.ContentFormatWsdlLink:
+		api.OpenAPISpecPath = api.OpenAPISpec
+		if err := api.downloadOpenAPISpec(ctx); err != nil {
+			return err
+		}
+	}
+	if api.XMLPolicyFormat == apimanagement.PolicyContentFormatXMLLink {
+		api.XMLPolicyPath = api.XMLPolicy
+		return api.downloadXMLPolicy(ctx)
+	}
+	return nil
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// logger returns the log entry of the context with the api id and version of the definition
+func (api *Definition) logger(ctx context.Context) *log.Entry {
+	return logging.From(ctx).WithFields(log.Fields{logging.FieldAPIID: api.APIID, logging.FieldVersion: api.APIVersion})
 }
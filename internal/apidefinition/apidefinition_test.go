@@ -0,0 +1,34 @@
+package apidefinition
+
+import "testing"
+
+func TestRevisionID(t *testing.T) {
+	tests := []struct {
+		revision string
+		want     string
+		wantErr  bool
+	}{
+		{revision: "", want: "httpbin-v1"},
+		{revision: "1", want: "httpbin-v1"},
+		{revision: "3", want: "httpbin-v1;rev=3"},
+		{revision: "0", wantErr: true},
+		{revision: "-2", wantErr: true},
+		{revision: "02", wantErr: true},
+		{revision: "two", wantErr: true},
+	}
+	for _, tt := range tests {
+		api := &Definition{APIID: "httpbin", APIVersion: "v1", APIRevision: tt.revision}
+		err := api.ValidateRevision()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRevision(%q) = %v, want error %t", tt.revision, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		api.SetDefaults()
+		if got := api.RevisionID(); got != tt.want {
+			t.Errorf("RevisionID(%q) = %s, want %s", tt.revision, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package apidefinition
+
+import "fmt"
+
+// SpecNotFoundError is returned if the openapi spec can't be loaded from the given path
+type SpecNotFoundError struct {
+	Path string
+	Err  error
+}
+
+func (e *SpecNotFoundError) Error() string {
+	return fmt.Sprintf("unable to load openapi spec from '%s': %v", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *SpecNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// PolicyReadError is returned if the xml policy can't be loaded from the given path
+type PolicyReadError struct {
+	Path string
+	Err  error
+}
+
+func (e *PolicyReadError) Error() string {
+	return fmt.Sprintf("unable to load xml policy from '%s': %v", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *PolicyReadError) Unwrap() error {
+	return e.Err
+}
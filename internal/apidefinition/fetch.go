@@ -0,0 +1,178 @@
+package apidefinition
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	"gopkg.in/yaml.v2"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+)
+
+// FetchMode defines how specs and policies at http(s) urls are imported
+type FetchMode string
+
+// Supported fetch modes
+const (
+	// FetchLink passes the url to the api management service which downloads the file itself
+	FetchLink FetchMode = "link"
+	// FetchLocal downloads the file, validates it and uploads the content inline
+	FetchLocal FetchMode = "local"
+)
+
+// FetchModes contains all supported fetch modes
+var FetchModes = []FetchMode{FetchLink, FetchLocal}
+
+// ParseFetchMode returns the fetch mode with the given name, link if empty
+func ParseFetchMode(s string) (FetchMode, error) {
+	if s == "" {
+		return FetchLink, nil
+	}
+	for _, m := range FetchModes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown fetch mode '%s', supported are %s", s, enum.Join(FetchModes))
+}
+
+// maxDownloadSize limits the size of downloaded specs and policies
+const maxDownloadSize = 32 << 20
+
+// defaultDownloadTimeout limits the time of a download if the downloader doesn't set a client
+const defaultDownloadTimeout = time.Minute
+
+// Downloader downloads specs and policies in local fetch mode
+type Downloader struct {
+	// Client is used for the requests, a client with a timeout of one minute if nil
+	Client *http.Client
+	// Header is added to every request
+	Header http.Header
+	// BearerToken is sent in the authorization header if set
+	BearerToken string
+}
+
+// NewDownloader returns a downloader sending the headers ("Name: value") and the bearer token. the certificates of the
+// pem encoded ca file are trusted in addition to the system pool
+func NewDownloader(headers []string, bearerToken string, caFile string) (*Downloader, error) {
+	d := &Downloader{Header: http.Header{}, BearerToken: bearerToken}
+	for _, h := range headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header '%s', expected 'Name: value'", h)
+		}
+		d.Header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	if caFile == "" {
+		return d, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ca file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no pem encoded certificates in ca file %s", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	d.Client = &http.Client{Transport: transport, Timeout: defaultDownloadTimeout}
+	return d, nil
+}
+
+// Download returns the content of the url
+func (d *Downloader) Download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: defaultDownloadTimeout}
+	if d != nil {
+		for k, v := range d.Header {
+			req.Header[k] = v
+		}
+		if d.BearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+d.BearerToken)
+		}
+		if d.Client != nil {
+			client = d.Client
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxDownloadSize {
+		return nil, fmt.Errorf("download exceeds %d bytes", maxDownloadSize)
+	}
+	return b, nil
+}
+
+// specFormat validates the openapi or swagger document and returns its inline format
+func specFormat(content []byte) (apimanagement.ContentFormat, error) {
+	var doc map[string]interface{}
+	isJSON := json.Unmarshal(content, &doc) == nil
+	if !isJSON {
+		if err := yaml.Unmarshal(content, &doc); err != nil || doc == nil {
+			return "", errors.New("invalid openapi spec, neither json nor yaml")
+		}
+	}
+	switch {
+	case doc["openapi"] != nil && isJSON:
+		return apimanagement.ContentFormatOpenapijson, nil
+	case doc["openapi"] != nil:
+		return apimanagement.ContentFormatOpenapi, nil
+	case doc["swagger"] != nil && isJSON:
+		return apimanagement.ContentFormatSwaggerJSON, nil
+	case doc["swagger"] != nil:
+		return "", errors.New("swagger 2.0 specs are only supported as json")
+	}
+	return "", errors.New("invalid openapi spec, neither the openapi nor the swagger version is set")
+}
+
+// validateXML checks that the policy or wsdl is well-formed xml
+func validateXML(content []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(content))
+	root := false
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid xml: %w", err)
+		}
+		if _, ok := t.(xml.StartElement); ok {
+			root = true
+		}
+	}
+	if !root {
+		return errors.New("invalid xml, no root element")
+	}
+	return nil
+}
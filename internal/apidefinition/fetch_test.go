@@ -0,0 +1,172 @@
+package apidefinition
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+)
+
+// newFileServer returns a tls server serving the files to requests with the bearer token and api key,
+// and the path of its ca file
+func newFileServer(t *testing.T, files map[string]string) (*httptest.Server, string) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		content, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(srv.Close)
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return srv, ca
+}
+
+func TestFetchLocal(t *testing.T) {
+	srv, ca := newFileServer(t, map[string]string{
+		"/openapi.json": `{"openapi": "3.0.1", "info": {"title": "httpbin"}}`,
+		"/openapi.yaml": "openapi: 3.0.1\ninfo:\n  title: httpbin\n",
+		"/swagger.json": `{"swagger": "2.0"}`,
+		"/invalid.json": `{"info": {"title": "httpbin"}}`,
+		"/policy.xml":   `<policies><inbound><base /></inbound></policies>`,
+		"/invalid.xml":  `<policies><inbound>`,
+	})
+	tests := []struct {
+		name         string
+		spec         string
+		policy       string
+		noCA         bool
+		fallback     bool
+		specFormat   apimanagement.ContentFormat
+		policyFormat apimanagement.PolicyContentFormat
+		wantSpecErr  bool
+		wantPolicy   bool
+	}{
+		{name: "json", spec: "/openapi.json", policy: "/policy.xml", specFormat: apimanagement.ContentFormatOpenapijson, policyFormat: apimanagement.PolicyContentFormatXML},
+		{name: "yaml", spec: "/openapi.yaml", policy: "/policy.xml", specFormat: apimanagement.ContentFormatOpenapi, policyFormat: apimanagement.PolicyContentFormatXML},
+		{name: "swagger", spec: "/swagger.json", policy: "/policy.xml", specFormat: apimanagement.ContentFormatSwaggerJSON, policyFormat: apimanagement.PolicyContentFormatXML},
+		{name: "invalid spec", spec: "/invalid.json", policy: "/policy.xml", wantSpecErr: true},
+		{name: "invalid policy", spec: "/openapi.json", policy: "/invalid.xml", specFormat: apimanagement.ContentFormatOpenapijson, wantPolicy: true},
+		{name: "missing spec", spec: "/missing.json", policy: "/policy.xml", wantSpecErr: true},
+		{name: "untrusted ca", spec: "/openapi.json", policy: "/policy.xml", noCA: true, wantSpecErr: true},
+		{name: "fallback", spec: "/missing.json", policy: "/policy.xml", noCA: true, fallback: true, specFormat: apimanagement.ContentFormatOpenapijsonLink, policyFormat: apimanagement.PolicyContentFormatXMLLink},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caFile := ca
+			if tt.noCA {
+				caFile = ""
+			}
+			d, err := NewDownloader([]string{"X-Api-Key: key"}, "token", caFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			api := &Definition{
+				OpenAPISpecPath: srv.URL + tt.spec,
+				XMLPolicyPath:   srv.URL + tt.policy,
+				Fetch:           FetchLocal,
+				FetchFallback:   tt.fallback,
+				Downloader:      d,
+			}
+
+			var specErr *SpecNotFoundError
+			err = api.GetOpenAPISpec(context.Background())
+			if errors.As(err, &specErr) != tt.wantSpecErr {
+				t.Fatalf("err = %v, want spec error %t", err, tt.wantSpecErr)
+			}
+			if err != nil {
+				return
+			}
+			if api.OpenAPIFormat != tt.specFormat {
+				t.Errorf("spec format = %s, want %s", api.OpenAPIFormat, tt.specFormat)
+			}
+
+			var policyErr *PolicyReadError
+			err = api.GetXMLPolicy(context.Background())
+			if errors.As(err, &policyErr) != tt.wantPolicy {
+				t.Fatalf("err = %v, want policy error %t", err, tt.wantPolicy)
+			}
+			if err == nil && api.XMLPolicyFormat != tt.policyFormat {
+				t.Errorf("policy format = %s, want %s", api.XMLPolicyFormat, tt.policyFormat)
+			}
+		})
+	}
+}
+
+func TestDownloadLinks(t *testing.T) {
+	srv, ca := newFileServer(t, map[string]string{
+		"/openapi.json": `{"openapi": "3.0.1", "info": {"title": "httpbin"}}`,
+		"/service.wsdl": `<definitions name="httpbin" />`,
+		"/policy.xml":   `<policies><inbound><base /></inbound></policies>`,
+	})
+	d, err := NewDownloader([]string{"X-Api-Key: key"}, "token", ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		apiType    apimanagement.APIType
+		spec       string
+		specFormat apimanagement.ContentFormat
+		wantSpec   string
+		wantFormat apimanagement.ContentFormat
+		wantErr    bool
+	}{
+		{name: "openapi", spec: srv.URL + "/openapi.json", specFormat: apimanagement.ContentFormatOpenapijsonLink, wantSpec: `{"openapi": "3.0.1", "info": {"title": "httpbin"}}`, wantFormat: apimanagement.ContentFormatOpenapijson},
+		{name: "wsdl", apiType: apimanagement.APITypeSoap, spec: srv.URL + "/service.wsdl", specFormat: apimanagement.ContentFormatWsdlLink, wantSpec: `<definitions name="httpbin" />`, wantFormat: apimanagement.ContentFormatWsdl},
+		{name: "graphql endpoint", apiType: apimanagement.APITypeGraphql, spec: "https://my.backend.service/graphql", specFormat: apimanagement.ContentFormatGraphqlLink, wantSpec: "https://my.backend.service/graphql", wantFormat: apimanagement.ContentFormatGraphqlLink},
+		{name: "inline", spec: `{"openapi": "3.0.1"}`, specFormat: apimanagement.ContentFormatOpenapijson, wantSpec: `{"openapi": "3.0.1"}`, wantFormat: apimanagement.ContentFormatOpenapijson},
+		{name: "missing", spec: srv.URL + "/missing.json", specFormat: apimanagement.ContentFormatOpenapijsonLink, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &Definition{
+				OpenAPISpec:     tt.spec,
+				OpenAPIFormat:   tt.specFormat,
+				XMLPolicy:       srv.URL + "/policy.xml",
+				XMLPolicyFormat: apimanagement.PolicyContentFormatXMLLink,
+				Metadata:        Metadata{APIType: tt.apiType},
+				Downloader:      d,
+			}
+			err := api.DownloadLinks(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if api.OpenAPISpec != tt.wantSpec || api.OpenAPIFormat != tt.wantFormat {
+				t.Errorf("spec = %s %s, want %s %s", api.OpenAPIFormat, api.OpenAPISpec, tt.wantFormat, tt.wantSpec)
+			}
+			if api.XMLPolicy != `<policies><inbound><base /></inbound></policies>` || api.XMLPolicyFormat != apimanagement.PolicyContentFormatXML {
+				t.Errorf("policy = %s %s, want the downloaded policy", api.XMLPolicyFormat, api.XMLPolicy)
+			}
+		})
+	}
+}
+
+func TestNewDownloader(t *testing.T) {
+	if _, err := NewDownloader([]string{"X-Api-Key"}, "", ""); err == nil {
+		t.Error("header without value accepted")
+	}
+	if _, err := NewDownloader(nil, "", filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("missing ca file accepted")
+	}
+}
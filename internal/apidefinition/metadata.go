@@ -0,0 +1,120 @@
+package apidefinition
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+)
+
+// APITypes are the supported api types
+var APITypes = []apimanagement.APIType{
+	apimanagement.APITypeHTTP,
+	apimanagement.APITypeSoap,
+	apimanagement.APITypeWebsocket,
+	apimanagement.APITypeGraphql,
+}
+
+// importTypes are the types of the import which create an api of the given type
+var importTypes = map[apimanagement.APIType]apimanagement.SoapAPIType{
+	apimanagement.APITypeSoap:      apimanagement.SoapAPITypeSoapPassThrough,
+	apimanagement.APITypeWebsocket: apimanagement.SoapAPITypeWebSocket,
+	apimanagement.APITypeGraphql:   apimanagement.SoapAPITypeGraphQL,
+}
+
+// Metadata are the optional properties of the api in the api management service
+type Metadata struct {
+	Description string `json:"description,omitempty"`
+	// APIType is http if empty. soap apis are imported from a wsdl instead of the openapi spec, graphql apis
+	// from the endpoint or a schema and websocket apis have no spec
+	APIType apimanagement.APIType `json:"apiType,omitempty"`
+	// SubscriptionKeyHeader and SubscriptionKeyQuery replace the default names of the subscription key
+	// header (Ocp-Apim-Subscription-Key) and query parameter (subscription-key)
+	SubscriptionKeyHeader string `json:"subscriptionKeyHeader,omitempty"`
+	SubscriptionKeyQuery  string `json:"subscriptionKeyQuery,omitempty"`
+	// IsCurrent makes the revision the current revision of the api, the service decides if nil
+	IsCurrent *bool `json:"isCurrent,omitempty"`
+	// Tags are assigned to the api, missing tags are created
+	Tags []string `json:"tags,omitempty"`
+	// ContactName, ContactEmail and ContactURL are the contact of the api
+	ContactName  string `json:"contactName,omitempty"`
+	ContactEmail string `json:"contactEmail,omitempty"`
+	ContactURL   string `json:"contactUrl,omitempty"`
+	// LicenseName and LicenseURL are the license of the api
+	LicenseName       string `json:"licenseName,omitempty"`
+	LicenseURL        string `json:"licenseUrl,omitempty"`
+	TermsOfServiceURL string `json:"termsOfServiceUrl,omitempty"`
+}
+
+// ParseAPIType returns the api type, http if empty
+func ParseAPIType(s string) (apimanagement.APIType, error) {
+	switch t := apimanagement.APIType(strings.ToLower(strings.TrimSpace(s))); t {
+	case "":
+		return apimanagement.APITypeHTTP, nil
+	case apimanagement.APITypeHTTP, apimanagement.APITypeSoap, apimanagement.APITypeWebsocket, apimanagement.APITypeGraphql:
+		return t, nil
+	}
+	return "", fmt.Errorf("invalid api type '%s', expected one of %s", s, enum.Join(APITypes))
+}
+
+// subscriptionKeyParameterNames returns the names of the subscription key, nil if the defaults are used
+func (m *Metadata) subscriptionKeyParameterNames() *apimanagement.SubscriptionKeyParameterNamesContract {
+	if m.SubscriptionKeyHeader == "" && m.SubscriptionKeyQuery == "" {
+		return nil
+	}
+	names := &apimanagement.SubscriptionKeyParameterNamesContract{}
+	if m.SubscriptionKeyHeader != "" {
+		names.Header = &m.SubscriptionKeyHeader
+	}
+	if m.SubscriptionKeyQuery != "" {
+		names.Query = &m.SubscriptionKeyQuery
+	}
+	return names
+}
+
+// contact returns the contact of the api, nil if none is set
+func (m *Metadata) contact() *apimanagement.APIContactInformation {
+	if m.ContactName == "" && m.ContactEmail == "" && m.ContactURL == "" {
+		return nil
+	}
+	return &apimanagement.APIContactInformation{
+		Name:  optional(m.ContactName),
+		Email: optional(m.ContactEmail),
+		URL:   optional(m.ContactURL),
+	}
+}
+
+// license returns the license of the api, nil if none is set
+func (m *Metadata) license() *apimanagement.APILicenseInformation {
+	if m.LicenseName == "" && m.LicenseURL == "" {
+		return nil
+	}
+	return &apimanagement.APILicenseInformation{
+		Name: optional(m.LicenseName),
+		URL:  optional(m.LicenseURL),
+	}
+}
+
+// Properties sets the metadata in the properties of the api
+func (m *Metadata) Properties(p *apimanagement.APICreateOrUpdateProperties) {
+	if m.Description != "" {
+		p.Description = &m.Description
+	}
+	p.APIType = m.APIType
+	p.SoapAPIType = importTypes[m.APIType]
+	p.SubscriptionKeyParameterNames = m.subscriptionKeyParameterNames()
+	p.IsCurrent = m.IsCurrent
+	p.Contact = m.contact()
+	p.License = m.license()
+	p.TermsOfServiceURL = optional(m.TermsOfServiceURL)
+}
+
+// optional returns nil for an empty string
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,70 @@
+package apidefinition
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+)
+
+func TestParseAPIType(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    apimanagement.APIType
+		wantErr bool
+	}{
+		{in: "", want: apimanagement.APITypeHTTP},
+		{in: "HTTP", want: apimanagement.APITypeHTTP},
+		{in: "soap", want: apimanagement.APITypeSoap},
+		{in: "websocket", want: apimanagement.APITypeWebsocket},
+		{in: "GraphQL", want: apimanagement.APITypeGraphql},
+		{in: "rest", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAPIType(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAPIType(%q) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestImportFormat(t *testing.T) {
+	dir := t.TempDir()
+	wsdl := filepath.Join(dir, "service.wsdl")
+	if err := ioutil.WriteFile(wsdl, []byte(`<definitions />`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	schema := filepath.Join(dir, "schema.graphql")
+	if err := ioutil.WriteFile(schema, []byte(`type Query { pets: [String] }`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		apiType apimanagement.APIType
+		path    string
+		want    apimanagement.ContentFormat
+		wantErr bool
+	}{
+		{name: "wsdl file", apiType: apimanagement.APITypeSoap, path: wsdl, want: apimanagement.ContentFormatWsdl},
+		{name: "wsdl link", apiType: apimanagement.APITypeSoap, path: "https://my.backend.service/service.wsdl", want: apimanagement.ContentFormatWsdlLink},
+		{name: "graphql schema", apiType: apimanagement.APITypeGraphql, path: schema, want: GraphQLSchema},
+		{name: "graphql endpoint", apiType: apimanagement.APITypeGraphql, path: "https://my.backend.service/graphql", want: apimanagement.ContentFormatGraphqlLink},
+		{name: "websocket", apiType: apimanagement.APITypeWebsocket},
+		{name: "websocket with spec", apiType: apimanagement.APITypeWebsocket, path: schema, wantErr: true},
+		{name: "http without spec", apiType: apimanagement.APITypeHTTP, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &Definition{OpenAPISpecPath: tt.path, Metadata: Metadata{APIType: tt.apiType}}
+			err := api.GetOpenAPISpec(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if api.OpenAPIFormat != tt.want {
+				t.Errorf("format = %s, want %s", api.OpenAPIFormat, tt.want)
+			}
+		})
+	}
+}
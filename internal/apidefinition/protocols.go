@@ -0,0 +1,88 @@
+package apidefinition
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+)
+
+// Protocols contains the protocols supported by each api type
+var Protocols = map[apimanagement.APIType][]apimanagement.Protocol{
+	apimanagement.APITypeHTTP:      {apimanagement.ProtocolHTTPS, apimanagement.ProtocolHTTP},
+	apimanagement.APITypeSoap:      {apimanagement.ProtocolHTTPS, apimanagement.ProtocolHTTP},
+	apimanagement.APITypeGraphql:   {apimanagement.ProtocolHTTPS, apimanagement.ProtocolHTTP},
+	apimanagement.APITypeWebsocket: {apimanagement.ProtocolWss, apimanagement.ProtocolWs},
+}
+
+// ParseProtocols returns the comma separated protocols, nil if empty. the defaults of the api type are
+// set by SetDefaults
+func ParseProtocols(s string) ([]apimanagement.Protocol, error) {
+	var protocols []apimanagement.Protocol
+	for _, v := range strings.Split(s, ",") {
+		p := apimanagement.Protocol(strings.ToLower(strings.TrimSpace(v)))
+		switch p {
+		case "":
+			continue
+		case apimanagement.ProtocolHTTPS, apimanagement.ProtocolHTTP, apimanagement.ProtocolWss, apimanagement.ProtocolWs:
+		default:
+			return nil, fmt.Errorf("invalid protocol '%s', expected https, http, ws or wss", v)
+		}
+		protocols = appendProtocol(protocols, p)
+	}
+	return protocols, nil
+}
+
+// ValidateProtocols checks that the api type supports the protocols of the definition and the scheme
+// of the backend, websocket apis are exposed with and call websocket backends
+func (api *Definition) ValidateProtocols() error {
+	t := api.Metadata.APIType
+	if t == "" {
+		t = apimanagement.APITypeHTTP
+	}
+	supported, ok := Protocols[t]
+	if !ok {
+		return fmt.Errorf("unsupported api type %s", t)
+	}
+	for _, p := range api.APIProtocols {
+		if !hasProtocol(supported, p) {
+			return fmt.Errorf("protocol %s isn't supported by %s apis, expected one of %s", p, t, enum.Join(supported))
+		}
+	}
+	if api.APIServiceURL == "" {
+		return nil
+	}
+	u, err := url.Parse(api.APIServiceURL)
+	if err != nil {
+		return fmt.Errorf("invalid service url '%s': %w", api.APIServiceURL, err)
+	}
+	// the protocols of the type are the schemes of its backends
+	if !hasProtocol(supported, apimanagement.Protocol(strings.ToLower(u.Scheme))) {
+		return fmt.Errorf("service url '%s' of the %s api must use one of %s", api.APIServiceURL, t, enum.Join(supported))
+	}
+	return nil
+}
+
+// Unencrypted returns true if the api is exposed over plain http or ws
+func (api *Definition) Unencrypted() bool {
+	return hasProtocol(api.APIProtocols, apimanagement.ProtocolHTTP) || hasProtocol(api.APIProtocols, apimanagement.ProtocolWs)
+}
+
+func hasProtocol(protocols []apimanagement.Protocol, p apimanagement.Protocol) bool {
+	for _, e := range protocols {
+		if e == p {
+			return true
+		}
+	}
+	return false
+}
+
+func appendProtocol(protocols []apimanagement.Protocol, p apimanagement.Protocol) []apimanagement.Protocol {
+	if hasProtocol(protocols, p) {
+		return protocols
+	}
+	return append(protocols, p)
+}
@@ -0,0 +1,58 @@
+package apidefinition
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+)
+
+func TestParseProtocols(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []apimanagement.Protocol
+		wantErr bool
+	}{
+		{in: ""},
+		{in: "https, HTTP,https", want: []apimanagement.Protocol{apimanagement.ProtocolHTTPS, apimanagement.ProtocolHTTP}},
+		{in: "http", want: []apimanagement.Protocol{apimanagement.ProtocolHTTP}},
+		{in: "WSS,ws", want: []apimanagement.Protocol{apimanagement.ProtocolWss, apimanagement.ProtocolWs}},
+		{in: "https,ftp", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseProtocols(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseProtocols(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateProtocols(t *testing.T) {
+	tests := []struct {
+		name       string
+		apiType    apimanagement.APIType
+		protocols  []apimanagement.Protocol
+		serviceURL string
+		wantErr    bool
+	}{
+		{name: "http api", protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTPS, apimanagement.ProtocolHTTP}},
+		{name: "soap api", apiType: apimanagement.APITypeSoap, protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTP}},
+		{name: "websocket protocol of http api", protocols: []apimanagement.Protocol{"wss"}, wantErr: true},
+		{name: "graphql api", apiType: apimanagement.APITypeGraphql, protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTPS}},
+		{name: "websocket api", apiType: apimanagement.APITypeWebsocket, protocols: []apimanagement.Protocol{apimanagement.ProtocolWss, apimanagement.ProtocolWs}},
+		{name: "http protocol of websocket api", apiType: apimanagement.APITypeWebsocket, protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTPS}, wantErr: true},
+		{name: "unknown api type", apiType: "rest", protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTPS}, wantErr: true},
+		{name: "http backend", protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTPS}, serviceURL: "http://my.backend.service/httpbin"},
+		{name: "websocket backend of http api", protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTPS}, serviceURL: "wss://my.backend.service/chat", wantErr: true},
+		{name: "websocket backend", apiType: apimanagement.APITypeWebsocket, protocols: []apimanagement.Protocol{apimanagement.ProtocolWss}, serviceURL: "WSS://my.backend.service/chat"},
+		{name: "http backend of websocket api", apiType: apimanagement.APITypeWebsocket, protocols: []apimanagement.Protocol{apimanagement.ProtocolWss}, serviceURL: "https://my.backend.service/chat", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &Definition{APIProtocols: tt.protocols, APIServiceURL: tt.serviceURL, Metadata: Metadata{APIType: tt.apiType}}
+			if err := api.ValidateProtocols(); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
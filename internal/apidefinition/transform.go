@@ -0,0 +1,254 @@
+package apidefinition
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	"gopkg.in/yaml.v2"
+)
+
+// OperationMethods are the keys of the operations in a path item
+var OperationMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Transform modifies the openapi spec before it is imported
+type Transform struct {
+	// Servers replace the servers of the spec, the first one sets host, base path and schemes of swagger 2.0 specs
+	Servers []string
+	// StripTags removes the operations with one of the tags
+	StripTags []string
+	// StripInternal removes the paths and operations with "x-internal: true"
+	StripInternal bool
+
+	Title        string
+	Description  string
+	ContactName  string
+	ContactEmail string
+	ContactURL   string
+
+	// OperationIDSuffix is appended to every operationId which doesn't end with it
+	OperationIDSuffix string
+}
+
+// IsZero returns true if the transform doesn't change the spec
+func (t *Transform) IsZero() bool {
+	return len(t.Servers) == 0 && len(t.StripTags) == 0 && !t.StripInternal && t.Title == "" && t.Description == "" &&
+		t.ContactName == "" && t.ContactEmail == "" && t.ContactURL == "" && t.OperationIDSuffix == ""
+}
+
+// transformOpenAPISpec applies the transform to the inline spec
+func (api *Definition) transformOpenAPISpec(ctx context.Context) error {
+	if api.Transform.IsZero() {
+		return nil
+	}
+	switch api.OpenAPIFormat {
+	case apimanagement.ContentFormatOpenapi, apimanagement.ContentFormatOpenapijson, apimanagement.ContentFormatSwaggerJSON:
+	default:
+		return fmt.Errorf("unable to transform the openapi spec imported as %s, use --fetch local for specs at urls", api.OpenAPIFormat)
+	}
+
+	parse := ParseSpec
+	if api.OpenAPIFormat == apimanagement.ContentFormatOpenapi {
+		parse = parseYAMLSpec
+	}
+	doc, err := parse([]byte(api.OpenAPISpec))
+	if err != nil {
+		return &SpecNotFoundError{Path: api.OpenAPISpecPath, Err: err}
+	}
+	if err := api.Transform.apply(doc); err != nil {
+		return err
+	}
+
+	var b []byte
+	if api.OpenAPIFormat == apimanagement.ContentFormatOpenapi {
+		b, err = yaml.Marshal(doc)
+	} else {
+		b, err = json.MarshalIndent(doc, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	api.logger(ctx).Info("Transformed openapi spec")
+	api.OpenAPISpec = string(b)
+	return nil
+}
+
+// apply modifies the parsed spec
+func (t *Transform) apply(doc map[string]interface{}) error {
+	if len(t.Servers) > 0 {
+		if err := setServers(doc, t.Servers); err != nil {
+			return err
+		}
+	}
+
+	info := object(doc, "info")
+	setString(info, "title", t.Title)
+	setString(info, "description", t.Description)
+	if t.ContactName != "" || t.ContactEmail != "" || t.ContactURL != "" {
+		contact := object(info, "contact")
+		setString(contact, "name", t.ContactName)
+		setString(contact, "email", t.ContactEmail)
+		setString(contact, "url", t.ContactURL)
+	}
+
+	strip := map[string]bool{}
+	for _, tag := range t.StripTags {
+		strip[tag] = true
+	}
+	if tags, ok := doc["tags"].([]interface{}); ok && len(strip) > 0 {
+		kept := []interface{}{}
+		for _, tag := range tags {
+			if m, ok := tag.(map[string]interface{}); !ok || !strip[fmt.Sprint(m["name"])] {
+				kept = append(kept, tag)
+			}
+		}
+		doc["tags"] = kept
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	for p, v := range paths {
+		item, ok := v.(map[string]interface{})
+		if !ok || strings.HasPrefix(p, "x-") {
+			continue
+		}
+		if t.StripInternal && IsTrue(item["x-internal"]) {
+			delete(paths, p)
+			continue
+		}
+		operations, stripped := 0, 0
+		for _, m := range OperationMethods {
+			op, ok := item[m].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if (t.StripInternal && IsTrue(op["x-internal"])) || hasTag(op, strip) {
+				delete(item, m)
+				stripped++
+				continue
+			}
+			operations++
+			if id, ok := op["operationId"].(string); ok && t.OperationIDSuffix != "" && !strings.HasSuffix(id, t.OperationIDSuffix) {
+				op["operationId"] = id + t.OperationIDSuffix
+			}
+		}
+		// paths without remaining operations are removed
+		if stripped > 0 && operations == 0 {
+			delete(paths, p)
+		}
+	}
+	return nil
+}
+
+// setServers replaces the servers of an openapi spec or the host, base path and schemes of a swagger spec
+func setServers(doc map[string]interface{}, servers []string) error {
+	if doc["swagger"] == nil {
+		list := make([]interface{}, 0, len(servers))
+		for _, s := range servers {
+			list = append(list, map[string]interface{}{"url": s})
+		}
+		doc["servers"] = list
+		return nil
+	}
+
+	u, err := url.Parse(servers[0])
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid server url '%s'", servers[0])
+	}
+	doc["host"] = u.Host
+	doc["basePath"] = "/" + strings.TrimPrefix(u.Path, "/")
+	schemes := []interface{}{}
+	for _, s := range servers {
+		if u, err := url.Parse(s); err == nil && u.Scheme != "" {
+			schemes = appendUnique(schemes, u.Scheme)
+		}
+	}
+	doc["schemes"] = schemes
+	return nil
+}
+
+// ParseSpec returns the json or yaml openapi spec as generic json document
+func ParseSpec(content []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(content))
+	// numbers of examples and defaults are kept as written
+	d.UseNumber()
+	if err := d.Decode(&doc); err == nil {
+		return doc, nil
+	}
+	return parseYAMLSpec(content)
+}
+
+// parseYAMLSpec returns the yaml openapi spec as generic json document
+func parseYAMLSpec(content []byte) (map[string]interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(content, &v); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec, neither json nor yaml: %w", err)
+	}
+	doc, ok := jsonValue(v).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid openapi spec, the document isn't an object")
+	}
+	return doc, nil
+}
+
+// jsonValue converts the maps of a yaml document to maps with string keys
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	}
+	return v
+}
+
+// object returns the object with the key, it is created if it doesn't exist
+func object(m map[string]interface{}, key string) map[string]interface{} {
+	o, ok := m[key].(map[string]interface{})
+	if !ok {
+		o = map[string]interface{}{}
+		m[key] = o
+	}
+	return o
+}
+
+func setString(m map[string]interface{}, key string, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
+
+// IsTrue returns true if the value of a parsed spec is the boolean true
+func IsTrue(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+func hasTag(op map[string]interface{}, tags map[string]bool) bool {
+	list, _ := op["tags"].([]interface{})
+	for _, t := range list {
+		if tags[fmt.Sprint(t)] {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []interface{}, v string) []interface{} {
+	for _, e := range list {
+		if e == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
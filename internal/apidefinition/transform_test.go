@@ -0,0 +1,92 @@
+package apidefinition
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+)
+
+const spec = `{
+  "openapi": "3.0.1",
+  "info": {"title": "generated", "version": "1.0"},
+  "servers": [{"url": "http://localhost:8080"}],
+  "tags": [{"name": "admin"}, {"name": "pets"}],
+  "paths": {
+    "/pets": {
+      "get": {"operationId": "listPets", "tags": ["pets"], "responses": {"200": {"description": "ok", "content": {"application/json": {"example": {"id": 12345678901234567890}}}}}},
+      "delete": {"operationId": "deletePets", "tags": ["admin"]}
+    },
+    "/admin": {"post": {"operationId": "reset", "tags": ["admin"]}},
+    "/debug": {"x-internal": true, "get": {"operationId": "debug"}},
+    "/health": {"get": {"operationId": "health", "x-internal": true}, "head": {"operationId": "ping"}},
+    "/shared": {"$ref": "#/components/pathItems/shared"}
+  }
+}`
+
+const swaggerSpec = "swagger: '2.0'\ninfo:\n  title: generated\nhost: localhost:8080\nbasePath: /\npaths: {}\n"
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      string
+		format    apimanagement.ContentFormat
+		transform Transform
+		path      []string
+		want      interface{}
+	}{
+		{name: "servers", transform: Transform{Servers: []string{"https://api.example.com/pets"}}, path: []string{"servers"},
+			want: []interface{}{map[string]interface{}{"url": "https://api.example.com/pets"}}},
+		{name: "title", transform: Transform{Title: "pets"}, path: []string{"info", "title"}, want: "pets"},
+		{name: "description", transform: Transform{Description: "the pets api"}, path: []string{"info", "description"}, want: "the pets api"},
+		{name: "contact", transform: Transform{ContactName: "team pets", ContactEmail: "pets@example.com"}, path: []string{"info", "contact"},
+			want: map[string]interface{}{"name": "team pets", "email": "pets@example.com"}},
+		{name: "operation id suffix", transform: Transform{OperationIDSuffix: "V1"}, path: []string{"paths", "/pets", "get", "operationId"}, want: "listPetsV1"},
+		{name: "strip tag", transform: Transform{StripTags: []string{"admin"}}, path: []string{"paths", "/pets", "delete"}, want: nil},
+		{name: "strip tag removes empty path", transform: Transform{StripTags: []string{"admin"}}, path: []string{"paths", "/admin"}, want: nil},
+		{name: "strip tag keeps path reference", transform: Transform{StripTags: []string{"admin"}}, path: []string{"paths", "/shared", "$ref"}, want: "#/components/pathItems/shared"},
+		{name: "strip tag definition", transform: Transform{StripTags: []string{"admin"}}, path: []string{"tags"}, want: []interface{}{map[string]interface{}{"name": "pets"}}},
+		{name: "strip internal path", transform: Transform{StripInternal: true}, path: []string{"paths", "/debug"}, want: nil},
+		{name: "strip internal operation", transform: Transform{StripInternal: true}, path: []string{"paths", "/health", "get"}, want: nil},
+		{name: "keeps large numbers", transform: Transform{Title: "pets"}, path: []string{"paths", "/pets", "get", "responses", "200", "content", "application/json", "example", "id"},
+			want: "12345678901234567890"},
+		{name: "yaml", spec: "openapi: 3.0.1\ninfo:\n  title: generated\n", format: apimanagement.ContentFormatOpenapi, transform: Transform{Title: "pets"}, path: []string{"info", "title"}, want: "pets"},
+		{name: "swagger servers", spec: swaggerSpec, transform: Transform{Servers: []string{"https://api.example.com/pets"}}, path: []string{"host"}, want: "api.example.com"},
+		{name: "swagger base path", spec: swaggerSpec, transform: Transform{Servers: []string{"https://api.example.com/pets"}}, path: []string{"basePath"}, want: "/pets"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &Definition{OpenAPISpec: spec, OpenAPIFormat: apimanagement.ContentFormatOpenapijson, Transform: tt.transform}
+			if tt.spec != "" {
+				api.OpenAPISpec = tt.spec
+				api.OpenAPIFormat = tt.format
+			}
+			if err := api.GetOpenAPISpec(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			doc, err := ParseSpec([]byte(api.OpenAPISpec))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got interface{} = doc
+			for _, key := range tt.path {
+				m, _ := got.(map[string]interface{})
+				got = m[key]
+			}
+			if s, ok := got.(interface{ String() string }); ok {
+				got = s.String()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%v = %#v, want %#v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformLink(t *testing.T) {
+	api := &Definition{OpenAPISpecPath: "https://my.backend.service/openapi.json", Transform: Transform{Title: "pets"}}
+	if err := api.GetOpenAPISpec(context.Background()); err == nil {
+		t.Error("transform of a linked spec accepted")
+	}
+}
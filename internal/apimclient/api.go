@@ -1,53 +1,72 @@
 package apimclient
 
 import (
-	"github.com/Azure/azure-sdk-for-go/profiles/latest/apimanagement/mgmt/apimanagement"
-	"github.com/google/uuid"
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
 )
 
-// CreateOrUpdateAPI creates or updates the API definition against the API management service
+// CreateOrUpdateAPI creates or updates the API definition against the API management service if the api
+// wasn't changed since its etag was read
 func (apim *ApimClient) CreateOrUpdateAPI(
+	ctx context.Context,
+	etag *ETag,
 	cf apimanagement.ContentFormat,
 	dn string,
 	va string,
 	pr []apimanagement.Protocol,
 	pa string,
-	sr bool,
+	sr *bool,
 	ve string,
 	c apimanagement.APIVersionSetContract,
 	re string,
 	uid string,
 	su string,
+	m apidefinition.Metadata,
 ) (apimanagement.APIContract, error) {
 	apiProperties := apimanagement.APICreateOrUpdateParameter{
 		APICreateOrUpdateProperties: &apimanagement.APICreateOrUpdateProperties{
-			Format:               cf,
 			DisplayName:          &dn,
-			Value:                &va,
 			Protocols:            &pr,
 			Path:                 &pa,
-			SubscriptionRequired: &sr,
+			SubscriptionRequired: sr,
 			APIVersion:           &ve,
 			APIVersionSetID:      c.ID,
 			APIRevision:          &re,
 			ServiceURL:           &su,
 		},
 	}
-	future, err := apim.APIClient.CreateOrUpdate(
-		apim.Ctx,
-		apim.ResourceGroup,
-		apim.ServiceName,
-		uid,
-		apiProperties,
-		uuid.New().String())
-	if err != nil {
-		return apimanagement.APIContract{}, err
+	// websocket apis have no spec, the schema of graphql apis is uploaded after the api is created
+	if cf != "" && cf != apidefinition.GraphQLSchema {
+		apiProperties.Format = cf
+		apiProperties.Value = &va
 	}
-	err = future.WaitForCompletionRef(apim.Ctx, apim.APIClient.Client)
-	if err != nil {
-		return apimanagement.APIContract{}, err
+	// revisions are copies of the api with the revision number as suffix
+	if base, _, ok := strings.Cut(uid, ";rev="); ok {
+		source := "/apis/" + base
+		apiProperties.SourceAPIID = &source
 	}
-	contract, err := future.Result(apim.APIClient)
+	m.Properties(apiProperties.APICreateOrUpdateProperties)
+	var contract apimanagement.APIContract
+	err := apim.conditionalUpdate(
+		ctx,
+		etag,
+		func(ctx context.Context, ifMatch string) error {
+			return apim.do(ctx, "create or update api", func(ctx context.Context) (err error) {
+				contract, err = apim.APIClient.CreateOrUpdate(
+					ctx,
+					apim.ResourceGroup,
+					apim.ServiceName,
+					uid,
+					apiProperties,
+					ifMatch)
+				return err
+			})
+		},
+	)
 	if err != nil {
 		return apimanagement.APIContract{}, err
 	}
@@ -2,69 +2,312 @@ package apimclient
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/profiles/latest/apimanagement/mgmt/apimanagement"
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
 
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+	"github.com/foryouandyourcustomers/azapim/internal/telemetry"
+)
+
+const (
+	// DefaultAPIPollingTimeout is the maximum time to wait for an api import
+	DefaultAPIPollingTimeout = 15 * time.Minute
+	// DefaultDisasterRecoveryPollingTimeout is the maximum time to wait for a backup or restore
+	DefaultDisasterRecoveryPollingTimeout = 30 * time.Minute
 )
 
 // ApimClient represents the azure api management service clients
 type ApimClient struct {
-	Ctx               context.Context
-	APIClient         apimanagement.APIClient
-	VersionSetClient  apimanagement.APIVersionSetClient
-	PolicyClient      apimanagement.APIPolicyClient
-	ProductsAPIClient apimanagement.ProductAPIClient
-	ProductClient     apimanagement.ProductClient
-	ServiceClient     apimanagement.ServiceClient
+	Authorizer        autorest.Authorizer
+	BaseURI           string
+	APIClient         APIs
+	APIExportClient   APIExports
+	APIRevisionClient APIRevisions
+	VersionSetClient  VersionSets
+	PolicyClient      Policies
+	SchemaClient      Schemas
+	ProductsAPIClient ProductAPIs
+	TagClient         Tags
+	ServiceClient     Services
 	Subscription      string
 	ResourceGroup     string
 	ServiceName       string
+	// Retry is applied to every call against the api management service,
+	// the zero value doesn't retry
+	Retry retry.Policy
+	// APIPollingTimeout and DisasterRecoveryPollingTimeout limit the time to wait for the long running
+	// api import and backup or restore operations, the defaults are used if not set
+	APIPollingTimeout              time.Duration
+	DisasterRecoveryPollingTimeout time.Duration
+	// OnConflict defines how concurrent changes of the version set, api and policy are handled,
+	// updates fail on conflicts if not set
+	OnConflict ConflictStrategy
+}
+
+// do executes the operation with the retry policy of the client and records it as telemetry step.
+// if the context is canceled the returned error names the operation which was in progress
+func (apim *ApimClient) do(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	ctx = logging.WithFields(ctx, apim.fields())
+	ctx = logging.WithStep(ctx, operation)
+	err := telemetry.Step(ctx, operation, func(ctx context.Context) error {
+		attempts := 0
+		err := apim.Retry.Do(ctx, operation, func(ctx context.Context) error {
+			attempts++
+			return fn(ctx)
+		})
+		telemetry.RecordRetries(ctx, operation, attempts-1)
+		return err
+	}, apim.attributes()...)
+	return interrupted(ctx, operation, err)
+}
+
+// find executes the read operation and returns false if the resource doesn't exist. a missing
+// resource isn't a failure of the operation, so it is recorded as successful step
+func (apim *ApimClient) find(ctx context.Context, operation string, fn func(ctx context.Context) error) (bool, error) {
+	found := true
+	err := apim.do(ctx, operation, func(ctx context.Context) error {
+		err := fn(ctx)
+		if retry.StatusCode(err) == http.StatusNotFound {
+			found = false
+			return nil
+		}
+		return err
+	})
+	return found && err == nil, err
+}
+
+// fields returns the log fields identifying the api management service
+func (apim *ApimClient) fields() log.Fields {
+	return log.Fields{logging.FieldSubscription: apim.Subscription, logging.FieldService: apim.ServiceName}
 }
 
-// Authenticate against the definied azure subscription
-func (apim *ApimClient) Authenticate() {
-	apim.APIClient = apimanagement.NewAPIClient(apim.Subscription)
-	apim.VersionSetClient = apimanagement.NewAPIVersionSetClient(apim.Subscription)
-	apim.PolicyClient = apimanagement.NewAPIPolicyClient(apim.Subscription)
-	apim.ProductsAPIClient = apimanagement.NewProductAPIClient(apim.Subscription)
-	apim.ProductClient = apimanagement.NewProductClient(apim.Subscription)
-	apim.ServiceClient = apimanagement.NewServiceClient(apim.Subscription)
+// attributes returns the telemetry attributes identifying the api management service
+func (apim *ApimClient) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("azure.subscription", apim.Subscription),
+		attribute.String("azure.resource_group", apim.ResourceGroup),
+		attribute.String("azure.apim.service", apim.ServiceName),
+	}
+}
+
+// Authenticate initializes the clients for the definied azure subscription with the authorizer.
+// clients which are already set are kept
+func (apim *ApimClient) Authenticate() error {
+	a := apim.Authorizer
+	if a == nil {
+		return errors.New("no authorizer set for the api management client")
+	}
+	baseURI := apim.BaseURI
+	if baseURI == "" {
+		baseURI = apimanagement.DefaultBaseURI
+	}
+
+	if apim.APIClient == nil {
+		c := apimanagement.NewAPIClientWithBaseURI(baseURI, apim.Subscription)
+		c.Authorizer = a
+		retry.Disable(&c.Client)
+		apim.APIClient = apiClient{c, pollingTimeout(apim.APIPollingTimeout, DefaultAPIPollingTimeout)}
+	}
+	if apim.APIExportClient == nil {
+		c := apimanagement.NewAPIExportClientWithBaseURI(baseURI, apim.Subscription)
+		c.Authorizer = a
+		retry.Disable(&c.Client)
+		apim.APIExportClient = apiExportClient{c}
+	}
+	if apim.APIRevisionClient == nil {
+		c := apimanagement.NewAPIRevisionClientWithBaseURI(baseURI, apim.Subscription)
+		c.Authorizer = a
+		retry.Disable(&c.Client)
+		apim.APIRevisionClient = apiRevisionClient{c}
+	}
+	if apim.VersionSetClient == nil {
+		c := apimanagement.NewAPIVersionSetClientWithBaseURI(baseURI, apim.Subscription)
+		c.Authorizer = a
+		retry.Disable(&c.Client)
+		apim.VersionSetClient = c
+	}
+	if apim.PolicyClient == nil {
+		c := apimanagement.NewAPIPolicyClientWithBaseURI(baseURI, apim.Subscription)
+		c.Authorizer = a
+		retry.Disable(&c.Client)
+		apim.PolicyClient = c
+	}
+	if apim.SchemaClient == nil {
+		c := apimanagement.NewAPISchemaClientWithBaseURI(baseURI, apim.Subscription)
+		c.Authorizer = a
+		retry.Disable(&c.Client)
+		apim.SchemaClient = schemaClient{c, pollingTimeout(apim.APIPollingTimeout, DefaultAPIPollingTimeout)}
+	}
+	if apim.ProductsAPIClient == nil {
+		c := apimanagement.NewProductAPIClientWithBaseURI(baseURI, apim.Subscription)
+		c.Authorizer = a
+		retry.Disable(&c.Client)
+		apim.ProductsAPIClient = c
+	}
+	if apim.TagClient == nil {
+		c := apimanagement.NewTagClientWithBaseURI(baseURI, apim.Subscription)
+		c.Authorizer = a
+		retry.Disable(&c.Client)
+		apim.TagClient = c
+	}
+	if apim.ServiceClient == nil {
+		c := apimanagement.NewServiceClientWithBaseURI(baseURI, apim.Subscription)
+		c.Authorizer = a
+		retry.Disable(&c.Client)
+		apim.ServiceClient = serviceClient{c, pollingTimeout(apim.DisasterRecoveryPollingTimeout, DefaultDisasterRecoveryPollingTimeout)}
+	}
+	return nil
+}
+
+func pollingTimeout(d time.Duration, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// CreateOrUpdate - create or update the specified api. the result contains all
+// resources changed until an error occured. for transactional definitions all changes
+// are reverted if a step fails and a RollbackError is returned
+func (apim *ApimClient) CreateOrUpdate(ctx context.Context, a *apidefinition.Definition) (r *DeploymentResult, err error) {
+	ctx = logging.WithFields(ctx, apim.fields())
+	ctx = logging.WithFields(ctx, log.Fields{logging.FieldAPIID: a.APIID, logging.FieldVersion: a.APIVersion})
+	attrs := append(apim.attributes(), attribute.String("azapim.apiid", a.APIID), attribute.String("azapim.apiversion", a.APIVersion))
+	err = telemetry.Step(ctx, "create or update versioned api", func(ctx context.Context) error {
+		r, err = apim.deploy(ctx, a)
+		return err
+	}, attrs...)
+	return r, err
+}
+
+func (apim *ApimClient) deploy(ctx context.Context, a *apidefinition.Definition) (*DeploymentResult, error) {
+	start := time.Now()
+	r := &DeploymentResult{
+		APIName:     a.APIUniqueID,
+		APIVersion:  a.APIVersion,
+		APIRevision: a.APIRevision,
+		APIPath:     a.APIPath,
+		ServiceURL:  a.APIServiceURL,
+		Products:    []string{},
+	}
+	defer func() {
+		r.DurationSeconds = seconds(start)
+	}()
+
+	if err := a.ValidateProtocols(); err != nil {
+		return r, err
+	}
+	if err := a.ValidateRevision(); err != nil {
+		return r, err
+	}
 
-	a, err := auth.NewAuthorizerFromCLI()
+	// the etags are read before the deployed api is compared or snapshotted, so the updates are rejected
+	// if someone else changes the resources after the deployment started
+	etags, err := apim.deploymentETags(ctx, a)
 	if err != nil {
-		// looking at the newauthorizerfromenvrionment funciton it
-		// seems that thing never returns an error whatsoever!
-		log.Debug("Unable to create authorizer from az cli. Lets load the authorizer from the environment and hope for the best!")
-		a, _ = auth.NewAuthorizerFromEnvironment()
+		return r, err
+	}
+
+	if a.FailOnBreaking {
+		if err := apim.refuseBreakingChanges(ctx, a, r); err != nil {
+			return r, err
+		}
+	}
+
+	var tx *transaction
+	if a.Transactional {
+		logging.From(ctx).Info("Taking snapshot of the api for a rollback")
+		if tx, err = apim.begin(ctx, a); err != nil {
+			return r, err
+		}
+	}
 
+	// a resource changed concurrently is checked again before the update is repeated, the snapshot
+	// has to contain the concurrent change as nothing of the deployment was written
+	etags.api.revalidate = func(ctx context.Context) error {
+		if a.FailOnBreaking {
+			if err := apim.refuseBreakingChanges(ctx, a, r); err != nil {
+				return err
+			}
+		}
+		if tx != nil {
+			return apim.snapshotAPI(ctx, tx)
+		}
+		return nil
+	}
+	if tx != nil {
+		etags.versionSet.revalidate = func(ctx context.Context) error { return apim.snapshotVersionSet(ctx, tx) }
+		etags.policy.revalidate = func(ctx context.Context) error { return apim.snapshotPolicy(ctx, tx) }
 	}
-	apim.APIClient.Authorizer = a
-	apim.VersionSetClient.Authorizer = a
-	apim.PolicyClient.Authorizer = a
-	apim.ProductsAPIClient.Authorizer = a
-	apim.ProductClient.Authorizer = a
-	apim.ServiceClient.Authorizer = a
 
-	// increase the polling timeout for the service client to 30 minutes
-	apim.ServiceClient.Client.PollingDuration = 30 * time.Minute
+	err = apim.createOrUpdate(ctx, a, r, tx, etags)
+	if err == nil || tx == nil {
+		return r, err
+	}
+
+	// the rollback isn't canceled with the deployment, it is limited by the polling timeout of an api import instead
+	// the span and log fields of the deployment are kept, so the rollback is part of its trace
+	detached := logging.WithFields(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), logging.FieldsOf(ctx))
+	rollbackCtx, cancel := context.WithTimeout(detached, pollingTimeout(apim.APIPollingTimeout, DefaultAPIPollingTimeout))
+	defer cancel()
+	logging.From(ctx).Warnf("Deployment failed, rolling back: %s", err)
+	rbErr := apim.rollback(rollbackCtx, tx, err)
+	r.RolledBack = rbErr.RolledBack
+	r.RollbackFailures = rbErr.Failed
+	return r, rbErr
+}
+
+// deploymentETags are the etags of the resources of a deployment read when it starts
+type deploymentETags struct {
+	versionSet *ETag
+	api        *ETag
+	policy     *ETag
+}
+
+func (apim *ApimClient) deploymentETags(ctx context.Context, a *apidefinition.Definition) (*deploymentETags, error) {
+	var e deploymentETags
+	var err error
+	if e.versionSet, err = apim.VersionSetETag(ctx, a.APIID); err != nil {
+		return nil, err
+	}
+	if e.api, err = apim.APIETag(ctx, a.RevisionID()); err != nil {
+		return nil, err
+	}
+	if e.policy, err = apim.PolicyETag(ctx, a.RevisionID()); err != nil {
+		return nil, err
+	}
+	return &e, nil
 }
 
-// CreateOrUpdate - create or update the specified api
-func (apim *ApimClient) CreateOrUpdate(a *apidefinition.Definition) error {
-	log.Infof("Creating/Updating API versionset: '%s'", a.APIID)
-	versionSet, err := apim.CreateOrUpdateVersionSet(a.APIDisplayName, a.APIVersioningScheme, a.APIID)
+func (apim *ApimClient) createOrUpdate(ctx context.Context, a *apidefinition.Definition, r *DeploymentResult, tx *transaction, etags *deploymentETags) error {
+	logging.From(ctx).Infof("Creating/Updating API versionset: '%s'", a.APIID)
+	if tx != nil {
+		tx.versionSetChanged = true
+	}
+	versionSet, err := apim.CreateOrUpdateVersionSet(ctx, etags.versionSet, a.APIDisplayName, a.APIVersioningScheme, a.APIID)
 	if err != nil {
 		return err
 	}
-	log.Infof("Created/Updated API versionset: '%s'", *versionSet.ID)
+	r.VersionSetID = *versionSet.ID
+	logging.From(ctx).Infof("Created/Updated API versionset: '%s'", *versionSet.ID)
 
-	log.Infof("Creating/Updating API: '%s' with version '%s' (unique id: %s)", a.APIDisplayName, a.APIVersion, a.APIUniqueID)
+	logging.From(ctx).Infof("Creating/Updating API: '%s' with version '%s' and revision '%s' (unique id: %s)", a.APIDisplayName, a.APIVersion, a.APIRevision, a.RevisionID())
+	if tx != nil {
+		tx.apiChanged = true
+	}
 	api, err := apim.CreateOrUpdateAPI(
+		ctx,
+		etags.api,
 		a.OpenAPIFormat,
 		a.APIDisplayName,
 		a.OpenAPISpec,
@@ -74,28 +317,76 @@ func (apim *ApimClient) CreateOrUpdate(a *apidefinition.Definition) error {
 		a.APIVersion,
 		versionSet,
 		a.APIRevision,
-		a.APIUniqueID,
+		a.RevisionID(),
 		a.APIServiceURL,
+		a.Metadata,
 	)
 	if err != nil {
 		return err
 	}
-	log.Info("Created/Updated API '%s'", *api.ID)
+	r.APIID = *api.ID
+	logging.From(ctx).Infof("Created/Updated API '%s'", *api.ID)
+
+	if a.OpenAPIFormat == apidefinition.GraphQLSchema {
+		logging.From(ctx).Info("Uploading GraphQL schema")
+		if tx != nil {
+			tx.schemaChanged = true
+		}
+		if _, err := apim.CreateOrUpdateGraphQLSchema(ctx, a.OpenAPISpec, a.RevisionID()); err != nil {
+			return err
+		}
+	}
 
-	log.Info("Creating/Updating API Policy")
-	policy, err := apim.CreateOrUpdatePolicy(a.XMLPolicyFormat, a.XMLPolicy, a.APIUniqueID)
+	logging.From(ctx).Info("Creating/Updating API Policy")
+	if tx != nil {
+		tx.policyChanged = true
+	}
+	policy, err := apim.CreateOrUpdatePolicy(ctx, etags.policy, a.XMLPolicyFormat, a.XMLPolicy, a.RevisionID())
 	if err != nil {
 		return err
 	}
-	log.Infof("Created/Updated API versionset: '%s'", *policy.ID)
+	r.PolicyID = *policy.ID
+	logging.From(ctx).Infof("Created/Updated API policy: '%s'", *policy.ID)
 
 	for _, v := range a.APIProducts {
-		log.Infof("Assign API to product '%s'", v)
-		_, err := apim.AssignToProduct(v, a.APIUniqueID)
+		logging.From(ctx).Infof("Assign API to product '%s'", v)
+		if tx != nil {
+			tx.products = append(tx.products, v)
+		}
+		_, err := apim.AssignToProduct(ctx, v, a.APIUniqueID)
 		if err != nil {
 			return err
 		}
-		log.Info("Assigned API to product")
+		r.Products = append(r.Products, v)
+		logging.From(ctx).Info("Assigned API to product")
+	}
+
+	for _, t := range a.Metadata.Tags {
+		logging.From(ctx).Infof("Assign tag '%s' to API", t)
+		if tx != nil {
+			tx.tags = append(tx.tags, t)
+		}
+		if err := apim.AssignTag(ctx, t, a.APIUniqueID); err != nil {
+			return err
+		}
+		r.Tags = append(r.Tags, t)
+	}
+
+	// the gateway url and the network of the service are informational only, a failure doesn't fail the deployment
+	var s apimanagement.ServiceResource
+	err = apim.do(ctx, "get service", func(ctx context.Context) (err error) {
+		s, err = apim.ServiceClient.Get(ctx, apim.ResourceGroup, apim.ServiceName)
+		return err
+	})
+	if err != nil {
+		logging.From(ctx).Warnf("Unable to retrieve the gateway url of the service: %s", err)
+	} else if s.ServiceProperties != nil && s.GatewayURL != nil {
+		r.GatewayURL = gatewayURL(*s.GatewayURL, a.APIPath, a.APIVersion)
+	}
+	if a.Unencrypted() && (s.ServiceProperties == nil || s.VirtualNetworkType != apimanagement.VirtualNetworkTypeInternal) {
+		w := "the api is exposed over unencrypted http or ws and the gateway of the service is reachable from the internet"
+		logging.From(ctx).Warn(w)
+		r.Warnings = append(r.Warnings, w)
 	}
 	return nil
 }
@@ -0,0 +1,806 @@
+package apimclient_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
+	"github.com/foryouandyourcustomers/azapim/internal/fake"
+)
+
+func newDefinition() *apidefinition.Definition {
+	d := &apidefinition.Definition{
+		APIID:          "httpbin",
+		APIDisplayName: "httpbin api",
+		APIPath:        "/httpbin",
+		APIVersion:     "v1",
+		APIServiceURL:  "https://my.backend.service/httpbin",
+		APIProductsRaw: "starter,unlimited",
+		OpenAPISpec:    `{"openapi": "3.0.1"}`,
+	}
+	d.SetDefaults()
+	if err := d.GetOpenAPISpec(context.Background()); err != nil {
+		panic(err)
+	}
+	if err := d.GetXMLPolicy(context.Background()); err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func newClient(s *fake.Service) *apimclient.ApimClient {
+	apim := &apimclient.ApimClient{}
+	s.Install(apim)
+	if err := apim.Authenticate(); err != nil {
+		panic(err)
+	}
+	return apim
+}
+
+func TestCreateOrUpdateSequence(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+
+	r, err := apim.CreateOrUpdate(context.Background(), newDefinition())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		fake.OpGetVersionSetETag,
+		fake.OpGetAPIETag,
+		fake.OpGetPolicyETag,
+		fake.OpCreateOrUpdateVersionSet,
+		fake.OpCreateOrUpdateAPI,
+		fake.OpCreateOrUpdatePolicy,
+		fake.OpAssignToProduct,
+		fake.OpAssignToProduct,
+		fake.OpGetService,
+	}
+	if got := s.Operations(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("operations = %v, want %v", got, want)
+	}
+
+	api, ok := s.APIs["httpbin-v1"]
+	if !ok {
+		t.Fatal("api httpbin-v1 not created")
+	}
+	if *api.APIVersionSetID != *s.VersionSets["httpbin"].ID {
+		t.Errorf("api version set = %s, want %s", *api.APIVersionSetID, *s.VersionSets["httpbin"].ID)
+	}
+	if *api.ServiceURL != "https://my.backend.service/httpbin" {
+		t.Errorf("service url = %s", *api.ServiceURL)
+	}
+	if s.Policies["httpbin-v1"].Format != apimanagement.PolicyContentFormatXML {
+		t.Errorf("policy format = %s, want %s", s.Policies["httpbin-v1"].Format, apimanagement.PolicyContentFormatXML)
+	}
+	for _, p := range []string{"starter", "unlimited"} {
+		if !reflect.DeepEqual(s.ProductAPIs[p], []string{"httpbin-v1"}) {
+			t.Errorf("product %s apis = %v", p, s.ProductAPIs[p])
+		}
+	}
+
+	if r.GatewayURL != "https://apim.azure-api.net/httpbin/v1" {
+		t.Errorf("gateway url = %s", r.GatewayURL)
+	}
+	if r.APIID != s.ResourceID("apis", "httpbin-v1") || r.VersionSetID != *s.VersionSets["httpbin"].ID {
+		t.Errorf("unexpected result ids: %+v", r)
+	}
+	if !reflect.DeepEqual(r.Products, []string{"starter", "unlimited"}) {
+		t.Errorf("result products = %v", r.Products)
+	}
+}
+
+func TestCreateOrUpdateStopsOnFailure(t *testing.T) {
+	failure := errors.New("failure")
+	etags := []string{fake.OpGetVersionSetETag, fake.OpGetAPIETag, fake.OpGetPolicyETag}
+	versionSet := append(etags, fake.OpCreateOrUpdateVersionSet)
+	versionSetAndAPI := append(versionSet, fake.OpCreateOrUpdateAPI)
+	tests := []struct {
+		failing string
+		want    []string
+	}{
+		{
+			failing: fake.OpGetAPIETag,
+			want:    []string{fake.OpGetVersionSetETag, fake.OpGetAPIETag},
+		},
+		{
+			failing: fake.OpCreateOrUpdateVersionSet,
+			want:    versionSet,
+		},
+		{
+			failing: fake.OpCreateOrUpdateAPI,
+			want:    versionSetAndAPI,
+		},
+		{
+			failing: fake.OpCreateOrUpdatePolicy,
+			want:    append(versionSetAndAPI, fake.OpCreateOrUpdatePolicy),
+		},
+		{
+			failing: fake.OpAssignToProduct,
+			want:    append(versionSetAndAPI, fake.OpCreateOrUpdatePolicy, fake.OpAssignToProduct),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.failing, func(t *testing.T) {
+			s := fake.NewService("sub", "rg", "apim")
+			s.Errors[tt.failing] = failure
+			apim := newClient(s)
+
+			_, err := apim.CreateOrUpdate(context.Background(), newDefinition())
+			if !errors.Is(err, failure) {
+				t.Fatalf("error = %v, want %v", err, failure)
+			}
+			if got := s.Operations(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("operations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateConflicts(t *testing.T) {
+	tests := []struct {
+		name           string
+		strategy       apimclient.ConflictStrategy
+		transactional  bool
+		failOnBreaking bool
+		wantConflict   bool
+		wantBreaking   bool
+		// wantETagReads is the number of reads of the etag of the api
+		wantETagReads int
+		// wantSpec is the spec of the api after the deployment
+		wantSpec string
+	}{
+		{name: "fail", strategy: apimclient.ConflictFail, wantConflict: true, wantETagReads: 1, wantSpec: concurrentSpec},
+		{name: "retry", strategy: apimclient.ConflictRetry, wantETagReads: 2, wantSpec: `{"openapi": "3.0.1"}`},
+		{name: "retry transactional", strategy: apimclient.ConflictRetry, transactional: true, wantETagReads: 2, wantSpec: `{"openapi": "3.0.1"}`},
+		{name: "retry revalidates", strategy: apimclient.ConflictRetry, failOnBreaking: true, wantConflict: true, wantBreaking: true, wantETagReads: 2, wantSpec: concurrentSpec},
+		{name: "force", strategy: apimclient.ConflictForce, wantSpec: `{"openapi": "3.0.1"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := fake.NewService("sub", "rg", "apim")
+			apim := newClient(s)
+			apim.OnConflict = tt.strategy
+			if _, err := apim.CreateOrUpdate(context.Background(), newDefinition()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// another deployment changes the api after the deployment started
+			apim.APIClient = &concurrentAPIs{APIs: apim.APIClient}
+			s.Calls = nil
+			d := newDefinition()
+			d.Transactional = tt.transactional
+			d.FailOnBreaking = tt.failOnBreaking
+			_, err := apim.CreateOrUpdate(context.Background(), d)
+
+			var e *apimclient.ConflictError
+			if errors.As(err, &e) != tt.wantConflict {
+				t.Fatalf("error = %v, want conflict %v", err, tt.wantConflict)
+			}
+			if !tt.wantConflict && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var breaking *apimclient.BreakingChangeError
+			if errors.As(err, &breaking) != tt.wantBreaking {
+				t.Errorf("error = %v, want breaking change %v", err, tt.wantBreaking)
+			}
+			if n := count(s.Operations(), fake.OpGetAPIETag); n != tt.wantETagReads {
+				t.Errorf("etag of the api read %d times, want %d: %v", n, tt.wantETagReads, s.Operations())
+			}
+			if tt.transactional && count(s.Operations(), fake.OpGetAPI) != 2 {
+				t.Errorf("snapshot of the api not taken again after the conflict: %v", s.Operations())
+			}
+			if got := *s.APIs["httpbin-v1"].Value; got != tt.wantSpec {
+				t.Errorf("spec = %s, want %s", got, tt.wantSpec)
+			}
+		})
+	}
+}
+
+// concurrentSpec is the spec deployed concurrently by someone else
+const concurrentSpec = `{"openapi": "3.0.1", "paths": {"/get": {"get": {}}}}`
+
+// concurrentAPIs deploys the concurrent spec once before the first update of the api
+type concurrentAPIs struct {
+	apimclient.APIs
+	raced bool
+}
+
+func (c *concurrentAPIs) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, apiid string, parameters apimanagement.APICreateOrUpdateParameter, ifMatch string) (apimanagement.APIContract, error) {
+	if !c.raced {
+		c.raced = true
+		concurrent := *parameters.APICreateOrUpdateProperties
+		spec := concurrentSpec
+		concurrent.Value = &spec
+		concurrentParameters := apimanagement.APICreateOrUpdateParameter{APICreateOrUpdateProperties: &concurrent}
+		if _, err := c.APIs.CreateOrUpdate(ctx, resourceGroupName, serviceName, apiid, concurrentParameters, ""); err != nil {
+			return apimanagement.APIContract{}, err
+		}
+	}
+	return c.APIs.CreateOrUpdate(ctx, resourceGroupName, serviceName, apiid, parameters, ifMatch)
+}
+
+func count(operations []string, op string) int {
+	n := 0
+	for _, o := range operations {
+		if o == op {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCreateOrUpdateTransactionalRollback(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+	d := newDefinition()
+	d.APIProducts = []string{"starter"}
+	if _, err := apim.CreateOrUpdate(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	previous := *s.Policies["httpbin-v1"].Value
+
+	// the second product assignment fails after the policy was changed and the api was assigned to unlimited
+	failure := errors.New("failure")
+	apim.ProductsAPIClient = failingProductAPIs{ProductAPIs: apim.ProductsAPIClient, product: "broken", err: failure}
+	d = newDefinition()
+	d.Transactional = true
+	d.XMLPolicy = "<policies><inbound><base /><rate-limit calls=\"10\" renewal-period=\"60\" /></inbound></policies>"
+	d.APIProducts = []string{"starter", "unlimited", "broken"}
+
+	r, err := apim.CreateOrUpdate(context.Background(), d)
+	var e *apimclient.RollbackError
+	if !errors.As(err, &e) || !errors.Is(err, failure) {
+		t.Fatalf("error = %v, want rollback error for %v", err, failure)
+	}
+	want := []string{
+		"removed api httpbin-v1 from product unlimited",
+		"restored policy of api httpbin-v1",
+		"restored api httpbin-v1",
+		"restored version set httpbin",
+	}
+	if !reflect.DeepEqual(r.RolledBack, want) || len(r.RollbackFailures) > 0 {
+		t.Fatalf("rolled back = %v (failures %v), want %v", r.RolledBack, r.RollbackFailures, want)
+	}
+	if got := *s.Policies["httpbin-v1"].Value; got != previous {
+		t.Errorf("policy = %s, want %s", got, previous)
+	}
+	if !reflect.DeepEqual(s.ProductAPIs["starter"], []string{"httpbin-v1"}) || len(s.ProductAPIs["unlimited"]) > 0 {
+		t.Errorf("product assignments = %v", s.ProductAPIs)
+	}
+}
+
+func TestCreateOrUpdateTransactionalRollbackNewAPI(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	s.Errors[fake.OpCreateOrUpdatePolicy] = errors.New("failure")
+	apim := newClient(s)
+	d := newDefinition()
+	d.Transactional = true
+
+	r, err := apim.CreateOrUpdate(context.Background(), d)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	want := []string{"deleted api httpbin-v1", "deleted version set httpbin"}
+	if !reflect.DeepEqual(r.RolledBack, want) {
+		t.Fatalf("rolled back = %v, want %v", r.RolledBack, want)
+	}
+	if len(s.APIs) > 0 || len(s.VersionSets) > 0 {
+		t.Errorf("api or version set not deleted: %v %v", s.APIs, s.VersionSets)
+	}
+}
+
+// failingProductAPIs fails the assignment of apis to the given product
+type failingProductAPIs struct {
+	apimclient.ProductAPIs
+	product string
+	err     error
+}
+
+func (f failingProductAPIs) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, productID string, apiid string) (apimanagement.APIContract, error) {
+	if productID == f.product {
+		return apimanagement.APIContract{}, f.err
+	}
+	return f.ProductAPIs.CreateOrUpdate(ctx, resourceGroupName, serviceName, productID, apiid)
+}
+
+func TestCreateOrUpdateFailOnBreaking(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+	deployed := newDefinition()
+	deployed.OpenAPISpec = `{"openapi": "3.0.1", "paths": {"/get": {"get": {}}, "/post": {"post": {}}}}`
+	if _, err := apim.CreateOrUpdate(context.Background(), deployed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		spec         string
+		wantBreaking bool
+	}{
+		{name: "compatible", spec: `{"openapi": "3.0.1", "paths": {"/get": {"get": {}}, "/post": {"post": {}}, "/put": {"put": {}}}}`},
+		{name: "operation removed", spec: `{"openapi": "3.0.1", "paths": {"/get": {"get": {}}}}`, wantBreaking: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDefinition()
+			d.OpenAPISpec = tt.spec
+			d.FailOnBreaking = true
+			s.Calls = nil
+			_, err := apim.CreateOrUpdate(context.Background(), d)
+
+			var breakingErr *apimclient.BreakingChangeError
+			if errors.As(err, &breakingErr) != tt.wantBreaking {
+				t.Fatalf("err = %v, want breaking change %t", err, tt.wantBreaking)
+			}
+			if !tt.wantBreaking {
+				return
+			}
+			if breakingErr.SuggestedVersion != "v2" {
+				t.Errorf("suggested version = %s, want v2", breakingErr.SuggestedVersion)
+			}
+			want := []string{fake.OpGetVersionSetETag, fake.OpGetAPIETag, fake.OpGetPolicyETag, fake.OpExportAPI}
+			if got := s.Operations(); !reflect.DeepEqual(got, want) {
+				t.Errorf("operations = %v, want only the etags and the export", got)
+			}
+		})
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+
+	v, err := apim.NextVersion(context.Background(), newDefinition(), "v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.NextVersion != "v1" || v.LatestVersion != "" {
+		t.Errorf("suggestion without deployed versions = %+v", v)
+	}
+
+	for _, version := range []string{"v1", "v2"} {
+		d := newDefinition()
+		d.APIVersion = version
+		d.OpenAPISpec = `{"openapi": "3.0.1", "paths": {"/get": {"get": {}}}}`
+		d.SetDefaults()
+		if _, err := apim.CreateOrUpdate(context.Background(), d); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the next revision follows the highest deployed revision, revision 2 isn't the current one
+	d := newDefinition()
+	d.APIVersion = "v2"
+	d.APIRevision = "2"
+	d.OpenAPISpec = `{"openapi": "3.0.1", "paths": {"/get": {"get": {}}}}`
+	d.SetDefaults()
+	if _, err := apim.CreateOrUpdate(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		spec         string
+		wantVersion  string
+		wantRevision string
+	}{
+		{name: "unchanged", spec: `{"openapi": "3.0.1", "paths": {"/get": {"get": {}}}}`, wantVersion: "v2"},
+		{name: "compatible", spec: `{"openapi": "3.0.1", "paths": {"/get": {"get": {}}, "/post": {"post": {}}}}`, wantVersion: "v2", wantRevision: "3"},
+		{name: "breaking", spec: `{"openapi": "3.0.1", "paths": {}}`, wantVersion: "v3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDefinition()
+			d.OpenAPISpec = tt.spec
+			v, err := apim.NextVersion(context.Background(), d, "v1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v.LatestVersion != "v2" || v.LatestRevision != "2" || v.NextVersion != tt.wantVersion || v.NextRevision != tt.wantRevision {
+				t.Errorf("suggestion = %+v, want %s revision %q", v, tt.wantVersion, tt.wantRevision)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateRevision(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+	if _, err := apim.CreateOrUpdate(context.Background(), newDefinition()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d := newDefinition()
+	d.APIRevision = "2"
+	d.XMLPolicy = "<policies><inbound /></policies>"
+	d.Transactional = true
+	r, err := apim.CreateOrUpdate(context.Background(), d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.APIName != "httpbin-v1" || r.APIRevision != "2" || r.APIID != s.ResourceID("apis", "httpbin-v1;rev=2") {
+		t.Errorf("result = %+v", r)
+	}
+	rev, ok := s.APIs["httpbin-v1;rev=2"]
+	if !ok || *rev.APIRevision != "2" || rev.SourceAPIID == nil || *rev.SourceAPIID != "/apis/httpbin-v1" {
+		t.Fatalf("revision = %+v", rev.APICreateOrUpdateProperties)
+	}
+	if *s.APIs["httpbin-v1"].APIRevision != "1" {
+		t.Errorf("revision 1 changed to %s", *s.APIs["httpbin-v1"].APIRevision)
+	}
+	if *s.Policies["httpbin-v1;rev=2"].Value != d.XMLPolicy || *s.Policies["httpbin-v1"].Value == d.XMLPolicy {
+		t.Errorf("policies = %v, want the policy deployed to the revision", s.Policies)
+	}
+	// products are assigned to the api, they apply to all revisions
+	if !reflect.DeepEqual(r.Products, []string{"starter", "unlimited"}) || !reflect.DeepEqual(s.ProductAPIs["starter"], []string{"httpbin-v1"}) {
+		t.Errorf("products = %v, product apis = %v", r.Products, s.ProductAPIs)
+	}
+
+	d.APIRevision = "0"
+	if _, err := apim.CreateOrUpdate(context.Background(), d); err == nil {
+		t.Error("invalid revision accepted")
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+	if _, err := apim.CreateOrUpdate(context.Background(), newDefinition()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d := &apimclient.Deprecation{
+		APIID:              "httpbin",
+		APIVersion:         "v1",
+		Since:              time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		Sunset:             time.Date(2027, 3, 31, 0, 0, 0, 0, time.UTC),
+		RemoveFromProducts: []string{"starter", "premium"},
+	}
+	// a second deprecation replaces the notice and headers of the first one
+	for i := 0; i < 2; i++ {
+		r, err := apim.Deprecate(context.Background(), d)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if i == 0 && !reflect.DeepEqual(r.RemovedFromProducts, []string{"starter"}) {
+			t.Errorf("removed from products = %v, want starter", r.RemovedFromProducts)
+		}
+	}
+
+	api := s.APIs["httpbin-v1"]
+	if *api.DisplayName != "httpbin api (deprecated)" {
+		t.Errorf("display name = %s", *api.DisplayName)
+	}
+	if *api.Description != "Deprecated: this version will be removed on 2027-03-31." {
+		t.Errorf("description = %s", *api.Description)
+	}
+	policy := *s.Policies["httpbin-v1"].Value
+	for _, want := range []string{`<value>@("@1790812800")</value>`, "<value>Wed, 31 Mar 2027 00:00:00 GMT</value>"} {
+		if strings.Count(policy, want) != 1 {
+			t.Errorf("policy %s doesn't contain %s once", policy, want)
+		}
+	}
+	if !strings.HasSuffix(policy, "</set-header>\n</outbound>\n<on-error>\n<base />\n</on-error>\n</policies>") {
+		t.Errorf("headers not at the end of the outbound policy: %s", policy)
+	}
+	if len(s.ProductAPIs["starter"]) != 0 || len(s.ProductAPIs["unlimited"]) != 1 {
+		t.Errorf("product apis = %v", s.ProductAPIs)
+	}
+}
+
+func TestDeprecatePolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{name: "empty outbound", policy: "<policies><inbound /><outbound /></policies>", want: "<outbound>\n<base />\n<set-header"},
+		{name: "no outbound", policy: "<policies><inbound /></policies>", want: "<inbound /><outbound>\n<base />\n<set-header"},
+		{name: "existing headers", policy: `<policies><outbound><set-header exists-action="override" name="sunset"><value>old</value></set-header><set-header name='Deprecation' /></outbound></policies>`,
+			want: "<outbound><set-header name=\"Deprecation\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := fake.NewService("sub", "rg", "apim")
+			apim := newClient(s)
+			if _, err := apim.CreateOrUpdate(context.Background(), newDefinition()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			s.Policies["httpbin-v1"] = apimanagement.PolicyContract{PolicyContractProperties: &apimanagement.PolicyContractProperties{Value: &tt.policy}}
+
+			d := &apimclient.Deprecation{APIID: "httpbin", APIVersion: "v1", Sunset: time.Now().AddDate(1, 0, 0)}
+			if _, err := apim.Deprecate(context.Background(), d); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			policy := *s.Policies["httpbin-v1"].Value
+			if !strings.Contains(policy, tt.want) || strings.Count(policy, "<set-header") != 2 || strings.Contains(policy, "old") {
+				t.Errorf("policy = %s, want %s", policy, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeprecateErrors(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+	d := &apimclient.Deprecation{APIID: "httpbin", APIVersion: "v1", Sunset: time.Now().AddDate(1, 0, 0)}
+	if _, err := apim.Deprecate(context.Background(), d); err == nil {
+		t.Error("deprecation of a missing version accepted")
+	}
+	d.Sunset = time.Now().AddDate(0, 0, -1)
+	if _, err := apim.Deprecate(context.Background(), d); err == nil {
+		t.Error("sunset in the past accepted")
+	}
+}
+
+func TestCreateOrUpdateMetadata(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	s.Tags["public"] = "Public"
+	apim := newClient(s)
+
+	d := newDefinition()
+	required, current := false, true
+	d.SubscriptionRequired = &required
+	d.Metadata = apidefinition.Metadata{
+		Description:           "the httpbin api",
+		APIType:               apimanagement.APITypeHTTP,
+		SubscriptionKeyHeader: "X-Api-Key",
+		IsCurrent:             &current,
+		Tags:                  []string{"public", "pets"},
+		ContactName:           "api team",
+		ContactEmail:          "api@example.com",
+		LicenseName:           "MIT",
+		TermsOfServiceURL:     "https://example.com/terms",
+	}
+	r, err := apim.CreateOrUpdate(context.Background(), d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	api := s.APIs["httpbin-v1"]
+	if *api.Description != "the httpbin api" || *api.SubscriptionRequired || !*api.IsCurrent || api.APIType != apimanagement.APITypeHTTP {
+		t.Errorf("api properties = %+v", api.APICreateOrUpdateProperties)
+	}
+	if names := api.SubscriptionKeyParameterNames; names == nil || *names.Header != "X-Api-Key" || names.Query != nil {
+		t.Errorf("subscription key names = %+v", names)
+	}
+	if c := api.Contact; c == nil || *c.Name != "api team" || *c.Email != "api@example.com" || c.URL != nil {
+		t.Errorf("contact = %+v", c)
+	}
+	if l := api.License; l == nil || *l.Name != "MIT" || l.URL != nil || *api.TermsOfServiceURL != "https://example.com/terms" {
+		t.Errorf("license = %+v, terms of service = %v", l, api.TermsOfServiceURL)
+	}
+	if s.Tags["public"] != "Public" || s.Tags["pets"] != "pets" {
+		t.Errorf("tags = %v, want existing tag kept and pets created", s.Tags)
+	}
+	if !reflect.DeepEqual(s.APITags["httpbin-v1"], []string{"public", "pets"}) || !reflect.DeepEqual(r.Tags, []string{"public", "pets"}) {
+		t.Errorf("api tags = %v, result tags = %v", s.APITags["httpbin-v1"], r.Tags)
+	}
+
+	d.Metadata.Tags = []string{"a/b"}
+	if _, err := apim.CreateOrUpdate(context.Background(), d); err == nil {
+		t.Error("invalid tag accepted")
+	}
+}
+
+func TestCreateOrUpdateAPITypes(t *testing.T) {
+	tests := []struct {
+		name       string
+		apiType    apimanagement.APIType
+		spec       string
+		serviceURL string
+		wantFormat apimanagement.ContentFormat
+		wantImport apimanagement.SoapAPIType
+		wantSchema bool
+	}{
+		{name: "http", apiType: apimanagement.APITypeHTTP, spec: `{"openapi": "3.0.1"}`, wantFormat: apimanagement.ContentFormatOpenapijson},
+		{name: "soap", apiType: apimanagement.APITypeSoap, spec: `<definitions />`, wantFormat: apimanagement.ContentFormatWsdl, wantImport: apimanagement.SoapAPITypeSoapPassThrough},
+		{name: "graphql", apiType: apimanagement.APITypeGraphql, spec: `type Query { pets: [String] }`, wantImport: apimanagement.SoapAPITypeGraphQL, wantSchema: true},
+		{name: "websocket", apiType: apimanagement.APITypeWebsocket, serviceURL: "wss://my.backend.service/httpbin", wantImport: apimanagement.SoapAPITypeWebSocket},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := fake.NewService("sub", "rg", "apim")
+			apim := newClient(s)
+			d := &apidefinition.Definition{
+				APIID:          "httpbin",
+				APIDisplayName: "httpbin api",
+				APIPath:        "/httpbin",
+				APIVersion:     "v1",
+				APIServiceURL:  "https://my.backend.service/httpbin",
+				OpenAPISpec:    tt.spec,
+				Metadata:       apidefinition.Metadata{APIType: tt.apiType},
+			}
+			if tt.serviceURL != "" {
+				d.APIServiceURL = tt.serviceURL
+			}
+			d.SetDefaults()
+			if err := d.GetOpenAPISpec(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.GetXMLPolicy(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := apim.CreateOrUpdate(context.Background(), d); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			api := s.APIs["httpbin-v1"]
+			if api.APIType != tt.apiType || api.SoapAPIType != tt.wantImport || api.Format != tt.wantFormat {
+				t.Errorf("type = %s, import = %s, format = %s, want %s, %s, %s", api.APIType, api.SoapAPIType, api.Format, tt.apiType, tt.wantImport, tt.wantFormat)
+			}
+			// apis are exposed with https or wss by default
+			if p := *api.Protocols; len(p) != 1 || p[0] != apidefinition.Protocols[tt.apiType][0] {
+				t.Errorf("protocols = %v", p)
+			}
+			if (api.Value != nil) != (tt.wantFormat != "") {
+				t.Errorf("value = %v, want it only with a format", api.Value)
+			}
+			schema, ok := s.Schemas["httpbin-v1"]
+			if ok != tt.wantSchema || (ok && *schema.Value != tt.spec) {
+				t.Errorf("schema = %+v, want uploaded %t", schema.SchemaContractProperties, tt.wantSchema)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateTransactionalRollbackSchema(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+	deploy := func(schema string, products ...string) (*apimclient.DeploymentResult, error) {
+		d := newDefinition()
+		d.OpenAPISpec = schema
+		d.OpenAPIFormat = ""
+		d.Metadata.APIType = apimanagement.APITypeGraphql
+		d.APIProducts = products
+		d.Transactional = true
+		if err := d.GetOpenAPISpec(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return apim.CreateOrUpdate(context.Background(), d)
+	}
+	previous := `type Query { pets: [String] }`
+	if _, err := deploy(previous); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	apim.ProductsAPIClient = failingProductAPIs{ProductAPIs: apim.ProductsAPIClient, product: "broken", err: errors.New("failure")}
+	r, err := deploy(`type Query { pets: [Pet] }`, "broken")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	want := []string{"restored policy of api httpbin-v1", "restored schema of api httpbin-v1", "restored api httpbin-v1", "restored version set httpbin"}
+	if !reflect.DeepEqual(r.RolledBack, want) || len(r.RollbackFailures) > 0 {
+		t.Fatalf("rolled back = %v (failures %v), want %v", r.RolledBack, r.RollbackFailures, want)
+	}
+	if got := *s.Schemas["httpbin-v1"].Value; got != previous {
+		t.Errorf("schema = %s, want %s", got, previous)
+	}
+	if api := s.APIs["httpbin-v1"]; api.Format != "" || api.Value != nil {
+		t.Errorf("graphql api restored with spec %s %v", api.Format, api.Value)
+	}
+}
+
+// formatExports records the formats of the exports
+type formatExports struct {
+	apimclient.APIExports
+	formats *[]apimanagement.ExportFormat
+}
+
+func (f formatExports) Export(ctx context.Context, resourceGroupName string, serviceName string, apiid string, format apimanagement.ExportFormat) (string, error) {
+	*f.formats = append(*f.formats, format)
+	return f.APIExports.Export(ctx, resourceGroupName, serviceName, apiid, format)
+}
+
+func TestCreateOrUpdateTransactionalRollbackSoap(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+	var formats []apimanagement.ExportFormat
+	apim.APIExportClient = formatExports{APIExports: apim.APIExportClient, formats: &formats}
+	deploy := func(wsdl string, products ...string) (*apimclient.DeploymentResult, error) {
+		d := newDefinition()
+		d.OpenAPISpec = wsdl
+		d.OpenAPIFormat = ""
+		d.Metadata.APIType = apimanagement.APITypeSoap
+		d.APIProducts = products
+		d.Transactional = true
+		if err := d.GetOpenAPISpec(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return apim.CreateOrUpdate(context.Background(), d)
+	}
+	previous := `<definitions name="pets" />`
+	if _, err := deploy(previous); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	apim.ProductsAPIClient = failingProductAPIs{ProductAPIs: apim.ProductsAPIClient, product: "broken", err: errors.New("failure")}
+	formats = nil
+	r, err := deploy(`<definitions name="animals" />`, "broken")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !reflect.DeepEqual(formats, []apimanagement.ExportFormat{apimanagement.ExportFormatWsdl}) {
+		t.Errorf("export formats = %v, want the wsdl", formats)
+	}
+	want := []string{"restored policy of api httpbin-v1", "restored api httpbin-v1", "restored version set httpbin"}
+	if !reflect.DeepEqual(r.RolledBack, want) || len(r.RollbackFailures) > 0 {
+		t.Fatalf("rolled back = %v (failures %v), want %v", r.RolledBack, r.RollbackFailures, want)
+	}
+	api := s.APIs["httpbin-v1"]
+	if api.Format != apimanagement.ContentFormatWsdl || api.SoapAPIType != apimanagement.SoapAPITypeSoapPassThrough || api.Value == nil || *api.Value != previous {
+		t.Errorf("soap api restored as %s %s %v, want the previous wsdl", api.Format, api.SoapAPIType, api.Value)
+	}
+}
+
+func TestCreateOrUpdateTransactionalRollbackTags(t *testing.T) {
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+	d := newDefinition()
+	d.Metadata.Tags = []string{"public"}
+	if _, err := apim.CreateOrUpdate(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.Tags["pets"] = "pets"
+	s.Errors[fake.OpCreateTag] = errors.New("tag creation failed")
+	d.Metadata.Tags = []string{"public", "pets", "admin"}
+	d.Transactional = true
+	var rbErr *apimclient.RollbackError
+	if _, err := apim.CreateOrUpdate(context.Background(), d); !errors.As(err, &rbErr) {
+		t.Fatalf("err = %v, want rollback error", err)
+	}
+	// the new assignment of pets is detached, the prior assignment of public is kept
+	if !reflect.DeepEqual(s.APITags["httpbin-v1"], []string{"public"}) {
+		t.Errorf("api tags = %v, want public", s.APITags["httpbin-v1"])
+	}
+}
+
+func TestCreateOrUpdateUnencrypted(t *testing.T) {
+	tests := []struct {
+		name        string
+		network     apimanagement.VirtualNetworkType
+		protocols   []apimanagement.Protocol
+		wantWarning bool
+	}{
+		{name: "https", protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTPS}},
+		{name: "http on public gateway", protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTPS, apimanagement.ProtocolHTTP}, wantWarning: true},
+		{name: "http on external network", network: apimanagement.VirtualNetworkTypeExternal, protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTP}, wantWarning: true},
+		{name: "http on internal network", network: apimanagement.VirtualNetworkTypeInternal, protocols: []apimanagement.Protocol{apimanagement.ProtocolHTTP}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := fake.NewService("sub", "rg", "apim")
+			s.VirtualNetworkType = tt.network
+			apim := newClient(s)
+			d := newDefinition()
+			d.APIProtocols = tt.protocols
+			r, err := apim.CreateOrUpdate(context.Background(), d)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (len(r.Warnings) > 0) != tt.wantWarning {
+				t.Errorf("warnings = %v, want warning %t", r.Warnings, tt.wantWarning)
+			}
+			if got := *s.APIs["httpbin-v1"].Protocols; !reflect.DeepEqual(got, tt.protocols) {
+				t.Errorf("protocols = %v, want %v", got, tt.protocols)
+			}
+		})
+	}
+
+	s := fake.NewService("sub", "rg", "apim")
+	d := newDefinition()
+	d.APIProtocols = []apimanagement.Protocol{"wss"}
+	if _, err := newClient(s).CreateOrUpdate(context.Background(), d); err == nil || len(s.Operations()) > 0 {
+		t.Errorf("unsupported protocol deployed, err = %v, operations = %v", err, s.Operations())
+	}
+}
@@ -0,0 +1,90 @@
+package apimclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/internal/specdiff"
+	"github.com/foryouandyourcustomers/azapim/internal/versioning"
+)
+
+// BreakingChangeError is returned if the new spec breaks the clients of the deployed version and
+// breaking changes are refused
+type BreakingChangeError struct {
+	APIVersion string
+	// SuggestedVersion is the version to deploy the spec as instead, empty if it can't be derived
+	SuggestedVersion string
+	Changes          []specdiff.Change
+}
+
+func (e *BreakingChangeError) Error() string {
+	msg := fmt.Sprintf("the openapi spec contains %d breaking changes to the deployed version %s", len(e.Changes), e.APIVersion)
+	if len(e.Changes) > 0 {
+		c := e.Changes[0]
+		msg = fmt.Sprintf("%s, e.g. %s %s: %s", msg, c.Operation, c.Location, c.Message)
+	}
+	if e.SuggestedVersion != "" {
+		msg = fmt.Sprintf("%s. deploy it as new version with --apiversion %s", msg, e.SuggestedVersion)
+	}
+	return msg
+}
+
+// CompareDeployed returns the changes from the deployed spec of the api version to the spec of the definition,
+// nil if the version isn't deployed. the spec of the definition has to be loaded inline
+func (apim *ApimClient) CompareDeployed(ctx context.Context, a *apidefinition.Definition) (*specdiff.Report, error) {
+	if err := requireInlineSpec(a); err != nil {
+		return nil, err
+	}
+
+	var deployed string
+	found, err := apim.find(ctx, "export api", func(ctx context.Context) (err error) {
+		deployed, err = apim.APIExportClient.Export(ctx, apim.ResourceGroup, apim.ServiceName, a.APIUniqueID, apimanagement.ExportFormatOpenapiJSON)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return specdiff.Compare([]byte(deployed), []byte(a.OpenAPISpec))
+}
+
+// requireInlineSpec checks that the content of the spec is loaded for a comparison
+func requireInlineSpec(a *apidefinition.Definition) error {
+	if t := a.Metadata.APIType; t == apimanagement.APITypeWebsocket || t == apimanagement.APITypeGraphql {
+		return fmt.Errorf("only openapi specs can be compared, %s apis have none", t)
+	}
+	switch a.OpenAPIFormat {
+	case apimanagement.ContentFormatOpenapi, apimanagement.ContentFormatOpenapijson, apimanagement.ContentFormatSwaggerJSON:
+		return nil
+	}
+	return fmt.Errorf("unable to compare the openapi spec imported as %s, use --fetch local for specs at urls", a.OpenAPIFormat)
+}
+
+// refuseBreakingChanges returns a BreakingChangeError if the spec of the definition breaks the deployed version
+func (apim *ApimClient) refuseBreakingChanges(ctx context.Context, a *apidefinition.Definition, r *DeploymentResult) error {
+	report, err := apim.CompareDeployed(ctx, a)
+	if err != nil {
+		return fmt.Errorf("unable to compare the openapi spec with the deployed version: %w", err)
+	}
+	if report == nil {
+		logging.From(ctx).Info("Version isn't deployed yet, no breaking changes")
+		return nil
+	}
+	r.Changes = report.Changes
+	breaking := report.BreakingChanges()
+	if len(breaking) == 0 {
+		logging.From(ctx).Infof("No breaking changes to the deployed version, %d compatible changes", len(report.Changes))
+		return nil
+	}
+	for _, c := range breaking {
+		logging.From(ctx).Errorf("Breaking change of %s %s: %s", c.Operation, c.Location, c.Message)
+	}
+	next, err := versioning.Next(a.APIVersion)
+	if err != nil {
+		logging.From(ctx).Warn(err)
+	}
+	return &BreakingChangeError{APIVersion: a.APIVersion, SuggestedVersion: next, Changes: breaking}
+}
@@ -0,0 +1,239 @@
+package apimclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/foryouandyourcustomers/azapim/internal/telemetry"
+)
+
+// VersionSets returns, creates, updates and deletes api version sets and returns their etags
+type VersionSets interface {
+	Get(ctx context.Context, resourceGroupName string, serviceName string, versionSetID string) (apimanagement.APIVersionSetContract, error)
+	GetEntityTag(ctx context.Context, resourceGroupName string, serviceName string, versionSetID string) (autorest.Response, error)
+	Delete(ctx context.Context, resourceGroupName string, serviceName string, versionSetID string, ifMatch string) (autorest.Response, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, versionSetID string, parameters apimanagement.APIVersionSetContract, ifMatch string) (apimanagement.APIVersionSetContract, error)
+}
+
+// APIs returns, creates, updates and deletes apis and returns their etags. create and update wait
+// until the operation is finished
+type APIs interface {
+	Get(ctx context.Context, resourceGroupName string, serviceName string, apiid string) (apimanagement.APIContract, error)
+	// List returns all apis of the service
+	List(ctx context.Context, resourceGroupName string, serviceName string) ([]apimanagement.APIContract, error)
+	GetEntityTag(ctx context.Context, resourceGroupName string, serviceName string, apiid string) (autorest.Response, error)
+	Delete(ctx context.Context, resourceGroupName string, serviceName string, apiid string, ifMatch string, deleteRevisions *bool) (autorest.Response, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, apiid string, parameters apimanagement.APICreateOrUpdateParameter, ifMatch string) (apimanagement.APIContract, error)
+	// Update changes the given properties of an existing api
+	Update(ctx context.Context, resourceGroupName string, serviceName string, apiid string, parameters apimanagement.APIUpdateContract, ifMatch string) (apimanagement.APIContract, error)
+}
+
+// APIExports exports the specs of deployed apis
+type APIExports interface {
+	// Export returns the spec of the api in the format, the openapi spec in json format or the wsdl of soap apis
+	Export(ctx context.Context, resourceGroupName string, serviceName string, apiid string, format apimanagement.ExportFormat) (string, error)
+}
+
+// APIRevisions lists the revisions of apis
+type APIRevisions interface {
+	// List returns all revisions of the api, the current and the others
+	List(ctx context.Context, resourceGroupName string, serviceName string, apiid string) ([]apimanagement.APIRevisionContract, error)
+}
+
+// Policies returns, creates, updates and deletes api policies and returns their etags
+type Policies interface {
+	Get(ctx context.Context, resourceGroupName string, serviceName string, apiid string, format apimanagement.PolicyExportFormat) (apimanagement.PolicyContract, error)
+	GetEntityTag(ctx context.Context, resourceGroupName string, serviceName string, apiid string) (autorest.Response, error)
+	Delete(ctx context.Context, resourceGroupName string, serviceName string, apiid string, ifMatch string) (autorest.Response, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, apiid string, parameters apimanagement.PolicyContract, ifMatch string) (apimanagement.PolicyContract, error)
+}
+
+// Schemas returns, uploads and deletes the schemas of apis. the upload waits until the operation is finished
+type Schemas interface {
+	Get(ctx context.Context, resourceGroupName string, serviceName string, apiid string, schemaID string) (apimanagement.SchemaContract, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, apiid string, schemaID string, parameters apimanagement.SchemaContract, ifMatch string) (apimanagement.SchemaContract, error)
+	Delete(ctx context.Context, resourceGroupName string, serviceName string, apiid string, schemaID string, ifMatch string, force *bool) (autorest.Response, error)
+}
+
+// ProductAPIs assigns apis to products, checks and removes the assignments
+type ProductAPIs interface {
+	CheckEntityExists(ctx context.Context, resourceGroupName string, serviceName string, productID string, apiid string) (autorest.Response, error)
+	Delete(ctx context.Context, resourceGroupName string, serviceName string, productID string, apiid string) (autorest.Response, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, productID string, apiid string) (apimanagement.APIContract, error)
+}
+
+// Tags creates tags, assigns them to apis, checks and removes the assignments
+type Tags interface {
+	GetEntityState(ctx context.Context, resourceGroupName string, serviceName string, tagID string) (autorest.Response, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, tagID string, parameters apimanagement.TagCreateUpdateParameters, ifMatch string) (apimanagement.TagContract, error)
+	GetEntityStateByAPI(ctx context.Context, resourceGroupName string, serviceName string, apiid string, tagID string) (autorest.Response, error)
+	AssignToAPI(ctx context.Context, resourceGroupName string, serviceName string, apiid string, tagID string) (apimanagement.TagContract, error)
+	DetachFromAPI(ctx context.Context, resourceGroupName string, serviceName string, apiid string, tagID string) (autorest.Response, error)
+}
+
+// Services returns, backups and restores api management services. backup and restore
+// wait until the operation is finished
+type Services interface {
+	Get(ctx context.Context, resourceGroupName string, serviceName string) (apimanagement.ServiceResource, error)
+	Backup(ctx context.Context, resourceGroupName string, serviceName string, parameters apimanagement.ServiceBackupRestoreParameters) error
+	Restore(ctx context.Context, resourceGroupName string, serviceName string, parameters apimanagement.ServiceBackupRestoreParameters) error
+}
+
+// apiClient implements APIs with the azure sdk client
+type apiClient struct {
+	apimanagement.APIClient
+	pollingTimeout time.Duration
+}
+
+func (c apiClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, apiid string, parameters apimanagement.APICreateOrUpdateParameter, ifMatch string) (apimanagement.APIContract, error) {
+	future, err := c.APIClient.CreateOrUpdate(ctx, resourceGroupName, serviceName, apiid, parameters, ifMatch)
+	if err != nil {
+		return apimanagement.APIContract{}, err
+	}
+	err = waitForCompletion(ctx, future.FutureAPI, c.APIClient.Client, "api import", c.pollingTimeout)
+	if err != nil {
+		return apimanagement.APIContract{}, err
+	}
+	return future.Result(c.APIClient)
+}
+
+func (c apiClient) List(ctx context.Context, resourceGroupName string, serviceName string) ([]apimanagement.APIContract, error) {
+	it, err := c.APIClient.ListByServiceComplete(ctx, resourceGroupName, serviceName, "", nil, nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var apis []apimanagement.APIContract
+	for ; it.NotDone(); err = it.NextWithContext(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		apis = append(apis, it.Value())
+	}
+	return apis, err
+}
+
+// apiRevisionClient implements APIRevisions with the azure sdk client
+type apiRevisionClient struct {
+	apimanagement.APIRevisionClient
+}
+
+func (c apiRevisionClient) List(ctx context.Context, resourceGroupName string, serviceName string, apiid string) ([]apimanagement.APIRevisionContract, error) {
+	it, err := c.APIRevisionClient.ListByServiceComplete(ctx, resourceGroupName, serviceName, apiid, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []apimanagement.APIRevisionContract
+	for ; it.NotDone(); err = it.NextWithContext(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, it.Value())
+	}
+	return revisions, err
+}
+
+// apiExportClient implements APIExports with the azure sdk client
+type apiExportClient struct {
+	apimanagement.APIExportClient
+}
+
+func (c apiExportClient) Export(ctx context.Context, resourceGroupName string, serviceName string, apiid string, format apimanagement.ExportFormat) (string, error) {
+	r, err := c.APIExportClient.Get(ctx, resourceGroupName, serviceName, apiid, format)
+	if err != nil {
+		return "", err
+	}
+	if r.Value == nil || r.Value.Link == nil {
+		return "", fmt.Errorf("no export link returned for api %s", apiid)
+	}
+
+	// the link contains a sas token for the exported blob. it is sent with the sender only, the
+	// authorizer of the client would add the bearer token of the resource manager to the request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *r.Value.Link, nil)
+	if err != nil {
+		return "", err
+	}
+	sender := c.Sender
+	if sender == nil {
+		sender = http.DefaultClient
+	}
+	resp, err := sender.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to download the exported spec of api %s: %w", apiid, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to download the exported spec of api %s: %s", apiid, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// schemaClient implements Schemas with the azure sdk client
+type schemaClient struct {
+	apimanagement.APISchemaClient
+	pollingTimeout time.Duration
+}
+
+func (c schemaClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, apiid string, schemaID string, parameters apimanagement.SchemaContract, ifMatch string) (apimanagement.SchemaContract, error) {
+	future, err := c.APISchemaClient.CreateOrUpdate(ctx, resourceGroupName, serviceName, apiid, schemaID, parameters, ifMatch)
+	if err != nil {
+		return apimanagement.SchemaContract{}, err
+	}
+	err = waitForCompletion(ctx, future.FutureAPI, c.APISchemaClient.Client, "schema upload", c.pollingTimeout)
+	if err != nil {
+		return apimanagement.SchemaContract{}, err
+	}
+	return future.Result(c.APISchemaClient)
+}
+
+// serviceClient implements Services with the azure sdk client
+type serviceClient struct {
+	apimanagement.ServiceClient
+	pollingTimeout time.Duration
+}
+
+func (c serviceClient) Backup(ctx context.Context, resourceGroupName string, serviceName string, parameters apimanagement.ServiceBackupRestoreParameters) error {
+	future, err := c.ServiceClient.Backup(ctx, resourceGroupName, serviceName, parameters)
+	if err != nil {
+		return err
+	}
+	return waitForCompletion(ctx, future.FutureAPI, c.ServiceClient.Client, "backup", c.pollingTimeout)
+}
+
+func (c serviceClient) Restore(ctx context.Context, resourceGroupName string, serviceName string, parameters apimanagement.ServiceBackupRestoreParameters) error {
+	future, err := c.ServiceClient.Restore(ctx, resourceGroupName, serviceName, parameters)
+	if err != nil {
+		return err
+	}
+	return waitForCompletion(ctx, future.FutureAPI, c.ServiceClient.Client, "restore", c.pollingTimeout)
+}
+
+// waitForCompletion polls the long running operation until it is finished or the polling timeout
+// is exceeded. the timeout is applied even if the context has a deadline, autorest ignores
+// the polling duration of the client in that case
+func waitForCompletion(ctx context.Context, f azure.FutureAPI, client autorest.Client, operation string, timeout time.Duration) error {
+	pollCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		pollCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := telemetry.Poll(pollCtx, operation, func(ctx context.Context) error {
+		return f.WaitForCompletionRef(ctx, client)
+	})
+	if err != nil && ctx.Err() == nil && errors.Is(pollCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s didn't finish within the polling timeout of %s: %w", operation, timeout, err)
+	}
+	return err
+}
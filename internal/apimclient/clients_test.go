@@ -0,0 +1,70 @@
+package apimclient_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
+)
+
+func TestExportWithoutAuthorization(t *testing.T) {
+	var mu sync.Mutex
+	authorization := map[string]string{}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authorization[r.URL.Path] = r.Header.Get("Authorization")
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/exports/petstore" {
+			fmt.Fprint(w, `{"openapi":"3.0.1"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"id":"petstore","format":"openapi-link","value":{"link":"%s/exports/petstore?sig=secret"}}`, srv.URL)
+	}))
+	defer srv.Close()
+
+	apim := &apimclient.ApimClient{
+		Authorizer:    autorest.NewBearerAuthorizer(&adal.Token{AccessToken: "armtoken"}),
+		BaseURI:       srv.URL,
+		Subscription:  "sub",
+		ResourceGroup: "rg",
+		ServiceName:   "apim",
+	}
+	if err := apim.Authenticate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spec, err := apim.APIExportClient.Export(context.Background(), "rg", "apim", "petstore", apimanagement.ExportFormatOpenapiJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec != `{"openapi":"3.0.1"}` {
+		t.Errorf("unexpected spec %s", spec)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(authorization) != 2 {
+		t.Fatalf("expected the export and the download request, got %v", authorization)
+	}
+	for p, a := range authorization {
+		switch p {
+		case "/exports/petstore":
+			if a != "" {
+				t.Errorf("the download of the exported spec is sent with the authorization header %s", a)
+			}
+		default:
+			if a != "Bearer armtoken" {
+				t.Errorf("expected the bearer token for the export request %s, got '%s'", p, a)
+			}
+		}
+	}
+}
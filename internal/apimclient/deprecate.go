@@ -0,0 +1,230 @@
+package apimclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/internal/telemetry"
+)
+
+// DateFormat is the format of the deprecation and sunset dates
+const DateFormat = "2006-01-02"
+
+// deprecatedSuffix is appended to the display name of deprecated versions
+const deprecatedSuffix = " (deprecated)"
+
+var (
+	// deprecationNotice matches the notice at the start of the description of a deprecated version
+	deprecationNotice = regexp.MustCompile(`^Deprecated: this version will be removed on \d{4}-\d{2}-\d{2}\.\s*`)
+	// deprecationHeaders matches the set-header policies of the deprecation and sunset headers
+	deprecationHeaders = regexp.MustCompile(`(?is)\s*<set-header\b[^>]*\bname=["'](?:deprecation|sunset)["'][^>]*?(?:/>|>.*?</set-header\s*>)`)
+	outboundEnd        = regexp.MustCompile(`(?i)</outbound\s*>`)
+	outboundEmpty      = regexp.MustCompile(`(?i)<outbound\s*/>`)
+	policiesEnd        = regexp.MustCompile(`(?i)</policies\s*>`)
+)
+
+// Deprecation marks a deployed version of a versioned api as deprecated
+type Deprecation struct {
+	APIID      string
+	APIVersion string
+	// Sunset is the date the version will be removed
+	Sunset time.Time
+	// Since is the date the version is deprecated, the current date if not set
+	Since time.Time
+	// RemoveFromProducts are the products the version is removed from, so new consumers can't subscribe to it
+	RemoveFromProducts []string
+}
+
+// Deprecate adds a deprecation notice to the description and display name of the api version and sets the
+// Deprecation and Sunset response headers in its outbound policy. running it again replaces the notice and headers
+func (apim *ApimClient) Deprecate(ctx context.Context, d *Deprecation) (r *DeprecationResult, err error) {
+	ctx = logging.WithFields(ctx, apim.fields())
+	ctx = logging.WithFields(ctx, log.Fields{logging.FieldAPIID: d.APIID, logging.FieldVersion: d.APIVersion})
+	attrs := append(apim.attributes(), attribute.String("azapim.apiid", d.APIID), attribute.String("azapim.apiversion", d.APIVersion))
+	err = telemetry.Step(ctx, "deprecate versioned api", func(ctx context.Context) error {
+		r, err = apim.deprecate(ctx, d)
+		return err
+	}, attrs...)
+	return r, err
+}
+
+func (apim *ApimClient) deprecate(ctx context.Context, d *Deprecation) (*DeprecationResult, error) {
+	start := time.Now()
+	since := d.Since
+	if since.IsZero() {
+		since = time.Now()
+	}
+	since = day(since)
+	sunset := day(d.Sunset)
+	if sunset.Before(since) {
+		return nil, fmt.Errorf("the sunset %s is before the deprecation on %s", sunset.Format(DateFormat), since.Format(DateFormat))
+	}
+	uid := fmt.Sprintf("%s-%s", d.APIID, d.APIVersion)
+	r := &DeprecationResult{
+		APIName:             uid,
+		APIVersion:          d.APIVersion,
+		Deprecated:          since.Format(DateFormat),
+		Sunset:              sunset.Format(DateFormat),
+		RemovedFromProducts: []string{},
+	}
+	defer func() {
+		r.DurationSeconds = seconds(start)
+	}()
+
+	apiETag, err := apim.APIETag(ctx, uid)
+	if err != nil {
+		return r, err
+	}
+	policyETag, err := apim.PolicyETag(ctx, uid)
+	if err != nil {
+		return r, err
+	}
+	notDeployed := fmt.Errorf("version %s of api %s isn't deployed", d.APIVersion, d.APIID)
+	if apim.OnConflict != ConflictForce && apiETag.Value == "" {
+		return r, notDeployed
+	}
+
+	// the notice and the headers are added to the current state of the api and policy, which are
+	// read again if they are changed concurrently
+	logging.From(ctx).Infof("Adding the deprecation notice to API '%s'", uid)
+	var api apimanagement.APIContract
+	err = apim.conditionalUpdate(ctx, apiETag, func(ctx context.Context, ifMatch string) error {
+		found, err := apim.find(ctx, "get api", func(ctx context.Context) (err error) {
+			api, err = apim.APIClient.Get(ctx, apim.ResourceGroup, apim.ServiceName, uid)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			return notDeployed
+		}
+		if api.APIContractProperties == nil {
+			return fmt.Errorf("no properties returned for api %s", uid)
+		}
+		displayName, description := deprecatedAPI(api.APIContractProperties, sunset)
+		update := apimanagement.APIUpdateContract{
+			APIContractUpdateProperties: &apimanagement.APIContractUpdateProperties{
+				DisplayName: &displayName,
+				Description: &description,
+			},
+		}
+		r.DisplayName = displayName
+		return apim.do(ctx, "update api", func(ctx context.Context) error {
+			_, err := apim.APIClient.Update(ctx, apim.ResourceGroup, apim.ServiceName, uid, update, ifMatch)
+			return err
+		})
+	})
+	if err != nil {
+		return r, err
+	}
+	if api.ID != nil {
+		r.APIID = *api.ID
+	}
+
+	logging.From(ctx).Info("Adding the Deprecation and Sunset headers to the API policy")
+	var policy apimanagement.PolicyContract
+	err = apim.conditionalUpdate(ctx, policyETag, func(ctx context.Context, ifMatch string) error {
+		found, err := apim.find(ctx, "get policy", func(ctx context.Context) (err error) {
+			policy, err = apim.PolicyClient.Get(ctx, apim.ResourceGroup, apim.ServiceName, uid, apimanagement.PolicyExportFormatXML)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		xml := apidefinition.DefaultXMLPolicy
+		if found && policy.PolicyContractProperties != nil && policy.Value != nil {
+			xml = *policy.Value
+		}
+		xml, err = deprecationPolicy(xml, since, sunset)
+		if err != nil {
+			return err
+		}
+		p := apimanagement.PolicyContract{
+			PolicyContractProperties: &apimanagement.PolicyContractProperties{Format: apimanagement.PolicyContentFormatXML, Value: &xml},
+		}
+		return apim.do(ctx, "create or update policy", func(ctx context.Context) (err error) {
+			policy, err = apim.PolicyClient.CreateOrUpdate(ctx, apim.ResourceGroup, apim.ServiceName, uid, p, ifMatch)
+			return err
+		})
+	})
+	if err != nil {
+		return r, err
+	}
+	r.PolicyID = *policy.ID
+
+	for _, p := range d.RemoveFromProducts {
+		logging.From(ctx).Infof("Removing API from product '%s'", p)
+		found, err := apim.RemoveFromProduct(ctx, p, uid)
+		if err != nil {
+			return r, err
+		}
+		if !found {
+			logging.From(ctx).Warnf("API isn't assigned to product '%s'", p)
+			continue
+		}
+		r.RemovedFromProducts = append(r.RemovedFromProducts, p)
+	}
+	logging.From(ctx).Infof("Deprecated API '%s', sunset on %s", uid, r.Sunset)
+	return r, nil
+}
+
+// deprecatedAPI returns the display name and description of the api with the deprecation notice
+func deprecatedAPI(p *apimanagement.APIContractProperties, sunset time.Time) (string, string) {
+	var displayName, description string
+	if p.DisplayName != nil {
+		displayName = *p.DisplayName
+	}
+	if p.Description != nil {
+		description = *p.Description
+	}
+	displayName = strings.TrimSuffix(displayName, deprecatedSuffix) + deprecatedSuffix
+	notice := fmt.Sprintf("Deprecated: this version will be removed on %s.", sunset.Format(DateFormat))
+	if description = deprecationNotice.ReplaceAllString(description, ""); description != "" {
+		notice += "\n\n" + description
+	}
+	return displayName, notice
+}
+
+// deprecationPolicy replaces the Deprecation and Sunset headers at the end of the outbound section of the policy.
+// the deprecation header is a structured date (RFC 9745), its leading @ is written as policy expression because
+// plain values starting with @ are expressions in api management. the sunset header is a http date (RFC 8594)
+func deprecationPolicy(xml string, since time.Time, sunset time.Time) (string, error) {
+	headers := fmt.Sprintf(`<set-header name="Deprecation" exists-action="override">
+<value>@("@%d")</value>
+</set-header>
+<set-header name="Sunset" exists-action="override">
+<value>%s</value>
+</set-header>
+`, since.Unix(), sunset.Format(http.TimeFormat))
+
+	xml = deprecationHeaders.ReplaceAllString(xml, "")
+	if loc := outboundEnd.FindAllStringIndex(xml, -1); len(loc) > 0 {
+		i := loc[len(loc)-1][0]
+		return xml[:i] + headers + xml[i:], nil
+	}
+	if loc := outboundEmpty.FindStringIndex(xml); loc != nil {
+		return xml[:loc[0]] + "<outbound>\n<base />\n" + headers + "</outbound>" + xml[loc[1]:], nil
+	}
+	if loc := policiesEnd.FindAllStringIndex(xml, -1); len(loc) > 0 {
+		i := loc[len(loc)-1][0]
+		return xml[:i] + "<outbound>\n<base />\n" + headers + "</outbound>\n" + xml[i:], nil
+	}
+	return "", errors.New("the api policy has no policies element")
+}
+
+// day returns the date at midnight utc
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
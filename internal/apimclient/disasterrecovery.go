@@ -1,9 +1,11 @@
 package apimclient
 
 import (
-	"github.com/Azure/azure-sdk-for-go/profiles/latest/apimanagement/mgmt/apimanagement"
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
 	"github.com/foryouandyourcustomers/azapim/internal/disasterrecovery"
-	log "github.com/sirupsen/logrus"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
 )
 
 // DisasterRecovery contains the configuration for the backupd and restore of the apim service
@@ -14,38 +16,31 @@ type DisasterRecovery struct {
 }
 
 // Initialize inializes the storage account client
-func (dr *DisasterRecovery) Initialize(s string) {
-	dr.Storage.InitializeClient(s)
+func (dr *DisasterRecovery) Initialize(ctx context.Context, s string) error {
+	if err := dr.Storage.InitializeClient(ctx, s); err != nil {
+		return err
+	}
 	dr.Parameters.AccessKey = &dr.Storage.Key
 	dr.Parameters.ContainerName = &dr.Storage.BlobName
 	dr.Parameters.BackupName = &dr.BackupName
 	dr.Parameters.StorageAccount = &dr.Storage.AccountName
+	return nil
 }
 
 // Backup backups the specified api management service
-func (apim *ApimClient) Backup(rg string, s string, p apimanagement.ServiceBackupRestoreParameters) error {
-	log.Infof("Execute DR Backup for Service '%s' with name '%s' to storage account and blob '%s/%s'", s, *p.BackupName, *p.StorageAccount, *p.ContainerName)
-	future, err := apim.ServiceClient.Backup(apim.Ctx, rg, s, p)
-	if err != nil {
-		return err
-	}
-	err = future.WaitForCompletionRef(apim.Ctx, apim.ServiceClient.Client)
-	if err != nil {
-		return err
-	}
-	return nil
+func (apim *ApimClient) Backup(ctx context.Context, rg string, s string, p apimanagement.ServiceBackupRestoreParameters) error {
+	ctx = logging.WithFields(ctx, apim.fields())
+	logging.From(ctx).Infof("Execute DR Backup for Service '%s' with name '%s' to storage account and blob '%s/%s'", s, *p.BackupName, *p.StorageAccount, *p.ContainerName)
+	return apim.do(ctx, "backup", func(ctx context.Context) error {
+		return apim.ServiceClient.Backup(ctx, rg, s, p)
+	})
 }
 
 // Restore disaster recovery backup for apim service
-func (apim *ApimClient) Restore(rg string, s string, p apimanagement.ServiceBackupRestoreParameters) error {
-	log.Infof("Execute DR Restore for Service '%s' with name '%s' to storage account and blob '%s/%s'", s, *p.BackupName, *p.StorageAccount, *p.ContainerName)
-	future, err := apim.ServiceClient.Restore(apim.Ctx, rg, s, p)
-	if err != nil {
-		return err
-	}
-	err = future.WaitForCompletionRef(apim.Ctx, apim.ServiceClient.Client)
-	if err != nil {
-		return err
-	}
-	return nil
+func (apim *ApimClient) Restore(ctx context.Context, rg string, s string, p apimanagement.ServiceBackupRestoreParameters) error {
+	ctx = logging.WithFields(ctx, apim.fields())
+	logging.From(ctx).Infof("Execute DR Restore for Service '%s' with name '%s' to storage account and blob '%s/%s'", s, *p.BackupName, *p.StorageAccount, *p.ContainerName)
+	return apim.do(ctx, "restore", func(ctx context.Context) error {
+		return apim.ServiceClient.Restore(ctx, rg, s, p)
+	})
 }
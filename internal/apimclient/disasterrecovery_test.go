@@ -0,0 +1,83 @@
+package apimclient_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
+	"github.com/foryouandyourcustomers/azapim/internal/disasterrecovery"
+	"github.com/foryouandyourcustomers/azapim/internal/fake"
+)
+
+func newDisasterRecovery(keys map[string][]string) *apimclient.DisasterRecovery {
+	return &apimclient.DisasterRecovery{
+		BackupName: "apim-1",
+		Storage: disasterrecovery.StorageAccount{
+			AccountClient: &fake.StorageAccounts{Keys: keys},
+			ResourceGroup: "storagerg",
+			AccountName:   "backups",
+			BlobName:      "apim",
+		},
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+
+	dr := newDisasterRecovery(map[string][]string{"storagerg/backups": {"key1", "key2"}})
+	if err := dr.Initialize(ctx, apim.Subscription); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := apim.Backup(ctx, apim.ResourceGroup, apim.ServiceName, dr.Parameters); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, ok := s.Backups["apim-1"]
+	if !ok {
+		t.Fatal("backup apim-1 not created")
+	}
+	if *b.AccessKey != "key1" || *b.StorageAccount != "backups" || *b.ContainerName != "apim" {
+		t.Errorf("unexpected backup parameters: %s %s %s", *b.AccessKey, *b.StorageAccount, *b.ContainerName)
+	}
+
+	if err := apim.Restore(ctx, apim.ResourceGroup, apim.ServiceName, dr.Parameters); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Restores) != 1 || *s.Restores[0].BackupName != "apim-1" {
+		t.Errorf("restores = %v", s.Restores)
+	}
+}
+
+func TestRestoreUnknownBackup(t *testing.T) {
+	ctx := context.Background()
+	s := fake.NewService("sub", "rg", "apim")
+	apim := newClient(s)
+
+	dr := newDisasterRecovery(map[string][]string{"storagerg/backups": {"key1"}})
+	if err := dr.Initialize(ctx, apim.Subscription); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := apim.Restore(ctx, apim.ResourceGroup, apim.ServiceName, dr.Parameters); err == nil {
+		t.Fatal("expected error for unknown backup")
+	}
+}
+
+func TestInitializeStorageKeyUnavailable(t *testing.T) {
+	tests := map[string]map[string][]string{
+		"unknown account": {},
+		"no keys":         {"storagerg/backups": {}},
+	}
+	for name, keys := range tests {
+		t.Run(name, func(t *testing.T) {
+			dr := newDisasterRecovery(keys)
+			err := dr.Initialize(context.Background(), "sub")
+			var keyErr *disasterrecovery.StorageKeyUnavailableError
+			if !errors.As(err, &keyErr) {
+				t.Fatalf("error = %v, want StorageKeyUnavailableError", err)
+			}
+		})
+	}
+}
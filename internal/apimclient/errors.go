@@ -0,0 +1,39 @@
+package apimclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// InterruptedError is returned if the context of an operation is canceled or its deadline
+// is exceeded. Step is the operation which was in progress
+type InterruptedError struct {
+	Step string
+	Err  error
+}
+
+func (e *InterruptedError) Error() string {
+	reason := "interrupted"
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		reason = "timed out"
+	}
+	return fmt.Sprintf("%s during step '%s': %v", reason, e.Step, e.Err)
+}
+
+// Unwrap returns the error of the context
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// interrupted returns an InterruptedError for the step if the context is done, otherwise err
+func interrupted(ctx context.Context, step string, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	var e *InterruptedError
+	if errors.As(err, &e) {
+		return err
+	}
+	return &InterruptedError{Step: step, Err: ctx.Err()}
+}
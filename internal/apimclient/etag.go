@@ -0,0 +1,147 @@
+package apimclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+)
+
+// ConflictStrategy defines how updates of resources changed concurrently by someone else are handled
+type ConflictStrategy string
+
+const (
+	// ConflictFail fails the update if the resource was changed since its etag was read
+	ConflictFail ConflictStrategy = "fail"
+	// ConflictRetry reads the resource again, revalidates it and repeats the update
+	ConflictRetry ConflictStrategy = "retry"
+	// ConflictForce updates the resource unconditionally
+	ConflictForce ConflictStrategy = "force"
+)
+
+// ConflictStrategies contains all supported conflict strategies
+var ConflictStrategies = []ConflictStrategy{ConflictFail, ConflictRetry, ConflictForce}
+
+// maxConflictRetries limits the updates of a resource with the retry strategy
+const maxConflictRetries = 3
+
+// ParseConflictStrategy returns the conflict strategy with the given name, fail if empty
+func ParseConflictStrategy(s string) (ConflictStrategy, error) {
+	if s == "" {
+		return ConflictFail, nil
+	}
+	for _, v := range ConflictStrategies {
+		if strings.EqualFold(s, string(v)) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict strategy '%s', supported are %s", s, enum.Join(ConflictStrategies))
+}
+
+// ConflictError is returned if a resource was changed by someone else between reading its etag and the update
+type ConflictError struct {
+	Resource string
+	Err      error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s was changed concurrently, the update was rejected: %v", e.Resource, e.Err)
+}
+
+// Unwrap returns the error of the rejected update
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// ETag is the etag of a resource read when a deployment or change starts. all updates of the resource are
+// conditional on it, so changes made by someone else in the meantime aren't overwritten
+type ETag struct {
+	Resource string
+	// Value is empty if the resource didn't exist or the conflict strategy is force
+	Value string
+	read  func(ctx context.Context) (autorest.Response, error)
+	// revalidate checks the current state of the resource before the update is repeated after a conflict
+	revalidate func(ctx context.Context) error
+}
+
+// readETag returns the etag of the resource. it isn't read with the force strategy, updates are unconditional then
+func (apim *ApimClient) readETag(ctx context.Context, resource string, read func(ctx context.Context) (autorest.Response, error)) (*ETag, error) {
+	e := &ETag{Resource: resource, read: read}
+	if apim.OnConflict == ConflictForce {
+		return e, nil
+	}
+	var r autorest.Response
+	found, err := apim.find(ctx, "get etag of "+resource, func(ctx context.Context) (err error) {
+		r, err = read(ctx)
+		return err
+	})
+	if err != nil || !found {
+		return e, err
+	}
+	if r.Response == nil || r.Header.Get("ETag") == "" {
+		return nil, fmt.Errorf("no etag returned for %s", resource)
+	}
+	e.Value = r.Header.Get("ETag")
+	return e, nil
+}
+
+// VersionSetETag returns the etag of the version set
+func (apim *ApimClient) VersionSetETag(ctx context.Context, id string) (*ETag, error) {
+	return apim.readETag(ctx, "version set "+id, func(ctx context.Context) (autorest.Response, error) {
+		return apim.VersionSetClient.GetEntityTag(ctx, apim.ResourceGroup, apim.ServiceName, id)
+	})
+}
+
+// APIETag returns the etag of the api
+func (apim *ApimClient) APIETag(ctx context.Context, uid string) (*ETag, error) {
+	return apim.readETag(ctx, "api "+uid, func(ctx context.Context) (autorest.Response, error) {
+		return apim.APIClient.GetEntityTag(ctx, apim.ResourceGroup, apim.ServiceName, uid)
+	})
+}
+
+// PolicyETag returns the etag of the policy of the api
+func (apim *ApimClient) PolicyETag(ctx context.Context, uid string) (*ETag, error) {
+	return apim.readETag(ctx, "policy of api "+uid, func(ctx context.Context) (autorest.Response, error) {
+		return apim.PolicyClient.GetEntityTag(ctx, apim.ResourceGroup, apim.ServiceName, uid)
+	})
+}
+
+// conditionalUpdate updates the resource only if it wasn't changed since its etag was read, resources which
+// didn't exist are created without a condition. with the retry strategy a rejected update reads the etag again,
+// revalidates the resource and is repeated. the update has to derive its changes from the current state of the
+// resource, it is called again after the resource was read. the force strategy updates unconditionally
+func (apim *ApimClient) conditionalUpdate(ctx context.Context, etag *ETag, update func(ctx context.Context, ifMatch string) error) error {
+	if apim.OnConflict == ConflictForce {
+		return update(ctx, "")
+	}
+	for attempt := 1; ; attempt++ {
+		err := update(ctx, etag.Value)
+		if err == nil || retry.StatusCode(err) != http.StatusPreconditionFailed {
+			return err
+		}
+		if apim.OnConflict != ConflictRetry || attempt > maxConflictRetries {
+			return &ConflictError{Resource: etag.Resource, Err: err}
+		}
+		logging.From(ctx).Warnf("%s was changed concurrently, read it again and repeat the update", etag.Resource)
+		current, err := apim.readETag(ctx, etag.Resource, etag.read)
+		if err != nil {
+			return err
+		}
+		if current.Value == "" {
+			return &ConflictError{Resource: etag.Resource, Err: errors.New("it was deleted")}
+		}
+		if etag.revalidate != nil {
+			if err := etag.revalidate(ctx); err != nil {
+				return &ConflictError{Resource: etag.Resource, Err: err}
+			}
+		}
+		etag.Value = current.Value
+	}
+}
@@ -0,0 +1,136 @@
+package apimclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/internal/specdiff"
+	"github.com/foryouandyourcustomers/azapim/internal/versioning"
+)
+
+// VersionSuggestion is the version for a new spec derived from its changes to the latest deployed version
+type VersionSuggestion struct {
+	APIID          string `json:"apiId"`
+	LatestVersion  string `json:"latestVersion,omitempty"`
+	LatestRevision string `json:"latestRevision,omitempty"`
+	NextVersion    string `json:"nextVersion"`
+	// NextRevision is set if the spec is compatible with the latest version and can be deployed as its new revision
+	NextRevision string            `json:"nextRevision,omitempty"`
+	Reason       string            `json:"reason"`
+	Changes      []specdiff.Change `json:"changes"`
+}
+
+// NextVersion compares the spec of the definition with the latest version in the version set of the api and
+// suggests the next version. breaking changes require a new version, compatible changes a new revision of the
+// latest version. the initial version is suggested if no version is deployed
+func (apim *ApimClient) NextVersion(ctx context.Context, a *apidefinition.Definition, initial string) (*VersionSuggestion, error) {
+	ctx = logging.WithFields(ctx, apim.fields())
+	ctx = logging.WithFields(ctx, log.Fields{logging.FieldAPIID: a.APIID})
+	if err := requireInlineSpec(a); err != nil {
+		return nil, err
+	}
+	v := &VersionSuggestion{APIID: a.APIID, Changes: []specdiff.Change{}}
+
+	var apis []apimanagement.APIContract
+	err := apim.do(ctx, "list apis", func(ctx context.Context) (err error) {
+		apis, err = apim.APIClient.List(ctx, apim.ResourceGroup, apim.ServiceName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the list contains the current revision of each api
+	versions := map[string]apimanagement.APIContract{}
+	var names []string
+	for _, api := range apis {
+		if api.Name == nil || api.APIContractProperties == nil || api.APIVersion == nil || !inVersionSet(api, a.APIID) {
+			continue
+		}
+		versions[*api.APIVersion] = api
+		names = append(names, *api.APIVersion)
+	}
+	if len(versions) == 0 {
+		v.NextVersion = initial
+		v.Reason = "no version of the api is deployed"
+		return v, nil
+	}
+
+	v.LatestVersion = versioning.Latest(names)
+	latest := versions[v.LatestVersion]
+	// the next revision follows the highest revision of the version, not the current one
+	var revisions []apimanagement.APIRevisionContract
+	err = apim.do(ctx, "list api revisions", func(ctx context.Context) (err error) {
+		revisions, err = apim.APIRevisionClient.List(ctx, apim.ResourceGroup, apim.ServiceName, *latest.Name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := highestRevision(revisions); ok {
+		v.LatestRevision = strconv.Itoa(n)
+	}
+	logging.From(ctx).Infof("Comparing the openapi spec with the latest version %s", v.LatestVersion)
+	var deployed string
+	err = apim.do(ctx, "export api", func(ctx context.Context) (err error) {
+		deployed, err = apim.APIExportClient.Export(ctx, apim.ResourceGroup, apim.ServiceName, *latest.Name, apimanagement.ExportFormatOpenapiJSON)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	report, err := specdiff.Compare([]byte(deployed), []byte(a.OpenAPISpec))
+	if err != nil {
+		return nil, err
+	}
+	v.Changes = report.Changes
+
+	switch {
+	case report.Breaking():
+		if v.NextVersion, err = versioning.Next(v.LatestVersion); err != nil {
+			return nil, err
+		}
+		v.Reason = fmt.Sprintf("%d breaking changes", len(report.BreakingChanges()))
+	case len(report.Changes) > 0:
+		v.NextVersion = v.LatestVersion
+		v.NextRevision = nextRevision(v.LatestRevision)
+		v.Reason = fmt.Sprintf("%d compatible changes", len(report.Changes))
+	default:
+		v.NextVersion = v.LatestVersion
+		v.Reason = "no changes"
+	}
+	return v, nil
+}
+
+// inVersionSet returns true if the api belongs to the version set of the api id
+func inVersionSet(api apimanagement.APIContract, apiID string) bool {
+	return api.APIVersionSetID != nil && strings.HasSuffix(strings.ToLower(*api.APIVersionSetID), "/apiversionsets/"+strings.ToLower(apiID))
+}
+
+// highestRevision returns the highest number of the revisions, false if none has a numeric revision
+func highestRevision(revisions []apimanagement.APIRevisionContract) (int, bool) {
+	highest, ok := 0, false
+	for _, r := range revisions {
+		if r.APIRevision == nil {
+			continue
+		}
+		if n, err := strconv.Atoi(*r.APIRevision); err == nil && (!ok || n > highest) {
+			highest, ok = n, true
+		}
+	}
+	return highest, ok
+}
+
+func nextRevision(revision string) string {
+	n, err := strconv.Atoi(revision)
+	if err != nil {
+		return "2"
+	}
+	return strconv.Itoa(n + 1)
+}
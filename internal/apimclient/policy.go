@@ -1,16 +1,18 @@
 package apimclient
 
 import (
-	"github.com/Azure/azure-sdk-for-go/profiles/latest/apimanagement/mgmt/apimanagement"
-	"github.com/google/uuid"
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
 )
 
-// CreateOrUpdatePolicy updates the xml policy of the given api
+// CreateOrUpdatePolicy updates the xml policy of the given api if it wasn't changed since its etag was read
 func (apim *ApimClient) CreateOrUpdatePolicy(
+	ctx context.Context,
+	etag *ETag,
 	f apimanagement.PolicyContentFormat,
 	p string,
 	uid string,
-
 ) (apimanagement.PolicyContract, error) {
 	apiPolicy := apimanagement.PolicyContract{
 		PolicyContractProperties: &apimanagement.PolicyContractProperties{
@@ -18,13 +20,23 @@ func (apim *ApimClient) CreateOrUpdatePolicy(
 			Value:  &p,
 		},
 	}
-	apiPolicyContract, err := apim.PolicyClient.CreateOrUpdate(
-		apim.Ctx,
-		apim.ResourceGroup,
-		apim.ServiceName,
-		uid,
-		apiPolicy,
-		uuid.New().String(),
+	var apiPolicyContract apimanagement.PolicyContract
+	err := apim.conditionalUpdate(
+		ctx,
+		etag,
+		func(ctx context.Context, ifMatch string) error {
+			return apim.do(ctx, "create or update policy", func(ctx context.Context) (err error) {
+				apiPolicyContract, err = apim.PolicyClient.CreateOrUpdate(
+					ctx,
+					apim.ResourceGroup,
+					apim.ServiceName,
+					uid,
+					apiPolicy,
+					ifMatch,
+				)
+				return err
+			})
+		},
 	)
 	if err != nil {
 		return apiPolicyContract, err
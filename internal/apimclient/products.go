@@ -1,12 +1,28 @@
 package apimclient
 
-import "github.com/Azure/azure-sdk-for-go/profiles/latest/apimanagement/mgmt/apimanagement"
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+)
 
 // AssignToProduct assigns an API to a given (existing) product
-func (apim *ApimClient) AssignToProduct(p string, id string) (apimanagement.APIContract, error) {
-	contract, err := apim.ProductsAPIClient.CreateOrUpdate(apim.Ctx, apim.ResourceGroup, apim.ServiceName, p, id)
+func (apim *ApimClient) AssignToProduct(ctx context.Context, p string, id string) (apimanagement.APIContract, error) {
+	var contract apimanagement.APIContract
+	err := apim.do(ctx, "assign api to product", func(ctx context.Context) (err error) {
+		contract, err = apim.ProductsAPIClient.CreateOrUpdate(ctx, apim.ResourceGroup, apim.ServiceName, p, id)
+		return err
+	})
 	if err != nil {
 		return apimanagement.APIContract{}, err
 	}
 	return contract, nil
 }
+
+// RemoveFromProduct removes the API from the product and returns false if it wasn't assigned to it
+func (apim *ApimClient) RemoveFromProduct(ctx context.Context, p string, id string) (bool, error) {
+	return apim.find(ctx, "remove api from product", func(ctx context.Context) error {
+		_, err := apim.ProductsAPIClient.Delete(ctx, apim.ResourceGroup, apim.ServiceName, p, id)
+		return err
+	})
+}
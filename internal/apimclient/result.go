@@ -0,0 +1,74 @@
+package apimclient
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/foryouandyourcustomers/azapim/internal/specdiff"
+)
+
+// DeploymentResult contains the resources created or updated by CreateOrUpdate
+type DeploymentResult struct {
+	VersionSetID    string   `json:"versionSetId"`
+	APIID           string   `json:"apiId"`
+	APIName         string   `json:"apiName"`
+	APIVersion      string   `json:"apiVersion"`
+	APIRevision     string   `json:"apiRevision"`
+	APIPath         string   `json:"apiPath"`
+	ServiceURL      string   `json:"serviceUrl"`
+	GatewayURL      string   `json:"gatewayUrl,omitempty"`
+	PolicyID        string   `json:"policyId"`
+	Products        []string `json:"products"`
+	Tags            []string `json:"tags,omitempty"`
+	DurationSeconds float64  `json:"durationSeconds"`
+	// Warnings are risks of the deployed configuration, e.g. unencrypted protocols on a public gateway
+	Warnings []string `json:"warnings,omitempty"`
+	// RolledBack and RollbackFailures contain the reverted changes of a failed transactional deployment
+	RolledBack       []string `json:"rolledBack,omitempty"`
+	RollbackFailures []string `json:"rollbackFailures,omitempty"`
+	// DeploymentID is the id of the deployment in the history, RollbackOf the id of the re-applied deployment
+	DeploymentID string `json:"deploymentId,omitempty"`
+	RollbackOf   string `json:"rollbackOf,omitempty"`
+	// Changes are the differences to the spec of the deployed version, compared with FailOnBreaking
+	Changes []specdiff.Change `json:"changes,omitempty"`
+}
+
+// DeprecationResult contains the changes to a deprecated api version
+type DeprecationResult struct {
+	APIID               string   `json:"apiId"`
+	APIName             string   `json:"apiName"`
+	APIVersion          string   `json:"apiVersion"`
+	DisplayName         string   `json:"displayName"`
+	Deprecated          string   `json:"deprecated"`
+	Sunset              string   `json:"sunset"`
+	PolicyID            string   `json:"policyId"`
+	RemovedFromProducts []string `json:"removedFromProducts"`
+	DurationSeconds     float64  `json:"durationSeconds"`
+}
+
+// DisasterRecoveryResult contains the parameters of a backup or restore
+type DisasterRecoveryResult struct {
+	Operation       string  `json:"operation"`
+	ServiceName     string  `json:"serviceName"`
+	BackupName      string  `json:"backupName"`
+	StorageAccount  string  `json:"storageAccount"`
+	Container       string  `json:"container"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// gatewayURL returns the public url of a segment versioned api
+func gatewayURL(gateway string, path string, version string) string {
+	if gateway == "" {
+		return ""
+	}
+	u := strings.TrimSuffix(gateway, "/")
+	if p := strings.Trim(path, "/"); p != "" {
+		u = fmt.Sprintf("%s/%s", u, p)
+	}
+	return fmt.Sprintf("%s/%s", u, version)
+}
+
+func seconds(start time.Time) float64 {
+	return time.Since(start).Round(time.Millisecond).Seconds()
+}
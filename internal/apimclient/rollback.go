@@ -0,0 +1,344 @@
+package apimclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+)
+
+// RollbackError is returned if a transactional deployment failed. RolledBack contains the changes which
+// were reverted, Failed the changes which couldn't be reverted
+type RollbackError struct {
+	Err        error
+	RolledBack []string
+	Failed     []string
+}
+
+func (e *RollbackError) Error() string {
+	if len(e.Failed) > 0 {
+		return fmt.Sprintf("deployment failed, rollback incomplete (%s): %v", strings.Join(e.Failed, "; "), e.Err)
+	}
+	return fmt.Sprintf("deployment failed and was rolled back (%s): %v", strings.Join(e.RolledBack, "; "), e.Err)
+}
+
+// Unwrap returns the error of the failed deployment
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// transaction contains the state of the version set, api, policy and product assignments prior to a
+// deployment and the resources changed by the deployment. a nil transaction doesn't track anything
+type transaction struct {
+	versionSetID string
+	// apiid is the api assigned to products and tags, revisionID the deployed revision of it
+	apiid      string
+	revisionID string
+
+	// state prior to the deployment, nil if the resource didn't exist
+	versionSet *apimanagement.APIVersionSetContract
+	api        *apimanagement.APIContract
+	spec       string
+	schema     *apimanagement.SchemaContract
+	policy     *apimanagement.PolicyContract
+	assigned   map[string]bool
+	tagged     map[string]bool
+
+	// resources changed by the deployment
+	versionSetChanged bool
+	apiChanged        bool
+	schemaChanged     bool
+	policyChanged     bool
+	products          []string
+	tags              []string
+}
+
+// begin takes a snapshot of all resources the deployment of the api will change
+func (apim *ApimClient) begin(ctx context.Context, a *apidefinition.Definition) (*transaction, error) {
+	tx := &transaction{versionSetID: a.APIID, apiid: a.APIUniqueID, revisionID: a.RevisionID(), assigned: map[string]bool{}, tagged: map[string]bool{}}
+	if err := apim.snapshotVersionSet(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := apim.snapshotAPI(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := apim.snapshotPolicy(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	for _, p := range a.APIProducts {
+		found, err := apim.find(ctx, "snapshot product assignment", func(ctx context.Context) error {
+			_, err := apim.ProductsAPIClient.CheckEntityExists(ctx, apim.ResourceGroup, apim.ServiceName, p, a.APIUniqueID)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		tx.assigned[p] = found
+	}
+
+	for _, t := range a.Metadata.Tags {
+		found, err := apim.find(ctx, "snapshot tag assignment", func(ctx context.Context) error {
+			_, err := apim.TagClient.GetEntityStateByAPI(ctx, apim.ResourceGroup, apim.ServiceName, a.APIUniqueID, t)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		tx.tagged[t] = found
+	}
+	return tx, nil
+}
+
+// snapshotVersionSet takes a snapshot of the version set, it is taken again if the version set changed concurrently
+func (apim *ApimClient) snapshotVersionSet(ctx context.Context, tx *transaction) error {
+	var vs apimanagement.APIVersionSetContract
+	found, err := apim.find(ctx, "snapshot version set", func(ctx context.Context) (err error) {
+		vs, err = apim.VersionSetClient.Get(ctx, apim.ResourceGroup, apim.ServiceName, tx.versionSetID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	tx.versionSet = nil
+	if found {
+		tx.versionSet = &vs
+	}
+	return nil
+}
+
+// specFormat is the format a spec is exported in for the snapshot and imported in by the rollback
+type specFormat struct {
+	export  apimanagement.ExportFormat
+	content apimanagement.ContentFormat
+}
+
+// specFormats contains the formats of the api types with a spec. graphql apis are restored from the snapshot of
+// their schema, websocket apis have no spec
+var specFormats = map[apimanagement.APIType]specFormat{
+	apimanagement.APITypeHTTP: {apimanagement.ExportFormatOpenapiJSON, apimanagement.ContentFormatOpenapijson},
+	apimanagement.APITypeSoap: {apimanagement.ExportFormatWsdl, apimanagement.ContentFormatWsdl},
+}
+
+// snapshotAPI takes a snapshot of the api and its spec, the openapi spec of http apis, the wsdl of soap apis and
+// the schema of graphql apis. websocket apis have no spec
+func (apim *ApimClient) snapshotAPI(ctx context.Context, tx *transaction) error {
+	var api apimanagement.APIContract
+	found, err := apim.find(ctx, "snapshot api", func(ctx context.Context) (err error) {
+		api, err = apim.APIClient.Get(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	tx.api, tx.spec, tx.schema = nil, "", nil
+	if !found {
+		return nil
+	}
+	tx.api = &api
+	if apiType(&api) == apimanagement.APITypeGraphql {
+		return apim.snapshotSchema(ctx, tx)
+	}
+	format, ok := specFormats[apiType(&api)]
+	if !ok {
+		return nil
+	}
+	return apim.do(ctx, "export api", func(ctx context.Context) (err error) {
+		tx.spec, err = apim.APIExportClient.Export(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID, format.export)
+		return err
+	})
+}
+
+// snapshotSchema takes a snapshot of the schema of the graphql api
+func (apim *ApimClient) snapshotSchema(ctx context.Context, tx *transaction) error {
+	var schema apimanagement.SchemaContract
+	found, err := apim.find(ctx, "snapshot schema", func(ctx context.Context) (err error) {
+		schema, err = apim.SchemaClient.Get(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID, graphQLSchemaID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if found {
+		tx.schema = &schema
+	}
+	return nil
+}
+
+// apiType returns the type of the deployed api, http if not set
+func apiType(api *apimanagement.APIContract) apimanagement.APIType {
+	if api.APIContractProperties == nil || api.APIType == "" {
+		return apimanagement.APITypeHTTP
+	}
+	return api.APIType
+}
+
+// snapshotPolicy takes a snapshot of the policy of the api
+func (apim *ApimClient) snapshotPolicy(ctx context.Context, tx *transaction) error {
+	var policy apimanagement.PolicyContract
+	found, err := apim.find(ctx, "snapshot policy", func(ctx context.Context) (err error) {
+		policy, err = apim.PolicyClient.Get(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID, apimanagement.PolicyExportFormatXML)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	tx.policy = nil
+	if found {
+		tx.policy = &policy
+	}
+	return nil
+}
+
+// rollback restores the state prior to the deployment for all changed resources in reverse order
+func (apim *ApimClient) rollback(ctx context.Context, tx *transaction, cause error) *RollbackError {
+	e := &RollbackError{Err: cause, RolledBack: []string{}}
+	revert := func(change string, fn func(ctx context.Context) error) {
+		logging.From(ctx).Infof("Rollback: %s", change)
+		// a missing resource means the failed step didn't change it
+		found, err := apim.find(ctx, "rollback", fn)
+		if err == nil && !found {
+			logging.From(ctx).Infof("Rollback: nothing to revert, %s doesn't exist", change)
+			return
+		}
+		if err != nil {
+			logging.From(ctx).Errorf("Rollback failed: %s: %s", change, err)
+			e.Failed = append(e.Failed, fmt.Sprintf("%s: %v", change, err))
+			return
+		}
+		e.RolledBack = append(e.RolledBack, change)
+	}
+
+	// created tags are kept, they may be assigned to other apis in the meantime
+	for i := len(tx.tags) - 1; i >= 0; i-- {
+		t := tx.tags[i]
+		if tx.tagged[t] {
+			continue
+		}
+		revert(fmt.Sprintf("removed tag %s from api %s", t, tx.apiid), func(ctx context.Context) error {
+			_, err := apim.TagClient.DetachFromAPI(ctx, apim.ResourceGroup, apim.ServiceName, tx.apiid, t)
+			return err
+		})
+	}
+
+	for i := len(tx.products) - 1; i >= 0; i-- {
+		p := tx.products[i]
+		if tx.assigned[p] {
+			continue
+		}
+		revert(fmt.Sprintf("removed api %s from product %s", tx.apiid, p), func(ctx context.Context) error {
+			_, err := apim.ProductsAPIClient.Delete(ctx, apim.ResourceGroup, apim.ServiceName, p, tx.apiid)
+			return err
+		})
+	}
+
+	// the policy of a new api is deleted with the api
+	if tx.policyChanged && (tx.api != nil || !tx.apiChanged) {
+		if tx.policy != nil {
+			revert(fmt.Sprintf("restored policy of api %s", tx.revisionID), func(ctx context.Context) error {
+				p := apimanagement.PolicyContract{PolicyContractProperties: &apimanagement.PolicyContractProperties{
+					Format: apimanagement.PolicyContentFormatXML,
+					Value:  tx.policy.Value,
+				}}
+				_, err := apim.PolicyClient.CreateOrUpdate(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID, p, "*")
+				return err
+			})
+		} else {
+			revert(fmt.Sprintf("deleted policy of api %s", tx.revisionID), func(ctx context.Context) error {
+				_, err := apim.PolicyClient.Delete(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID, "*")
+				return err
+			})
+		}
+	}
+
+	// the schema of a new api is deleted with the api
+	if tx.schemaChanged && tx.api != nil {
+		if tx.schema != nil {
+			revert(fmt.Sprintf("restored schema of api %s", tx.revisionID), func(ctx context.Context) error {
+				s := apimanagement.SchemaContract{SchemaContractProperties: tx.schema.SchemaContractProperties}
+				_, err := apim.SchemaClient.CreateOrUpdate(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID, graphQLSchemaID, s, "*")
+				return err
+			})
+		} else {
+			revert(fmt.Sprintf("deleted schema of api %s", tx.revisionID), func(ctx context.Context) error {
+				_, err := apim.SchemaClient.Delete(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID, graphQLSchemaID, "*", nil)
+				return err
+			})
+		}
+	}
+
+	if tx.apiChanged {
+		if tx.api != nil {
+			revert(fmt.Sprintf("restored api %s", tx.revisionID), func(ctx context.Context) error {
+				_, err := apim.APIClient.CreateOrUpdate(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID, restoreParameters(tx.api, tx.spec), "*")
+				return err
+			})
+		} else {
+			revert(fmt.Sprintf("deleted api %s", tx.revisionID), func(ctx context.Context) error {
+				deleteRevisions := true
+				_, err := apim.APIClient.Delete(ctx, apim.ResourceGroup, apim.ServiceName, tx.revisionID, "*", &deleteRevisions)
+				return err
+			})
+		}
+	}
+
+	if tx.versionSetChanged {
+		if tx.versionSet != nil {
+			revert(fmt.Sprintf("restored version set %s", tx.versionSetID), func(ctx context.Context) error {
+				vs := apimanagement.APIVersionSetContract{APIVersionSetContractProperties: tx.versionSet.APIVersionSetContractProperties}
+				_, err := apim.VersionSetClient.CreateOrUpdate(ctx, apim.ResourceGroup, apim.ServiceName, tx.versionSetID, vs, "*")
+				return err
+			})
+		} else if tx.api == nil {
+			// a new version set is only used by the new api
+			revert(fmt.Sprintf("deleted version set %s", tx.versionSetID), func(ctx context.Context) error {
+				_, err := apim.VersionSetClient.Delete(ctx, apim.ResourceGroup, apim.ServiceName, tx.versionSetID, "*")
+				return err
+			})
+		}
+	}
+	return e
+}
+
+// restoreParameters returns the parameters to restore the api from its contract and exported spec in the format
+// of its type, apis without an exported spec are restored without importing one
+func restoreParameters(api *apimanagement.APIContract, spec string) apimanagement.APICreateOrUpdateParameter {
+	p := apimanagement.APICreateOrUpdateParameter{APICreateOrUpdateProperties: &apimanagement.APICreateOrUpdateProperties{}}
+	if api.APIContractProperties == nil {
+		return p
+	}
+	c := api.APIContractProperties
+	p.APICreateOrUpdateProperties = &apimanagement.APICreateOrUpdateProperties{
+		DisplayName:                   c.DisplayName,
+		ServiceURL:                    c.ServiceURL,
+		Path:                          c.Path,
+		Protocols:                     c.Protocols,
+		Description:                   c.Description,
+		AuthenticationSettings:        c.AuthenticationSettings,
+		SubscriptionKeyParameterNames: c.SubscriptionKeyParameterNames,
+		APIType:                       c.APIType,
+		APIRevision:                   c.APIRevision,
+		APIVersion:                    c.APIVersion,
+		APIVersionSetID:               c.APIVersionSetID,
+		SubscriptionRequired:          c.SubscriptionRequired,
+		IsCurrent:                     c.IsCurrent,
+		Contact:                       c.Contact,
+		License:                       c.License,
+		TermsOfServiceURL:             c.TermsOfServiceURL,
+	}
+	format, ok := specFormats[apiType(api)]
+	if !ok || spec == "" {
+		return p
+	}
+	p.Format = format.content
+	p.Value = &spec
+	if apiType(api) == apimanagement.APITypeSoap {
+		// the wsdl is imported as soap pass-through api, the contract doesn't contain the import type
+		p.SoapAPIType = apimanagement.SoapAPITypeSoapPassThrough
+	}
+	return p
+}
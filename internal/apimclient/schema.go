@@ -0,0 +1,29 @@
+package apimclient
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+)
+
+const (
+	// graphQLSchemaID is the id of the schema of graphql apis
+	graphQLSchemaID = "graphql"
+	// graphQLContentType is the content type of graphql schemas
+	graphQLContentType = "application/vnd.ms-azure-apim.graphql.schema"
+)
+
+// CreateOrUpdateGraphQLSchema uploads the schema of the graphql api
+func (apim *ApimClient) CreateOrUpdateGraphQLSchema(ctx context.Context, schema string, uid string) (apimanagement.SchemaContract, error) {
+	contentType := graphQLContentType
+	parameters := apimanagement.SchemaContract{SchemaContractProperties: &apimanagement.SchemaContractProperties{
+		ContentType:              &contentType,
+		SchemaDocumentProperties: &apimanagement.SchemaDocumentProperties{Value: &schema},
+	}}
+	var contract apimanagement.SchemaContract
+	err := apim.do(ctx, "upload graphql schema", func(ctx context.Context) (err error) {
+		contract, err = apim.SchemaClient.CreateOrUpdate(ctx, apim.ResourceGroup, apim.ServiceName, uid, graphQLSchemaID, parameters, "")
+		return err
+	})
+	return contract, err
+}
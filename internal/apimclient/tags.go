@@ -0,0 +1,40 @@
+package apimclient
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+)
+
+// tagID matches the characters allowed in the id of a tag
+var tagID = regexp.MustCompile(`^[^*#&+:<>?/%\\]+$`)
+
+// AssignTag assigns the tag to the API, the tag is created with its id as display name if it doesn't exist
+func (apim *ApimClient) AssignTag(ctx context.Context, t string, id string) error {
+	if !tagID.MatchString(t) {
+		return fmt.Errorf("invalid tag '%s', tags must not contain any of *#&+:<>?/%%\\", t)
+	}
+	found, err := apim.find(ctx, "get tag", func(ctx context.Context) error {
+		_, err := apim.TagClient.GetEntityState(ctx, apim.ResourceGroup, apim.ServiceName, t)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		tag := apimanagement.TagCreateUpdateParameters{TagContractProperties: &apimanagement.TagContractProperties{DisplayName: &t}}
+		err := apim.do(ctx, "create tag", func(ctx context.Context) error {
+			_, err := apim.TagClient.CreateOrUpdate(ctx, apim.ResourceGroup, apim.ServiceName, t, tag, "")
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return apim.do(ctx, "assign tag to api", func(ctx context.Context) error {
+		_, err := apim.TagClient.AssignToAPI(ctx, apim.ResourceGroup, apim.ServiceName, id, t)
+		return err
+	})
+}
@@ -1,12 +1,15 @@
 package apimclient
 
 import (
-	"github.com/Azure/azure-sdk-for-go/profiles/latest/apimanagement/mgmt/apimanagement"
-	"github.com/google/uuid"
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
 )
 
-// CreateOrUpdateVersionSet create or updates the specified version set of the api
+// CreateOrUpdateVersionSet create or updates the specified version set of the api if it wasn't changed since its etag was read
 func (apim *ApimClient) CreateOrUpdateVersionSet(
+	ctx context.Context,
+	etag *ETag,
 	dn string,
 	vs apimanagement.VersioningScheme,
 	id string,
@@ -18,13 +21,23 @@ func (apim *ApimClient) CreateOrUpdateVersionSet(
 		},
 	}
 
-	apiVersionSetContract, err := apim.VersionSetClient.CreateOrUpdate(
-		apim.Ctx,
-		apim.ResourceGroup,
-		apim.ServiceName,
-		id,
-		apiVersionSet,
-		uuid.New().String())
+	var apiVersionSetContract apimanagement.APIVersionSetContract
+	err := apim.conditionalUpdate(
+		ctx,
+		etag,
+		func(ctx context.Context, ifMatch string) error {
+			return apim.do(ctx, "create or update version set", func(ctx context.Context) (err error) {
+				apiVersionSetContract, err = apim.VersionSetClient.CreateOrUpdate(
+					ctx,
+					apim.ResourceGroup,
+					apim.ServiceName,
+					id,
+					apiVersionSet,
+					ifMatch)
+				return err
+			})
+		},
+	)
 	if err != nil {
 		return apiVersionSetContract, err
 	}
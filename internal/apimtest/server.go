@@ -0,0 +1,667 @@
+// Package apimtest provides a local http server emulating the subset of the azure resource
+// manager endpoints used by azapim. It allows to run end-to-end tests without an azure subscription.
+package apimtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Server is a fake azure resource manager for api management services and storage accounts
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	resources  map[string]map[string]interface{}
+	etags      map[string]int
+	specs      map[string]string
+	blobs      map[string]blob
+	keys       map[string][]string
+	backups    map[string]map[string]interface{}
+	operations map[string]*operation
+	failures   map[string][]int
+	requests   []string
+	counter    int
+
+	// PendingPolls is the number of polls a long running operation stays in progress
+	PendingPolls int
+}
+
+// operation is a long running operation which finishes after a number of polls
+type operation struct {
+	pending int
+	status  int
+	body    map[string]interface{}
+}
+
+// NewServer starts a new fake azure resource manager. it has to be closed by the caller
+func NewServer() *Server {
+	s := &Server{
+		resources:    map[string]map[string]interface{}{},
+		etags:        map[string]int{},
+		specs:        map[string]string{},
+		blobs:        map[string]blob{},
+		keys:         map[string][]string{},
+		backups:      map[string]map[string]interface{}{},
+		operations:   map[string]*operation{},
+		failures:     map[string][]int{},
+		PendingPolls: 1,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// ServiceID returns the resource id of an api management service
+func ServiceID(subscription string, resourceGroup string, serviceName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ApiManagement/service/%s", subscription, resourceGroup, serviceName)
+}
+
+// StorageAccountID returns the resource id of a storage account
+func StorageAccountID(subscription string, resourceGroup string, accountName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s", subscription, resourceGroup, accountName)
+}
+
+// AddService adds an api management service in the succeeded provisioning state
+func (s *Server) AddService(subscription string, resourceGroup string, serviceName string) {
+	id := ServiceID(subscription, resourceGroup, serviceName)
+	s.Put(id, map[string]interface{}{
+		"location": "westeurope",
+		"sku":      map[string]interface{}{"name": "Developer", "capacity": 1},
+		"properties": map[string]interface{}{
+			"provisioningState": "Succeeded",
+			"gatewayUrl":        fmt.Sprintf("https://%s.azure-api.net", serviceName),
+			"publisherEmail":    "apim@example.com",
+			"publisherName":     "apimtest",
+		},
+	})
+}
+
+// AddProduct adds a product to an existing api management service
+func (s *Server) AddProduct(serviceID string, productID string) {
+	s.Put(serviceID+"/products/"+productID, map[string]interface{}{
+		"properties": map[string]interface{}{"displayName": productID, "state": "published"},
+	})
+}
+
+// AddStorageAccount adds a storage account with the given access keys
+func (s *Server) AddStorageAccount(subscription string, resourceGroup string, accountName string, keys ...string) {
+	id := StorageAccountID(subscription, resourceGroup, accountName)
+	s.Put(id, map[string]interface{}{"location": "westeurope"})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[normalize(id)] = keys
+}
+
+// SetPermissions sets the permissions of the authenticated principal on the given scope
+func (s *Server) SetPermissions(scope string, actions []string, notActions []string) {
+	s.Put(scope+"/providers/Microsoft.Authorization/permissions", map[string]interface{}{
+		"value": []map[string]interface{}{{"actions": actions, "notActions": notActions}},
+	})
+}
+
+// Put stores a resource with the given id, overwriting existing resources
+func (s *Server) Put(id string, body map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(id, body)
+}
+
+// Get returns a copy of the resource with the given id
+func (s *Server) Get(id string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.resources[normalize(id)]
+	if !ok {
+		return nil, false
+	}
+	return copyResource(r), true
+}
+
+// Backup returns the parameters of the backup with the given name
+func (s *Server) Backup(name string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.backups[name]
+	return b, ok
+}
+
+// Fail lets the next requests with the given method and path suffix fail with the status codes
+func (s *Server) Fail(method string, pathSuffix string, statusCodes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := method + " " + normalize(pathSuffix)
+	s.failures[k] = append(s.failures[k], statusCodes...)
+}
+
+// Spec returns the openapi spec imported for the api with the given id
+func (s *Server) Spec(apiID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spec, ok := s.specs[normalize(apiID)]
+	return spec, ok
+}
+
+// Requests returns all received requests as "METHOD path" in the order they were received
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := strings.TrimSuffix(r.URL.Path, "/")
+	s.requests = append(s.requests, r.Method+" "+p)
+
+	if code, ok := s.failure(r.Method, p); ok {
+		if code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable {
+			// azure asks clients to back off, no need to wait in tests
+			w.Header().Set("Retry-After", "0")
+		}
+		writeError(w, code, "InjectedFailure", fmt.Sprintf("injected failure for %s %s", r.Method, p))
+		return
+	}
+
+	if strings.HasPrefix(p, "/operations/") {
+		s.pollOperation(w, path.Base(p))
+		return
+	}
+	if strings.HasPrefix(p, BlobPrefix+"/") {
+		s.blob(w, r, strings.TrimPrefix(p, BlobPrefix+"/"))
+		return
+	}
+	if strings.HasPrefix(p, exportPrefix) {
+		s.download(w, strings.TrimPrefix(p, exportPrefix))
+		return
+	}
+	if r.Method == http.MethodGet && r.URL.Query().Get("export") == "true" {
+		s.export(w, r, p)
+		return
+	}
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		b, _ := ioutil.ReadAll(r.Body)
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &body); err != nil {
+				writeError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+				return
+			}
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(normalize(p), "/listkeys"):
+		s.listKeys(w, path.Dir(p))
+	case r.Method == http.MethodPost && (strings.HasSuffix(p, "/backup") || strings.HasSuffix(p, "/restore")):
+		s.backupRestore(w, r, path.Dir(p), path.Base(p), body)
+	case r.Method == http.MethodPut:
+		s.put(w, r, p, body)
+	case r.Method == http.MethodPatch:
+		s.patch(w, r, p, body)
+	case r.Method == http.MethodHead:
+		if _, ok := s.resources[normalize(p)]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", s.etag(p))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet:
+		res, ok := s.resources[normalize(p)]
+		if !ok && strings.HasSuffix(normalize(p), "/providers/microsoft.authorization/permissions") {
+			// principals without role assignments have no permissions
+			writeJSON(w, http.StatusOK, map[string]interface{}{"value": []interface{}{}})
+			return
+		}
+		if !ok && normalize(p) == "/subscriptions" {
+			s.listSubscriptions(w)
+			return
+		}
+		if _, parent := s.resources[normalize(path.Dir(p))]; !ok && parent && path.Base(p) == "apis" {
+			s.list(w, p)
+			return
+		}
+		if _, api := s.resources[normalize(path.Dir(p))]; !ok && api && path.Base(p) == "revisions" {
+			s.listRevisions(w, path.Dir(p))
+			return
+		}
+		if !ok && strings.HasSuffix(normalize(p), "/providers/microsoft.apimanagement/service") {
+			s.listServices(w, path.Dir(path.Dir(path.Dir(p))))
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("resource %s not found", p))
+			return
+		}
+		w.Header().Set("ETag", s.etag(p))
+		writeJSON(w, http.StatusOK, res)
+	case r.Method == http.MethodDelete:
+		if _, ok := s.resources[normalize(p)]; !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		s.delete(p)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, p string, body map[string]interface{}) {
+	parent := path.Dir(path.Dir(p))
+	if _, ok := s.resources[normalize(parent)]; !ok {
+		writeError(w, http.StatusNotFound, "ParentResourceNotFound", fmt.Sprintf("parent resource %s not found", parent))
+		return
+	}
+	if body == nil {
+		body = map[string]interface{}{}
+	}
+	if m := r.Header.Get("If-Match"); m != "" {
+		_, exists := s.resources[normalize(p)]
+		if !exists || (m != "*" && m != s.etag(p)) {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", fmt.Sprintf("etag %s of %s doesn't match", m, p))
+			return
+		}
+	}
+
+	switch {
+	case isProductAPI(p):
+		// product api links return the linked api contract
+		api, ok := s.resources[normalize(path.Dir(path.Dir(parent))+"/apis/"+path.Base(p))]
+		if !ok {
+			writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("api %s not found", path.Base(p)))
+			return
+		}
+		s.store(p, body)
+		writeJSON(w, http.StatusCreated, api)
+	case isAPI(p):
+		// apis are created with a long running operation
+		if props, ok := body["properties"].(map[string]interface{}); ok {
+			if v, ok := props["value"].(string); ok {
+				s.specs[normalize(p)] = v
+			}
+			delete(props, "value")
+			delete(props, "format")
+		}
+		res := s.store(p, body)
+		w.Header().Set("ETag", s.etag(p))
+		s.startOperation(w, r, http.StatusOK, res)
+	default:
+		res := s.store(p, body)
+		w.Header().Set("ETag", s.etag(p))
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// patch merges the properties of the body into the existing resource
+func (s *Server) patch(w http.ResponseWriter, r *http.Request, p string, body map[string]interface{}) {
+	res, ok := s.resources[normalize(p)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("resource %s not found", p))
+		return
+	}
+	if m := r.Header.Get("If-Match"); m != "" && m != "*" && m != s.etag(p) {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", fmt.Sprintf("etag %s of %s doesn't match", m, p))
+		return
+	}
+	res = copyResource(res)
+	props, _ := res["properties"].(map[string]interface{})
+	if update, ok := body["properties"].(map[string]interface{}); ok {
+		for k, v := range update {
+			props[k] = v
+		}
+	}
+	s.store(p, res)
+	w.Header().Set("ETag", s.etag(p))
+	writeJSON(w, http.StatusOK, res)
+}
+
+// list returns the resources of the collection. like azure the apis contain the current revision of each api only
+func (s *Server) list(w http.ResponseWriter, collection string) {
+	value := []interface{}{}
+	prefix := normalize(collection) + "/"
+	keys := make([]string, 0, len(s.resources))
+	for k := range s.resources {
+		name := strings.TrimPrefix(k, prefix)
+		if strings.HasPrefix(k, prefix) && !strings.Contains(name, "/") && !strings.Contains(name, ";rev=") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value = append(value, s.resources[k])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": value})
+}
+
+// listRevisions returns the revisions of the api, the copies named {api};rev={revision} and the api itself as
+// the current revision
+func (s *Server) listRevisions(w http.ResponseWriter, apiID string) {
+	value := []interface{}{}
+	base := normalize(apiID)
+	keys := []string{}
+	for k := range s.resources {
+		if k == base || strings.HasPrefix(k, base+";rev=") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		res := s.resources[k]
+		props, _ := res["properties"].(map[string]interface{})
+		value = append(value, map[string]interface{}{
+			"apiId":       res["id"],
+			"apiRevision": props["apiRevision"],
+			"isCurrent":   k == base,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": value})
+}
+
+// listSubscriptions returns all subscriptions containing resources
+func (s *Server) listSubscriptions(w http.ResponseWriter) {
+	ids := map[string]bool{}
+	for k := range s.resources {
+		if parts := strings.Split(k, "/"); len(parts) > 2 && parts[1] == "subscriptions" {
+			ids[parts[2]] = true
+		}
+	}
+	var value []interface{}
+	for id := range ids {
+		value = append(value, map[string]interface{}{
+			"id":             "/subscriptions/" + id,
+			"subscriptionId": id,
+			"displayName":    id,
+			"state":          "Enabled",
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": value})
+}
+
+// listServices returns all api management services of the subscription
+func (s *Server) listServices(w http.ResponseWriter, subscriptionID string) {
+	value := []interface{}{}
+	prefix := normalize(subscriptionID) + "/resourcegroups/"
+	for k, res := range s.resources {
+		// {resource group}/providers/microsoft.apimanagement/service/{name}
+		rest := strings.TrimPrefix(k, prefix)
+		parts := strings.Split(rest, "/")
+		if rest != k && len(parts) == 5 && strings.Join(parts[1:4], "/") == "providers/microsoft.apimanagement/service" {
+			value = append(value, res)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": value})
+}
+
+func (s *Server) backupRestore(w http.ResponseWriter, r *http.Request, serviceID string, action string, body map[string]interface{}) {
+	service, ok := s.resources[normalize(serviceID)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("service %s not found", serviceID))
+		return
+	}
+	name, _ := body["backupName"].(string)
+	if action == "restore" {
+		if _, ok := s.backups[name]; !ok {
+			writeError(w, http.StatusBadRequest, "InvalidParameters", fmt.Sprintf("backup %s not found", name))
+			return
+		}
+	} else {
+		s.backups[name] = body
+	}
+	s.startOperation(w, r, http.StatusOK, service)
+}
+
+func (s *Server) listKeys(w http.ResponseWriter, accountID string) {
+	keys, ok := s.keys[normalize(accountID)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("storage account %s not found", accountID))
+		return
+	}
+	result := []map[string]interface{}{}
+	for i, k := range keys {
+		result = append(result, map[string]interface{}{"keyName": fmt.Sprintf("key%d", i+1), "value": k, "permissions": "FULL"})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": result})
+}
+
+// exportPrefix is the path of the links to exported specs
+const exportPrefix = "/exports"
+
+// export answers with a link to the imported spec of the api like the api export of azure
+func (s *Server) export(w http.ResponseWriter, r *http.Request, apiID string) {
+	if _, ok := s.resources[normalize(apiID)]; !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("api %s not found", apiID))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     apiID,
+		"format": r.URL.Query().Get("format"),
+		"value":  map[string]interface{}{"link": fmt.Sprintf("http://%s%s%s", r.Host, exportPrefix, apiID)},
+	})
+}
+
+func (s *Server) download(w http.ResponseWriter, apiID string) {
+	spec, ok := s.specs[normalize(apiID)]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(spec))
+}
+
+// BlobPrefix is the path of the emulated blob service, the blob endpoint of a storage account is
+// {server url}/blob/{account}
+const BlobPrefix = "/blob"
+
+// blob is a stored blob with its metadata
+type blob struct {
+	body     []byte
+	metadata map[string]string
+}
+
+// blob emulates the container and blob operations of the blob service used by the deployment history
+func (s *Server) blob(w http.ResponseWriter, r *http.Request, p string) {
+	parts := strings.SplitN(p, "/", 3)
+	if len(parts) < 2 {
+		writeError(w, http.StatusBadRequest, "InvalidUri", p)
+		return
+	}
+	account, container := parts[0], parts[1]
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey "+account+":") {
+		writeError(w, http.StatusForbidden, "AuthenticationFailed", "shared key authorization required")
+		return
+	}
+	containerKey := account + "/" + container
+
+	if len(parts) == 2 {
+		switch {
+		case r.Method == http.MethodPut:
+			if _, ok := s.blobs[containerKey]; ok {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			s.blobs[containerKey] = blob{}
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet && r.URL.Query().Get("comp") == "list":
+			s.listBlobs(w, containerKey, r.URL.Query().Get("prefix"))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "UnsupportedHttpVerb", r.Method)
+		}
+		return
+	}
+
+	if _, ok := s.blobs[containerKey]; !ok {
+		writeError(w, http.StatusNotFound, "ContainerNotFound", container)
+		return
+	}
+	key := containerKey + "/" + parts[2]
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		b := blob{body: body, metadata: map[string]string{}}
+		for k := range r.Header {
+			if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-meta-") {
+				b.metadata[strings.TrimPrefix(k, "x-ms-meta-")] = r.Header.Get(k)
+			}
+		}
+		s.blobs[key] = b
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		b, ok := s.blobs[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "BlobNotFound", parts[2])
+			return
+		}
+		_, _ = w.Write(b.body)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UnsupportedHttpVerb", r.Method)
+	}
+}
+
+func (s *Server) listBlobs(w http.ResponseWriter, containerKey string, prefix string) {
+	var names []string
+	for k := range s.blobs {
+		if name := strings.TrimPrefix(k, containerKey+"/"); name != k && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+	for _, n := range names {
+		b.WriteString("<Blob><Name>" + html.EscapeString(n) + "</Name><Metadata>")
+		for k, v := range s.blobs[containerKey+"/"+n].metadata {
+			b.WriteString("<" + k + ">" + html.EscapeString(v) + "</" + k + ">")
+		}
+		b.WriteString("</Metadata></Blob>")
+	}
+	b.WriteString("</Blobs><NextMarker /></EnumerationResults>")
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// startOperation answers with an azure async operation which succeeds after PendingPolls polls
+func (s *Server) startOperation(w http.ResponseWriter, r *http.Request, status int, body map[string]interface{}) {
+	s.counter++
+	id := fmt.Sprintf("op%d", s.counter)
+	s.operations[id] = &operation{pending: s.PendingPolls, status: status, body: body}
+	w.Header().Set("Azure-AsyncOperation", fmt.Sprintf("http://%s/operations/%s", r.Host, id))
+	w.Header().Set("Retry-After", "0")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) pollOperation(w http.ResponseWriter, id string) {
+	op, ok := s.operations[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "OperationNotFound", id)
+		return
+	}
+	w.Header().Set("Retry-After", "0")
+	if op.pending > 0 {
+		op.pending--
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "InProgress"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "Succeeded"})
+}
+
+func (s *Server) failure(method string, p string) (int, bool) {
+	for k, codes := range s.failures {
+		parts := strings.SplitN(k, " ", 2)
+		if parts[0] != method || !strings.HasSuffix(normalize(p), parts[1]) || len(codes) == 0 {
+			continue
+		}
+		s.failures[k] = codes[1:]
+		return codes[0], true
+	}
+	return 0, false
+}
+
+// store saves the resource and returns the stored representation
+func (s *Server) store(id string, body map[string]interface{}) map[string]interface{} {
+	res := copyResource(body)
+	res["id"] = id
+	res["name"] = path.Base(id)
+	res["type"] = resourceType(id)
+	if _, ok := res["properties"]; !ok {
+		res["properties"] = map[string]interface{}{}
+	}
+	s.resources[normalize(id)] = res
+	s.etags[normalize(id)]++
+	return res
+}
+
+// etag returns the etag of the resource, it changes with every update
+func (s *Server) etag(id string) string {
+	return fmt.Sprintf("\"%d\"", s.etags[normalize(id)])
+}
+
+// delete removes the resource and all its child resources
+func (s *Server) delete(id string) {
+	n := normalize(id)
+	for k := range s.resources {
+		if k == n || strings.HasPrefix(k, n+"/") {
+			delete(s.resources, k)
+			delete(s.etags, k)
+			delete(s.specs, k)
+		}
+	}
+}
+
+func isAPI(p string) bool {
+	return path.Base(path.Dir(p)) == "apis" && path.Base(path.Dir(path.Dir(path.Dir(p)))) == "service"
+}
+
+func isProductAPI(p string) bool {
+	return path.Base(path.Dir(p)) == "apis" && path.Base(path.Dir(path.Dir(path.Dir(p)))) == "products"
+}
+
+// resourceType returns the arm resource type of the given resource id
+func resourceType(id string) string {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	for i, p := range parts {
+		if strings.EqualFold(p, "providers") && i+1 < len(parts) {
+			t := parts[i+1]
+			for j := i + 2; j < len(parts); j += 2 {
+				t += "/" + parts[j]
+			}
+			return t
+		}
+	}
+	return ""
+}
+
+func normalize(p string) string {
+	return strings.ToLower(strings.TrimSuffix(p, "/"))
+}
+
+func copyResource(r map[string]interface{}) map[string]interface{} {
+	b, _ := json.Marshal(r)
+	c := map[string]interface{}{}
+	_ = json.Unmarshal(b, &c)
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message},
+	})
+}
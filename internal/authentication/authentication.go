@@ -0,0 +1,187 @@
+// Package authentication creates the authorizer used for all requests against azure
+package authentication
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+)
+
+// Mode defines how azapim authenticates against azure
+type Mode string
+
+const (
+	// ModeAzureCLI uses the login of the az cli
+	ModeAzureCLI Mode = "azcli"
+	// ModeServicePrincipalSecret uses a service principal with a client secret
+	ModeServicePrincipalSecret Mode = "sp-secret"
+	// ModeServicePrincipalCertificate uses a service principal with a client certificate
+	ModeServicePrincipalCertificate Mode = "sp-certificate"
+	// ModeManagedIdentity uses the system or user assigned managed identity
+	ModeManagedIdentity Mode = "msi"
+	// ModeFederatedTokenFile uses a service principal with a federated token read from a file (workload identity federation)
+	ModeFederatedTokenFile Mode = "federated-token-file"
+)
+
+// Modes contains all supported authentication modes
+var Modes = []Mode{
+	ModeAzureCLI,
+	ModeServicePrincipalSecret,
+	ModeServicePrincipalCertificate,
+	ModeManagedIdentity,
+	ModeFederatedTokenFile,
+}
+
+// Settings contains the authentication mode and the credentials required by the mode.
+// tokens are requested for the azure environment, the public cloud if not set
+type Settings struct {
+	Mode                Mode
+	Environment         azure.Environment
+	TenantID            string
+	ClientID            string
+	ClientSecret        string
+	CertificatePath     string
+	CertificatePassword string
+	FederatedTokenFile  string
+}
+
+// MissingCredentialError is returned if a credential required by the authentication mode is not set
+type MissingCredentialError struct {
+	Mode    Mode
+	Setting string
+}
+
+func (e *MissingCredentialError) Error() string {
+	return fmt.Sprintf("authentication mode '%s' requires %s", e.Mode, e.Setting)
+}
+
+// Error is returned if the authorizer can't be created
+type Error struct {
+	Mode Mode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("unable to authenticate with mode '%s': %v", e.Mode, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ParseMode returns the authentication mode with the given name
+func ParseMode(m string) (Mode, error) {
+	for _, v := range Modes {
+		if strings.EqualFold(string(v), m) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unknown authentication mode '%s', valid modes are %s", m, enum.Join(Modes))
+}
+
+// NewAuthorizer returns the authorizer for the configured authentication mode
+func NewAuthorizer(s Settings) (autorest.Authorizer, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	env := s.Environment
+	if env.Name == "" {
+		env = azure.PublicCloud
+	}
+	resource := env.TokenAudience
+	if resource == "" {
+		resource = env.ResourceManagerEndpoint
+	}
+
+	var a autorest.Authorizer
+	var err error
+	switch s.Mode {
+	case ModeAzureCLI:
+		a, err = auth.NewAuthorizerFromCLIWithResource(resource)
+	case ModeServicePrincipalSecret:
+		c := auth.NewClientCredentialsConfig(s.ClientID, s.ClientSecret, s.TenantID)
+		c.Resource = resource
+		c.AADEndpoint = env.ActiveDirectoryEndpoint
+		a, err = c.Authorizer()
+	case ModeServicePrincipalCertificate:
+		c := auth.NewClientCertificateConfig(s.CertificatePath, s.CertificatePassword, s.ClientID, s.TenantID)
+		c.Resource = resource
+		c.AADEndpoint = env.ActiveDirectoryEndpoint
+		a, err = c.Authorizer()
+	case ModeManagedIdentity:
+		c := auth.NewMSIConfig()
+		c.Resource = resource
+		c.ClientID = s.ClientID
+		a, err = c.Authorizer()
+	case ModeFederatedTokenFile:
+		a, err = federatedTokenAuthorizer(s, env.ActiveDirectoryEndpoint, resource)
+	}
+	if err != nil {
+		return nil, &Error{Mode: s.Mode, Err: err}
+	}
+	return a, nil
+}
+
+// validate checks that all credentials required by the authentication mode are set
+func (s Settings) validate() error {
+	required := map[Mode][][2]string{
+		ModeServicePrincipalSecret: {
+			{s.TenantID, "a tenant id"},
+			{s.ClientID, "a client id"},
+			{s.ClientSecret, "a client secret"},
+		},
+		ModeServicePrincipalCertificate: {
+			{s.TenantID, "a tenant id"},
+			{s.ClientID, "a client id"},
+			{s.CertificatePath, "a client certificate"},
+		},
+		ModeFederatedTokenFile: {
+			{s.TenantID, "a tenant id"},
+			{s.ClientID, "a client id"},
+			{s.FederatedTokenFile, "a federated token file"},
+		},
+	}
+
+	if _, err := ParseMode(string(s.Mode)); err != nil {
+		return err
+	}
+	for _, r := range required[s.Mode] {
+		if r[0] == "" {
+			return &MissingCredentialError{Mode: s.Mode, Setting: r[1]}
+		}
+	}
+	return nil
+}
+
+// federatedTokenAuthorizer exchanges the federated token read from file for an azure token.
+// the file is read on every token refresh as it is rotated by the token issuer
+func federatedTokenAuthorizer(s Settings, aadEndpoint string, resource string) (autorest.Authorizer, error) {
+	oauthConfig, err := adal.NewOAuthConfig(aadEndpoint, s.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	readToken := func() (string, error) {
+		t, err := ioutil.ReadFile(s.FederatedTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read federated token file '%s': %w", s.FederatedTokenFile, err)
+		}
+		return strings.TrimSpace(string(t)), nil
+	}
+	if _, err := readToken(); err != nil {
+		return nil, err
+	}
+	spt, err := adal.NewServicePrincipalTokenFromFederatedTokenCallback(*oauthConfig, s.ClientID, readToken, resource)
+	if err != nil {
+		return nil, err
+	}
+	return autorest.NewBearerAuthorizer(spt), nil
+}
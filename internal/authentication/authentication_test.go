@@ -0,0 +1,66 @@
+package authentication
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	for _, m := range Modes {
+		got, err := ParseMode(string(m))
+		if err != nil || got != m {
+			t.Errorf("ParseMode(%s) = %s, %v", m, got, err)
+		}
+	}
+	if _, err := ParseMode("unknown"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestNewAuthorizerMissingCredentials(t *testing.T) {
+	tests := []struct {
+		settings Settings
+		missing  string
+	}{
+		{Settings{Mode: ModeServicePrincipalSecret, ClientID: "id", ClientSecret: "secret"}, "a tenant id"},
+		{Settings{Mode: ModeServicePrincipalSecret, TenantID: "tenant", ClientID: "id"}, "a client secret"},
+		{Settings{Mode: ModeServicePrincipalCertificate, TenantID: "tenant", ClientID: "id"}, "a client certificate"},
+		{Settings{Mode: ModeFederatedTokenFile, TenantID: "tenant"}, "a client id"},
+		{Settings{Mode: ModeFederatedTokenFile, TenantID: "tenant", ClientID: "id"}, "a federated token file"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.settings.Mode)+" "+tt.missing, func(t *testing.T) {
+			_, err := NewAuthorizer(tt.settings)
+			var credErr *MissingCredentialError
+			if !errors.As(err, &credErr) {
+				t.Fatalf("error = %v, want MissingCredentialError", err)
+			}
+			if credErr.Setting != tt.missing {
+				t.Errorf("missing = %s, want %s", credErr.Setting, tt.missing)
+			}
+		})
+	}
+}
+
+func TestNewAuthorizerFederatedTokenFile(t *testing.T) {
+	s := Settings{
+		Mode:               ModeFederatedTokenFile,
+		TenantID:           "00000000-0000-0000-0000-000000000000",
+		ClientID:           "00000000-0000-0000-0000-000000000001",
+		FederatedTokenFile: filepath.Join(t.TempDir(), "token"),
+	}
+
+	var authErr *Error
+	if _, err := NewAuthorizer(s); !errors.As(err, &authErr) {
+		t.Fatalf("error = %v, want Error for missing token file", err)
+	}
+
+	if err := ioutil.WriteFile(s.FederatedTokenFile, []byte("token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAuthorizer(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
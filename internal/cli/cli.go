@@ -2,45 +2,457 @@ package cli
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
+	"github.com/foryouandyourcustomers/azapim/internal/authentication"
+	"github.com/foryouandyourcustomers/azapim/internal/cloud"
+	"github.com/foryouandyourcustomers/azapim/internal/config"
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/internal/output"
+	"github.com/foryouandyourcustomers/azapim/internal/telemetry"
+	"github.com/foryouandyourcustomers/azapim/pkg/azapim"
 	ucli "github.com/urfave/cli/v2"
 )
 
-var (
-	apimClient apimclient.ApimClient
+// telemetryShutdownTimeout is the maximum time to export the remaining spans and metrics after a command
+const telemetryShutdownTimeout = 10 * time.Second
+
+// service identifies the api management service all commands are executed against
+type service struct {
+	Subscription  string
+	ResourceGroup string
+	ServiceName   string
+}
+
+// state holds all values of a single cli invocation
+type state struct {
+	service     service
+	cloud       string
+	armEndpoint string
+	armAudience string
+	authMode    string
+	auth        authentication.Settings
+	output      string
+	retry       azapim.RetryPolicy
+	timeout     time.Duration
+	apiPolling  time.Duration
+	drPolling   time.Duration
+	onConflict  string
+	cancel      context.CancelFunc
+	telemetry   telemetry.Config
+	exporter    string
+	providers   *telemetry.Providers
+	logLevel    string
+	logFormat   string
+	configPath  string
+	profile     string
+	config      *config.Effective
+	serviceID   string
+	lookedUp    bool
+	client      *azapim.Client
+	apiDef      azapim.Definition
+	dr          azapim.DisasterRecovery
+	history     azapim.HistoryOptions
+	fetch       fetchOptions
+	transform   transformOptions
+	metadata    metadataOptions
+
+	// logger writes the log entries of the invocation, redactor redacts its secrets from the entries and errors
+	logger   *log.Logger
+	redactor *logging.Redactor
+
+	// newAuthorizer and newClient create the authorizer and the api management client, replaceable for tests
+	// baseDelay is the initial delay between retries, replaceable for tests
+	// findConfig returns the config file used if none is set, no file is used if nil
+	// findService looks up the subscription and resource group of a service by its name
+	baseDelay     time.Duration
+	findConfig    func() string
+	findService   func(ctx context.Context, name string, subscription string, opts ...azapim.Option) (azapim.ServiceID, error)
+	newAuthorizer func(s authentication.Settings) (autorest.Authorizer, error)
+	newClient     func(subscription string, resourceGroup string, serviceName string, opts ...azapim.Option) (*azapim.Client, error)
+}
+
+// NewApp returns the azapim cli application. every call returns an application with
+// its own state, so multiple applications can be executed in the same process
+func NewApp() *ucli.App {
+	return newApp(&state{
+		baseDelay:     azapim.DefaultRetryPolicy().BaseDelay,
+		findConfig:    config.Find,
+		findService:   azapim.FindService,
+		newAuthorizer: authentication.NewAuthorizer,
+		newClient:     azapim.New,
+	})
+}
 
-	// Collection contains alli api commands from cli package
-	Collection = append(VersionedAPICli, DisasterRecoveryCli...)
+func newApp(s *state) *ucli.App {
+	if s.redactor == nil {
+		s.redactor = logging.NewRedactor()
+	}
+	return &ucli.App{
+		Name:     "azapim",
+		Usage:    "Helper functions for Azure API management service",
+		Flags:    s.globalFlags(),
+		Before:   s.before,
+		After:    s.after,
+		Commands: s.commands(),
+	}
+}
+
+// commands returns all commands of the cli
+func (s *state) commands() []*ucli.Command {
+	commands := s.versionedAPICommands()
+	commands = append(commands, s.disasterRecoveryCommands()...)
+	commands = append(commands, s.preflightCommands()...)
+	commands = append(commands, s.configCommands()...)
+	s.instrument(commands, "azapim")
+	return commands
+}
 
-	// GlobalFlags contains the definition of all global parameters
-	GlobalFlags = []ucli.Flag{
+// instrument records the actions of the commands and their subcommands as telemetry spans
+func (s *state) instrument(commands []*ucli.Command, parent string) {
+	for _, cmd := range commands {
+		name := parent + " " + cmd.Name
+		if action := cmd.Action; action != nil {
+			cmd.Action = func(c *ucli.Context) error {
+				return telemetry.Command(commandContext(c), name, func(ctx context.Context) error {
+					c.Context = ctx
+					return action(c)
+				}, s.attributes()...)
+			}
+		}
+		s.instrument(cmd.Subcommands, name)
+	}
+}
+
+// attributes returns the telemetry attributes identifying the api management service
+func (s *state) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("azure.subscription", s.service.Subscription),
+		attribute.String("azure.resource_group", s.service.ResourceGroup),
+		attribute.String("azure.apim.service", s.service.ServiceName),
+	}
+}
+
+// globalFlags returns the definition of all global parameters
+func (s *state) globalFlags() []ucli.Flag {
+	return []ucli.Flag{
+		&ucli.StringFlag{
+			Name:        "config",
+			Usage:       fmt.Sprintf("`PATH` of the config file with named profiles, defaults to the first existing of %s", strings.Join(config.Paths(), ", ")),
+			EnvVars:     []string{"AZAPIM_CONFIG"},
+			Destination: &s.configPath,
+		},
+		&ucli.StringFlag{
+			Name:        "profile",
+			Usage:       "`NAME` of the profile of the config file, defaults to the default profile of the file",
+			EnvVars:     []string{"AZAPIM_PROFILE"},
+			Destination: &s.profile,
+		},
+		&ucli.StringFlag{
+			Name:        "service-id",
+			Usage:       "resource `ID` of the API management service, sets the subscription, resource group and service name",
+			EnvVars:     []string{"AZAPIM_SERVICE_ID"},
+			Destination: &s.serviceID,
+		},
 		&ucli.StringFlag{
 			Name:        "subscription",
-			Usage:       "Azure Subscription `ID` of the API management service",
-			Required:    true,
+			Usage:       "Azure Subscription `ID` of the API management service, looked up by the service name if not set",
 			EnvVars:     []string{"SUBSCRIPTION"},
-			Destination: &apimClient.Subscription,
+			Destination: &s.service.Subscription,
 		},
 		&ucli.StringFlag{
 			Name:        "resourcegroup",
-			Usage:       "`Name` of the resource group containing the API management service",
-			Required:    true,
+			Usage:       "`Name` of the resource group containing the API management service, looked up by the service name if not set",
 			EnvVars:     []string{"RESOURCEGROUP"},
-			Destination: &apimClient.ResourceGroup,
+			Destination: &s.service.ResourceGroup,
 		},
 		&ucli.StringFlag{
 			Name:        "servicename",
 			Usage:       "`Name` of the API management service",
-			Required:    true,
 			EnvVars:     []string{"APIMGMT"},
-			Destination: &apimClient.ServiceName,
+			Destination: &s.service.ServiceName,
+		},
+		&ucli.StringFlag{
+			Name:        "output",
+			Aliases:     []string{"o"},
+			Usage:       fmt.Sprintf("`FORMAT` of the result document written to stdout, one of %s", enum.Join(output.Formats)),
+			Value:       string(output.Table),
+			EnvVars:     []string{"AZAPIM_OUTPUT"},
+			Destination: &s.output,
+		},
+		&ucli.StringFlag{
+			Name:        "cloud",
+			Usage:       fmt.Sprintf("`NAME` of the azure cloud, one of %s", enum.Join(cloud.Names)),
+			Value:       cloud.AzurePublic,
+			EnvVars:     []string{"AZURE_CLOUD"},
+			Destination: &s.cloud,
+		},
+		&ucli.StringFlag{
+			Name:        "arm-endpoint",
+			Usage:       "custom azure resource manager `URL`, overrides the endpoint of the cloud",
+			EnvVars:     []string{"AZURE_ARM_ENDPOINT"},
+			Destination: &s.armEndpoint,
+		},
+		&ucli.StringFlag{
+			Name:        "arm-audience",
+			Usage:       "token `AUDIENCE` for a custom azure resource manager endpoint, defaults to the audience of the cloud",
+			EnvVars:     []string{"AZURE_ARM_AUDIENCE"},
+			Destination: &s.armAudience,
+		},
+		&ucli.StringFlag{
+			Name:        "auth-mode",
+			Usage:       fmt.Sprintf("authentication `MODE`, one of %s", enum.Join(authentication.Modes)),
+			Value:       string(azapim.AuthModeAzureCLI),
+			EnvVars:     []string{"AZURE_AUTH_MODE"},
+			Destination: &s.authMode,
+		},
+		&ucli.StringFlag{
+			Name:        "tenant-id",
+			Usage:       "Azure AD tenant `ID` of the service principal (sp-secret, sp-certificate, federated-token-file)",
+			EnvVars:     []string{"AZURE_TENANT_ID"},
+			Destination: &s.auth.TenantID,
+		},
+		&ucli.StringFlag{
+			Name:        "client-id",
+			Usage:       "client `ID` of the service principal or user assigned managed identity",
+			EnvVars:     []string{"AZURE_CLIENT_ID"},
+			Destination: &s.auth.ClientID,
+		},
+		&ucli.StringFlag{
+			Name:        "client-secret",
+			Usage:       "client `SECRET` of the service principal (sp-secret)",
+			EnvVars:     []string{"AZURE_CLIENT_SECRET"},
+			Destination: &s.auth.ClientSecret,
+		},
+		&ucli.StringFlag{
+			Name:        "client-certificate",
+			Usage:       "`PATH` to the pfx client certificate of the service principal (sp-certificate)",
+			EnvVars:     []string{"AZURE_CERTIFICATE_PATH"},
+			Destination: &s.auth.CertificatePath,
+		},
+		&ucli.StringFlag{
+			Name:        "client-certificate-password",
+			Usage:       "`PASSWORD` of the client certificate (sp-certificate)",
+			EnvVars:     []string{"AZURE_CERTIFICATE_PASSWORD"},
+			Destination: &s.auth.CertificatePassword,
+		},
+		&ucli.StringFlag{
+			Name:        "federated-token-file",
+			Usage:       "`PATH` to the file containing the federated token (federated-token-file)",
+			EnvVars:     []string{"AZURE_FEDERATED_TOKEN_FILE"},
+			Destination: &s.auth.FederatedTokenFile,
+		},
+		&ucli.IntFlag{
+			Name:        "max-retries",
+			Usage:       "maximum `NUMBER` of retries of requests failing with transient errors (throttling, conflicts, server errors), 0 disables retries",
+			Value:       azapim.DefaultRetryPolicy().MaxRetries,
+			EnvVars:     []string{"AZAPIM_MAX_RETRIES"},
+			Destination: &s.retry.MaxRetries,
+		},
+		&ucli.DurationFlag{
+			Name:        "retry-timeout",
+			Usage:       "maximum `DURATION` spent on retries of a single request",
+			Value:       azapim.DefaultRetryPolicy().Timeout,
+			EnvVars:     []string{"AZAPIM_RETRY_TIMEOUT"},
+			Destination: &s.retry.Timeout,
+		},
+		&ucli.DurationFlag{
+			Name:        "timeout",
+			Usage:       "maximum `DURATION` of the command, 0 for no limit",
+			EnvVars:     []string{"AZAPIM_TIMEOUT"},
+			Destination: &s.timeout,
+		},
+		&ucli.DurationFlag{
+			Name:        "api-polling-timeout",
+			Usage:       "maximum `DURATION` to wait for an api import",
+			Value:       azapim.DefaultAPIPollingTimeout,
+			EnvVars:     []string{"AZAPIM_API_POLLING_TIMEOUT"},
+			Destination: &s.apiPolling,
+		},
+		&ucli.DurationFlag{
+			Name:        "dr-polling-timeout",
+			Usage:       "maximum `DURATION` to wait for a backup or restore",
+			Value:       azapim.DefaultDisasterRecoveryPollingTimeout,
+			EnvVars:     []string{"AZAPIM_DR_POLLING_TIMEOUT"},
+			Destination: &s.drPolling,
 		},
+		&ucli.StringFlag{
+			Name:        "on-conflict",
+			Usage:       fmt.Sprintf("`STRATEGY` if a resource was changed concurrently since its etag was read, one of %s", enum.Join(apimclient.ConflictStrategies)),
+			Value:       string(azapim.ConflictFail),
+			EnvVars:     []string{"AZAPIM_ON_CONFLICT"},
+			Destination: &s.onConflict,
+		},
+		&ucli.StringFlag{
+			Name:        "log-level",
+			Usage:       fmt.Sprintf("minimum `LEVEL` of the log entries written to stderr, one of %s", enum.Join(logging.Levels)),
+			Value:       "info",
+			EnvVars:     []string{"AZAPIM_LOG_LEVEL"},
+			Destination: &s.logLevel,
+		},
+		&ucli.StringFlag{
+			Name:        "log-format",
+			Usage:       fmt.Sprintf("`FORMAT` of the log entries, one of %s", enum.Join(logging.Formats)),
+			Value:       string(logging.FormatText),
+			EnvVars:     []string{"AZAPIM_LOG_FORMAT"},
+			Destination: &s.logFormat,
+		},
+		&ucli.StringFlag{
+			Name:        "telemetry",
+			Usage:       fmt.Sprintf("`EXPORTER` of the opentelemetry traces and metrics, one of %s. stdout writes to stderr to keep the result document parseable", enum.Join(telemetry.Exporters)),
+			Value:       string(telemetry.ExporterNone),
+			EnvVars:     []string{"AZAPIM_TELEMETRY"},
+			Destination: &s.exporter,
+		},
+		&ucli.StringFlag{
+			Name:        "otlp-endpoint",
+			Usage:       "base `URL` of the otlp http collector, e.g. http://localhost:4318. defaults to OTEL_EXPORTER_OTLP_ENDPOINT",
+			EnvVars:     []string{"AZAPIM_OTLP_ENDPOINT"},
+			Destination: &s.telemetry.Endpoint,
+		},
+	}
+}
+
+// before is executed prior to execution of any subcommand
+func (s *state) before(c *ucli.Context) error {
+	// the profile may set all other global flags, including the log level
+	if err := s.applyConfig(c); err != nil {
+		return s.exit(err)
 	}
-	// BeforeFunction is executed prior to execution of any subcommand
-	BeforeFunction = func(c *ucli.Context) error {
-		apimClient.Ctx = context.Background()
-		apimClient.Authenticate()
+	logger, err := logging.New(s.logLevel, s.logFormat, c.App.ErrWriter, s.redactor)
+	if err != nil {
+		return s.exit(err)
+	}
+	s.logger = logger
+	s.redactor.AddSecret(s.auth.ClientSecret)
+	s.redactor.AddSecret(s.auth.CertificatePassword)
+	// the steps of the command log with the logger of the invocation and register the secrets they retrieve
+	c.Context = logging.WithLogger(logging.WithRedactor(commandContext(c), s.redactor), s.logger)
+	if err := s.applyServiceID(); err != nil {
+		return s.exit(err)
+	}
+
+	if _, err := output.ParseFormat(s.output); err != nil {
+		return s.exit(err)
+	}
+
+	mode, err := authentication.ParseMode(s.authMode)
+	if err != nil {
+		return s.exit(err)
+	}
+	s.auth.Mode = mode
+
+	onConflict, err := apimclient.ParseConflictStrategy(s.onConflict)
+	if err != nil {
+		return s.exit(err)
+	}
+
+	env, err := cloud.Environment(s.cloud, s.armEndpoint, s.armAudience)
+	if err != nil {
+		return s.exit(err)
+	}
+	s.auth.Environment = env
+
+	if s.telemetry.Exporter, err = telemetry.ParseExporter(s.exporter); err != nil {
+		return s.exit(err)
+	}
+	s.telemetry.Writer = c.App.ErrWriter
+	if s.providers, err = telemetry.Setup(commandContext(c), s.telemetry); err != nil {
+		return s.exit(err)
+	}
+	c.Context = telemetry.WithProviders(commandContext(c), s.providers)
+
+	if s.retry.MaxRetries < 0 {
+		return s.exit(fmt.Errorf("invalid number of retries %d", s.retry.MaxRetries))
+	}
+	if s.timeout < 0 || s.apiPolling < 0 || s.drPolling < 0 {
+		return s.exit(errors.New("timeouts must not be negative"))
+	}
+	if s.timeout > 0 {
+		// the context of the subcommands is derived from the context of the app
+		c.Context, s.cancel = context.WithTimeout(commandContext(c), s.timeout)
+	}
+
+	s.retry.BaseDelay = s.baseDelay
+	s.retry.MaxDelay = azapim.DefaultRetryPolicy().MaxDelay
+	s.retry.OnRetry = func(operation string, attempt int, delay time.Duration, err error) {
+		s.logger.WithFields(s.fields()).WithField(logging.FieldStep, operation).Warnf("%s failed, retry %d/%d in %s: %s", operation, attempt, s.retry.MaxRetries, delay.Round(time.Millisecond), err)
+	}
+
+	// the configuration is shown without connecting to the service
+	if c.Args().First() == "config" {
 		return nil
 	}
-)
+	if err := s.validateService(); err != nil {
+		return s.exit(err)
+	}
+
+	// the authorizer is created once and shared by all clients
+	a, err := s.newAuthorizer(s.auth)
+	if err != nil {
+		return s.exit(err)
+	}
+	if err := s.lookupService(commandContext(c), a, env); err != nil {
+		return s.exit(err)
+	}
+	c.Context = logging.WithFields(commandContext(c), s.fields())
+	s.client, err = s.newClient(
+		s.service.Subscription,
+		s.service.ResourceGroup,
+		s.service.ServiceName,
+		azapim.WithAuthorizer(a),
+		azapim.WithEnvironment(env),
+		azapim.WithRetryPolicy(s.retry),
+		azapim.WithPollingTimeouts(s.apiPolling, s.drPolling),
+		azapim.WithConflictStrategy(azapim.ConflictStrategy(onConflict)),
+	)
+	return s.exit(err)
+}
+
+// fields returns the log fields identifying the service
+func (s *state) fields() log.Fields {
+	return log.Fields{logging.FieldSubscription: s.service.Subscription, logging.FieldService: s.service.ServiceName}
+}
+
+// after is executed after the subcommand, it releases the timeout of the command and exports the
+// remaining telemetry
+func (s *state) after(c *ucli.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.providers != nil {
+		// the context of the command may be canceled already
+		ctx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+		defer cancel()
+		if err := s.providers.Shutdown(ctx); err != nil {
+			s.logger.Warnf("Unable to export the telemetry: %s", err)
+		}
+	}
+	return nil
+}
+
+// write writes the result of a command to stdout in the selected output format
+func (s *state) write(c *ucli.Context, result interface{}) error {
+	f, err := output.ParseFormat(s.output)
+	if err != nil {
+		return s.exit(err)
+	}
+	return s.exit(output.Write(c.App.Writer, f, result))
+}
+
+// commandContext returns the context used for the execution of a command
+func commandContext(c *ucli.Context) context.Context {
+	if c.Context != nil {
+		return c.Context
+	}
+	return context.Background()
+}
@@ -0,0 +1,950 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apimtest"
+	"github.com/foryouandyourcustomers/azapim/internal/authentication"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/pkg/azapim"
+	ucli "github.com/urfave/cli/v2"
+)
+
+const (
+	subscription  = "00000000-0000-0000-0000-000000000000"
+	resourceGroup = "apimresourcegroup"
+	serviceName   = "apimservicename"
+)
+
+// run executes the cli for the test service against the fake server and returns stdout
+func run(t *testing.T, srv *apimtest.Server, args ...string) (string, error) {
+	t.Helper()
+	global := []string{"--subscription", subscription, "--resourcegroup", resourceGroup, "--servicename", serviceName}
+	return runArgs(t, srv, append(global, args...)...)
+}
+
+// runArgs executes the cli with the arguments against the fake server and returns stdout
+func runArgs(t *testing.T, srv *apimtest.Server, args ...string) (string, error) {
+	t.Helper()
+	return runState(t, newState(srv), args...)
+}
+
+// newState returns the state of an invocation against the fake server
+func newState(srv *apimtest.Server) *state {
+	return &state{
+		newAuthorizer: func(authentication.Settings) (autorest.Authorizer, error) {
+			return autorest.NullAuthorizer{}, nil
+		},
+		newClient: func(subscription string, resourceGroup string, serviceName string, opts ...azapim.Option) (*azapim.Client, error) {
+			return azapim.New(subscription, resourceGroup, serviceName, append(opts, azapim.WithBaseURI(srv.URL))...)
+		},
+		findService: func(ctx context.Context, name string, subscription string, opts ...azapim.Option) (azapim.ServiceID, error) {
+			return azapim.FindService(ctx, name, subscription, append(opts, azapim.WithBaseURI(srv.URL))...)
+		},
+	}
+}
+
+// runState executes the cli with the state and returns stdout, the state contains the parsed flags afterwards
+func runState(t *testing.T, s *state, args ...string) (string, error) {
+	t.Helper()
+	app := newApp(s)
+	out := &bytes.Buffer{}
+	app.Writer = out
+	app.ExitErrHandler = func(*ucli.Context, error) {}
+
+	err := app.Run(append([]string{"azapim"}, args...))
+	return out.String(), err
+}
+
+func newServer(t *testing.T) *apimtest.Server {
+	t.Helper()
+	srv := apimtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddService(subscription, resourceGroup, serviceName)
+	return srv
+}
+
+func TestVersionedAPICreateJSONOutput(t *testing.T) {
+	srv := newServer(t)
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := run(t, srv,
+		"--output", "json",
+		// spans and metrics are written to stderr and don't interfere with the result document
+		"--telemetry", "stdout",
+		"versionedapi", "--apiid", "httpbin",
+		"create",
+		"--openapispec", spec,
+		"--apipath", "/httpbin",
+		"--apiversion", "v1",
+		"--apiserviceurl", "https://my.backend.service/httpbin",
+		"--apidisplayname", "httpbin api",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var r azapim.DeploymentResult
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("invalid json output %q: %v", out, err)
+	}
+	if r.GatewayURL != "https://apimservicename.azure-api.net/httpbin/v1" {
+		t.Errorf("gateway url = %s", r.GatewayURL)
+	}
+	if r.APIName != "httpbin-v1" {
+		t.Errorf("api name = %s", r.APIName)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	srv := newServer(t)
+
+	_, err := run(t, srv,
+		"versionedapi", "--apiid", "httpbin",
+		"create",
+		"--openapispec", filepath.Join(t.TempDir(), "missing.json"),
+		"--apipath", "/httpbin",
+		"--apiversion", "v1",
+		"--apiserviceurl", "https://my.backend.service/httpbin",
+	)
+	assertExitCode(t, err, ExitCodeSpecNotFound)
+
+	_, err = run(t, srv,
+		"versionedapi", "--apiid", "httpbin",
+		"create",
+		"--openapispec", "https://my.backend.service/httpbin/openapi.json",
+		"--apipath", "/httpbin",
+		"--apiversion", "v1",
+		"--apiserviceurl", "https://my.backend.service/httpbin",
+		"--fetch", "remote",
+	)
+	assertExitCode(t, err, ExitCodeError)
+
+	_, err = run(t, srv, "dr", "--storageaccount", "missing", "--storageaccountrg", "rg", "--blobname", "apim", "backup")
+	assertExitCode(t, err, ExitCodeStorageKeyUnavailable)
+
+	_, err = run(t, srv, "--output", "xml", "preflight")
+	assertExitCode(t, err, ExitCodeError)
+
+	_, err = run(t, srv, "--max-retries", "-1", "preflight")
+	assertExitCode(t, err, ExitCodeError)
+
+	_, err = run(t, srv, "--telemetry", "jaeger", "preflight")
+	assertExitCode(t, err, ExitCodeError)
+
+	_, err = run(t, srv, "--log-level", "verbose", "preflight")
+	assertExitCode(t, err, ExitCodeError)
+
+	_, err = run(t, srv, "--on-conflict", "ignore", "preflight")
+	assertExitCode(t, err, ExitCodeError)
+}
+
+func TestTimeout(t *testing.T) {
+	srv := newServer(t)
+	srv.PendingPolls = 1 << 30
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := run(t, srv,
+		"--timeout", "100ms",
+		"versionedapi", "--apiid", "httpbin",
+		"create",
+		"--openapispec", spec,
+		"--apipath", "/httpbin",
+		"--apiversion", "v1",
+		"--apiserviceurl", "https://my.backend.service/httpbin",
+		"--apidisplayname", "httpbin api",
+	)
+	assertExitCode(t, err, ExitCodeInterrupted)
+	if !strings.Contains(err.Error(), "timed out during step 'create or update api'") {
+		t.Errorf("error %q doesn't name the step", err)
+	}
+}
+
+func TestParallelApps(t *testing.T) {
+	srv := newServer(t)
+	done := make(chan error)
+	for _, f := range []string{"json", "yaml", "table"} {
+		go func(f string) {
+			_, err := run(t, srv, "--output", f, "preflight")
+			done <- err
+		}(f)
+	}
+	for i := 0; i < 3; i++ {
+		// the fake principal has no permissions, every preflight fails with the same exit code
+		assertExitCode(t, <-done, ExitCodePreflightFailed)
+	}
+}
+
+func TestGlobalFlags(t *testing.T) {
+	srv := newServer(t)
+	t.Setenv("SUBSCRIPTION", subscription)
+	t.Setenv("RESOURCEGROUP", resourceGroup)
+	t.Setenv("APIMGMT", "fromenv")
+
+	s := newState(srv)
+	var created service
+	newClient := s.newClient
+	s.newClient = func(subscription string, resourceGroup string, serviceName string, opts ...azapim.Option) (*azapim.Client, error) {
+		created = service{Subscription: subscription, ResourceGroup: resourceGroup, ServiceName: serviceName}
+		return newClient(subscription, resourceGroup, serviceName, opts...)
+	}
+	// flags take precedence over the environment
+	_, err := runState(t, s, "--servicename", serviceName, "--output", "json", "preflight")
+	assertExitCode(t, err, ExitCodePreflightFailed)
+	want := service{Subscription: subscription, ResourceGroup: resourceGroup, ServiceName: serviceName}
+	if s.service != want || created != want {
+		t.Errorf("service = %+v, client created for %+v, want %+v", s.service, created, want)
+	}
+	if s.output != "json" || s.client == nil {
+		t.Errorf("output = %s, client = %v", s.output, s.client)
+	}
+}
+
+func TestVersionedAPIFlags(t *testing.T) {
+	srv := newServer(t)
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APISERVICEURL", "https://my.backend.service/httpbin")
+	for _, p := range []string{"starter", "unlimited"} {
+		srv.AddProduct(apimtest.ServiceID(subscription, resourceGroup, serviceName), p)
+	}
+
+	s := newState(srv)
+	_, err := runState(t, s,
+		"--subscription", subscription, "--resourcegroup", resourceGroup, "--servicename", serviceName,
+		"versionedapi", "--apiid", "httpbin",
+		"create",
+		"--openapispec", spec,
+		"--apipath", "/httpbin",
+		"--apiversion", "v1",
+		"--apidisplayname", "httpbin api",
+		"--apiproducts", "starter,unlimited",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d := s.apiDef
+	if d.APIID != "httpbin" || d.APIPath != "/httpbin" || d.APIVersion != "v1" || d.APIRevision != "1" ||
+		d.APIServiceURL != "https://my.backend.service/httpbin" || d.OpenAPISpecPath != spec ||
+		!reflect.DeepEqual(d.APIProducts, []string{"starter", "unlimited"}) {
+		t.Errorf("definition = %+v", d)
+	}
+
+	// required flags are checked before anything is deployed
+	_, err = run(t, srv, "versionedapi", "--apiid", "httpbin", "create", "--openapispec", spec, "--apiversion", "v1")
+	if err == nil || !strings.Contains(err.Error(), "apipath") {
+		t.Errorf("missing --apipath = %v", err)
+	}
+	_, err = run(t, srv, "versionedapi", "create", "--openapispec", spec, "--apipath", "/httpbin", "--apiversion", "v1")
+	if err == nil || !strings.Contains(err.Error(), "apiid") {
+		t.Errorf("missing --apiid = %v", err)
+	}
+}
+
+func TestDisasterRecoveryFlags(t *testing.T) {
+	srv := newServer(t)
+	s := newState(srv)
+	_, err := runState(t, s,
+		"--subscription", subscription, "--resourcegroup", resourceGroup, "--servicename", serviceName,
+		"dr", "--storageaccount", "missing", "--storageaccountrg", "backuprg", "--blobname", "apim", "--backupname", "nightly",
+		"backup",
+	)
+	assertExitCode(t, err, ExitCodeStorageKeyUnavailable)
+	if s.dr.StorageAccount != "missing" || s.dr.StorageResourceGroup != "backuprg" || s.dr.Container != "apim" || s.dr.BackupName != "nightly" {
+		t.Errorf("disaster recovery = %+v", s.dr)
+	}
+}
+
+func TestAppsDontShareState(t *testing.T) {
+	srv := newServer(t)
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	create := func(args ...string) error {
+		_, err := run(t, srv, append([]string{
+			"versionedapi", "--apiid", "httpbin",
+			"create",
+			"--openapispec", spec,
+			"--apipath", "/httpbin",
+			"--apiserviceurl", "https://my.backend.service/httpbin",
+			"--apidisplayname", "httpbin api",
+		}, args...)...)
+		return err
+	}
+	srv.AddProduct(apimtest.ServiceID(subscription, resourceGroup, serviceName), "starter")
+	if err := create("--apiversion", "v1", "--apiproducts", "starter"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := srv.Get(apimtest.ServiceID(subscription, resourceGroup, serviceName) + "/products/starter/apis/httpbin-v1"); !ok {
+		t.Fatal("api version v1 not assigned to starter")
+	}
+	// the products of the first invocation aren't assigned by the second one
+	if err := create("--apiversion", "v2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, r := range srv.Requests() {
+		if strings.Contains(r, "/products/starter/apis/httpbin-v2") {
+			t.Errorf("second invocation assigned the product of the first: %s", r)
+		}
+	}
+	if _, ok := srv.Get(apimtest.ServiceID(subscription, resourceGroup, serviceName) + "/apis/httpbin-v2"); !ok {
+		t.Error("api version v2 not created")
+	}
+}
+
+func TestPreflightFlags(t *testing.T) {
+	global := []string{"--subscription", subscription, "--resourcegroup", resourceGroup, "--servicename", serviceName}
+	tests := []struct {
+		name    string
+		global  []string
+		args    []string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "versionedapi",
+			want:    []string{"Microsoft.ApiManagement/service/apis/read", "Microsoft.ApiManagement/service/apis/write"},
+			notWant: []string{"Microsoft.ApiManagement/service/apis/delete", "Microsoft.Storage/storageAccounts/listkeys/action", "Microsoft.Resources/subscriptions/read"},
+		},
+		{
+			name:   "lookup by name",
+			global: []string{"--servicename", serviceName},
+			want:   []string{"Microsoft.Resources/subscriptions/read", "Microsoft.ApiManagement/service/read"},
+		},
+		{
+			name: "transactional",
+			args: []string{"--transactional"},
+			want: []string{"Microsoft.ApiManagement/service/apis/delete", "Microsoft.ApiManagement/service/apiVersionSets/delete", "Microsoft.ApiManagement/service/apis/policies/delete"},
+		},
+		{
+			name: "history",
+			args: []string{"--history-storageaccount", "history", "--history-storageaccountrg", "historyrg"},
+			want: []string{"Microsoft.Storage/storageAccounts/listkeys/action"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			args := global
+			if tt.global != nil {
+				args = tt.global
+			}
+			args = append(append(args, "--output", "json", "preflight"), tt.args...)
+			out, err := runArgs(t, srv, args...)
+			assertExitCode(t, err, ExitCodePreflightFailed)
+
+			var r azapim.PreflightReport
+			if err := json.Unmarshal([]byte(out), &r); err != nil {
+				t.Fatalf("invalid json output %q: %v", out, err)
+			}
+			checked := map[string]bool{}
+			for _, c := range r.Checks {
+				checked[c.Action] = true
+			}
+			for _, a := range tt.want {
+				if !checked[a] {
+					t.Errorf("action %s not checked: %v", a, checked)
+				}
+			}
+			for _, a := range tt.notWant {
+				if checked[a] {
+					t.Errorf("action %s checked", a)
+				}
+			}
+		})
+	}
+}
+
+func TestExitRedactsMessage(t *testing.T) {
+	url := "https://account.blob.core.windows.net/backups/apim?sv=2019-12-12&sig=abc%2Fdef%3D&se=2021"
+	s := &state{redactor: logging.NewRedactor()}
+	s.redactor.AddSecret("registered-client-secret")
+	err := s.exit(&azapim.StorageKeyUnavailableError{Err: fmt.Errorf("GET %s with registered-client-secret: 403", url)})
+	assertExitCode(t, err, ExitCodeStorageKeyUnavailable)
+	if strings.Contains(err.Error(), "abc%2Fdef") || !strings.Contains(err.Error(), "sig="+logging.Redacted) {
+		t.Errorf("message = %s, want the sas signature redacted", err)
+	}
+	if strings.Contains(err.Error(), "registered-client-secret") {
+		t.Errorf("message = %s, want the registered secret redacted", err)
+	}
+}
+
+func TestInvocationLogger(t *testing.T) {
+	srv := newServer(t)
+	level, formatter := log.GetLevel(), log.StandardLogger().Formatter
+	first, second := newState(srv), newState(srv)
+	if _, err := runState(t, first, "--log-level", "debug", "--log-format", "json", "--client-secret", "first-client-secret", "config", "show"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := runState(t, second, "--client-secret", "second-client-secret", "config", "show"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if log.GetLevel() != level || log.StandardLogger().Formatter != formatter {
+		t.Error("the invocation changed the standard logger")
+	}
+	if first.logger.GetLevel() != log.DebugLevel || second.logger.GetLevel() != log.InfoLevel {
+		t.Errorf("levels = %s, %s, want the level of each invocation", first.logger.GetLevel(), second.logger.GetLevel())
+	}
+	if got := first.redactor.Redact("first-client-secret second-client-secret"); got != logging.Redacted+" second-client-secret" {
+		t.Errorf("redacted = %s, want the secret of the invocation only", got)
+	}
+}
+
+func assertExitCode(t *testing.T, err error, code int) {
+	t.Helper()
+	e, ok := err.(interface{ ExitCode() int })
+	if !ok {
+		t.Fatalf("error %v has no exit code, want %d", err, code)
+	}
+	if e.ExitCode() != code {
+		t.Errorf("exit code = %d (%v), want %d", e.ExitCode(), err, code)
+	}
+}
+
+func TestVersionedAPIHistoryAndRollback(t *testing.T) {
+	srv := newServer(t)
+	srv.AddStorageAccount(subscription, "historyrg", "history", base64.StdEncoding.EncodeToString([]byte("secretkey")))
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	versionedAPI := []string{
+		"--output", "json",
+		"versionedapi", "--apiid", "httpbin",
+		"--history-storageaccount", "history",
+		"--history-storageaccountrg", "historyrg",
+		"--history-endpoint", srv.URL + apimtest.BlobPrefix + "/history",
+	}
+
+	out, err := run(t, srv, append(versionedAPI,
+		"create",
+		"--openapispec", spec,
+		"--apipath", "/httpbin",
+		"--apiversion", "v1",
+		"--apiserviceurl", "https://my.backend.service/httpbin",
+		"--apidisplayname", "httpbin api",
+	)...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var r azapim.DeploymentResult
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("invalid json output %q: %v", out, err)
+	}
+	if r.DeploymentID == "" {
+		t.Fatal("deployment id not set")
+	}
+
+	out, err = run(t, srv, append(versionedAPI, "history", "--apiversion", "v1")...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var h azapim.DeploymentHistory
+	if err := json.Unmarshal([]byte(out), &h); err != nil {
+		t.Fatalf("invalid json output %q: %v", out, err)
+	}
+	if len(h.Deployments) != 1 || h.Deployments[0].ID != r.DeploymentID {
+		t.Errorf("history = %+v, want deployment %s", h.Deployments, r.DeploymentID)
+	}
+
+	out, err = run(t, srv, append(versionedAPI, "rollback", "--apiversion", "v1", "--to", r.DeploymentID)...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rb azapim.DeploymentResult
+	if err := json.Unmarshal([]byte(out), &rb); err != nil {
+		t.Fatalf("invalid json output %q: %v", out, err)
+	}
+	if rb.RollbackOf != r.DeploymentID {
+		t.Errorf("rollback of = %s, want %s", rb.RollbackOf, r.DeploymentID)
+	}
+
+	_, err = run(t, srv, "versionedapi", "--apiid", "httpbin", "history", "--apiversion", "v1")
+	assertExitCode(t, err, ExitCodeError)
+}
+
+func TestConfigProfiles(t *testing.T) {
+	srv := newServer(t)
+	file := filepath.Join(t.TempDir(), "azapim.yaml")
+	content := `default: dev
+profiles:
+  dev:
+    subscription: ` + subscription + `
+    resourcegroup: ` + resourceGroup + `
+    servicename: unknown
+    max-retries: 1
+  prod:
+    subscription: ` + subscription + `
+    resourcegroup: ` + resourceGroup + `
+    servicename: ` + serviceName + `
+    on-conflict: retry
+    client-secret: s3cr3t-from-the-file
+`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AZAPIM_ON_CONFLICT", "force")
+
+	out, err := runArgs(t, srv, "--config", file, "--profile", "prod", "--output", "json", "--max-retries", "2", "config", "show")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var cfg struct {
+		File     string
+		Profile  string
+		Settings []struct{ Name, Value, Source string }
+	}
+	if err := json.Unmarshal([]byte(out), &cfg); err != nil {
+		t.Fatalf("invalid json output %q: %v", out, err)
+	}
+	if cfg.File != file || cfg.Profile != "prod" {
+		t.Errorf("file = %s, profile = %s", cfg.File, cfg.Profile)
+	}
+	settings := map[string]string{}
+	for _, s := range cfg.Settings {
+		settings[s.Name] = s.Value + " (" + s.Source + ")"
+	}
+	// flag > env > file > default
+	for name, want := range map[string]string{
+		"servicename":   serviceName + " (file)",
+		"max-retries":   "2 (flag)",
+		"on-conflict":   "force (env)",
+		"client-secret": "REDACTED (file)",
+		"output":        "json (flag)",
+		"auth-mode":     string(azapim.AuthModeAzureCLI) + " (default)",
+	} {
+		if settings[name] != want {
+			t.Errorf("%s = %s, want %s", name, settings[name], want)
+		}
+	}
+
+	// the default profile is used without --profile
+	out, err = runArgs(t, srv, "--config", file, "--output", "json", "config", "show")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal([]byte(out), &cfg); err != nil || cfg.Profile != "dev" {
+		t.Errorf("profile = %s, want the default profile dev: %v", cfg.Profile, err)
+	}
+	_, err = runArgs(t, srv, "--config", file, "--profile", "prod", "preflight")
+	assertExitCode(t, err, ExitCodePreflightFailed)
+
+	_, err = runArgs(t, srv, "--config", file, "--profile", "staging", "preflight")
+	assertExitCode(t, err, ExitCodeError)
+	_, err = runArgs(t, srv, "--profile", "prod", "preflight")
+	assertExitCode(t, err, ExitCodeError)
+	_, err = runArgs(t, srv, "preflight")
+	assertExitCode(t, err, ExitCodeError)
+}
+
+func TestServiceResolution(t *testing.T) {
+	srv := newServer(t)
+	srv.AddService("11111111-1111-1111-1111-111111111111", "other", "otherservice")
+	id := "/subscriptions/" + subscription + "/resourceGroups/" + resourceGroup + "/providers/Microsoft.ApiManagement/service/" + serviceName
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "service id", args: []string{"--service-id", id}},
+		{name: "service id and matching name", args: []string{"--service-id", id, "--servicename", serviceName}},
+		{name: "service id and other resource group", args: []string{"--service-id", id, "--resourcegroup", "other"}, wantErr: true},
+		{name: "invalid service id", args: []string{"--service-id", "/subscriptions/" + subscription + "/resourceGroups/" + resourceGroup}, wantErr: true},
+		{name: "lookup by name", args: []string{"--servicename", serviceName}},
+		{name: "lookup by name in subscription", args: []string{"--servicename", serviceName, "--subscription", subscription}},
+		{name: "lookup by name in resource group", args: []string{"--servicename", serviceName, "--resourcegroup", resourceGroup}},
+		{name: "lookup by name in other resource group", args: []string{"--servicename", serviceName, "--resourcegroup", "other"}, wantErr: true},
+		{name: "lookup of unknown name", args: []string{"--servicename", "unknown"}, wantErr: true},
+		{name: "no service", wantErr: true},
+	}
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runArgs(t, srv, append(tt.args,
+				"versionedapi", "--apiid", "httpbin",
+				"create",
+				"--openapispec", spec,
+				"--apipath", "/httpbin",
+				"--apiversion", "v1",
+				"--apiserviceurl", "https://my.backend.service/httpbin",
+				"--apidisplayname", "httpbin api",
+			)...)
+			if tt.wantErr {
+				assertExitCode(t, err, ExitCodeError)
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestFailOnBreaking(t *testing.T) {
+	srv := newServer(t)
+	dir := t.TempDir()
+	create := func(spec string, args ...string) (string, error) {
+		path := filepath.Join(dir, "openapi.json")
+		if err := ioutil.WriteFile(path, []byte(spec), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return run(t, srv, append([]string{
+			"--output", "json",
+			"versionedapi", "--apiid", "httpbin",
+			"create",
+			"--openapispec", path,
+			"--apipath", "/httpbin",
+			"--apiversion", "v1",
+			"--apiserviceurl", "https://my.backend.service/httpbin",
+			"--apidisplayname", "httpbin api",
+		}, args...)...)
+	}
+
+	if _, err := create(`{"openapi": "3.0.1", "paths": {"/get": {"get": {}}, "/post": {"post": {}}}}`, "--fail-on-breaking"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, err := create(`{"openapi": "3.0.1", "paths": {"/get": {"get": {}}}}`, "--fail-on-breaking")
+	assertExitCode(t, err, ExitCodeBreakingChange)
+	if !strings.Contains(err.Error(), "--apiversion v2") {
+		t.Errorf("error doesn't suggest v2: %v", err)
+	}
+	var r azapim.DeploymentResult
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("invalid result %q: %v", out, err)
+	}
+	if len(r.Changes) != 1 || r.Changes[0].Operation != "POST /post" {
+		t.Errorf("changes = %+v", r.Changes)
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	srv := newServer(t)
+	dir := t.TempDir()
+	v1 := filepath.Join(dir, "v1.json")
+	v2 := filepath.Join(dir, "v2.json")
+	if err := ioutil.WriteFile(v1, []byte(`{"openapi": "3.0.1", "paths": {"/get": {"get": {}}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(v2, []byte(`{"openapi": "3.0.1", "paths": {"/post": {"post": {}}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := run(t, srv,
+		"versionedapi", "--apiid", "httpbin",
+		"create",
+		"--openapispec", v1,
+		"--apipath", "/httpbin",
+		"--apiversion", "v1",
+		"--apiserviceurl", "https://my.backend.service/httpbin",
+		"--apidisplayname", "httpbin api",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out, err := run(t, srv, "--output", "json", "versionedapi", "--apiid", "httpbin", "next-version", "--openapispec", v2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var v azapim.VersionSuggestion
+	if err := json.Unmarshal([]byte(out), &v); err != nil {
+		t.Fatalf("invalid result %q: %v", out, err)
+	}
+	if v.LatestVersion != "v1" || v.NextVersion != "v2" {
+		t.Errorf("suggestion = %+v, want v2 after v1", v)
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	srv := newServer(t)
+	srv.AddProduct(apimtest.ServiceID(subscription, resourceGroup, serviceName), "starter")
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := run(t, srv,
+		"versionedapi", "--apiid", "httpbin",
+		"create",
+		"--openapispec", spec,
+		"--apipath", "/httpbin",
+		"--apiversion", "v1",
+		"--apiserviceurl", "https://my.backend.service/httpbin",
+		"--apidisplayname", "httpbin api",
+		"--apiproducts", "starter",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := run(t, srv, "versionedapi", "--apiid", "httpbin", "deprecate", "--apiversion", "v1", "--sunset", "31.03.2027"); err == nil {
+		t.Error("invalid sunset accepted")
+	}
+	out, err := run(t, srv, "--output", "json",
+		"versionedapi", "--apiid", "httpbin",
+		"deprecate", "--apiversion", "v1", "--sunset", "2027-03-31", "--deprecated-since", "2026-10-01", "--remove-from-products", "starter",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var r azapim.DeprecationResult
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("invalid result %q: %v", out, err)
+	}
+	if r.Sunset != "2027-03-31" || r.DisplayName != "httpbin api (deprecated)" || len(r.RemovedFromProducts) != 1 {
+		t.Errorf("result = %+v", r)
+	}
+
+	id := apimtest.ServiceID(subscription, resourceGroup, serviceName) + "/apis/httpbin-v1"
+	api, _ := srv.Get(id)
+	props := api["properties"].(map[string]interface{})
+	if props["displayName"] != "httpbin api (deprecated)" || !strings.HasPrefix(props["description"].(string), "Deprecated:") {
+		t.Errorf("api properties = %v", props)
+	}
+	policy, _ := srv.Get(id + "/policies/policy")
+	if value := policy["properties"].(map[string]interface{})["value"].(string); !strings.Contains(value, "Wed, 31 Mar 2027 00:00:00 GMT") {
+		t.Errorf("policy without sunset header: %s", value)
+	}
+	if _, ok := srv.Get(apimtest.ServiceID(subscription, resourceGroup, serviceName) + "/products/starter/apis/httpbin-v1"); ok {
+		t.Error("api not removed from product starter")
+	}
+}
+
+func TestVersionedAPIMetadata(t *testing.T) {
+	srv := newServer(t)
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{
+		"versionedapi", "--apiid", "httpbin",
+		"create",
+		"--openapispec", spec,
+		"--apipath", "/httpbin",
+		"--apiversion", "v1",
+		"--apiserviceurl", "https://my.backend.service/httpbin",
+		"--apidisplayname", "httpbin api",
+		"--apidescription", "the httpbin api",
+		"--apitags", "public, pets",
+		"--subscription-required=false",
+		"--subscription-key-header", "X-Api-Key",
+		"--contact-name", "api team",
+		"--contact-email", "api@example.com",
+		"--license-name", "MIT",
+		"--license-url", "https://opensource.org/licenses/MIT",
+		"--terms-of-service-url", "https://example.com/terms",
+	}
+	if _, err := run(t, srv, append(args, "--apitype", "rest")...); err == nil {
+		t.Error("unknown api type accepted")
+	}
+	if _, err := run(t, srv, args...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id := apimtest.ServiceID(subscription, resourceGroup, serviceName)
+	api, _ := srv.Get(id + "/apis/httpbin-v1")
+	props := api["properties"].(map[string]interface{})
+	if props["description"] != "the httpbin api" || props["subscriptionRequired"] != false || props["type"] != "http" {
+		t.Errorf("api properties = %v", props)
+	}
+	if names, _ := props["subscriptionKeyParameterNames"].(map[string]interface{}); names["header"] != "X-Api-Key" {
+		t.Errorf("subscription key names = %v", names)
+	}
+	contact, _ := props["contact"].(map[string]interface{})
+	license, _ := props["license"].(map[string]interface{})
+	if contact["name"] != "api team" || contact["email"] != "api@example.com" || license["name"] != "MIT" ||
+		license["url"] != "https://opensource.org/licenses/MIT" || props["termsOfServiceUrl"] != "https://example.com/terms" {
+		t.Errorf("contact = %v, license = %v, terms of service = %v", contact, license, props["termsOfServiceUrl"])
+	}
+	for _, tag := range []string{"public", "pets"} {
+		if _, ok := srv.Get(id + "/apis/httpbin-v1/tags/" + tag); !ok {
+			t.Errorf("tag %s not assigned", tag)
+		}
+		if _, ok := srv.Get(id + "/tags/" + tag); !ok {
+			t.Errorf("tag %s not created", tag)
+		}
+	}
+}
+
+func TestVersionedAPIGraphQL(t *testing.T) {
+	srv := newServer(t)
+	schema := filepath.Join(t.TempDir(), "schema.graphql")
+	if err := ioutil.WriteFile(schema, []byte(`type Query { pets: [String] }`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := run(t, srv,
+		"versionedapi", "--apiid", "pets",
+		"create",
+		"--apitype", "graphql",
+		"--openapispec", schema,
+		"--apipath", "/pets",
+		"--apiversion", "v1",
+		"--apiserviceurl", "https://my.backend.service/graphql",
+		"--apidisplayname", "pets api",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id := apimtest.ServiceID(subscription, resourceGroup, serviceName) + "/apis/pets-v1"
+	api, _ := srv.Get(id)
+	if props := api["properties"].(map[string]interface{}); props["type"] != "graphql" || props["apiType"] != "graphql" {
+		t.Errorf("api properties = %v", props)
+	}
+	s, ok := srv.Get(id + "/schemas/graphql")
+	if !ok {
+		t.Fatal("schema not uploaded")
+	}
+	props := s["properties"].(map[string]interface{})
+	if doc, _ := props["document"].(map[string]interface{}); props["contentType"] != "application/vnd.ms-azure-apim.graphql.schema" || doc["value"] != "type Query { pets: [String] }" {
+		t.Errorf("schema = %v", props)
+	}
+}
+
+func TestVersionedAPIWebsocket(t *testing.T) {
+	srv := newServer(t)
+	create := func(args ...string) (string, error) {
+		return run(t, srv, append([]string{"--output", "json",
+			"versionedapi", "--apiid", "chat",
+			"create",
+			"--apitype", "websocket",
+			"--apipath", "/chat",
+			"--apiversion", "v1",
+			"--apidisplayname", "chat api",
+		}, args...)...)
+	}
+	if _, err := create("--apiserviceurl", "https://my.backend.service/chat"); err == nil {
+		t.Error("http backend of a websocket api accepted")
+	}
+	if _, err := create("--apiserviceurl", "wss://my.backend.service/chat", "--protocols", "https"); err == nil {
+		t.Error("https protocol of a websocket api accepted")
+	}
+	if _, err := create("--apiserviceurl", "wss://my.backend.service/chat", "--openapispec", "https://my.backend.service/openapi.json"); err == nil {
+		t.Error("spec of a websocket api accepted")
+	}
+
+	if _, err := create("--apiserviceurl", "wss://my.backend.service/chat"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	api, _ := srv.Get(apimtest.ServiceID(subscription, resourceGroup, serviceName) + "/apis/chat-v1")
+	props := api["properties"].(map[string]interface{})
+	if props["type"] != "websocket" || props["apiType"] != "websocket" || !reflect.DeepEqual(props["protocols"], []interface{}{"wss"}) {
+		t.Errorf("api properties = %v", props)
+	}
+
+	out, err := create("--apiserviceurl", "wss://my.backend.service/chat", "--protocols", "wss,ws")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var r azapim.DeploymentResult
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("invalid result %q: %v", out, err)
+	}
+	if len(r.Warnings) != 1 {
+		t.Errorf("warnings = %v, want the unencrypted ws warning", r.Warnings)
+	}
+}
+
+func TestVersionedAPIRevision(t *testing.T) {
+	srv := newServer(t)
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	create := func(args ...string) (string, error) {
+		return run(t, srv, append([]string{"--output", "json",
+			"versionedapi", "--apiid", "httpbin",
+			"create",
+			"--openapispec", spec,
+			"--apipath", "/httpbin",
+			"--apiversion", "v1",
+			"--apiserviceurl", "https://my.backend.service/httpbin",
+			"--apidisplayname", "httpbin api",
+		}, args...)...)
+	}
+	if _, err := create("--apirevision", "first"); err == nil {
+		t.Error("invalid revision accepted")
+	}
+	if _, err := create(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, err := create("--apirevision", "2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var r azapim.DeploymentResult
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("invalid result %q: %v", out, err)
+	}
+	if r.APIRevision != "2" {
+		t.Errorf("revision = %s, want 2", r.APIRevision)
+	}
+	rev, ok := srv.Get(apimtest.ServiceID(subscription, resourceGroup, serviceName) + "/apis/httpbin-v1;rev=2")
+	if !ok {
+		t.Fatal("revision 2 not created")
+	}
+	props := rev["properties"].(map[string]interface{})
+	if props["apiRevision"] != "2" || props["sourceApiId"] != "/apis/httpbin-v1" {
+		t.Errorf("revision properties = %v", props)
+	}
+}
+
+func TestVersionedAPIProtocols(t *testing.T) {
+	srv := newServer(t)
+	spec := filepath.Join(t.TempDir(), "openapi.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"openapi": "3.0.1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	create := func(protocols string) (string, error) {
+		return run(t, srv, "--output", "json",
+			"versionedapi", "--apiid", "httpbin",
+			"create",
+			"--openapispec", spec,
+			"--apipath", "/httpbin",
+			"--apiversion", "v1",
+			"--apiserviceurl", "https://my.backend.service/httpbin",
+			"--apidisplayname", "httpbin api",
+			"--protocols", protocols,
+		)
+	}
+	if _, err := create("ws,wss"); err == nil {
+		t.Error("websocket protocols accepted")
+	}
+	out, err := create("https,http")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var r azapim.DeploymentResult
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("invalid result %q: %v", out, err)
+	}
+	if len(r.Warnings) != 1 {
+		t.Errorf("warnings = %v, want the unencrypted http warning", r.Warnings)
+	}
+	api, _ := srv.Get(apimtest.ServiceID(subscription, resourceGroup, serviceName) + "/apis/httpbin-v1")
+	if protocols := api["properties"].(map[string]interface{})["protocols"]; len(protocols.([]interface{})) != 2 {
+		t.Errorf("protocols = %v", protocols)
+	}
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	ucli "github.com/urfave/cli/v2"
+
+	"github.com/foryouandyourcustomers/azapim/internal/config"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+)
+
+// secretSettings are not printed by config show
+var secretSettings = map[string]bool{"client-secret": true, "client-certificate-password": true}
+
+// configCommands returns the config cli definition
+func (s *state) configCommands() []*ucli.Command {
+	return []*ucli.Command{
+		{
+			Name:     "config",
+			Category: "Configuration",
+			Usage:    "Show the configuration",
+			Subcommands: []*ucli.Command{
+				{
+					Name:  "show",
+					Usage: "Print the effective value and source (flag, env, file, default) of every global setting",
+					Action: func(c *ucli.Context) error {
+						return s.write(c, s.config)
+					},
+				},
+			},
+		},
+	}
+}
+
+// applyConfig sets the global flags which are neither set on the command line nor in the environment
+// to the values of the selected profile, and records the effective configuration
+func (s *state) applyConfig(c *ucli.Context) error {
+	path := s.configPath
+	if path == "" && s.findConfig != nil {
+		path = s.findConfig()
+	}
+
+	var name string
+	var profile config.Profile
+	if path != "" {
+		f, err := config.Load(path)
+		if err != nil {
+			return err
+		}
+		if name, profile, err = f.Profile(s.profile); err != nil {
+			return err
+		}
+	} else if s.profile != "" {
+		return fmt.Errorf("%w for profile '%s', searched %s", config.ErrNoFile, s.profile, strings.Join(config.Paths(), ", "))
+	}
+
+	flags := map[string]bool{}
+	for _, f := range c.App.Flags {
+		flags[f.Names()[0]] = true
+	}
+	for k := range profile {
+		if !flags[k] || k == "config" || k == "profile" {
+			return fmt.Errorf("unknown setting '%s' in profile '%s' of config file %s", k, name, path)
+		}
+	}
+
+	// the sources are determined before the profile values are set
+	onCommandLine := map[string]bool{}
+	for _, n := range c.LocalFlagNames() {
+		onCommandLine[n] = true
+	}
+	s.config = &config.Effective{File: path, Profile: name}
+	for _, f := range c.App.Flags {
+		n := f.Names()[0]
+		if n == "help" {
+			continue
+		}
+		source := config.SourceDefault
+		switch v, ok := profile[n]; {
+		case onCommandLine[n]:
+			source = config.SourceFlag
+		case c.IsSet(n):
+			source = config.SourceEnv
+		case ok:
+			if err := c.Set(n, v); err != nil {
+				return fmt.Errorf("invalid value '%s' of setting '%s' in profile '%s': %w", v, n, name, err)
+			}
+			source = config.SourceFile
+		}
+		value := fmt.Sprint(c.Value(n))
+		if secretSettings[n] && value != "" {
+			s.redactor.AddSecret(value)
+			value = logging.Redacted
+		}
+		s.config.Settings = append(s.config.Settings, config.Setting{Name: n, Value: value, Source: source})
+	}
+	return nil
+}
@@ -1,18 +1,12 @@
 package cli
 
 import (
-	"fmt"
-	"time"
-
-	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
 	ucli "github.com/urfave/cli/v2"
 )
 
-var (
-	dr apimclient.DisasterRecovery
-
-	// DisasterRecoveryCli contains the disaster recovery cli
-	DisasterRecoveryCli = []*ucli.Command{
+// disasterRecoveryCommands returns the disaster recovery cli definition
+func (s *state) disasterRecoveryCommands() []*ucli.Command {
+	return []*ucli.Command{
 		{
 			Name:     "dr",
 			Category: "Management",
@@ -23,28 +17,28 @@ var (
 					Usage:       "the storage account where the disaster recovery backup is stored",
 					Required:    true,
 					EnvVars:     []string{"STORAGEACCOUNT"},
-					Destination: &dr.Storage.AccountName,
+					Destination: &s.dr.StorageAccount,
 				},
 				&ucli.StringFlag{
 					Name:        "storageaccountrg",
 					Usage:       "the storage account resource group",
 					Required:    true,
 					EnvVars:     []string{"STORAGEACCOUNTRG"},
-					Destination: &dr.Storage.ResourceGroup,
+					Destination: &s.dr.StorageResourceGroup,
 				},
 				&ucli.StringFlag{
 					Name:        "blobname",
 					Usage:       "the blob container containing the api management backups",
 					Required:    true,
 					EnvVars:     []string{"BLOBNAME"},
-					Destination: &dr.Storage.BlobName,
+					Destination: &s.dr.Container,
 				},
 				&ucli.StringFlag{
 					Name:        "backupname",
 					Usage:       "the name of the backup to create or restore from",
 					Required:    false,
 					EnvVars:     []string{"BACKUPNAME"},
-					Destination: &dr.BackupName,
+					Destination: &s.dr.BackupName,
 				},
 			},
 			Subcommands: []*ucli.Command{
@@ -52,32 +46,25 @@ var (
 					Name:  "backup",
 					Usage: "Backup the api management service",
 					Action: func(c *ucli.Context) error {
-						err := apimClient.Backup(apimClient.ResourceGroup, apimClient.ServiceName, dr.Parameters)
+						r, err := s.client.Backup(commandContext(c), &s.dr)
 						if err != nil {
-							return ucli.Exit(err, 1)
+							return s.exit(err)
 						}
-						return nil
+						return s.write(c, r)
 					},
 				},
 				{
 					Name:  "restore",
 					Usage: "Restore the api management service",
 					Action: func(c *ucli.Context) error {
-						err := apimClient.Restore(apimClient.ResourceGroup, apimClient.ServiceName, dr.Parameters)
+						r, err := s.client.Restore(commandContext(c), &s.dr)
 						if err != nil {
-							return ucli.Exit(err, 1)
+							return s.exit(err)
 						}
-						return nil
+						return s.write(c, r)
 					},
 				},
 			},
-			Before: func(c *ucli.Context) error {
-				if len(dr.BackupName) == 0 {
-					dr.BackupName = fmt.Sprintf("%s-%d", apimClient.ServiceName, time.Now().Unix())
-				}
-				dr.Initialize(apimClient.Subscription)
-				return nil
-			},
 		},
 	}
-)
+}
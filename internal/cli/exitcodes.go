@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"context"
+	"errors"
+
+	"github.com/foryouandyourcustomers/azapim/internal/authentication"
+	"github.com/foryouandyourcustomers/azapim/pkg/azapim"
+	ucli "github.com/urfave/cli/v2"
+)
+
+const (
+	// ExitCodeError is returned for all errors without a dedicated exit code
+	ExitCodeError = 1
+	// ExitCodeSpecNotFound is returned if the openapi spec can't be loaded
+	ExitCodeSpecNotFound = 2
+	// ExitCodePolicyReadFailure is returned if the xml policy can't be loaded
+	ExitCodePolicyReadFailure = 3
+	// ExitCodeStorageKeyUnavailable is returned if the storage account key can't be retrieved
+	ExitCodeStorageKeyUnavailable = 4
+	// ExitCodeAuthentication is returned if the authentication against azure fails
+	ExitCodeAuthentication = 5
+	// ExitCodePreflightFailed is returned if the preflight checks found missing permissions
+	ExitCodePreflightFailed = 6
+	// ExitCodeInterrupted is returned if the command was canceled by a signal or exceeded the timeout
+	ExitCodeInterrupted = 7
+	// ExitCodeConflict is returned if a resource was changed concurrently and the update was rejected
+	ExitCodeConflict = 8
+	// ExitCodeBreakingChange is returned if the spec breaks the deployed version and breaking changes are refused
+	ExitCodeBreakingChange = 9
+)
+
+// exit maps the given error to an urfave cli exit error with the matching exit code
+func (s *state) exit(err error) error {
+	if err == nil {
+		return nil
+	}
+	// the messages may contain sas tokens or keys of failed requests
+	return ucli.Exit(s.redactor.Redact(err.Error()), exitCode(err))
+}
+
+// exitCode returns the exit code of the given error
+func exitCode(err error) int {
+	var specErr *azapim.SpecNotFoundError
+	var policyErr *azapim.PolicyReadError
+	var keyErr *azapim.StorageKeyUnavailableError
+	var authErr *authentication.Error
+	var credErr *authentication.MissingCredentialError
+	var conflictErr *azapim.ConflictError
+	var breakingErr *azapim.BreakingChangeError
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ExitCodeInterrupted
+	case errors.As(err, &specErr):
+		return ExitCodeSpecNotFound
+	case errors.As(err, &policyErr):
+		return ExitCodePolicyReadFailure
+	case errors.As(err, &keyErr):
+		return ExitCodeStorageKeyUnavailable
+	case errors.As(err, &authErr), errors.As(err, &credErr):
+		return ExitCodeAuthentication
+	case errors.As(err, &conflictErr):
+		return ExitCodeConflict
+	case errors.As(err, &breakingErr):
+		return ExitCodeBreakingChange
+	default:
+		return ExitCodeError
+	}
+}
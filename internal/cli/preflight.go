@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/internal/preflight"
+	"github.com/foryouandyourcustomers/azapim/pkg/azapim"
+	ucli "github.com/urfave/cli/v2"
+)
+
+// preflightCommands returns the preflight cli definition
+func (s *state) preflightCommands() []*ucli.Command {
+	var o azapim.PreflightOptions
+	return []*ucli.Command{
+		{
+			Name:     "preflight",
+			Aliases:  []string{"whoami"},
+			Category: "Management",
+			Usage:    "Show the authenticated principal and check its permissions for the given commands",
+			Flags: []ucli.Flag{
+				&ucli.StringSliceFlag{
+					Name:    "for",
+					Usage:   fmt.Sprintf("commands to check the permissions for, any of %s", enum.Join(preflight.Commands)),
+					Value:   ucli.NewStringSlice(preflight.CommandVersionedAPI),
+					EnvVars: []string{"PREFLIGHT_FOR"},
+				},
+				&ucli.StringFlag{
+					Name:        "storageaccount",
+					Usage:       "the storage account used for backup and restore",
+					Required:    false,
+					EnvVars:     []string{"STORAGEACCOUNT"},
+					Destination: &o.StorageAccount,
+				},
+				&ucli.StringFlag{
+					Name:        "storageaccountrg",
+					Usage:       "the storage account resource group",
+					Required:    false,
+					EnvVars:     []string{"STORAGEACCOUNTRG"},
+					Destination: &o.StorageResourceGroup,
+				},
+				&ucli.BoolFlag{
+					Name:        "transactional",
+					Usage:       "check the permissions of the rollback of transactional versioned api deployments",
+					EnvVars:     []string{"TRANSACTIONAL"},
+					Destination: &o.Transactional,
+				},
+				&ucli.StringFlag{
+					Name:        "history-storageaccount",
+					Usage:       "the storage account of the deployment history",
+					EnvVars:     []string{"HISTORY_STORAGEACCOUNT"},
+					Destination: &o.History.StorageAccount,
+				},
+				&ucli.StringFlag{
+					Name:        "history-storageaccountrg",
+					Usage:       "the resource group of the storage account of the deployment history",
+					EnvVars:     []string{"HISTORY_STORAGEACCOUNTRG"},
+					Destination: &o.History.ResourceGroup,
+				},
+			},
+			Action: func(c *ucli.Context) error {
+				o.Commands = c.StringSlice("for")
+				o.Lookup = s.lookedUp
+				r, err := s.client.Preflight(commandContext(c), o)
+				if err != nil {
+					return s.exit(err)
+				}
+				logPreflightReport(commandContext(c), r)
+				if err := s.write(c, r); err != nil {
+					return err
+				}
+				if !r.OK() {
+					return ucli.Exit(
+						fmt.Sprintf("preflight failed: service ready: %t, missing permissions: %d", r.Service.Ready, len(r.Gaps())),
+						ExitCodePreflightFailed,
+					)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func logPreflightReport(ctx context.Context, r *azapim.PreflightReport) {
+	log := logging.From(ctx)
+	if r.PrincipalError != "" {
+		log.Warnf("Unable to resolve the authenticated principal: %s", r.PrincipalError)
+	} else {
+		log.Infof("Authenticated as %s '%s' (object id: %s, application id: %s, tenant: %s)",
+			r.Principal.Type, r.Principal.Name, r.Principal.ObjectID, r.Principal.ApplicationID, r.Principal.TenantID)
+	}
+
+	switch {
+	case r.Service.Error != "":
+		log.Errorf("API management service '%s' not available: %s", r.Service.ID, r.Service.Error)
+	case !r.Service.Ready:
+		log.Errorf("API management service '%s' is in provisioning state '%s'", r.Service.ID, r.Service.ProvisioningState)
+	default:
+		log.Infof("API management service '%s' is in provisioning state '%s'", r.Service.ID, r.Service.ProvisioningState)
+	}
+
+	for _, c := range r.Checks {
+		switch {
+		case c.Error != "":
+			log.Errorf("Unable to check '%s' on '%s' (%s): %s", c.Action, c.Scope, strings.Join(c.Commands, ", "), c.Error)
+		case !c.Allowed:
+			log.Errorf("Missing permission '%s' on '%s' (%s)", c.Action, c.Scope, strings.Join(c.Commands, ", "))
+		default:
+			log.Infof("Permission '%s' on '%s' granted", c.Action, c.Scope)
+		}
+	}
+}
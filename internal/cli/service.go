@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/pkg/azapim"
+)
+
+// applyServiceID sets the subscription, resource group and name of the service from the resource id.
+// values set explicitly must match the id
+func (s *state) applyServiceID() error {
+	if s.serviceID == "" {
+		return nil
+	}
+	id, err := azapim.ParseServiceID(s.serviceID)
+	if err != nil {
+		return err
+	}
+	return s.setService(id, "the service id "+s.serviceID)
+}
+
+// setService sets the subscription, resource group and name of the service. values set explicitly must match
+// the id, source names the origin of the id in the error
+func (s *state) setService(id azapim.ServiceID, source string) error {
+	for _, v := range []struct {
+		name  string
+		value *string
+		id    string
+	}{
+		{"subscription", &s.service.Subscription, id.Subscription},
+		{"resourcegroup", &s.service.ResourceGroup, id.ResourceGroup},
+		{"servicename", &s.service.ServiceName, id.Name},
+	} {
+		if *v.value != "" && !strings.EqualFold(*v.value, v.id) {
+			return fmt.Errorf("%s '%s' doesn't match %s", v.name, *v.value, source)
+		}
+	}
+	s.service.Subscription = id.Subscription
+	s.service.ResourceGroup = id.ResourceGroup
+	s.service.ServiceName = id.Name
+	return nil
+}
+
+// validateService checks that the api management service is identified. the flags aren't marked as required
+// because urfave checks required flags before they can be set from the config file
+func (s *state) validateService() error {
+	if s.service.ServiceName == "" {
+		return errors.New("the api management service is required, set --service-id or --servicename with the flags, environment variables or a profile of the config file")
+	}
+	return nil
+}
+
+// lookupService finds the subscription and resource group of the service by its name if either isn't set.
+// values set explicitly must match the found service
+func (s *state) lookupService(ctx context.Context, a autorest.Authorizer, env azure.Environment) error {
+	if s.service.Subscription != "" && s.service.ResourceGroup != "" {
+		return nil
+	}
+	if s.findService == nil {
+		return errors.New("subscription and resource group of the api management service are required")
+	}
+	id, err := s.findService(ctx, s.service.ServiceName, s.service.Subscription,
+		azapim.WithAuthorizer(a),
+		azapim.WithEnvironment(env),
+		azapim.WithRetryPolicy(s.retry),
+	)
+	if err != nil {
+		return err
+	}
+	logging.From(ctx).Infof("Found api management service %s", id)
+	// a service with the same name in another resource group isn't the one the user asked for
+	if err := s.setService(id, "the found service "+id.String()); err != nil {
+		return err
+	}
+	s.lookedUp = true
+	return nil
+}
@@ -1,15 +1,21 @@
 package cli
 
 import (
-	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	ucli "github.com/urfave/cli/v2"
-)
 
-var (
-	apiDef apidefinition.Definition
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/pkg/azapim"
+)
 
-	// VersionedAPICli contains the versionedapi cli definition
-	VersionedAPICli = []*ucli.Command{
+// versionedAPICommands returns the versionedapi cli definition
+func (s *state) versionedAPICommands() []*ucli.Command {
+	return []*ucli.Command{
 		{
 			Name:     "versionedapi",
 			Category: "Apis",
@@ -20,7 +26,32 @@ var (
 					Usage:       "name (api id) of the api to deploy",
 					Required:    true,
 					EnvVars:     []string{"APIID"},
-					Destination: &apiDef.APIID,
+					Destination: &s.apiDef.APIID,
+				},
+				&ucli.StringFlag{
+					Name:        "history-storageaccount",
+					Usage:       "the storage account recording the deployment history, no history is recorded if empty",
+					EnvVars:     []string{"HISTORY_STORAGEACCOUNT"},
+					Destination: &s.history.StorageAccount,
+				},
+				&ucli.StringFlag{
+					Name:        "history-storageaccountrg",
+					Usage:       "the resource group of the history storage account",
+					EnvVars:     []string{"HISTORY_STORAGEACCOUNTRG"},
+					Destination: &s.history.ResourceGroup,
+				},
+				&ucli.StringFlag{
+					Name:        "history-container",
+					Usage:       "the blob container of the deployment history",
+					Value:       azapim.DefaultHistoryContainer,
+					EnvVars:     []string{"HISTORY_CONTAINER"},
+					Destination: &s.history.Container,
+				},
+				&ucli.StringFlag{
+					Name:        "history-endpoint",
+					Usage:       "the url the requests of the history are sent to instead of the blob service url derived from the account and cloud",
+					EnvVars:     []string{"HISTORY_ENDPOINT"},
+					Destination: &s.history.Endpoint,
 				},
 			},
 			Subcommands: []*ucli.Command{
@@ -28,68 +59,510 @@ var (
 					Name:  "create",
 					Usage: "Create or Update a versioned api",
 					Action: func(c *ucli.Context) error {
-						apiDef.SetDefaults()
-						apiDef.GetOpenAPISpec()
-						apiDef.GetXMLPolicy()
-						err := apimClient.CreateOrUpdate(&apiDef)
+						if err := s.configureMetadata(c); err != nil {
+							return s.exit(err)
+						}
+						if err := s.configureSpec(); err != nil {
+							return s.exit(err)
+						}
+						r, err := s.client.CreateOrUpdateVersionedAPI(commandContext(c), &s.apiDef)
+						var rbErr *azapim.RollbackError
+						var breakingErr *azapim.BreakingChangeError
+						if errors.As(err, &rbErr) || errors.As(err, &breakingErr) {
+							// the result documents what was rolled back or which changes are breaking
+							if wErr := s.write(c, r); wErr != nil {
+								logging.From(commandContext(c)).Errorf("Unable to write the result: %s", wErr)
+							}
+						}
 						if err != nil {
-							return ucli.Exit(err, 1)
+							return s.exit(err)
 						}
-						return nil
+						if s.history.StorageAccount != "" {
+							rec, err := s.client.RecordDeployment(commandContext(c), s.history, &s.apiDef)
+							if err != nil {
+								if wErr := s.write(c, r); wErr != nil {
+									logging.From(commandContext(c)).Errorf("Unable to write the result: %s", wErr)
+								}
+								return s.exit(fmt.Errorf("the api was deployed but recording the deployment failed: %w", err))
+							}
+							r.DeploymentID = rec.ID
+						}
+						return s.write(c, r)
 					},
-					Flags: []ucli.Flag{
+					Flags: append([]ucli.Flag{
 						&ucli.StringFlag{
 							Name:        "openapispec",
-							Usage:       "Url or path to openapi spec definition (file:// or https://)",
-							Required:    true,
+							Usage:       "Url or path to openapi spec definition (file:// or https://), the wsdl of soap APIs, the endpoint or schema of graphql APIs. websocket APIs have none",
 							EnvVars:     []string{"OPENAPISPEC"},
-							Destination: &apiDef.OpenAPISpecPath,
+							Destination: &s.apiDef.OpenAPISpecPath,
 						},
 						&ucli.StringFlag{
 							Name:        "xmlpolicy",
 							Usage:       "Url or path to xml policy (file:// or https://)",
 							Required:    false,
 							EnvVars:     []string{"XMLPOLICY"},
-							Destination: &apiDef.XMLPolicyPath,
+							Destination: &s.apiDef.XMLPolicyPath,
 						},
 						&ucli.StringFlag{
 							Name:        "apipath",
 							Usage:       "the api path relative to the apim service url",
 							Required:    true,
 							EnvVars:     []string{"APIPATH"},
-							Destination: &apiDef.APIPath,
+							Destination: &s.apiDef.APIPath,
 						},
+						s.apiVersionFlag(),
 						&ucli.StringFlag{
-							Name:        "apiversion",
-							Usage:       "version number for the versioned api deplopyment",
-							Required:    true,
-							EnvVars:     []string{"APIVERSION"},
-							Destination: &apiDef.APIVersion,
+							Name:        "apirevision",
+							Usage:       "revision number of the api version, revisions other than 1 are created from the api. 1 if not set",
+							EnvVars:     []string{"APIREVISION"},
+							Destination: &s.apiDef.APIRevision,
 						},
 						&ucli.StringFlag{
 							Name:        "apiserviceurl",
 							Usage:       "Absolute URL of the backend service implementing this API",
 							Required:    true,
 							EnvVars:     []string{"APISERVICEURL"},
-							Destination: &apiDef.APIServiceURL,
+							Destination: &s.apiDef.APIServiceURL,
 						},
 						&ucli.StringFlag{
 							Name:        "apiproducts",
-							Usage:       "Comma separated list of products to assign the API to, Attention: tool isnt removing API from ANY products at the moment",
+							Usage:       "Comma separated list of products to assign the API to. assignments to other products are kept, a failed --transactional deployment removes the assignments it added and deprecate --remove-from-products removes the version from products",
 							Required:    false,
 							EnvVars:     []string{"APIPRODUCTS"},
-							Destination: &apiDef.APIProductsRaw,
+							Destination: &s.metadata.products,
 						},
 						&ucli.StringFlag{
 							Name:        "apidisplayname",
 							Usage:       "Display name in the API management service ",
 							Required:    false,
 							EnvVars:     []string{"APIDISPLAYNAME"},
-							Destination: &apiDef.APIDisplayName,
+							Destination: &s.apiDef.APIDisplayName,
+						},
+						&ucli.BoolFlag{
+							Name:        "transactional",
+							Usage:       "restore the prior state of the version set, api, policy and product assignments if a step fails",
+							EnvVars:     []string{"TRANSACTIONAL"},
+							Destination: &s.apiDef.Transactional,
+						},
+						&ucli.BoolFlag{
+							Name:        "fail-on-breaking",
+							Usage:       "compare the openapi spec with the deployed version and refuse breaking changes, suggesting a new --apiversion",
+							EnvVars:     []string{"FAIL_ON_BREAKING"},
+							Destination: &s.apiDef.FailOnBreaking,
+						},
+					}, append(s.metadataFlags(), append(s.fetchFlags(), s.transformFlags()...)...)...),
+				},
+				{
+					Name:  "next-version",
+					Usage: "Suggest the next version of the api for an openapi spec, a new version for breaking changes or a new revision for compatible changes",
+					Action: func(c *ucli.Context) error {
+						if err := s.configureSpec(); err != nil {
+							return s.exit(err)
+						}
+						v, err := s.client.NextVersion(commandContext(c), &s.apiDef, c.String("initial-version"))
+						if err != nil {
+							return s.exit(err)
+						}
+						return s.write(c, v)
+					},
+					Flags: append([]ucli.Flag{
+						&ucli.StringFlag{
+							Name:        "openapispec",
+							Usage:       "Url or path to openapi spec definition (file:// or https://)",
+							Required:    true,
+							EnvVars:     []string{"OPENAPISPEC"},
+							Destination: &s.apiDef.OpenAPISpecPath,
+						},
+						&ucli.StringFlag{
+							Name:  "initial-version",
+							Usage: "`version` suggested if no version of the api is deployed",
+							Value: "v1",
+						},
+					}, append(s.fetchFlags(), s.transformFlags()...)...),
+				},
+				{
+					Name:  "deprecate",
+					Usage: "Mark an api version as deprecated with a notice, Deprecation and Sunset response headers and optionally remove it from products",
+					Action: func(c *ucli.Context) error {
+						d, err := s.deprecation(c)
+						if err != nil {
+							return s.exit(err)
+						}
+						r, err := s.client.Deprecate(commandContext(c), d)
+						if err != nil {
+							return s.exit(err)
+						}
+						return s.write(c, r)
+					},
+					Flags: []ucli.Flag{
+						s.apiVersionFlag(),
+						&ucli.StringFlag{
+							Name:     "sunset",
+							Usage:    "`date` (YYYY-MM-DD) the version will be removed",
+							Required: true,
+							EnvVars:  []string{"SUNSET"},
+						},
+						&ucli.StringFlag{
+							Name:    "deprecated-since",
+							Usage:   "`date` (YYYY-MM-DD) of the deprecation, today if empty",
+							EnvVars: []string{"DEPRECATED_SINCE"},
+						},
+						&ucli.StringFlag{
+							Name:    "remove-from-products",
+							Usage:   "Comma separated list of products to remove the version from, so new consumers can't subscribe to it",
+							EnvVars: []string{"REMOVE_FROM_PRODUCTS"},
+						},
+					},
+				},
+				{
+					Name:  "history",
+					Usage: "List the recorded deployments of an api version",
+					Action: func(c *ucli.Context) error {
+						if err := s.requireHistory(); err != nil {
+							return s.exit(err)
+						}
+						h, err := s.client.History(commandContext(c), s.history, s.apiDef.APIID, s.apiDef.APIVersion)
+						if err != nil {
+							return s.exit(err)
+						}
+						return s.write(c, h)
+					},
+					Flags: []ucli.Flag{
+						s.apiVersionFlag(),
+					},
+				},
+				{
+					Name:  "rollback",
+					Usage: "Re-apply the spec, policy, service url and products of a recorded deployment",
+					Action: func(c *ucli.Context) error {
+						if err := s.requireHistory(); err != nil {
+							return s.exit(err)
+						}
+						r, err := s.client.Rollback(commandContext(c), s.history, s.apiDef.APIID, s.apiDef.APIVersion, c.String("to"))
+						var rbErr *azapim.RollbackError
+						if errors.As(err, &rbErr) {
+							if wErr := s.write(c, r); wErr != nil {
+								logging.From(commandContext(c)).Errorf("Unable to write the result: %s", wErr)
+							}
+						}
+						if err != nil {
+							return s.exit(err)
+						}
+						return s.write(c, r)
+					},
+					Flags: []ucli.Flag{
+						s.apiVersionFlag(),
+						&ucli.StringFlag{
+							Name:     "to",
+							Usage:    "id of the recorded deployment to roll back to, see versionedapi history",
+							Required: true,
 						},
 					},
 				},
 			},
 		},
 	}
-)
+}
+
+// apiVersionFlag returns the flag of the version of the versioned api
+func (s *state) apiVersionFlag() ucli.Flag {
+	return &ucli.StringFlag{
+		Name:        "apiversion",
+		Usage:       "version number for the versioned api deplopyment",
+		Required:    true,
+		EnvVars:     []string{"APIVERSION"},
+		Destination: &s.apiDef.APIVersion,
+	}
+}
+
+// fetchOptions configure the download of specs and policies at http(s) urls
+type fetchOptions struct {
+	mode        string
+	fallback    bool
+	headers     ucli.StringSlice
+	bearerToken string
+	caFile      string
+}
+
+// fetchFlags returns the flags defining how specs and policies at http(s) urls are imported
+func (s *state) fetchFlags() []ucli.Flag {
+	return []ucli.Flag{
+		&ucli.StringFlag{
+			Name:        "fetch",
+			Usage:       fmt.Sprintf("how specs and policies at http(s) urls are imported, one of %s. link lets the APIM service download them, local downloads and uploads them inline", enum.Join(azapim.FetchModes)),
+			Value:       string(azapim.FetchLink),
+			EnvVars:     []string{"FETCH"},
+			Destination: &s.fetch.mode,
+		},
+		&ucli.BoolFlag{
+			Name:        "fetch-fallback",
+			Usage:       "import the link if the download fails with --fetch local",
+			EnvVars:     []string{"FETCH_FALLBACK"},
+			Destination: &s.fetch.fallback,
+		},
+		&ucli.StringSliceFlag{
+			Name:        "fetch-header",
+			Usage:       "`header` sent with the downloads of --fetch local, e.g. 'X-Api-Key: value', repeatable",
+			EnvVars:     []string{"FETCH_HEADERS"},
+			Destination: &s.fetch.headers,
+		},
+		&ucli.StringFlag{
+			Name:        "fetch-bearer-token",
+			Usage:       "bearer `token` sent with the downloads of --fetch local",
+			EnvVars:     []string{"FETCH_BEARER_TOKEN"},
+			Destination: &s.fetch.bearerToken,
+		},
+		&ucli.StringFlag{
+			Name:        "fetch-ca-file",
+			Usage:       "`path` of pem encoded ca certificates trusted by the downloads of --fetch local",
+			EnvVars:     []string{"FETCH_CA_FILE"},
+			Destination: &s.fetch.caFile,
+		},
+	}
+}
+
+// configureSpec sets the fetch mode, the downloader and the transform of the definition
+func (s *state) configureSpec() error {
+	mode, err := azapim.ParseFetchMode(s.fetch.mode)
+	if err != nil {
+		return err
+	}
+	s.redactor.AddSecret(s.fetch.bearerToken)
+	d, err := azapim.NewDownloader(s.fetch.headers.Value(), s.fetch.bearerToken, s.fetch.caFile)
+	if err != nil {
+		return err
+	}
+	s.apiDef.Fetch = mode
+	s.apiDef.FetchFallback = s.fetch.fallback
+	s.apiDef.Downloader = d
+	s.apiDef.Transform.Servers = s.transform.servers.Value()
+	s.apiDef.Transform.StripTags = s.transform.stripTags.Value()
+	return nil
+}
+
+// transformOptions are the list values of the spec transform
+type transformOptions struct {
+	servers   ucli.StringSlice
+	stripTags ucli.StringSlice
+}
+
+// transformFlags returns the flags modifying the openapi spec before the import
+func (s *state) transformFlags() []ucli.Flag {
+	return []ucli.Flag{
+		&ucli.StringSliceFlag{
+			Name:        "spec-server",
+			Usage:       "`url` replacing the servers of the openapi spec, repeatable",
+			EnvVars:     []string{"SPEC_SERVERS"},
+			Destination: &s.transform.servers,
+		},
+		&ucli.StringSliceFlag{
+			Name:        "spec-strip-tag",
+			Usage:       "remove the operations with the `tag` from the openapi spec, repeatable",
+			EnvVars:     []string{"SPEC_STRIP_TAGS"},
+			Destination: &s.transform.stripTags,
+		},
+		&ucli.BoolFlag{
+			Name:        "spec-strip-internal",
+			Usage:       "remove the paths and operations marked with x-internal: true from the openapi spec",
+			EnvVars:     []string{"SPEC_STRIP_INTERNAL"},
+			Destination: &s.apiDef.Transform.StripInternal,
+		},
+		&ucli.StringFlag{
+			Name:        "spec-title",
+			Usage:       "set the title of the openapi spec",
+			EnvVars:     []string{"SPEC_TITLE"},
+			Destination: &s.apiDef.Transform.Title,
+		},
+		&ucli.StringFlag{
+			Name:        "spec-description",
+			Usage:       "set the description of the openapi spec",
+			EnvVars:     []string{"SPEC_DESCRIPTION"},
+			Destination: &s.apiDef.Transform.Description,
+		},
+		&ucli.StringFlag{
+			Name:        "spec-contact-name",
+			Usage:       "set the contact name of the openapi spec",
+			EnvVars:     []string{"SPEC_CONTACT_NAME"},
+			Destination: &s.apiDef.Transform.ContactName,
+		},
+		&ucli.StringFlag{
+			Name:        "spec-contact-email",
+			Usage:       "set the contact email of the openapi spec",
+			EnvVars:     []string{"SPEC_CONTACT_EMAIL"},
+			Destination: &s.apiDef.Transform.ContactEmail,
+		},
+		&ucli.StringFlag{
+			Name:        "spec-contact-url",
+			Usage:       "set the contact url of the openapi spec",
+			EnvVars:     []string{"SPEC_CONTACT_URL"},
+			Destination: &s.apiDef.Transform.ContactURL,
+		},
+		&ucli.StringFlag{
+			Name:        "spec-operationid-suffix",
+			Usage:       "append the `suffix` to every operationId of the openapi spec",
+			EnvVars:     []string{"SPEC_OPERATIONID_SUFFIX"},
+			Destination: &s.apiDef.Transform.OperationIDSuffix,
+		},
+	}
+}
+
+// deprecation returns the deprecation of the api version defined by the flags
+func (s *state) deprecation(c *ucli.Context) (*azapim.Deprecation, error) {
+	d := &azapim.Deprecation{APIID: s.apiDef.APIID, APIVersion: s.apiDef.APIVersion}
+	var err error
+	if d.Sunset, err = time.Parse(azapim.DateFormat, c.String("sunset")); err != nil {
+		return nil, fmt.Errorf("invalid --sunset '%s', expected YYYY-MM-DD", c.String("sunset"))
+	}
+	if since := c.String("deprecated-since"); since != "" {
+		if d.Since, err = time.Parse(azapim.DateFormat, since); err != nil {
+			return nil, fmt.Errorf("invalid --deprecated-since '%s', expected YYYY-MM-DD", since)
+		}
+	}
+	for _, p := range strings.Split(c.String("remove-from-products"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			d.RemoveFromProducts = append(d.RemoveFromProducts, p)
+		}
+	}
+	return d, nil
+}
+
+// metadataOptions are the values of the api metadata which are converted before the deployment
+type metadataOptions struct {
+	apiType              string
+	protocols            string
+	subscriptionRequired bool
+	isCurrent            bool
+	tags                 string
+	products             string
+}
+
+// metadataFlags returns the flags of the optional properties of the api
+func (s *state) metadataFlags() []ucli.Flag {
+	return []ucli.Flag{
+		&ucli.StringFlag{
+			Name:        "apidescription",
+			Usage:       "Description of the API in the API management service",
+			EnvVars:     []string{"APIDESCRIPTION"},
+			Destination: &s.apiDef.Metadata.Description,
+		},
+		&ucli.StringFlag{
+			Name:        "apitype",
+			Usage:       fmt.Sprintf("type of the API, one of %s. soap apis are imported from the wsdl at --openapispec, graphql apis from the endpoint at the url or the schema in the file", enum.Join(azapim.APITypes)),
+			Value:       string(azapim.APITypes[0]),
+			EnvVars:     []string{"APITYPE"},
+			Destination: &s.metadata.apiType,
+		},
+		&ucli.StringFlag{
+			Name:        "protocols",
+			Usage:       "Comma separated list of protocols the API is exposed with, https and http for http, soap and graphql APIs, wss and ws for websocket APIs. https or wss if not set",
+			EnvVars:     []string{"PROTOCOLS"},
+			Destination: &s.metadata.protocols,
+		},
+		&ucli.StringFlag{
+			Name:        "apitags",
+			Usage:       "Comma separated list of tags assigned to the API, missing tags are created",
+			EnvVars:     []string{"APITAGS"},
+			Destination: &s.metadata.tags,
+		},
+		&ucli.BoolFlag{
+			Name:        "subscription-required",
+			Usage:       "require a subscription key for calls of the API, disable with --subscription-required=false",
+			Value:       true,
+			EnvVars:     []string{"SUBSCRIPTION_REQUIRED"},
+			Destination: &s.metadata.subscriptionRequired,
+		},
+		&ucli.StringFlag{
+			Name:        "subscription-key-header",
+			Usage:       "`name` of the subscription key header, Ocp-Apim-Subscription-Key if empty",
+			EnvVars:     []string{"SUBSCRIPTION_KEY_HEADER"},
+			Destination: &s.apiDef.Metadata.SubscriptionKeyHeader,
+		},
+		&ucli.StringFlag{
+			Name:        "subscription-key-query",
+			Usage:       "`name` of the subscription key query parameter, subscription-key if empty",
+			EnvVars:     []string{"SUBSCRIPTION_KEY_QUERY"},
+			Destination: &s.apiDef.Metadata.SubscriptionKeyQuery,
+		},
+		&ucli.StringFlag{
+			Name:        "contact-name",
+			Usage:       "`name` of the contact person or organization of the API",
+			EnvVars:     []string{"CONTACT_NAME"},
+			Destination: &s.apiDef.Metadata.ContactName,
+		},
+		&ucli.StringFlag{
+			Name:        "contact-email",
+			Usage:       "email `address` of the contact of the API",
+			EnvVars:     []string{"CONTACT_EMAIL"},
+			Destination: &s.apiDef.Metadata.ContactEmail,
+		},
+		&ucli.StringFlag{
+			Name:        "contact-url",
+			Usage:       "`url` of the contact information of the API",
+			EnvVars:     []string{"CONTACT_URL"},
+			Destination: &s.apiDef.Metadata.ContactURL,
+		},
+		&ucli.StringFlag{
+			Name:        "license-name",
+			Usage:       "`name` of the license of the API",
+			EnvVars:     []string{"LICENSE_NAME"},
+			Destination: &s.apiDef.Metadata.LicenseName,
+		},
+		&ucli.StringFlag{
+			Name:        "license-url",
+			Usage:       "`url` of the license of the API",
+			EnvVars:     []string{"LICENSE_URL"},
+			Destination: &s.apiDef.Metadata.LicenseURL,
+		},
+		&ucli.StringFlag{
+			Name:        "terms-of-service-url",
+			Usage:       "`url` of the terms of service of the API",
+			EnvVars:     []string{"TERMS_OF_SERVICE_URL"},
+			Destination: &s.apiDef.Metadata.TermsOfServiceURL,
+		},
+		&ucli.BoolFlag{
+			Name:        "is-current",
+			Usage:       "make the deployed revision the current revision of the API, the service decides if not set",
+			EnvVars:     []string{"IS_CURRENT"},
+			Destination: &s.metadata.isCurrent,
+		},
+	}
+}
+
+// configureMetadata sets the api type, the protocols, the subscription requirement, is current, the tags and the
+// products of the definition
+func (s *state) configureMetadata(c *ucli.Context) error {
+	t, err := azapim.ParseAPIType(s.metadata.apiType)
+	if err != nil {
+		return err
+	}
+	s.apiDef.Metadata.APIType = t
+	if s.apiDef.APIProtocols, err = azapim.ParseProtocols(s.metadata.protocols); err != nil {
+		return err
+	}
+	if err := s.apiDef.Validate(); err != nil {
+		return err
+	}
+	s.apiDef.SubscriptionRequired = &s.metadata.subscriptionRequired
+	if c.IsSet("is-current") {
+		s.apiDef.Metadata.IsCurrent = &s.metadata.isCurrent
+	}
+	for _, tag := range strings.Split(s.metadata.tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			s.apiDef.Metadata.Tags = append(s.apiDef.Metadata.Tags, tag)
+		}
+	}
+	for _, product := range strings.Split(s.metadata.products, ",") {
+		if product = strings.TrimSpace(product); product != "" {
+			s.apiDef.APIProducts = append(s.apiDef.APIProducts, product)
+		}
+	}
+	return nil
+}
+
+// requireHistory checks that the storage account of the deployment history is set
+func (s *state) requireHistory() error {
+	if s.history.StorageAccount == "" || s.history.ResourceGroup == "" {
+		return errors.New("--history-storageaccount and --history-storageaccountrg are required")
+	}
+	return nil
+}
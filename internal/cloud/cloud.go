@@ -0,0 +1,59 @@
+// Package cloud resolves the azure environment (endpoints and token audience) of the azure cloud to use
+package cloud
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+)
+
+const (
+	// AzurePublic is the azure public cloud
+	AzurePublic = "AzurePublic"
+	// AzureChina is the azure china cloud operated by 21Vianet
+	AzureChina = "AzureChina"
+	// AzureUSGovernment is the azure us government cloud
+	AzureUSGovernment = "AzureUSGovernment"
+)
+
+// Names contains all supported cloud names
+var Names = []string{AzurePublic, AzureChina, AzureUSGovernment}
+
+var environments = map[string]azure.Environment{
+	strings.ToLower(AzurePublic):       azure.PublicCloud,
+	strings.ToLower(AzureChina):        azure.ChinaCloud,
+	strings.ToLower(AzureUSGovernment): azure.USGovernmentCloud,
+}
+
+// Environment returns the azure environment of the named cloud. if an arm endpoint is given it
+// replaces the resource manager endpoint of the cloud, the token audience defaults to the one of the cloud
+func Environment(name string, armEndpoint string, armAudience string) (azure.Environment, error) {
+	if name == "" {
+		name = AzurePublic
+	}
+	env, ok := environments[strings.ToLower(name)]
+	if !ok {
+		return azure.Environment{}, fmt.Errorf("unknown cloud '%s', valid clouds are %s", name, enum.Join(Names))
+	}
+
+	if armEndpoint != "" {
+		u, err := url.Parse(armEndpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return azure.Environment{}, fmt.Errorf("invalid arm endpoint '%s', an absolute url is required", armEndpoint)
+		}
+		env.ResourceManagerEndpoint = armEndpoint
+	}
+	if armAudience != "" {
+		env.TokenAudience = armAudience
+	}
+	return env, nil
+}
+
+// ResourceManagerURI returns the resource manager endpoint of the environment without trailing slash
+func ResourceManagerURI(env azure.Environment) string {
+	return strings.TrimSuffix(env.ResourceManagerEndpoint, "/")
+}
@@ -0,0 +1,44 @@
+package cloud
+
+import (
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+func TestEnvironment(t *testing.T) {
+	tests := []struct {
+		name         string
+		endpoint     string
+		audience     string
+		wantURI      string
+		wantAudience string
+	}{
+		{"", "", "", "https://management.azure.com", azure.PublicCloud.TokenAudience},
+		{"azurechina", "", "", "https://management.chinacloudapi.cn", azure.ChinaCloud.TokenAudience},
+		{AzureUSGovernment, "", "", "https://management.usgovcloudapi.net", azure.USGovernmentCloud.TokenAudience},
+		{AzurePublic, "https://arm.example.com/", "", "https://arm.example.com", azure.PublicCloud.TokenAudience},
+		{AzurePublic, "https://arm.example.com", "https://arm.example.com/", "https://arm.example.com", "https://arm.example.com/"},
+	}
+	for _, tt := range tests {
+		env, err := Environment(tt.name, tt.endpoint, tt.audience)
+		if err != nil {
+			t.Fatalf("Environment(%s, %s) unexpected error: %v", tt.name, tt.endpoint, err)
+		}
+		if got := ResourceManagerURI(env); got != tt.wantURI {
+			t.Errorf("Environment(%s, %s) uri = %s, want %s", tt.name, tt.endpoint, got, tt.wantURI)
+		}
+		if env.TokenAudience != tt.wantAudience {
+			t.Errorf("Environment(%s, %s) audience = %s, want %s", tt.name, tt.endpoint, env.TokenAudience, tt.wantAudience)
+		}
+	}
+}
+
+func TestEnvironmentInvalid(t *testing.T) {
+	if _, err := Environment("AzureMoon", "", ""); err == nil {
+		t.Error("expected error for unknown cloud")
+	}
+	if _, err := Environment(AzurePublic, "management.example.com", ""); err == nil {
+		t.Error("expected error for relative arm endpoint")
+	}
+}
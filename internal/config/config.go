@@ -0,0 +1,124 @@
+// Package config loads the azapim configuration file. the file contains named profiles with values
+// of global flags, e.g. the subscription, resource group and name of the dev, staging and prod services
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+)
+
+// Sources of the effective settings, from the highest to the lowest precedence
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// File is the configuration file
+type File struct {
+	// Default is the profile used if none is selected
+	Default  string             `yaml:"default"`
+	Profiles map[string]Profile `yaml:"profiles"`
+	Path     string             `yaml:"-"`
+}
+
+// Profile contains values of global flags by flag name, e.g. subscription or auth-mode
+type Profile map[string]string
+
+// Paths returns the locations searched for the configuration file, ./azapim.yaml first, then ~/.azapim.yaml
+func Paths() []string {
+	paths := []string{"azapim.yaml"}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".azapim.yaml"))
+	}
+	return paths
+}
+
+// Find returns the first existing configuration file of Paths, empty if none exists
+func Find() string {
+	for _, p := range Paths() {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// Load reads the configuration file
+func Load(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+	f := &File{}
+	if err := yaml.UnmarshalStrict(b, f); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	f.Path = path
+	if f.Default != "" {
+		if _, ok := f.Profiles[f.Default]; !ok {
+			return nil, fmt.Errorf("default profile '%s' not defined in config file %s", f.Default, path)
+		}
+	}
+	return f, nil
+}
+
+// Profile returns the profile with the given name or the default profile if the name is empty.
+// it returns an empty name and profile if neither is set
+func (f *File) Profile(name string) (string, Profile, error) {
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	p, ok := f.Profiles[name]
+	if !ok {
+		return "", nil, fmt.Errorf("profile '%s' not defined in config file %s, defined are %s", name, f.Path, enum.Join(f.names()))
+	}
+	return name, p, nil
+}
+
+func (f *File) names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for n := range f.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ErrNoFile is returned if a profile is selected but no configuration file exists
+var ErrNoFile = errors.New("no config file found")
+
+// Setting is the effective value of a global flag and where it came from
+type Setting struct {
+	Name   string `json:"name" yaml:"name"`
+	Value  string `json:"value" yaml:"value"`
+	Source string `json:"source" yaml:"source"`
+}
+
+// Effective is the configuration used by a command
+type Effective struct {
+	File     string    `json:"file,omitempty" yaml:"file,omitempty"`
+	Profile  string    `json:"profile,omitempty" yaml:"profile,omitempty"`
+	Settings []Setting `json:"settings" yaml:"settings"`
+}
+
+// Table returns the settings as table rows
+func (e *Effective) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(e.Settings))
+	for _, s := range e.Settings {
+		rows = append(rows, []string{s.Name, s.Value, s.Source})
+	}
+	return []string{"SETTING", "VALUE", "SOURCE"}, rows
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		profile string
+		want    string
+		wantErr bool
+	}{
+		{name: "default profile", content: "default: dev\nprofiles:\n  dev:\n    servicename: apim-dev\n", want: "apim-dev"},
+		{name: "selected profile", content: "default: dev\nprofiles:\n  dev: {}\n  prod:\n    servicename: apim-prod\n", profile: "prod", want: "apim-prod"},
+		{name: "no profile", content: "profiles:\n  dev:\n    servicename: apim-dev\n"},
+		{name: "unknown profile", content: "profiles:\n  dev: {}\n", profile: "prod", wantErr: true},
+		{name: "undefined default", content: "default: prod\nprofiles:\n  dev: {}\n", wantErr: true},
+		{name: "unknown key", content: "profile:\n  dev: {}\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "azapim.yaml")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			f, err := Load(path)
+			var p Profile
+			if err == nil {
+				_, p, err = f.Profile(tt.profile)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if p["servicename"] != tt.want {
+				t.Errorf("servicename = %s, want %s", p["servicename"], tt.want)
+			}
+		})
+	}
+}
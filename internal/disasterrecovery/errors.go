@@ -0,0 +1,22 @@
+package disasterrecovery
+
+import "fmt"
+
+// StorageKeyUnavailableError is returned if no access key can be retrieved for the storage account
+type StorageKeyUnavailableError struct {
+	AccountName   string
+	ResourceGroup string
+	Err           error
+}
+
+func (e *StorageKeyUnavailableError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("no access key available for storage account '%s/%s'", e.ResourceGroup, e.AccountName)
+	}
+	return fmt.Sprintf("unable to retrieve access key for storage account '%s/%s': %v", e.ResourceGroup, e.AccountName, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *StorageKeyUnavailableError) Unwrap() error {
+	return e.Err
+}
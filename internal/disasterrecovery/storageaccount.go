@@ -2,47 +2,68 @@ package disasterrecovery
 
 import (
 	"context"
-
-	log "github.com/sirupsen/logrus"
+	"errors"
 
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/storage/mgmt/storage"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
 )
 
+// AccountKeys lists the access keys of a storage account
+type AccountKeys interface {
+	ListKeys(ctx context.Context, resourceGroupName string, accountName string, expand storage.ListKeyExpand) (storage.AccountListKeysResult, error)
+}
+
 // StorageAccount is used to retrieve the storage access keys and check for the defined blob storage
 type StorageAccount struct {
-	Ctx           context.Context
-	AccountClient storage.AccountsClient
+	Authorizer    autorest.Authorizer
+	BaseURI       string
+	AccountClient AccountKeys
 	Subscription  string
 	ResourceGroup string
 	AccountName   string
 	BlobName      string
 	Key           string
+	// Retry is applied to the listing of the access keys, the zero value doesn't retry
+	Retry retry.Policy
 }
 
-// InitializeClient inializes the storage account client
-func (sc *StorageAccount) InitializeClient(s string) {
-	a, err := auth.NewAuthorizerFromCLI()
-	if err != nil {
-		// looking at the newauthorizerfromenvrionment funciton it
-		// seems that thing never returns an error whatsoever!
-		log.Debug("Unable to create authorizer from az cli. Lets load the authorizer from the environment and hope for the best!")
-		a, _ = auth.NewAuthorizerFromEnvironment()
-
-	}
+// InitializeClient inializes the storage account client with the authorizer and retrieves
+// the storage access key. an already set account client is kept
+func (sc *StorageAccount) InitializeClient(ctx context.Context, s string) error {
 	sc.Subscription = s
-	sc.AccountClient = storage.NewAccountsClient(sc.Subscription)
-	sc.AccountClient.Authorizer = a
-	sc.Ctx = context.Background()
-	sc.getKey()
+	if sc.AccountClient == nil {
+		if sc.Authorizer == nil {
+			return errors.New("no authorizer set for the storage account client")
+		}
+		baseURI := sc.BaseURI
+		if baseURI == "" {
+			baseURI = storage.DefaultBaseURI
+		}
+		c := storage.NewAccountsClientWithBaseURI(baseURI, sc.Subscription)
+		c.Authorizer = sc.Authorizer
+		retry.Disable(&c.Client)
+		sc.AccountClient = c
+	}
+	return sc.getKey(ctx)
 }
 
-func (sc *StorageAccount) getKey() {
-
-	k, err := sc.AccountClient.ListKeys(sc.Ctx, sc.ResourceGroup, sc.AccountName, "kerb")
+func (sc *StorageAccount) getKey(ctx context.Context) error {
+	var k storage.AccountListKeysResult
+	err := sc.Retry.Do(ctx, "list storage account keys", func(ctx context.Context) (err error) {
+		k, err = sc.AccountClient.ListKeys(ctx, sc.ResourceGroup, sc.AccountName, storage.Kerb)
+		return err
+	})
 	if err != nil {
-		log.Fatalln(err)
+		return &StorageKeyUnavailableError{AccountName: sc.AccountName, ResourceGroup: sc.ResourceGroup, Err: err}
+	}
+	if k.Keys == nil || len(*k.Keys) == 0 || (*k.Keys)[0].Value == nil {
+		return &StorageKeyUnavailableError{AccountName: sc.AccountName, ResourceGroup: sc.ResourceGroup}
 	}
 
 	sc.Key = *(*k.Keys)[0].Value
+	logging.AddSecret(ctx, sc.Key)
+	return nil
 }
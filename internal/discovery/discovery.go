@@ -0,0 +1,155 @@
+// Package discovery identifies an api management service by its resource id, or looks up its
+// subscription and resource group by the name of the service
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/subscriptions"
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/foryouandyourcustomers/azapim/internal/logging"
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+)
+
+// ServiceID identifies an api management service
+type ServiceID struct {
+	Subscription  string `json:"subscription"`
+	ResourceGroup string `json:"resourceGroup"`
+	Name          string `json:"name"`
+}
+
+// String returns the resource id of the service
+func (id ServiceID) String() string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ApiManagement/service/%s", id.Subscription, id.ResourceGroup, id.Name)
+}
+
+// Parse returns the service identified by the resource id
+// /subscriptions/{subscription}/resourceGroups/{resource group}/providers/Microsoft.ApiManagement/service/{name}
+func Parse(id string) (ServiceID, error) {
+	r, err := azure.ParseResourceID(id)
+	if err != nil || !strings.EqualFold(r.Provider, "Microsoft.ApiManagement") || !strings.EqualFold(r.ResourceType, "service") || len(strings.Split(strings.Trim(id, "/"), "/")) != 8 {
+		return ServiceID{}, fmt.Errorf("invalid api management service id '%s', expected /subscriptions/{subscription}/resourceGroups/{resource group}/providers/Microsoft.ApiManagement/service/{name}", id)
+	}
+	return ServiceID{Subscription: r.SubscriptionID, ResourceGroup: r.ResourceGroup, Name: r.ResourceName}, nil
+}
+
+// Subscriptions lists the ids of the subscriptions accessible to the principal
+type Subscriptions interface {
+	List(ctx context.Context) ([]string, error)
+}
+
+// Services lists the resource ids of the api management services of a subscription
+type Services interface {
+	List(ctx context.Context, subscription string) ([]string, error)
+}
+
+// NotFoundError is returned if no service with the name exists in the searched subscriptions
+type NotFoundError struct {
+	Name          string
+	Subscriptions []string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("api management service '%s' not found in the subscriptions %s", e.Name, strings.Join(e.Subscriptions, ", "))
+}
+
+// Find looks up the service by its name in the subscription, or in all subscriptions accessible to the principal if
+// the subscription is empty. service names are globally unique, subscriptions which can't be listed are skipped
+func Find(ctx context.Context, p retry.Policy, subs Subscriptions, services Services, name string, subscription string) (ServiceID, error) {
+	searched := []string{subscription}
+	if subscription == "" {
+		err := p.Do(ctx, "list subscriptions", func(ctx context.Context) (err error) {
+			searched, err = subs.List(ctx)
+			return err
+		})
+		if err != nil {
+			return ServiceID{}, err
+		}
+	}
+
+	var listErr error
+	for _, s := range searched {
+		var ids []string
+		err := p.Do(ctx, "list api management services", func(ctx context.Context) (err error) {
+			ids, err = services.List(ctx, s)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ServiceID{}, err
+			}
+			logging.From(ctx).Warnf("Unable to list the api management services of subscription %s: %s", s, err)
+			listErr = err
+			continue
+		}
+		for _, id := range ids {
+			if sid, err := Parse(id); err == nil && strings.EqualFold(sid.Name, name) {
+				return sid, nil
+			}
+		}
+	}
+	if listErr != nil && len(searched) == 1 {
+		return ServiceID{}, listErr
+	}
+	return ServiceID{}, &NotFoundError{Name: name, Subscriptions: searched}
+}
+
+// NewClients returns the clients listing subscriptions and services with the authorizer
+func NewClients(baseURI string, a autorest.Authorizer) (Subscriptions, Services) {
+	return subscriptionsClient{baseURI, a}, servicesClient{baseURI, a}
+}
+
+type subscriptionsClient struct {
+	baseURI    string
+	authorizer autorest.Authorizer
+}
+
+func (c subscriptionsClient) List(ctx context.Context) ([]string, error) {
+	client := subscriptions.NewClientWithBaseURI(c.baseURI)
+	client.Authorizer = c.authorizer
+	retry.Disable(&client.Client)
+	it, err := client.ListComplete(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for ; it.NotDone(); err = it.NextWithContext(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		if v := it.Value(); v.SubscriptionID != nil {
+			ids = append(ids, *v.SubscriptionID)
+		}
+	}
+	return ids, err
+}
+
+type servicesClient struct {
+	baseURI    string
+	authorizer autorest.Authorizer
+}
+
+func (c servicesClient) List(ctx context.Context, subscription string) ([]string, error) {
+	client := apimanagement.NewServiceClientWithBaseURI(c.baseURI, subscription)
+	client.Authorizer = c.authorizer
+	retry.Disable(&client.Client)
+	it, err := client.ListComplete(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for ; it.NotDone(); err = it.NextWithContext(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		if v := it.Value(); v.ID != nil {
+			ids = append(ids, *v.ID)
+		}
+	}
+	return ids, err
+}
@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		want    ServiceID
+		wantErr bool
+	}{
+		{name: "service", id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ApiManagement/service/apim", want: ServiceID{"sub", "rg", "apim"}},
+		{name: "lower case", id: "/subscriptions/sub/resourcegroups/rg/providers/microsoft.apimanagement/service/apim", want: ServiceID{"sub", "rg", "apim"}},
+		{name: "child resource", id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ApiManagement/service/apim/apis/httpbin", wantErr: true},
+		{name: "other provider", id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/apim", wantErr: true},
+		{name: "resource group", id: "/subscriptions/sub/resourceGroups/rg", wantErr: true},
+		{name: "name", id: "apim", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeSubscriptions []string
+
+func (f fakeSubscriptions) List(context.Context) ([]string, error) {
+	return f, nil
+}
+
+// fakeServices contains the service ids by subscription, subscriptions without entry can't be listed
+type fakeServices map[string][]string
+
+func (f fakeServices) List(_ context.Context, subscription string) ([]string, error) {
+	ids, ok := f[subscription]
+	if !ok {
+		return nil, errors.New("forbidden")
+	}
+	return ids, nil
+}
+
+func TestFind(t *testing.T) {
+	subs := fakeSubscriptions{"denied", "dev", "prod"}
+	services := fakeServices{
+		"dev":  {"/subscriptions/dev/resourceGroups/rg/providers/Microsoft.ApiManagement/service/apim-dev"},
+		"prod": {"/subscriptions/prod/resourceGroups/rg/providers/Microsoft.ApiManagement/service/apim-prod"},
+	}
+	tests := []struct {
+		name         string
+		service      string
+		subscription string
+		want         ServiceID
+		wantNotFound bool
+		wantErr      bool
+	}{
+		{name: "all subscriptions", service: "apim-prod", want: ServiceID{"prod", "rg", "apim-prod"}},
+		{name: "case insensitive", service: "APIM-Dev", want: ServiceID{"dev", "rg", "apim-dev"}},
+		{name: "subscription", service: "apim-dev", subscription: "dev", want: ServiceID{"dev", "rg", "apim-dev"}},
+		{name: "other subscription", service: "apim-dev", subscription: "prod", wantNotFound: true, wantErr: true},
+		{name: "unknown", service: "apim-test", wantNotFound: true, wantErr: true},
+		{name: "denied subscription", service: "apim-dev", subscription: "denied", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Find(context.Background(), retry.Policy{}, subs, services, tt.service, tt.subscription)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			var notFound *NotFoundError
+			if errors.As(err, &notFound) != tt.wantNotFound {
+				t.Errorf("err = %v, want not found %t", err, tt.wantNotFound)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
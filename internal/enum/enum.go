@@ -0,0 +1,14 @@
+// Package enum contains helpers for the string enums of flags and settings
+package enum
+
+import "strings"
+
+// Join returns the values as comma separated list, e.g. to name the supported values in the usage of a flag
+// or in the error of an unknown value
+func Join[T ~string](values []T) string {
+	s := make([]string, 0, len(values))
+	for _, v := range values {
+		s = append(s, string(v))
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,24 @@
+package enum
+
+import "testing"
+
+type color string
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []color
+		want   string
+	}{
+		{name: "none", values: nil, want: ""},
+		{name: "one", values: []color{"red"}, want: "red"},
+		{name: "many", values: []color{"red", "green", "blue"}, want: "red, green, blue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Join(tt.values); got != tt.want {
+				t.Errorf("Join() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
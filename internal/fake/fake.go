@@ -0,0 +1,716 @@
+// Package fake contains in-memory implementations of the azure clients used by azapim.
+// They record every call and allow to inject errors per operation.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/storage/mgmt/storage"
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
+)
+
+// Operation names used for recorded calls and injected errors
+const (
+	OpGetVersionSet            = "GetVersionSet"
+	OpDeleteVersionSet         = "DeleteVersionSet"
+	OpGetAPI                   = "GetAPI"
+	OpDeleteAPI                = "DeleteAPI"
+	OpExportAPI                = "ExportAPI"
+	OpListAPIs                 = "ListAPIs"
+	OpListAPIRevisions         = "ListAPIRevisions"
+	OpGetPolicy                = "GetPolicy"
+	OpDeletePolicy             = "DeletePolicy"
+	OpGetSchema                = "GetSchema"
+	OpDeleteSchema             = "DeleteSchema"
+	OpCheckProductAPI          = "CheckProductAPI"
+	OpRemoveFromProduct        = "RemoveFromProduct"
+	OpGetVersionSetETag        = "GetVersionSetETag"
+	OpGetAPIETag               = "GetAPIETag"
+	OpGetPolicyETag            = "GetPolicyETag"
+	OpCreateOrUpdateVersionSet = "CreateOrUpdateVersionSet"
+	OpCreateOrUpdateAPI        = "CreateOrUpdateAPI"
+	OpUpdateAPI                = "UpdateAPI"
+	OpCreateOrUpdatePolicy     = "CreateOrUpdatePolicy"
+	OpCreateOrUpdateSchema     = "CreateOrUpdateSchema"
+	OpAssignToProduct          = "AssignToProduct"
+	OpGetTag                   = "GetTag"
+	OpCreateTag                = "CreateTag"
+	OpCheckAPITag              = "CheckAPITag"
+	OpAssignTag                = "AssignTag"
+	OpDetachTag                = "DetachTag"
+	OpBackup                   = "Backup"
+	OpRestore                  = "Restore"
+	OpGetService               = "GetService"
+	OpListKeys                 = "ListKeys"
+)
+
+// Call is a single recorded call against the fake
+type Call struct {
+	Operation string
+	Name      string
+}
+
+// Service is an in-memory api management service
+type Service struct {
+	mu sync.Mutex
+
+	Subscription  string
+	ResourceGroup string
+	ServiceName   string
+	// VirtualNetworkType is the network of the service, None if empty
+	VirtualNetworkType apimanagement.VirtualNetworkType
+
+	VersionSets map[string]apimanagement.APIVersionSetContract
+	APIs        map[string]apimanagement.APICreateOrUpdateParameter
+	Policies    map[string]apimanagement.PolicyContract
+	// Schemas contains the graphql schemas of the apis
+	Schemas     map[string]apimanagement.SchemaContract
+	ProductAPIs map[string][]string
+	// Tags contains the display names of the tags, APITags the tags of the apis
+	Tags     map[string]string
+	APITags  map[string][]string
+	Backups  map[string]apimanagement.ServiceBackupRestoreParameters
+	Restores []apimanagement.ServiceBackupRestoreParameters
+	// ETags contains the etags of version sets, apis and policies by "kind/name"
+	ETags map[string]string
+
+	// Errors contains errors returned for the given operation
+	Errors map[string]error
+	// Calls contains all calls in the order they were made
+	Calls []Call
+
+	// updates counts the updates to derive unique etags, the calls may be reset
+	updates int
+}
+
+// NewService returns an empty in-memory api management service
+func NewService(subscription string, resourceGroup string, serviceName string) *Service {
+	return &Service{
+		Subscription:  subscription,
+		ResourceGroup: resourceGroup,
+		ServiceName:   serviceName,
+		VersionSets:   map[string]apimanagement.APIVersionSetContract{},
+		APIs:          map[string]apimanagement.APICreateOrUpdateParameter{},
+		Policies:      map[string]apimanagement.PolicyContract{},
+		Schemas:       map[string]apimanagement.SchemaContract{},
+		ProductAPIs:   map[string][]string{},
+		Tags:          map[string]string{},
+		APITags:       map[string][]string{},
+		Backups:       map[string]apimanagement.ServiceBackupRestoreParameters{},
+		ETags:         map[string]string{},
+		Errors:        map[string]error{},
+	}
+}
+
+// Install sets all clients of the given api management client to the fake implementations
+func (s *Service) Install(apim *apimclient.ApimClient) {
+	apim.Authorizer = autorest.NullAuthorizer{}
+	apim.Subscription = s.Subscription
+	apim.ResourceGroup = s.ResourceGroup
+	apim.ServiceName = s.ServiceName
+	apim.VersionSetClient = versionSets{s}
+	apim.APIClient = apis{s}
+	apim.APIExportClient = apiExports{s}
+	apim.APIRevisionClient = apiRevisions{s}
+	apim.PolicyClient = policies{s}
+	apim.SchemaClient = schemas{s}
+	apim.ProductsAPIClient = productAPIs{s}
+	apim.TagClient = tags{s}
+	apim.ServiceClient = services{s}
+}
+
+// Operations returns the names of all recorded operations in order
+func (s *Service) Operations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ops := make([]string, 0, len(s.Calls))
+	for _, c := range s.Calls {
+		ops = append(ops, c.Operation)
+	}
+	return ops
+}
+
+// ResourceID returns the azure resource id of a sub resource of the service
+func (s *Service) ResourceID(kind string, name string) string {
+	return fmt.Sprintf(
+		"/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ApiManagement/service/%s/%s/%s",
+		s.Subscription, s.ResourceGroup, s.ServiceName, kind, name,
+	)
+}
+
+// record records the call and returns the injected error for the operation
+func (s *Service) record(op string, name string, resourceGroup string, serviceName string) error {
+	s.Calls = append(s.Calls, Call{Operation: op, Name: name})
+	if err, ok := s.Errors[op]; ok {
+		return err
+	}
+	if resourceGroup != s.ResourceGroup || serviceName != s.ServiceName {
+		return notFound(fmt.Sprintf("service %s/%s", resourceGroup, serviceName))
+	}
+	return nil
+}
+
+// entityTag returns the etag of the resource like the azure sdk returns it in the response header
+func (s *Service) entityTag(key string) (autorest.Response, error) {
+	etag, ok := s.ETags[key]
+	if !ok {
+		return autorest.Response{}, notFound(key)
+	}
+	h := http.Header{}
+	h.Set("ETag", etag)
+	return autorest.Response{Response: &http.Response{StatusCode: http.StatusOK, Header: h}}, nil
+}
+
+// update checks the if-match condition of an update and changes the etag of the resource
+func (s *Service) update(key string, ifMatch string) error {
+	etag, ok := s.ETags[key]
+	if ifMatch != "" && (!ok || (ifMatch != "*" && ifMatch != etag)) {
+		return autorest.DetailedError{
+			StatusCode: http.StatusPreconditionFailed,
+			Message:    fmt.Sprintf("%s was changed, etag %s doesn't match", key, ifMatch),
+		}
+	}
+	s.updates++
+	s.ETags[key] = fmt.Sprintf("\"%d\"", s.updates)
+	return nil
+}
+
+// notFound returns an error similar to the errors returned by the azure sdk
+func notFound(what string) error {
+	return autorest.DetailedError{
+		StatusCode: http.StatusNotFound,
+		Message:    fmt.Sprintf("%s not found", what),
+	}
+}
+
+type versionSets struct{ s *Service }
+
+func (f versionSets) GetEntityTag(ctx context.Context, resourceGroupName string, serviceName string, versionSetID string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpGetVersionSetETag, versionSetID, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	return f.s.entityTag("apiVersionSets/" + versionSetID)
+}
+
+func (f versionSets) Get(ctx context.Context, resourceGroupName string, serviceName string, versionSetID string) (apimanagement.APIVersionSetContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpGetVersionSet, versionSetID, resourceGroupName, serviceName); err != nil {
+		return apimanagement.APIVersionSetContract{}, err
+	}
+	vs, ok := f.s.VersionSets[versionSetID]
+	if !ok {
+		return apimanagement.APIVersionSetContract{}, notFound(fmt.Sprintf("version set %s", versionSetID))
+	}
+	return vs, nil
+}
+
+func (f versionSets) Delete(ctx context.Context, resourceGroupName string, serviceName string, versionSetID string, ifMatch string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpDeleteVersionSet, versionSetID, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	delete(f.s.VersionSets, versionSetID)
+	delete(f.s.ETags, "apiVersionSets/"+versionSetID)
+	return autorest.Response{}, nil
+}
+
+func (f versionSets) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, versionSetID string, parameters apimanagement.APIVersionSetContract, ifMatch string) (apimanagement.APIVersionSetContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpCreateOrUpdateVersionSet, versionSetID, resourceGroupName, serviceName); err != nil {
+		return apimanagement.APIVersionSetContract{}, err
+	}
+	if err := f.s.update("apiVersionSets/"+versionSetID, ifMatch); err != nil {
+		return apimanagement.APIVersionSetContract{}, err
+	}
+	id := f.s.ResourceID("apiVersionSets", versionSetID)
+	parameters.ID = &id
+	parameters.Name = &versionSetID
+	f.s.VersionSets[versionSetID] = parameters
+	return parameters, nil
+}
+
+type apis struct{ s *Service }
+
+func (f apis) GetEntityTag(ctx context.Context, resourceGroupName string, serviceName string, apiid string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpGetAPIETag, apiid, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	return f.s.entityTag("apis/" + apiid)
+}
+
+func (f apis) Get(ctx context.Context, resourceGroupName string, serviceName string, apiid string) (apimanagement.APIContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpGetAPI, apiid, resourceGroupName, serviceName); err != nil {
+		return apimanagement.APIContract{}, err
+	}
+	api, ok := f.s.APIs[apiid]
+	if !ok {
+		return apimanagement.APIContract{}, notFound(fmt.Sprintf("api %s", apiid))
+	}
+	return f.s.apiContract(apiid, api), nil
+}
+
+func (f apis) List(ctx context.Context, resourceGroupName string, serviceName string) ([]apimanagement.APIContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpListAPIs, "", resourceGroupName, serviceName); err != nil {
+		return nil, err
+	}
+	// like azure the list contains the current revision of each api only
+	names := make([]string, 0, len(f.s.APIs))
+	for n := range f.s.APIs {
+		if !strings.Contains(n, ";rev=") {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	contracts := make([]apimanagement.APIContract, 0, len(names))
+	for _, n := range names {
+		contracts = append(contracts, f.s.apiContract(n, f.s.APIs[n]))
+	}
+	return contracts, nil
+}
+
+// apiContract returns the contract of the stored api
+func (s *Service) apiContract(apiid string, api apimanagement.APICreateOrUpdateParameter) apimanagement.APIContract {
+	id := s.ResourceID("apis", apiid)
+	p := api.APICreateOrUpdateProperties
+	return apimanagement.APIContract{
+		ID:   &id,
+		Name: &apiid,
+		APIContractProperties: &apimanagement.APIContractProperties{
+			DisplayName:                   p.DisplayName,
+			Description:                   p.Description,
+			ServiceURL:                    p.ServiceURL,
+			Path:                          p.Path,
+			Protocols:                     p.Protocols,
+			APIRevision:                   p.APIRevision,
+			APIVersion:                    p.APIVersion,
+			APIVersionSetID:               p.APIVersionSetID,
+			SubscriptionRequired:          p.SubscriptionRequired,
+			APIType:                       p.APIType,
+			SubscriptionKeyParameterNames: p.SubscriptionKeyParameterNames,
+			IsCurrent:                     p.IsCurrent,
+			Contact:                       p.Contact,
+			License:                       p.License,
+			TermsOfServiceURL:             p.TermsOfServiceURL,
+		},
+	}
+}
+
+func (f apis) Delete(ctx context.Context, resourceGroupName string, serviceName string, apiid string, ifMatch string, deleteRevisions *bool) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpDeleteAPI, apiid, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	delete(f.s.APIs, apiid)
+	delete(f.s.Policies, apiid)
+	delete(f.s.Schemas, apiid)
+	delete(f.s.ETags, "apis/"+apiid)
+	delete(f.s.ETags, "policies/"+apiid)
+	delete(f.s.APITags, apiid)
+	for p, apis := range f.s.ProductAPIs {
+		f.s.ProductAPIs[p] = remove(apis, apiid)
+	}
+	return autorest.Response{}, nil
+}
+
+func (f apis) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, apiid string, parameters apimanagement.APICreateOrUpdateParameter, ifMatch string) (apimanagement.APIContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpCreateOrUpdateAPI, apiid, resourceGroupName, serviceName); err != nil {
+		return apimanagement.APIContract{}, err
+	}
+	if err := f.s.update("apis/"+apiid, ifMatch); err != nil {
+		return apimanagement.APIContract{}, err
+	}
+	f.s.APIs[apiid] = parameters
+	id := f.s.ResourceID("apis", apiid)
+	return apimanagement.APIContract{ID: &id, Name: &apiid}, nil
+}
+
+func (f apis) Update(ctx context.Context, resourceGroupName string, serviceName string, apiid string, parameters apimanagement.APIUpdateContract, ifMatch string) (apimanagement.APIContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpUpdateAPI, apiid, resourceGroupName, serviceName); err != nil {
+		return apimanagement.APIContract{}, err
+	}
+	api, ok := f.s.APIs[apiid]
+	if !ok {
+		return apimanagement.APIContract{}, notFound(fmt.Sprintf("api %s", apiid))
+	}
+	if err := f.s.update("apis/"+apiid, ifMatch); err != nil {
+		return apimanagement.APIContract{}, err
+	}
+	// only the properties changed by azapim are merged
+	p := *api.APICreateOrUpdateProperties
+	if u := parameters.APIContractUpdateProperties; u != nil {
+		if u.DisplayName != nil {
+			p.DisplayName = u.DisplayName
+		}
+		if u.Description != nil {
+			p.Description = u.Description
+		}
+	}
+	api.APICreateOrUpdateProperties = &p
+	f.s.APIs[apiid] = api
+	return f.s.apiContract(apiid, api), nil
+}
+
+type apiRevisions struct{ s *Service }
+
+func (f apiRevisions) List(ctx context.Context, resourceGroupName string, serviceName string, apiid string) ([]apimanagement.APIRevisionContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpListAPIRevisions, apiid, resourceGroupName, serviceName); err != nil {
+		return nil, err
+	}
+	if _, ok := f.s.APIs[apiid]; !ok {
+		return nil, notFound(fmt.Sprintf("api %s", apiid))
+	}
+	// the revisions are copies of the api named {api};rev={revision}, the api itself is the current revision
+	names := []string{}
+	for n := range f.s.APIs {
+		if n == apiid || strings.HasPrefix(n, apiid+";rev=") {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	revisions := make([]apimanagement.APIRevisionContract, 0, len(names))
+	for _, n := range names {
+		p := f.s.APIs[n].APICreateOrUpdateProperties
+		current := n == apiid
+		id := f.s.ResourceID("apis", n)
+		revisions = append(revisions, apimanagement.APIRevisionContract{APIID: &id, APIRevision: p.APIRevision, IsCurrent: &current})
+	}
+	return revisions, nil
+}
+
+type apiExports struct{ s *Service }
+
+// Export returns the imported spec of the api whatever the format
+func (f apiExports) Export(ctx context.Context, resourceGroupName string, serviceName string, apiid string, format apimanagement.ExportFormat) (string, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpExportAPI, apiid, resourceGroupName, serviceName); err != nil {
+		return "", err
+	}
+	api, ok := f.s.APIs[apiid]
+	if !ok {
+		return "", notFound(fmt.Sprintf("api %s", apiid))
+	}
+	if api.Value == nil {
+		return "", nil
+	}
+	return *api.Value, nil
+}
+
+type policies struct{ s *Service }
+
+func (f policies) Get(ctx context.Context, resourceGroupName string, serviceName string, apiid string, format apimanagement.PolicyExportFormat) (apimanagement.PolicyContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpGetPolicy, apiid, resourceGroupName, serviceName); err != nil {
+		return apimanagement.PolicyContract{}, err
+	}
+	p, ok := f.s.Policies[apiid]
+	if !ok {
+		return apimanagement.PolicyContract{}, notFound(fmt.Sprintf("policy of api %s", apiid))
+	}
+	return p, nil
+}
+
+func (f policies) Delete(ctx context.Context, resourceGroupName string, serviceName string, apiid string, ifMatch string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpDeletePolicy, apiid, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	delete(f.s.Policies, apiid)
+	delete(f.s.ETags, "policies/"+apiid)
+	return autorest.Response{}, nil
+}
+
+func (f policies) GetEntityTag(ctx context.Context, resourceGroupName string, serviceName string, apiid string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpGetPolicyETag, apiid, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	return f.s.entityTag("policies/" + apiid)
+}
+
+func (f policies) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, apiid string, parameters apimanagement.PolicyContract, ifMatch string) (apimanagement.PolicyContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpCreateOrUpdatePolicy, apiid, resourceGroupName, serviceName); err != nil {
+		return apimanagement.PolicyContract{}, err
+	}
+	if _, ok := f.s.APIs[apiid]; !ok {
+		return apimanagement.PolicyContract{}, notFound(fmt.Sprintf("api %s", apiid))
+	}
+	if err := f.s.update("policies/"+apiid, ifMatch); err != nil {
+		return apimanagement.PolicyContract{}, err
+	}
+	id := f.s.ResourceID("apis", apiid) + "/policies/policy"
+	parameters.ID = &id
+	f.s.Policies[apiid] = parameters
+	return parameters, nil
+}
+
+type schemas struct{ s *Service }
+
+func (f schemas) Get(ctx context.Context, resourceGroupName string, serviceName string, apiid string, schemaID string) (apimanagement.SchemaContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpGetSchema, apiid, resourceGroupName, serviceName); err != nil {
+		return apimanagement.SchemaContract{}, err
+	}
+	schema, ok := f.s.Schemas[apiid]
+	if !ok {
+		return apimanagement.SchemaContract{}, notFound(fmt.Sprintf("schema of api %s", apiid))
+	}
+	return schema, nil
+}
+
+func (f schemas) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, apiid string, schemaID string, parameters apimanagement.SchemaContract, ifMatch string) (apimanagement.SchemaContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpCreateOrUpdateSchema, apiid, resourceGroupName, serviceName); err != nil {
+		return apimanagement.SchemaContract{}, err
+	}
+	if _, ok := f.s.APIs[apiid]; !ok {
+		return apimanagement.SchemaContract{}, notFound(fmt.Sprintf("api %s", apiid))
+	}
+	id := f.s.ResourceID("apis", apiid) + "/schemas/" + schemaID
+	parameters.ID = &id
+	f.s.Schemas[apiid] = parameters
+	return parameters, nil
+}
+
+func (f schemas) Delete(ctx context.Context, resourceGroupName string, serviceName string, apiid string, schemaID string, ifMatch string, force *bool) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpDeleteSchema, apiid, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	delete(f.s.Schemas, apiid)
+	return autorest.Response{}, nil
+}
+
+type productAPIs struct{ s *Service }
+
+func (f productAPIs) CheckEntityExists(ctx context.Context, resourceGroupName string, serviceName string, productID string, apiid string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpCheckProductAPI, productID, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	for _, a := range f.s.ProductAPIs[productID] {
+		if a == apiid {
+			return autorest.Response{Response: &http.Response{StatusCode: http.StatusNoContent}}, nil
+		}
+	}
+	return autorest.Response{}, notFound(fmt.Sprintf("api %s in product %s", apiid, productID))
+}
+
+func (f productAPIs) Delete(ctx context.Context, resourceGroupName string, serviceName string, productID string, apiid string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpRemoveFromProduct, productID, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	apis := remove(f.s.ProductAPIs[productID], apiid)
+	if len(apis) == len(f.s.ProductAPIs[productID]) {
+		return autorest.Response{}, notFound(fmt.Sprintf("api %s in product %s", apiid, productID))
+	}
+	f.s.ProductAPIs[productID] = apis
+	return autorest.Response{}, nil
+}
+
+func (f productAPIs) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, productID string, apiid string) (apimanagement.APIContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpAssignToProduct, productID, resourceGroupName, serviceName); err != nil {
+		return apimanagement.APIContract{}, err
+	}
+	if _, ok := f.s.APIs[apiid]; !ok {
+		return apimanagement.APIContract{}, notFound(fmt.Sprintf("api %s", apiid))
+	}
+	for _, a := range f.s.ProductAPIs[productID] {
+		if a == apiid {
+			id := f.s.ResourceID("apis", apiid)
+			return apimanagement.APIContract{ID: &id, Name: &apiid}, nil
+		}
+	}
+	f.s.ProductAPIs[productID] = append(f.s.ProductAPIs[productID], apiid)
+	id := f.s.ResourceID("apis", apiid)
+	return apimanagement.APIContract{ID: &id, Name: &apiid}, nil
+}
+
+type tags struct{ s *Service }
+
+func (f tags) GetEntityState(ctx context.Context, resourceGroupName string, serviceName string, tagID string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpGetTag, tagID, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	if _, ok := f.s.Tags[tagID]; !ok {
+		return autorest.Response{}, notFound(fmt.Sprintf("tag %s", tagID))
+	}
+	return autorest.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+}
+
+func (f tags) CreateOrUpdate(ctx context.Context, resourceGroupName string, serviceName string, tagID string, parameters apimanagement.TagCreateUpdateParameters, ifMatch string) (apimanagement.TagContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpCreateTag, tagID, resourceGroupName, serviceName); err != nil {
+		return apimanagement.TagContract{}, err
+	}
+	f.s.Tags[tagID] = *parameters.DisplayName
+	id := f.s.ResourceID("tags", tagID)
+	return apimanagement.TagContract{ID: &id, Name: &tagID}, nil
+}
+
+func (f tags) GetEntityStateByAPI(ctx context.Context, resourceGroupName string, serviceName string, apiid string, tagID string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpCheckAPITag, tagID, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	for _, t := range f.s.APITags[apiid] {
+		if t == tagID {
+			return autorest.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+		}
+	}
+	return autorest.Response{}, notFound(fmt.Sprintf("tag %s of api %s", tagID, apiid))
+}
+
+func (f tags) AssignToAPI(ctx context.Context, resourceGroupName string, serviceName string, apiid string, tagID string) (apimanagement.TagContract, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpAssignTag, tagID, resourceGroupName, serviceName); err != nil {
+		return apimanagement.TagContract{}, err
+	}
+	if _, ok := f.s.APIs[apiid]; !ok {
+		return apimanagement.TagContract{}, notFound(fmt.Sprintf("api %s", apiid))
+	}
+	if _, ok := f.s.Tags[tagID]; !ok {
+		return apimanagement.TagContract{}, notFound(fmt.Sprintf("tag %s", tagID))
+	}
+	f.s.APITags[apiid] = append(remove(f.s.APITags[apiid], tagID), tagID)
+	id := f.s.ResourceID("apis", apiid) + "/tags/" + tagID
+	return apimanagement.TagContract{ID: &id, Name: &tagID}, nil
+}
+
+func (f tags) DetachFromAPI(ctx context.Context, resourceGroupName string, serviceName string, apiid string, tagID string) (autorest.Response, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpDetachTag, tagID, resourceGroupName, serviceName); err != nil {
+		return autorest.Response{}, err
+	}
+	f.s.APITags[apiid] = remove(f.s.APITags[apiid], tagID)
+	return autorest.Response{}, nil
+}
+
+type services struct{ s *Service }
+
+func (f services) Get(ctx context.Context, resourceGroupName string, serviceName string) (apimanagement.ServiceResource, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpGetService, serviceName, resourceGroupName, serviceName); err != nil {
+		return apimanagement.ServiceResource{}, err
+	}
+	id := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ApiManagement/service/%s", f.s.Subscription, f.s.ResourceGroup, f.s.ServiceName)
+	state := "Succeeded"
+	gateway := fmt.Sprintf("https://%s.azure-api.net", f.s.ServiceName)
+	vnet := f.s.VirtualNetworkType
+	if vnet == "" {
+		vnet = apimanagement.VirtualNetworkTypeNone
+	}
+	return apimanagement.ServiceResource{
+		ID:   &id,
+		Name: &f.s.ServiceName,
+		ServiceProperties: &apimanagement.ServiceProperties{
+			ProvisioningState:  &state,
+			GatewayURL:         &gateway,
+			VirtualNetworkType: vnet,
+		},
+	}, nil
+}
+
+func (f services) Backup(ctx context.Context, resourceGroupName string, serviceName string, parameters apimanagement.ServiceBackupRestoreParameters) error {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpBackup, *parameters.BackupName, resourceGroupName, serviceName); err != nil {
+		return err
+	}
+	f.s.Backups[*parameters.BackupName] = parameters
+	return nil
+}
+
+func (f services) Restore(ctx context.Context, resourceGroupName string, serviceName string, parameters apimanagement.ServiceBackupRestoreParameters) error {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if err := f.s.record(OpRestore, *parameters.BackupName, resourceGroupName, serviceName); err != nil {
+		return err
+	}
+	if _, ok := f.s.Backups[*parameters.BackupName]; !ok {
+		return notFound(fmt.Sprintf("backup %s", *parameters.BackupName))
+	}
+	f.s.Restores = append(f.s.Restores, parameters)
+	return nil
+}
+
+// remove returns the list without the given value
+func remove(list []string, v string) []string {
+	result := []string{}
+	for _, e := range list {
+		if e != v {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// StorageAccounts is an in-memory list of storage accounts and their access keys
+type StorageAccounts struct {
+	// Keys contains the access keys by "resourcegroup/accountname"
+	Keys map[string][]string
+	// Err is returned by ListKeys if set
+	Err error
+}
+
+// ListKeys returns the access keys of the given storage account
+func (f *StorageAccounts) ListKeys(ctx context.Context, resourceGroupName string, accountName string, expand storage.ListKeyExpand) (storage.AccountListKeysResult, error) {
+	if f.Err != nil {
+		return storage.AccountListKeysResult{}, f.Err
+	}
+	keys, ok := f.Keys[resourceGroupName+"/"+accountName]
+	if !ok {
+		return storage.AccountListKeysResult{}, notFound(fmt.Sprintf("storage account %s/%s", resourceGroupName, accountName))
+	}
+	result := []storage.AccountKey{}
+	for i := range keys {
+		result = append(result, storage.AccountKey{Value: &keys[i]})
+	}
+	return storage.AccountListKeysResult{Keys: &result}, nil
+}
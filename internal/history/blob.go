@@ -0,0 +1,216 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+)
+
+// BlobStore stores the deployment records as json blobs "{prefix}/{api id}/{version}/{deployment}.json"
+// in a container of a storage account. requests are authorized with the shared key of the account
+type BlobStore struct {
+	Account string
+	Key     string
+	// Environment is the cloud of the storage account, the blob service url is derived from its storage endpoint suffix
+	Environment azure.Environment
+	// Endpoint replaces the blob service url of the cloud if set, e.g. https://account.blob.core.windows.net
+	Endpoint  string
+	Container string
+	Prefix    string
+	Client    *http.Client
+	// Retry is applied to every request of the blob service, the zero value doesn't retry
+	Retry retry.Policy
+}
+
+// Save creates the container if required and uploads the record, the spec and policy hashes are stored as metadata
+func (b *BlobStore) Save(ctx context.Context, r *Record) error {
+	c, err := b.container(ctx)
+	if err != nil {
+		return err
+	}
+	err = b.Retry.Do(ctx, "create history container", func(ctx context.Context) error {
+		_, err := c.CreateIfNotExists(nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("create container %s failed: %w", b.Container, err)
+	}
+	body, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	blob := c.GetBlobReference(b.blobName(r.APIID, r.APIVersion, r.ID))
+	blob.Properties.ContentType = "application/json"
+	blob.Metadata = storage.BlobMetadata{}
+	metadata := map[string]string{
+		"timestamp":  r.Timestamp.Format(time.RFC3339),
+		"gitsha":     r.GitSHA,
+		"spechash":   r.SpecHash,
+		"policyhash": r.PolicyHash,
+		"rollbackof": r.RollbackOf,
+	}
+	for k, v := range metadata {
+		// empty metadata values are rejected by the blob service
+		if v != "" {
+			blob.Metadata[k] = v
+		}
+	}
+	err = b.Retry.Do(ctx, "save deployment", func(ctx context.Context) error {
+		return blob.CreateBlockBlobFromReader(bytes.NewReader(body), nil)
+	})
+	if err != nil {
+		return fmt.Errorf("save deployment %s failed: %w", r.ID, err)
+	}
+	return nil
+}
+
+// Get downloads the record of the deployment
+func (b *BlobStore) Get(ctx context.Context, apiID string, version string, id string) (*Record, error) {
+	c, err := b.container(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var body io.ReadCloser
+	err = b.Retry.Do(ctx, "get deployment", func(ctx context.Context) (err error) {
+		body, err = c.GetBlobReference(b.blobName(apiID, version, id)).Get(nil)
+		return err
+	})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("%w: %s of api %s version %s", ErrNotFound, id, apiID, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get deployment %s failed: %w", id, err)
+	}
+	defer body.Close()
+	var r Record
+	if err := json.NewDecoder(body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("invalid record of deployment %s: %w", id, err)
+	}
+	return &r, nil
+}
+
+// List returns the deployments of the api version from the blob names and metadata, oldest first
+func (b *BlobStore) List(ctx context.Context, apiID string, version string) ([]Record, error) {
+	c, err := b.container(ctx)
+	if err != nil {
+		return nil, err
+	}
+	params := storage.ListBlobsParameters{
+		Prefix:  strings.TrimSuffix(b.blobName(apiID, version, ""), ".json"),
+		Include: &storage.IncludeBlobDataset{Metadata: true},
+	}
+	records := []Record{}
+	for {
+		var l storage.BlobListResponse
+		err := b.Retry.Do(ctx, "list deployments", func(ctx context.Context) (err error) {
+			l, err = c.ListBlobs(params)
+			return err
+		})
+		if isNotFound(err) {
+			// no deployment recorded yet
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list deployments failed: %w", err)
+		}
+		for _, blob := range l.Blobs {
+			records = append(records, record(blob, apiID, version))
+		}
+		if l.NextMarker == "" {
+			break
+		}
+		params.Marker = l.NextMarker
+	}
+	// the deployment ids start with the timestamp
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+// record returns the listed deployment from the name and metadata of its blob
+func record(blob storage.Blob, apiID string, version string) Record {
+	t, _ := time.Parse(time.RFC3339, blob.Metadata["timestamp"])
+	return Record{
+		ID:         strings.TrimSuffix(path.Base(blob.Name), ".json"),
+		APIID:      apiID,
+		APIVersion: version,
+		Timestamp:  t,
+		GitSHA:     blob.Metadata["gitsha"],
+		RollbackOf: blob.Metadata["rollbackof"],
+		SpecHash:   blob.Metadata["spechash"],
+		PolicyHash: blob.Metadata["policyhash"],
+	}
+}
+
+func (b *BlobStore) blobName(apiID string, version string, id string) string {
+	name := path.Join(apiID, version, id) + ".json"
+	if b.Prefix != "" {
+		name = path.Join(b.Prefix, name)
+	}
+	return name
+}
+
+// container returns the container of the history. the storage client doesn't take a context,
+// its requests are sent with the context of the call
+func (b *BlobStore) container(ctx context.Context) (*storage.Container, error) {
+	env := b.Environment
+	s := &sender{ctx: ctx, client: b.Client}
+	if b.Endpoint != "" {
+		var err error
+		if s.endpoint, err = url.Parse(strings.TrimSuffix(b.Endpoint, "/")); err != nil {
+			return nil, fmt.Errorf("invalid history endpoint '%s': %w", b.Endpoint, err)
+		}
+		// the requests are sent to the endpoint, the suffix only completes the url the client builds
+		if env.StorageEndpointSuffix == "" {
+			env.StorageEndpointSuffix = azure.PublicCloud.StorageEndpointSuffix
+		}
+	}
+	client, err := storage.NewBasicClientOnSovereignCloud(b.Account, b.Key, env)
+	if err != nil {
+		return nil, fmt.Errorf("storage account %s: %w", b.Account, err)
+	}
+	client.Sender = s
+	blobs := client.GetBlobService()
+	return blobs.GetContainerReference(b.Container), nil
+}
+
+// sender sends the requests of the storage client once, failed requests are retried with the Retry of the store. if the
+// endpoint is set the requests are sent to it, the signed resource stays the same
+type sender struct {
+	ctx      context.Context
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func (s *sender) Send(c *storage.Client, req *http.Request) (*http.Response, error) {
+	req = req.WithContext(s.ctx)
+	if s.endpoint != nil {
+		req.URL.Scheme = s.endpoint.Scheme
+		req.URL.Host = s.endpoint.Host
+		req.URL.Path = s.endpoint.Path + req.URL.Path
+		req.Host = s.endpoint.Host
+	}
+	if s.client != nil {
+		return s.client.Do(req)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// isNotFound returns true if the container or blob doesn't exist
+func isNotFound(err error) bool {
+	var e storage.AzureStorageServiceError
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,134 @@
+// Package history records successful deployments of versioned apis in a storage account
+// container, so a previous state of an api can be re-applied later
+package history
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+)
+
+// gitSHAVariables are the environment variables of common ci systems containing the commit of the build
+var gitSHAVariables = []string{"GITHUB_SHA", "BUILD_SOURCEVERSION", "CI_COMMIT_SHA", "GIT_COMMIT"}
+
+// ErrNotFound is returned if a deployment doesn't exist in the history
+var ErrNotFound = errors.New("deployment not found")
+
+// Record is a single deployment of a versioned api. Spec and Policy are omitted in listings
+type Record struct {
+	ID          string    `json:"id"`
+	APIID       string    `json:"apiId"`
+	APIVersion  string    `json:"apiVersion"`
+	Revision    string    `json:"revision,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	GitSHA      string    `json:"gitSha,omitempty"`
+	RollbackOf  string    `json:"rollbackOf,omitempty"`
+	SpecHash    string    `json:"specHash"`
+	PolicyHash  string    `json:"policyHash"`
+	DisplayName string    `json:"displayName,omitempty"`
+	APIPath     string    `json:"apiPath,omitempty"`
+	ServiceURL  string    `json:"serviceUrl,omitempty"`
+	Products    []string  `json:"products,omitempty"`
+	// Protocols, SubscriptionRequired and Metadata are the optional properties of the api
+	Protocols            []apimanagement.Protocol `json:"protocols,omitempty"`
+	SubscriptionRequired *bool                    `json:"subscriptionRequired,omitempty"`
+	Metadata             *apidefinition.Metadata  `json:"metadata,omitempty"`
+	SpecFormat           string                   `json:"specFormat,omitempty"`
+	Spec                 string                   `json:"spec,omitempty"`
+	PolicyFormat         string                   `json:"policyFormat,omitempty"`
+	Policy               string                   `json:"policy,omitempty"`
+}
+
+// Store saves and loads the deployment records
+type Store interface {
+	Save(ctx context.Context, r *Record) error
+	// List returns all deployments of the api version without spec and policy, oldest first
+	List(ctx context.Context, apiID string, version string) ([]Record, error)
+	Get(ctx context.Context, apiID string, version string, id string) (*Record, error)
+}
+
+// NewRecord returns the record of a deployment of the loaded definition
+func NewRecord(d *apidefinition.Definition, now time.Time) *Record {
+	specHash := hash(d.OpenAPISpec)
+	return &Record{
+		ID:                   fmt.Sprintf("%s-%s", now.UTC().Format("20060102T150405.000Z"), ShortHash(specHash)),
+		APIID:                d.APIID,
+		APIVersion:           d.APIVersion,
+		Revision:             d.APIRevision,
+		Timestamp:            now.UTC(),
+		GitSHA:               GitSHA(),
+		SpecHash:             specHash,
+		PolicyHash:           hash(d.XMLPolicy),
+		DisplayName:          d.APIDisplayName,
+		APIPath:              d.APIPath,
+		ServiceURL:           d.APIServiceURL,
+		Products:             d.APIProducts,
+		Protocols:            d.APIProtocols,
+		SubscriptionRequired: d.SubscriptionRequired,
+		Metadata:             &d.Metadata,
+		SpecFormat:           string(d.OpenAPIFormat),
+		Spec:                 d.OpenAPISpec,
+		PolicyFormat:         string(d.XMLPolicyFormat),
+		Policy:               d.XMLPolicy,
+	}
+}
+
+// Definition returns the definition to re-apply the recorded deployment. specs and policies imported
+// from links are recorded with their content, the endpoint of graphql apis as link
+func (r *Record) Definition() *apidefinition.Definition {
+	d := &apidefinition.Definition{
+		APIID:                r.APIID,
+		APIVersion:           r.APIVersion,
+		APIRevision:          r.Revision,
+		APIDisplayName:       r.DisplayName,
+		APIPath:              r.APIPath,
+		APIServiceURL:        r.ServiceURL,
+		APIProducts:          append([]string{}, r.Products...),
+		OpenAPISpec:          r.Spec,
+		OpenAPIFormat:        apimanagement.ContentFormat(r.SpecFormat),
+		XMLPolicy:            r.Policy,
+		XMLPolicyFormat:      apimanagement.PolicyContentFormat(r.PolicyFormat),
+		APIProtocols:         append([]apimanagement.Protocol{}, r.Protocols...),
+		SubscriptionRequired: r.SubscriptionRequired,
+	}
+	// deployments recorded before the metadata use the defaults
+	if r.Metadata != nil {
+		d.Metadata = *r.Metadata
+		d.Metadata.Tags = append([]string{}, r.Metadata.Tags...)
+	}
+	return d
+}
+
+// GitSHA returns the commit of the build from the environment of the ci system, empty if unknown
+func GitSHA() string {
+	for _, v := range gitSHAVariables {
+		if sha := os.Getenv(v); sha != "" {
+			return sha
+		}
+	}
+	return ""
+}
+
+func hash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+// ShortHash returns the first characters of the hash value
+func ShortHash(h string) string {
+	const n = 8
+	v := strings.TrimPrefix(h, "sha256:")
+	if len(v) > n {
+		return v[:n]
+	}
+	return v
+}
@@ -0,0 +1,136 @@
+package history
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/apimtest"
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+)
+
+func TestNewRecord(t *testing.T) {
+	t.Setenv("GITHUB_SHA", "abc123")
+	d := &apidefinition.Definition{
+		APIID:           "httpbin",
+		APIVersion:      "v1",
+		APIRevision:     "2",
+		APIPath:         "/httpbin",
+		APIServiceURL:   "https://backend",
+		APIProducts:     []string{"starter"},
+		OpenAPISpec:     "spec",
+		OpenAPIFormat:   "openapi+json",
+		XMLPolicyPath:   "policy.xml",
+		XMLPolicy:       "<policies />",
+		XMLPolicyFormat: "xml",
+		Metadata:        apidefinition.Metadata{Description: "the httpbin api", Tags: []string{"public"}},
+	}
+	r := NewRecord(d, time.Date(2021, 3, 4, 5, 6, 7, 8e6, time.UTC))
+
+	// the id contains the start of the sha256 of "spec"
+	if r.ID != "20210304T050607.008Z-d4f02eaa" {
+		t.Errorf("id = %s", r.ID)
+	}
+	if !strings.HasPrefix(r.SpecHash, "sha256:") || r.SpecHash == r.PolicyHash {
+		t.Errorf("spec hash = %s, policy hash = %s", r.SpecHash, r.PolicyHash)
+	}
+	if r.GitSHA != "abc123" {
+		t.Errorf("git sha = %s", r.GitSHA)
+	}
+
+	got := r.Definition()
+	if got.OpenAPISpec != d.OpenAPISpec || got.XMLPolicy != d.XMLPolicy || got.XMLPolicyPath != "" ||
+		got.APIServiceURL != d.APIServiceURL || got.APIRevision != "2" || len(got.APIProducts) != 1 ||
+		got.Metadata.Description != d.Metadata.Description || len(got.Metadata.Tags) != 1 {
+		t.Errorf("definition = %+v", got)
+	}
+}
+
+func TestBlobStore(t *testing.T) {
+	srv := apimtest.NewServer()
+	defer srv.Close()
+	b := &BlobStore{
+		Account:   "history",
+		Key:       base64.StdEncoding.EncodeToString([]byte("secretkey")),
+		Endpoint:  srv.URL + apimtest.BlobPrefix + "/history",
+		Container: "deployments",
+		Prefix:    "apim",
+	}
+	ctx := context.Background()
+
+	records, err := b.List(ctx, "httpbin", "v1")
+	if err != nil || len(records) != 0 {
+		t.Fatalf("list without container = %v, %v", records, err)
+	}
+	if _, err := b.Get(ctx, "httpbin", "v1", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get without container = %v, want %v", err, ErrNotFound)
+	}
+
+	d := &apidefinition.Definition{APIID: "httpbin", APIVersion: "v1", OpenAPISpec: "spec", XMLPolicy: "<policies />"}
+	first := NewRecord(d, time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC))
+	second := NewRecord(d, time.Date(2021, 3, 5, 5, 6, 7, 0, time.UTC))
+	second.RollbackOf = first.ID
+	for _, r := range []*Record{second, first} {
+		if err := b.Save(ctx, r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	records, err = b.List(ctx, "httpbin", "v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 || records[0].ID != first.ID || records[1].RollbackOf != first.ID ||
+		!records[0].Timestamp.Equal(first.Timestamp) || records[0].SpecHash != first.SpecHash || records[0].Spec != "" {
+		t.Errorf("records = %+v", records)
+	}
+	got, err := b.Get(ctx, "httpbin", "v1", first.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Spec != "spec" || got.Policy != "<policies />" {
+		t.Errorf("record = %+v", got)
+	}
+	if _, err := b.Get(ctx, "httpbin", "v1", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get missing deployment = %v, want %v", err, ErrNotFound)
+	}
+
+	b.Key = "not base64"
+	if err := b.Save(ctx, first); err == nil {
+		t.Error("invalid key accepted")
+	}
+}
+
+func TestBlobStoreRetry(t *testing.T) {
+	srv := apimtest.NewServer()
+	defer srv.Close()
+	b := &BlobStore{
+		Account:   "history",
+		Key:       base64.StdEncoding.EncodeToString([]byte("secretkey")),
+		Endpoint:  srv.URL + apimtest.BlobPrefix + "/history",
+		Container: "deployments",
+		Retry:     retry.Policy{MaxRetries: 2, BaseDelay: time.Millisecond},
+	}
+	ctx := context.Background()
+	r := NewRecord(&apidefinition.Definition{APIID: "httpbin", APIVersion: "v1", OpenAPISpec: "spec"}, time.Now())
+
+	srv.Fail(http.MethodPut, r.ID+".json", http.StatusServiceUnavailable, http.StatusInternalServerError)
+	if err := b.Save(ctx, r); err != nil {
+		t.Fatalf("save not retried: %v", err)
+	}
+	srv.Fail(http.MethodGet, r.ID+".json", http.StatusServiceUnavailable)
+	if _, err := b.Get(ctx, "httpbin", "v1", r.ID); err != nil {
+		t.Fatalf("get not retried: %v", err)
+	}
+
+	b.Retry = retry.Policy{}
+	srv.Fail(http.MethodGet, r.ID+".json", http.StatusServiceUnavailable)
+	if _, err := b.Get(ctx, "httpbin", "v1", r.ID); err == nil {
+		t.Error("get retried without retry policy")
+	}
+}
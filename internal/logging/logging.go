@@ -0,0 +1,131 @@
+// Package logging creates the loggers of azapim. log entries are written with the logger of the context
+// they are written for and carry its fields, secrets like storage account keys, client secrets and sas
+// tokens are redacted
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+)
+
+// Fields of the log entries
+const (
+	FieldSubscription = "subscription"
+	FieldService      = "service"
+	FieldAPIID        = "apiid"
+	FieldVersion      = "version"
+	FieldStep         = "step"
+)
+
+// Format of the log entries
+type Format string
+
+// Supported formats
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// Formats contains all supported formats
+var Formats = []Format{FormatText, FormatJSON}
+
+// ParseFormat returns the format with the given name, text if empty
+func ParseFormat(s string) (Format, error) {
+	if s == "" {
+		return FormatText, nil
+	}
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown log format '%s', supported are %s", s, enum.Join(Formats))
+}
+
+// New returns a logger of the level and format whose entries are redacted with the redactor.
+// entries are written to w, stderr if nil
+func New(level string, format string, w io.Writer, r *Redactor) (*log.Logger, error) {
+	l, err := log.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("unknown log level '%s', supported are %s", level, enum.Join(Levels))
+	}
+	f, err := ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	var formatter log.Formatter = &log.TextFormatter{}
+	if f == FormatJSON {
+		formatter = &log.JSONFormatter{}
+	}
+	logger := log.New()
+	logger.SetLevel(l)
+	logger.SetFormatter(&redactingFormatter{Formatter: formatter, redactor: r})
+	if w != nil {
+		logger.SetOutput(w)
+	}
+	return logger, nil
+}
+
+// Levels contains the names of all supported log levels
+var Levels = levels()
+
+func levels() []string {
+	l := make([]string, 0, len(log.AllLevels))
+	for _, v := range log.AllLevels {
+		l = append(l, v.String())
+	}
+	return l
+}
+
+type fieldsKey struct{}
+
+type loggerKey struct{}
+
+// WithLogger returns a context whose entries are written with the logger
+func WithLogger(ctx context.Context, l *log.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// WithFields returns a context whose log entries contain the fields in addition to the fields of the parent
+func WithFields(ctx context.Context, fields log.Fields) context.Context {
+	merged := log.Fields{}
+	for k, v := range FieldsOf(ctx) {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// WithStep returns a context whose log entries name the step
+func WithStep(ctx context.Context, step string) context.Context {
+	return WithFields(ctx, log.Fields{FieldStep: step})
+}
+
+// FieldsOf returns the log fields of the context
+func FieldsOf(ctx context.Context) log.Fields {
+	if ctx == nil {
+		return nil
+	}
+	f, _ := ctx.Value(fieldsKey{}).(log.Fields)
+	return f
+}
+
+// From returns the log entry of the logger of the context with its fields. the entry of the standard
+// logger is returned if the context has no logger
+func From(ctx context.Context) *log.Entry {
+	l := log.StandardLogger()
+	if ctx != nil {
+		if v, ok := ctx.Value(loggerKey{}).(*log.Logger); ok && v != nil {
+			l = v
+		}
+	}
+	return l.WithFields(FieldsOf(ctx))
+}
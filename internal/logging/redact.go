@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Redacted replaces secrets in log entries
+const Redacted = "REDACTED"
+
+// patterns match secrets by their context, the first group is kept
+var patterns = []*regexp.Regexp{
+	// sas tokens in urls and connection strings
+	regexp.MustCompile(`(?i)([?&;]sig=)[^&;\s"']+`),
+	// storage account keys and credentials in connection strings, query strings and json
+	regexp.MustCompile(`(?i)((?:accountkey|accesskey|account_key|access_key|client_secret|clientsecret|password|sharedaccesskey)"?\s*[=:]\s*"?)[^"&;,\s]+`),
+	// authorization headers
+	regexp.MustCompile(`(?i)((?:bearer|sharedkey)\s+)[^\s"',]+`),
+}
+
+// Redactor replaces the registered secrets, sas signatures, keys and credentials in log entries and messages.
+// a nil redactor replaces the secrets matched by their context only
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets map[string]bool
+}
+
+// NewRedactor returns a redactor without registered secrets
+func NewRedactor() *Redactor {
+	return &Redactor{secrets: map[string]bool{}}
+}
+
+// AddSecret registers a value which is redacted from all log entries, e.g. a retrieved storage account key.
+// values shorter than 8 characters are ignored to keep the entries readable
+func (r *Redactor) AddSecret(v string) {
+	if r == nil || len(v) < 8 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets[v] = true
+}
+
+// Redact replaces registered secrets, sas signatures, keys and credentials in s
+func (r *Redactor) Redact(s string) string {
+	if r != nil {
+		r.mu.RLock()
+		for v := range r.secrets {
+			s = strings.ReplaceAll(s, v, Redacted)
+		}
+		r.mu.RUnlock()
+	}
+	for _, p := range patterns {
+		s = p.ReplaceAllString(s, "${1}"+Redacted)
+	}
+	return s
+}
+
+type redactorKey struct{}
+
+// WithRedactor returns a context whose secrets are registered with the redactor
+func WithRedactor(ctx context.Context, r *Redactor) context.Context {
+	return context.WithValue(ctx, redactorKey{}, r)
+}
+
+// AddSecret registers the value with the redactor of the context, it is ignored if the context has none
+func AddSecret(ctx context.Context, v string) {
+	r, _ := ctx.Value(redactorKey{}).(*Redactor)
+	r.AddSecret(v)
+}
+
+// redactingFormatter redacts the message and fields of an entry before it is formatted
+type redactingFormatter struct {
+	log.Formatter
+	redactor *Redactor
+}
+
+func (f *redactingFormatter) Format(e *log.Entry) ([]byte, error) {
+	redacted := *e
+	redacted.Message = f.redactor.Redact(e.Message)
+	redacted.Data = make(log.Fields, len(e.Data))
+	for k, v := range e.Data {
+		switch v := v.(type) {
+		case string:
+			redacted.Data[k] = f.redactor.Redact(v)
+		case error:
+			redacted.Data[k] = f.redactor.Redact(v.Error())
+		case fmt.Stringer:
+			redacted.Data[k] = f.redactor.Redact(v.String())
+		default:
+			redacted.Data[k] = v
+		}
+	}
+	return f.Formatter.Format(&redacted)
+}
@@ -0,0 +1,137 @@
+// Package output writes the results of azapim commands as json, yaml or table
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+)
+
+// Format of the written result document
+type Format string
+
+const (
+	// JSON writes the result as indented json document
+	JSON Format = "json"
+	// YAML writes the result as yaml document
+	YAML Format = "yaml"
+	// Table writes the result as human readable table
+	Table Format = "table"
+)
+
+// Formats contains all supported output formats
+var Formats = []Format{JSON, YAML, Table}
+
+// Tabular is implemented by results with a custom table representation
+type Tabular interface {
+	Table() (headers []string, rows [][]string)
+}
+
+// ParseFormat returns the output format with the given name
+func ParseFormat(f string) (Format, error) {
+	for _, v := range Formats {
+		if strings.EqualFold(string(v), f) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format '%s', valid formats are %s", f, enum.Join(Formats))
+}
+
+// Write writes the result in the given format. the json field names of the result are used for all formats
+func Write(w io.Writer, f Format, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	switch f {
+	case JSON:
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case YAML:
+		// unmarshal the json document into an ordered map to keep the field order of the result
+		var doc yaml.MapSlice
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		y, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(y)
+		return err
+	case Table:
+		if t, ok := v.(Tabular); ok {
+			headers, rows := t.Table()
+			return writeTable(w, headers, rows)
+		}
+		var doc yaml.MapSlice
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		rows := [][]string{}
+		flatten("", doc, &rows)
+		return writeTable(w, []string{"FIELD", "VALUE"}, rows)
+	default:
+		return fmt.Errorf("unknown output format '%s'", f)
+	}
+}
+
+func writeTable(w io.Writer, headers []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+// flatten converts the document into field/value rows, nested fields are joined with a dot
+func flatten(prefix string, v interface{}, rows *[][]string) {
+	key := func(k interface{}) string {
+		if prefix == "" {
+			return fmt.Sprint(k)
+		}
+		return fmt.Sprintf("%s.%v", prefix, k)
+	}
+
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		for _, i := range t {
+			flatten(key(i.Key), i.Value, rows)
+		}
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, fmt.Sprint(k))
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flatten(key(k), t[k], rows)
+		}
+	case []interface{}:
+		scalars := []string{}
+		for i, e := range t {
+			switch e.(type) {
+			case yaml.MapSlice, map[interface{}]interface{}, []interface{}:
+				flatten(key(i), e, rows)
+			default:
+				scalars = append(scalars, fmt.Sprint(e))
+			}
+		}
+		if len(scalars) > 0 || len(t) == 0 {
+			*rows = append(*rows, []string{prefix, strings.Join(scalars, ",")})
+		}
+	case nil:
+		*rows = append(*rows, []string{prefix, ""})
+	default:
+		*rows = append(*rows, []string{prefix, fmt.Sprint(t)})
+	}
+}
@@ -0,0 +1,48 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+)
+
+type result struct {
+	Name     string   `json:"name"`
+	Products []string `json:"products"`
+	Nested   struct {
+		ID string `json:"id"`
+	} `json:"nested"`
+}
+
+func TestWrite(t *testing.T) {
+	r := result{Name: "httpbin-v1", Products: []string{"starter", "unlimited"}}
+	r.Nested.ID = "/apis/httpbin-v1"
+
+	tests := map[Format]string{
+		JSON: "{\n  \"name\": \"httpbin-v1\",\n  \"products\": [\n    \"starter\",\n    \"unlimited\"\n  ],\n  \"nested\": {\n    \"id\": \"/apis/httpbin-v1\"\n  }\n}\n",
+		YAML: "name: httpbin-v1\nproducts:\n- starter\n- unlimited\nnested:\n  id: /apis/httpbin-v1\n",
+		Table: "FIELD      VALUE\n" +
+			"name       httpbin-v1\n" +
+			"products   starter,unlimited\n" +
+			"nested.id  /apis/httpbin-v1\n",
+	}
+	for f, want := range tests {
+		t.Run(string(f), func(t *testing.T) {
+			b := &bytes.Buffer{}
+			if err := Write(b, f, r); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if b.String() != want {
+				t.Errorf("output =\n%s\nwant\n%s", b.String(), want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("JSON"); err != nil || f != JSON {
+		t.Errorf("ParseFormat(JSON) = %s, %v", f, err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,101 @@
+package preflight
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/authorization/mgmt/authorization"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+)
+
+// Permissions lists the effective permissions of the authenticated principal on a scope
+type Permissions interface {
+	List(ctx context.Context, scope string) ([]authorization.Permission, error)
+}
+
+// PermissionsClient lists permissions with the azure resource manager
+type PermissionsClient struct {
+	autorest.Client
+	BaseURI string
+}
+
+// NewPermissionsClient returns a permissions client for the given resource manager endpoint
+func NewPermissionsClient(baseURI string, a autorest.Authorizer) PermissionsClient {
+	c := PermissionsClient{Client: autorest.NewClientWithUserAgent(""), BaseURI: baseURI}
+	c.Authorizer = a
+	retry.Disable(&c.Client)
+	return c
+}
+
+// List returns all permissions of the authenticated principal on the scope
+func (c PermissionsClient) List(ctx context.Context, scope string) ([]authorization.Permission, error) {
+	req, err := autorest.CreatePreparer(
+		autorest.AsGet(),
+		autorest.WithBaseURL(c.BaseURI),
+		autorest.WithPath(scope+"/providers/Microsoft.Authorization/permissions"),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": "2015-07-01"}),
+	).Prepare((&http.Request{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := []authorization.Permission{}
+	for req != nil {
+		resp, err := c.Send(req)
+		if err != nil {
+			return nil, err
+		}
+		var result authorization.PermissionGetResult
+		err = autorest.Respond(
+			resp,
+			azure.WithErrorUnlessStatusCode(http.StatusOK),
+			autorest.ByUnmarshallingJSON(&result),
+			autorest.ByClosing())
+		if err != nil {
+			return nil, err
+		}
+		if result.Value != nil {
+			permissions = append(permissions, *result.Value...)
+		}
+
+		req = nil
+		if result.NextLink != nil && *result.NextLink != "" {
+			req, err = autorest.Prepare((&http.Request{}).WithContext(ctx), autorest.AsGet(), autorest.WithBaseURL(*result.NextLink))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return permissions, nil
+}
+
+// Allowed returns true if the action is granted by any of the permissions and not excluded by its not actions
+func Allowed(permissions []authorization.Permission, action string) bool {
+	for _, p := range permissions {
+		if p.Actions == nil || !matchesAny(*p.Actions, action) {
+			continue
+		}
+		if p.NotActions != nil && matchesAny(*p.NotActions, action) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// matchesAny returns true if the action matches one of the patterns. patterns may contain
+// wildcards (*) and are compared case insensitive
+func matchesAny(patterns []string, action string) bool {
+	for _, p := range patterns {
+		expr := "(?i)^" + strings.ReplaceAll(regexp.QuoteMeta(p), `\*`, ".*") + "$"
+		if regexp.MustCompile(expr).MatchString(action) {
+			return true
+		}
+	}
+	return false
+}
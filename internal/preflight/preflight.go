@@ -0,0 +1,292 @@
+// Package preflight checks that the authenticated principal is able to execute azapim commands
+// against an api management service before any change is made
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/authorization/mgmt/authorization"
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+)
+
+// Commands checked by the preflight
+const (
+	CommandVersionedAPI = "versionedapi"
+	CommandBackup       = "backup"
+	CommandRestore      = "restore"
+)
+
+// Commands contains all commands which can be checked
+var Commands = []string{CommandVersionedAPI, CommandBackup, CommandRestore}
+
+// scope of an action
+const (
+	scopeService      = "service"
+	scopeStorage      = "storage"
+	scopeHistory      = "history"
+	scopeSubscription = "subscription"
+)
+
+// requiredActions contains the actions each command takes and the scope they are executed on
+var requiredActions = map[string][][2]string{
+	CommandVersionedAPI: {
+		// the etags of the version set, api and policy are read before the conditional updates
+		{scopeService, "Microsoft.ApiManagement/service/apiVersionSets/read"},
+		{scopeService, "Microsoft.ApiManagement/service/apis/read"},
+		{scopeService, "Microsoft.ApiManagement/service/apis/policies/read"},
+		// next-version lists the revisions of the latest version
+		{scopeService, "Microsoft.ApiManagement/service/apis/revisions/read"},
+		{scopeService, "Microsoft.ApiManagement/service/apiVersionSets/write"},
+		{scopeService, "Microsoft.ApiManagement/service/apis/write"},
+		{scopeService, "Microsoft.ApiManagement/service/apis/policies/write"},
+		// the schema of graphql apis is uploaded after the api is created
+		{scopeService, "Microsoft.ApiManagement/service/apis/schemas/write"},
+		{scopeService, "Microsoft.ApiManagement/service/products/apis/write"},
+		{scopeService, "Microsoft.ApiManagement/service/products/apis/delete"},
+		{scopeService, "Microsoft.ApiManagement/service/tags/write"},
+		{scopeService, "Microsoft.ApiManagement/service/apis/tags/write"},
+	},
+	CommandBackup: {
+		{scopeService, "Microsoft.ApiManagement/service/backup/action"},
+		{scopeStorage, "Microsoft.Storage/storageAccounts/listkeys/action"},
+	},
+	CommandRestore: {
+		{scopeService, "Microsoft.ApiManagement/service/restore/action"},
+		{scopeStorage, "Microsoft.Storage/storageAccounts/listkeys/action"},
+	},
+}
+
+// rollbackActions are taken by the rollback of a transactional versioned api deployment, it deletes the created
+// resources. the snapshot of the api includes its export, which requires the read action only, and the schema
+// of graphql apis
+var rollbackActions = [][2]string{
+	{scopeService, "Microsoft.ApiManagement/service/apiVersionSets/delete"},
+	{scopeService, "Microsoft.ApiManagement/service/apis/delete"},
+	{scopeService, "Microsoft.ApiManagement/service/apis/policies/delete"},
+	{scopeService, "Microsoft.ApiManagement/service/apis/schemas/read"},
+	{scopeService, "Microsoft.ApiManagement/service/apis/schemas/delete"},
+}
+
+// historyActions are taken by versioned api deployments recorded in the history, the blobs are written with the
+// access key of the storage account
+var historyActions = [][2]string{
+	{scopeHistory, "Microsoft.Storage/storageAccounts/listkeys/action"},
+}
+
+// lookupActions are taken by every command if the service is looked up by its name, the subscriptions and
+// their services are listed
+var lookupActions = [][2]string{
+	{scopeSubscription, "Microsoft.Resources/subscriptions/read"},
+	{scopeSubscription, "Microsoft.ApiManagement/service/read"},
+}
+
+// Services returns api management services
+type Services interface {
+	Get(ctx context.Context, resourceGroupName string, serviceName string) (apimanagement.ServiceResource, error)
+}
+
+// Config contains the service and storage account to check and the commands which will be executed
+type Config struct {
+	Authorizer    autorest.Authorizer
+	Services      Services
+	Permissions   Permissions
+	Subscription  string
+	ResourceGroup string
+	ServiceName   string
+	// Retry is applied to the requests of the checks, the zero value doesn't retry
+	Retry retry.Policy
+
+	// StorageResourceGroup and StorageAccount are required to check backup and restore
+	StorageResourceGroup string
+	StorageAccount       string
+
+	// Transactional checks the permissions of the rollback of versioned api deployments
+	Transactional bool
+	// HistoryResourceGroup and HistoryStorageAccount check the permissions of the deployment history
+	HistoryResourceGroup  string
+	HistoryStorageAccount string
+	// Lookup checks the permissions to look up the service by its name
+	Lookup bool
+
+	Commands []string
+}
+
+// actions returns the actions taken by each command with the configured options
+func (cfg *Config) actions() map[string][][2]string {
+	actions := map[string][][2]string{}
+	for _, c := range cfg.Commands {
+		actions[c] = append([][2]string{}, requiredActions[c]...)
+	}
+	if _, ok := actions[CommandVersionedAPI]; ok && cfg.Transactional {
+		actions[CommandVersionedAPI] = append(actions[CommandVersionedAPI], rollbackActions...)
+	}
+	if _, ok := actions[CommandVersionedAPI]; ok && cfg.HistoryStorageAccount != "" {
+		actions[CommandVersionedAPI] = append(actions[CommandVersionedAPI], historyActions...)
+	}
+	if cfg.Lookup {
+		for c := range actions {
+			actions[c] = append(actions[c], lookupActions...)
+		}
+	}
+	return actions
+}
+
+// ServiceStatus is the state of the api management service
+type ServiceStatus struct {
+	ID                string `json:"id"`
+	ProvisioningState string `json:"provisioningState,omitempty"`
+	Ready             bool   `json:"ready"`
+	Error             string `json:"error,omitempty"`
+}
+
+// CheckResult is the result of a single permission check
+type CheckResult struct {
+	Commands []string `json:"commands"`
+	Action   string   `json:"action"`
+	Scope    string   `json:"scope"`
+	Allowed  bool     `json:"allowed"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Report contains the results of all preflight checks
+type Report struct {
+	Principal      Principal     `json:"principal"`
+	PrincipalError string        `json:"principalError,omitempty"`
+	Service        ServiceStatus `json:"service"`
+	Checks         []CheckResult `json:"checks"`
+}
+
+// OK returns true if the service is ready and all permissions are granted
+func (r *Report) OK() bool {
+	return r.Service.Ready && len(r.Gaps()) == 0
+}
+
+// Gaps returns all failed permission checks
+func (r *Report) Gaps() []CheckResult {
+	gaps := []CheckResult{}
+	for _, c := range r.Checks {
+		if !c.Allowed {
+			gaps = append(gaps, c)
+		}
+	}
+	return gaps
+}
+
+// Run executes all preflight checks. it doesn't stop at the first failed check
+// but reports all gaps at once. errors are only returned for invalid configurations
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if len(cfg.Commands) == 0 {
+		cfg.Commands = Commands
+	}
+	scopes := map[string]string{
+		scopeService:      ServiceID(cfg.Subscription, cfg.ResourceGroup, cfg.ServiceName),
+		scopeSubscription: "/subscriptions/" + cfg.Subscription,
+	}
+	for _, c := range cfg.Commands {
+		if _, ok := requiredActions[c]; !ok {
+			return nil, fmt.Errorf("unknown command '%s', valid commands are %s", c, enum.Join(Commands))
+		}
+		if (c == CommandBackup || c == CommandRestore) && (cfg.StorageAccount == "" || cfg.StorageResourceGroup == "") {
+			return nil, fmt.Errorf("storage account and storage account resource group are required to check '%s'", c)
+		}
+	}
+	if (cfg.HistoryStorageAccount == "") != (cfg.HistoryResourceGroup == "") {
+		return nil, errors.New("storage account and resource group of the deployment history are required to check it")
+	}
+	if cfg.StorageAccount != "" {
+		scopes[scopeStorage] = storageAccountID(cfg.Subscription, cfg.StorageResourceGroup, cfg.StorageAccount)
+	}
+	if cfg.HistoryStorageAccount != "" {
+		scopes[scopeHistory] = storageAccountID(cfg.Subscription, cfg.HistoryResourceGroup, cfg.HistoryStorageAccount)
+	}
+
+	r := &Report{Service: ServiceStatus{ID: scopes[scopeService]}}
+
+	p, err := ResolvePrincipal(ctx, cfg.Authorizer)
+	if err != nil {
+		r.PrincipalError = err.Error()
+	}
+	r.Principal = p
+
+	var s apimanagement.ServiceResource
+	err = cfg.Retry.Do(ctx, "get service", func(ctx context.Context) (err error) {
+		s, err = cfg.Services.Get(ctx, cfg.ResourceGroup, cfg.ServiceName)
+		return err
+	})
+	if err != nil {
+		r.Service.Error = err.Error()
+	} else if s.ServiceProperties != nil && s.ServiceProperties.ProvisioningState != nil {
+		r.Service.ProvisioningState = *s.ServiceProperties.ProvisioningState
+		r.Service.Ready = strings.EqualFold(r.Service.ProvisioningState, "Succeeded")
+	}
+
+	r.Checks = checkPermissions(ctx, cfg.Retry, cfg.Permissions, cfg.Commands, cfg.actions(), scopes)
+	return r, nil
+}
+
+// checkPermissions checks every required action once, listing the permissions once per scope
+func checkPermissions(ctx context.Context, policy retry.Policy, permissions Permissions, commands []string, actions map[string][][2]string, scopes map[string]string) []CheckResult {
+	results := map[[2]string]*CheckResult{}
+	for _, c := range commands {
+		for _, a := range actions[c] {
+			if r, ok := results[a]; ok {
+				r.Commands = append(r.Commands, c)
+				continue
+			}
+			results[a] = &CheckResult{Commands: []string{c}, Action: a[1], Scope: scopes[a[0]]}
+		}
+	}
+
+	listed := map[string]bool{}
+	for k, r := range results {
+		if listed[k[0]] {
+			continue
+		}
+		listed[k[0]] = true
+		var perms []authorization.Permission
+		err := policy.Do(ctx, "list permissions", func(ctx context.Context) (err error) {
+			perms, err = permissions.List(ctx, r.Scope)
+			return err
+		})
+		for k2, r2 := range results {
+			if k2[0] != k[0] {
+				continue
+			}
+			if err != nil {
+				r2.Error = err.Error()
+				continue
+			}
+			r2.Allowed = Allowed(perms, r2.Action)
+		}
+	}
+
+	checks := make([]CheckResult, 0, len(results))
+	for _, r := range results {
+		checks = append(checks, *r)
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		if checks[i].Scope != checks[j].Scope {
+			return checks[i].Scope < checks[j].Scope
+		}
+		return checks[i].Action < checks[j].Action
+	})
+	return checks
+}
+
+// storageAccountID returns the resource id of a storage account
+func storageAccountID(subscription string, resourceGroup string, accountName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s", subscription, resourceGroup, accountName)
+}
+
+// ServiceID returns the resource id of an api management service
+func ServiceID(subscription string, resourceGroup string, serviceName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ApiManagement/service/%s", subscription, resourceGroup, serviceName)
+}
@@ -0,0 +1,180 @@
+package preflight
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/authorization/mgmt/authorization"
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+)
+
+func permission(actions []string, notActions []string) authorization.Permission {
+	return authorization.Permission{Actions: &actions, NotActions: &notActions}
+}
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []authorization.Permission
+		action      string
+		want        bool
+	}{
+		{"owner", []authorization.Permission{permission([]string{"*"}, nil)}, "Microsoft.ApiManagement/service/apis/write", true},
+		{"reader", []authorization.Permission{permission([]string{"*/read"}, nil)}, "Microsoft.ApiManagement/service/apis/write", false},
+		{"provider wildcard", []authorization.Permission{permission([]string{"Microsoft.ApiManagement/service/*"}, nil)}, "Microsoft.ApiManagement/service/backup/action", true},
+		{"case insensitive", []authorization.Permission{permission([]string{"microsoft.storage/storageaccounts/listKeys/action"}, nil)}, "Microsoft.Storage/storageAccounts/listkeys/action", true},
+		{"not action", []authorization.Permission{permission([]string{"*"}, []string{"Microsoft.ApiManagement/service/restore/action"})}, "Microsoft.ApiManagement/service/restore/action", false},
+		{"granted by second role", []authorization.Permission{
+			permission([]string{"*"}, []string{"Microsoft.ApiManagement/service/restore/action"}),
+			permission([]string{"Microsoft.ApiManagement/service/restore/action"}, nil),
+		}, "Microsoft.ApiManagement/service/restore/action", true},
+		{"no permissions", nil, "Microsoft.ApiManagement/service/apis/write", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Allowed(tt.permissions, tt.action); got != tt.want {
+				t.Errorf("Allowed(%s) = %t, want %t", tt.action, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalFromToken(t *testing.T) {
+	token := func(payload string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+	}
+
+	p, err := principalFromToken(token(`{"oid": "o1", "tid": "t1", "appid": "a1", "upn": "jane@example.com"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.ObjectID != "o1" || p.TenantID != "t1" || p.Name != "jane@example.com" || p.Type != "user" {
+		t.Errorf("unexpected user principal: %+v", p)
+	}
+
+	p, err = principalFromToken(token(`{"oid": "o2", "tid": "t1", "appid": "a2", "idtyp": "app"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.ApplicationID != "a2" || p.Type != "servicePrincipal" {
+		t.Errorf("unexpected service principal: %+v", p)
+	}
+
+	if _, err := principalFromToken("opaque"); err == nil {
+		t.Error("expected error for opaque token")
+	}
+}
+
+// services returns a provisioned service
+type services struct{}
+
+func (services) Get(ctx context.Context, resourceGroupName string, serviceName string) (apimanagement.ServiceResource, error) {
+	state := "Succeeded"
+	return apimanagement.ServiceResource{ServiceProperties: &apimanagement.ServiceProperties{ProvisioningState: &state}}, nil
+}
+
+// permissions grants the actions by scope
+type permissions map[string][]string
+
+func (p permissions) List(ctx context.Context, scope string) ([]authorization.Permission, error) {
+	return []authorization.Permission{permission(p[scope], nil)}, nil
+}
+
+func TestRun(t *testing.T) {
+	service := ServiceID("sub", "rg", "apim")
+	writer := []string{"Microsoft.ApiManagement/service/*/write", "Microsoft.ApiManagement/service/*/delete"}
+	tests := []struct {
+		name     string
+		cfg      Config
+		granted  permissions
+		wantGaps []string
+	}{
+		{
+			name:    "versioned api reads",
+			granted: permissions{service: writer},
+			wantGaps: []string{
+				"Microsoft.ApiManagement/service/apiVersionSets/read",
+				"Microsoft.ApiManagement/service/apis/policies/read",
+				"Microsoft.ApiManagement/service/apis/read",
+				"Microsoft.ApiManagement/service/apis/revisions/read",
+			},
+		},
+		{
+			name:    "transactional rollback",
+			cfg:     Config{Transactional: true},
+			granted: permissions{service: {"Microsoft.ApiManagement/service/*/read", "Microsoft.ApiManagement/service/*/write"}},
+			wantGaps: []string{
+				"Microsoft.ApiManagement/service/apiVersionSets/delete",
+				"Microsoft.ApiManagement/service/apis/delete",
+				"Microsoft.ApiManagement/service/apis/policies/delete",
+				"Microsoft.ApiManagement/service/apis/schemas/delete",
+				"Microsoft.ApiManagement/service/products/apis/delete",
+			},
+		},
+		{
+			name:    "not transactional",
+			granted: permissions{service: {"Microsoft.ApiManagement/service/*/read", "Microsoft.ApiManagement/service/*/write"}},
+			wantGaps: []string{
+				"Microsoft.ApiManagement/service/products/apis/delete",
+			},
+		},
+		{
+			name:     "history",
+			cfg:      Config{HistoryResourceGroup: "historyrg", HistoryStorageAccount: "history"},
+			granted:  permissions{service: {"Microsoft.ApiManagement/service/*"}},
+			wantGaps: []string{"Microsoft.Storage/storageAccounts/listkeys/action"},
+		},
+		{
+			name: "history granted",
+			cfg:  Config{HistoryResourceGroup: "historyrg", HistoryStorageAccount: "history"},
+			granted: permissions{
+				service: {"Microsoft.ApiManagement/service/*"},
+				storageAccountID("sub", "historyrg", "history"): {"Microsoft.Storage/storageAccounts/listkeys/action"},
+			},
+		},
+		{
+			name:     "lookup",
+			cfg:      Config{Lookup: true},
+			granted:  permissions{service: {"Microsoft.ApiManagement/service/*"}, "/subscriptions/sub": {"Microsoft.Resources/subscriptions/read"}},
+			wantGaps: []string{"Microsoft.ApiManagement/service/read"},
+		},
+		{
+			name:    "contributor",
+			granted: permissions{service: {"Microsoft.ApiManagement/service/*"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Services, cfg.Permissions = services{}, tt.granted
+			cfg.Subscription, cfg.ResourceGroup, cfg.ServiceName = "sub", "rg", "apim"
+			if cfg.Commands == nil {
+				cfg.Commands = []string{CommandVersionedAPI}
+			}
+			r, err := Run(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			gaps := []string{}
+			for _, g := range r.Gaps() {
+				gaps = append(gaps, g.Action)
+			}
+			sort.Strings(gaps)
+			want := append([]string{}, tt.wantGaps...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(gaps, want) {
+				t.Errorf("gaps = %v, want %v", gaps, want)
+			}
+		})
+	}
+}
+
+func TestRunHistoryRequiresResourceGroup(t *testing.T) {
+	_, err := Run(context.Background(), Config{Commands: []string{CommandVersionedAPI}, HistoryStorageAccount: "history"})
+	if err == nil {
+		t.Fatal("expected error for the history storage account without resource group")
+	}
+}
@@ -0,0 +1,86 @@
+package preflight
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// Principal is the identity azapim is authenticated as
+type Principal struct {
+	ObjectID      string `json:"objectId,omitempty"`
+	TenantID      string `json:"tenantId,omitempty"`
+	ApplicationID string `json:"applicationId,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Type          string `json:"type,omitempty"`
+}
+
+// claims contains the jwt claims used to identify the principal
+type claims struct {
+	ObjectID     string `json:"oid"`
+	TenantID     string `json:"tid"`
+	AppID        string `json:"appid"`
+	AzpID        string `json:"azp"`
+	UPN          string `json:"upn"`
+	UniqueName   string `json:"unique_name"`
+	Name         string `json:"name"`
+	IdentityType string `json:"idtyp"`
+}
+
+// ResolvePrincipal returns the principal of the access token used by the authorizer.
+// the token is only decoded, it was already validated by azure active directory
+func ResolvePrincipal(ctx context.Context, a autorest.Authorizer) (Principal, error) {
+	ba, ok := a.(*autorest.BearerAuthorizer)
+	if !ok {
+		return Principal{}, errors.New("the authorizer does not use bearer tokens")
+	}
+	tp := ba.TokenProvider()
+	if r, ok := tp.(adal.RefresherWithContext); ok {
+		if err := r.EnsureFreshWithContext(ctx); err != nil {
+			return Principal{}, err
+		}
+	}
+	return principalFromToken(tp.OAuthToken())
+}
+
+func principalFromToken(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, errors.New("the access token is not a jwt")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return Principal{}, err
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Principal{}, err
+	}
+
+	p := Principal{
+		ObjectID:      c.ObjectID,
+		TenantID:      c.TenantID,
+		ApplicationID: c.AppID,
+		Name:          c.UPN,
+		Type:          "user",
+	}
+	if p.ApplicationID == "" {
+		p.ApplicationID = c.AzpID
+	}
+	if p.Name == "" {
+		p.Name = c.UniqueName
+	}
+	if p.Name == "" {
+		p.Name = c.Name
+	}
+	// tokens of users contain a upn, tokens of applications and managed identities do not
+	if c.IdentityType == "app" || (c.UPN == "" && c.IdentityType != "user") {
+		p.Type = "servicePrincipal"
+	}
+	return p, nil
+}
@@ -0,0 +1,201 @@
+// Package retry retries operations against azure which failed with transient errors,
+// e.g. throttling (429) or conflicting operations in progress (409)
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// Policy defines how often and how long failed operations are retried
+type Policy struct {
+	// MaxRetries is the number of retries after the first attempt, 0 disables retries
+	MaxRetries int
+	// Timeout limits the total time spent on retries of a single operation, 0 means no limit
+	Timeout time.Duration
+	// BaseDelay is the delay before the first retry, it doubles with every retry
+	BaseDelay time.Duration
+	// MaxDelay limits the delay between two retries
+	MaxDelay time.Duration
+	// OnRetry is called before every retry
+	OnRetry func(operation string, attempt int, delay time.Duration, err error)
+}
+
+// DefaultPolicy returns the retry policy used if nothing else is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxRetries: 5,
+		Timeout:    10 * time.Minute,
+		BaseDelay:  2 * time.Second,
+		MaxDelay:   time.Minute,
+	}
+}
+
+// Error is returned if an operation failed after all retries
+type Error struct {
+	Operation string
+	Attempts  int
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s failed after %d attempts: %v", e.Operation, e.Attempts, e.Err)
+}
+
+// Unwrap returns the error of the last attempt
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// retryableStatusCodes contains the status codes of transient azure resource manager errors
+var retryableStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusConflict:            true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// Do executes fn and retries it as long as it fails with a retryable error and the
+// policy allows further retries. errors which are not retryable are returned immediately
+func (p Policy) Do(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		retryable, retryAfter := Classify(err)
+		if !retryable || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= p.MaxRetries {
+			if attempt == 0 {
+				return err
+			}
+			return &Error{Operation: operation, Attempts: attempt + 1, Err: err}
+		}
+
+		delay := p.delay(attempt, retryAfter)
+		if p.Timeout > 0 && time.Since(start)+delay > p.Timeout {
+			return &Error{Operation: operation, Attempts: attempt + 1, Err: err}
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(operation, attempt+1, delay, err)
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// delay returns the exponential backoff with jitter for the attempt. a retry-after
+// requested by the server is honored if it is longer than the backoff
+func (p Policy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	// full jitter in the upper half of the delay
+	if d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	if retryAfter > d {
+		d = retryAfter
+	}
+	return d
+}
+
+// Classify returns if the error is transient and the delay requested by the server, if any
+func Classify(err error) (bool, time.Duration) {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+
+	code, resp := statusCode(err)
+	if code != 0 {
+		return retryableStatusCodes[code], retryAfter(resp)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true, 0
+	}
+	return false, 0
+}
+
+// StatusCode returns the http status code of an azure sdk error or 0 if there is none
+func StatusCode(err error) int {
+	code, _ := statusCode(err)
+	return code
+}
+
+func statusCode(err error) (int, *http.Response) {
+	var reqErr *azure.RequestError
+	if errors.As(err, &reqErr) {
+		if c, ok := reqErr.StatusCode.(int); ok && c != 0 {
+			return c, reqErr.Response
+		}
+	}
+	var detailed autorest.DetailedError
+	if errors.As(err, &detailed) {
+		if c, ok := detailed.StatusCode.(int); ok && c != 0 {
+			return c, detailed.Response
+		}
+	}
+	// errors of the blob service don't contain the response
+	var storageErr storage.AzureStorageServiceError
+	if errors.As(err, &storageErr) && storageErr.StatusCode != 0 {
+		return storageErr.StatusCode, nil
+	}
+	return 0, nil
+}
+
+// retryAfter returns the delay requested by the Retry-After or x-ms-retry-after-ms header
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	if ms, err := strconv.Atoi(resp.Header.Get("x-ms-retry-after-ms")); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(h); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// Disable turns off the retries of the azure sdk client. the sdk retries transient errors and
+// registers missing resource providers in nested loops of its own, which would multiply the
+// attempts of the Policy. the providers of the resources azapim manages are registered already
+func Disable(c *autorest.Client) {
+	c.RetryAttempts = 1
+	c.SendDecorators = []autorest.SendDecorator{}
+}
@@ -0,0 +1,84 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func statusError(code int, header http.Header) error {
+	return autorest.DetailedError{
+		StatusCode: code,
+		Response:   &http.Response{StatusCode: code, Header: header},
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+		delay     time.Duration
+	}{
+		{"conflict", statusError(http.StatusConflict, http.Header{}), true, 0},
+		{"throttled", statusError(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"7"}}), true, 7 * time.Second},
+		{"throttled ms", statusError(http.StatusTooManyRequests, http.Header{"X-Ms-Retry-After-Ms": []string{"250"}}), true, 250 * time.Millisecond},
+		{"server error", statusError(http.StatusBadGateway, http.Header{}), true, 0},
+		{"bad request", statusError(http.StatusBadRequest, http.Header{}), false, 0},
+		{"forbidden", statusError(http.StatusForbidden, http.Header{}), false, 0},
+		{"not found", statusError(http.StatusNotFound, http.Header{}), false, 0},
+		{"precondition failed", statusError(http.StatusPreconditionFailed, http.Header{}), false, 0},
+		{"blob service unavailable", storage.AzureStorageServiceError{StatusCode: http.StatusServiceUnavailable}, true, 0},
+		{"blob not found", storage.AzureStorageServiceError{StatusCode: http.StatusNotFound}, false, 0},
+		{"canceled", context.Canceled, false, 0},
+		{"validation", errors.New("validation failed"), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retryable, delay := Classify(tt.err)
+			if retryable != tt.retryable || delay != tt.delay {
+				t.Errorf("got (%v, %s), want (%v, %s)", retryable, delay, tt.retryable, tt.delay)
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	p := Policy{MaxRetries: 2, BaseDelay: time.Millisecond}
+
+	calls := 0
+	err := p.Do(context.Background(), "op", func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return statusError(http.StatusConflict, http.Header{})
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("got %v after %d calls, want success after 3 calls", err, calls)
+	}
+
+	calls = 0
+	err = p.Do(context.Background(), "op", func(context.Context) error {
+		calls++
+		return statusError(http.StatusServiceUnavailable, http.Header{})
+	})
+	var e *Error
+	if !errors.As(err, &e) || e.Attempts != 3 || calls != 3 {
+		t.Fatalf("got %v after %d calls, want retry error after 3 calls", err, calls)
+	}
+
+	calls = 0
+	err = p.Do(context.Background(), "op", func(context.Context) error {
+		calls++
+		return statusError(http.StatusBadRequest, http.Header{})
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("got %v after %d calls, want error after 1 call", err, calls)
+	}
+}
@@ -0,0 +1,515 @@
+// Package specdiff compares two openapi or swagger specs and classifies the changes as breaking or non-breaking
+// for the clients of the api
+package specdiff
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+)
+
+// pathParameter matches the parameters of a path, their names don't change the operation
+var pathParameter = regexp.MustCompile(`\{[^}]*\}`)
+
+// maxDepth limits the comparison of recursive schemas
+const maxDepth = 32
+
+// Change is a single difference between the specs
+type Change struct {
+	Breaking  bool   `json:"breaking"`
+	Operation string `json:"operation,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Report contains the changes from the old to the new spec, breaking changes first
+type Report struct {
+	Changes []Change `json:"changes"`
+}
+
+// Breaking returns true if the report contains a breaking change
+func (r *Report) Breaking() bool {
+	return len(r.BreakingChanges()) > 0
+}
+
+// BreakingChanges returns the breaking changes of the report
+func (r *Report) BreakingChanges() []Change {
+	var changes []Change
+	for _, c := range r.Changes {
+		if c.Breaking {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// Table returns the changes as table rows
+func (r *Report) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(r.Changes))
+	for _, c := range r.Changes {
+		kind := "non-breaking"
+		if c.Breaking {
+			kind = "breaking"
+		}
+		rows = append(rows, []string{kind, c.Operation, c.Location, c.Message})
+	}
+	return []string{"CHANGE", "OPERATION", "LOCATION", "MESSAGE"}, rows
+}
+
+// direction defines whether a schema is sent by the client or returned to it
+type direction int
+
+const (
+	request direction = iota
+	response
+)
+
+// Compare returns the changes from the old to the new json or yaml spec
+func Compare(old []byte, new []byte) (*Report, error) {
+	o, err := apidefinition.ParseSpec(old)
+	if err != nil {
+		return nil, fmt.Errorf("old spec: %w", err)
+	}
+	n, err := apidefinition.ParseSpec(new)
+	if err != nil {
+		return nil, fmt.Errorf("new spec: %w", err)
+	}
+	d := &differ{old: &spec{o}, new: &spec{n}, report: &Report{Changes: []Change{}}, compared: map[comparison]bool{}}
+	d.operations()
+	sort.SliceStable(d.report.Changes, func(i, j int) bool {
+		return d.report.Changes[i].Breaking && !d.report.Changes[j].Breaking
+	})
+	return d.report, nil
+}
+
+type differ struct {
+	old    *spec
+	new    *spec
+	report *Report
+	// compared contains the referenced schemas already compared, recursive schemas
+	// like trees reference themselves more than once and would be compared exponentially often
+	compared map[comparison]bool
+}
+
+// comparison identifies the comparison of a referenced schema in an operation
+type comparison struct {
+	op     string
+	dir    direction
+	oldRef string
+	newRef string
+}
+
+func (d *differ) add(breaking bool, operation string, location string, format string, args ...interface{}) {
+	d.report.Changes = append(d.report.Changes, Change{Breaking: breaking, Operation: operation, Location: location, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *differ) operations() {
+	oldOps, newOps := d.old.operations(), d.new.operations()
+	for _, k := range sortedKeys(oldOps) {
+		o := oldOps[k]
+		n, ok := newOps[k]
+		if !ok {
+			d.add(true, o.name, "", "operation removed")
+			continue
+		}
+		d.parameters(o, n)
+		d.requestBody(o, n)
+		d.responses(o, n)
+	}
+	for _, k := range sortedKeys(newOps) {
+		if _, ok := oldOps[k]; !ok {
+			d.add(false, newOps[k].name, "", "operation added")
+		}
+	}
+}
+
+func (d *differ) parameters(o operation, n operation) {
+	oldParams, newParams := d.old.parameters(o), d.new.parameters(n)
+	for _, k := range sortedKeys(newParams) {
+		np := newParams[k]
+		loc := "parameter " + k
+		op, ok := oldParams[k]
+		switch {
+		case !ok && apidefinition.IsTrue(np["required"]):
+			d.add(true, n.name, loc, "required parameter added")
+		case !ok:
+			d.add(false, n.name, loc, "optional parameter added")
+		case !apidefinition.IsTrue(op["required"]) && apidefinition.IsTrue(np["required"]):
+			d.add(true, n.name, loc, "parameter became required")
+		}
+		if ok {
+			d.schema(n.name, loc, request, parameterSchema(op), parameterSchema(np), 0)
+		}
+	}
+	for _, k := range sortedKeys(oldParams) {
+		if _, ok := newParams[k]; !ok {
+			d.add(false, n.name, "parameter "+k, "parameter removed")
+		}
+	}
+}
+
+func (d *differ) requestBody(o operation, n operation) {
+	oldRequired, oldContent := d.old.requestBody(o)
+	newRequired, newContent := d.new.requestBody(n)
+	switch {
+	case oldContent == nil && newContent != nil && newRequired:
+		d.add(true, n.name, "request body", "required request body added")
+	case oldContent != nil && newContent != nil && !oldRequired && newRequired:
+		d.add(true, n.name, "request body", "request body became required")
+	}
+	for _, mt := range sortedKeys(oldContent) {
+		loc := "request body " + mt
+		if _, ok := newContent[mt]; !ok {
+			if newContent != nil {
+				d.add(true, n.name, loc, "media type removed")
+			}
+			continue
+		}
+		d.schema(n.name, loc, request, oldContent[mt], newContent[mt], 0)
+	}
+}
+
+func (d *differ) responses(o operation, n operation) {
+	oldResponses, newResponses := d.old.responses(o), d.new.responses(n)
+	for _, code := range sortedKeys(oldResponses) {
+		loc := "response " + code
+		newContent, ok := newResponses[code]
+		if !ok {
+			// clients handle errors generically, a removed success response changes their result
+			d.add(strings.HasPrefix(code, "2"), n.name, loc, "response removed")
+			continue
+		}
+		oldContent := oldResponses[code]
+		for _, mt := range sortedKeys(oldContent) {
+			if _, ok := newContent[mt]; !ok {
+				d.add(true, n.name, loc+" "+mt, "media type removed")
+				continue
+			}
+			d.schema(n.name, loc+" "+mt, response, oldContent[mt], newContent[mt], 0)
+		}
+	}
+	for _, code := range sortedKeys(newResponses) {
+		if _, ok := oldResponses[code]; !ok {
+			d.add(false, n.name, "response "+code, "response added")
+		}
+	}
+}
+
+// schema compares the schemas sent in the direction
+func (d *differ) schema(op string, loc string, dir direction, old interface{}, new interface{}, depth int) {
+	if c := (comparison{op, dir, ref(old), ref(new)}); c.oldRef != "" || c.newRef != "" {
+		if d.compared[c] {
+			return
+		}
+		d.compared[c] = true
+	}
+	o, n := d.old.resolve(old), d.new.resolve(new)
+	if o == nil || n == nil || depth > maxDepth {
+		return
+	}
+	if ot, nt := schemaType(o), schemaType(n); ot != "" && nt != "" && ot != nt {
+		d.add(true, op, loc, "type changed from %s to %s", ot, nt)
+		return
+	}
+	if of, nf := str(o["format"]), str(n["format"]); of != "" && nf != "" && of != nf {
+		d.add(true, op, loc, "format changed from %s to %s", of, nf)
+	}
+
+	removed, added := difference(list(o["enum"]), list(n["enum"]))
+	switch {
+	case dir == request && len(removed) > 0 && list(n["enum"]) != nil:
+		d.add(true, op, loc, "enum values removed: %s", strings.Join(removed, ", "))
+	case dir == request && list(o["enum"]) == nil && list(n["enum"]) != nil:
+		d.add(true, op, loc, "values restricted to %s", strings.Join(added, ", "))
+	case dir == response && len(added) > 0 && list(o["enum"]) != nil:
+		d.add(true, op, loc, "enum values added: %s", strings.Join(added, ", "))
+	case len(removed) > 0 || len(added) > 0:
+		d.add(false, op, loc, "enum values changed")
+	}
+	if dir == request {
+		d.constraints(op, loc, o, n)
+	}
+
+	oldProps, newProps := object(o["properties"]), object(n["properties"])
+	oldRequired, newRequired := set(list(o["required"])), set(list(n["required"]))
+	for _, p := range sortedKeys(newProps) {
+		ploc := loc + "." + p
+		_, existed := oldProps[p]
+		switch {
+		case dir == request && newRequired[p] && !existed:
+			d.add(true, op, ploc, "required property added")
+		case dir == request && newRequired[p] && !oldRequired[p]:
+			d.add(true, op, ploc, "property became required")
+		case dir == response && oldRequired[p] && !newRequired[p]:
+			d.add(true, op, ploc, "property is no longer always returned")
+		case !existed:
+			d.add(false, op, ploc, "property added")
+		}
+		if existed {
+			d.schema(op, ploc, dir, oldProps[p], newProps[p], depth+1)
+		}
+	}
+	for _, p := range sortedKeys(oldProps) {
+		if _, ok := newProps[p]; !ok {
+			d.add(dir == response, op, loc+"."+p, "property removed")
+		}
+	}
+	if o["items"] != nil && n["items"] != nil {
+		d.schema(op, loc+"[]", dir, o["items"], n["items"], depth+1)
+	}
+}
+
+// constraints reports narrowed limits of request values
+func (d *differ) constraints(op string, loc string, o map[string]interface{}, n map[string]interface{}) {
+	for _, c := range []struct {
+		key   string
+		upper bool
+	}{
+		{"maximum", true}, {"maxLength", true}, {"maxItems", true},
+		{"minimum", false}, {"minLength", false}, {"minItems", false},
+	} {
+		nv, ok := number(n[c.key])
+		if !ok {
+			continue
+		}
+		ov, existed := number(o[c.key])
+		if !existed || (c.upper && nv < ov) || (!c.upper && nv > ov) {
+			d.add(true, op, loc, "%s narrowed to %v", c.key, nv)
+		}
+	}
+}
+
+// spec is a parsed openapi 3 or swagger 2.0 document
+type spec struct {
+	doc map[string]interface{}
+}
+
+// operation is an operation of a path item
+type operation struct {
+	name string
+	item map[string]interface{}
+	op   map[string]interface{}
+}
+
+func (s *spec) swagger() bool {
+	return s.doc["swagger"] != nil
+}
+
+// operations returns the operations by method and path without parameter names
+func (s *spec) operations() map[string]operation {
+	ops := map[string]operation{}
+	paths := object(s.doc["paths"])
+	for _, p := range sortedKeys(paths) {
+		if strings.HasPrefix(p, "x-") {
+			continue
+		}
+		item := s.resolve(paths[p])
+		for _, m := range apidefinition.OperationMethods {
+			if op := object(item[m]); op != nil {
+				key := strings.ToUpper(m) + " " + pathParameter.ReplaceAllString(p, "{}")
+				ops[key] = operation{name: strings.ToUpper(m) + " " + p, item: item, op: op}
+			}
+		}
+	}
+	return ops
+}
+
+// parameters returns the parameters of the path item and the operation by location and name, except the body
+func (s *spec) parameters(o operation) map[string]map[string]interface{} {
+	params := map[string]map[string]interface{}{}
+	for _, v := range append(list(o.item["parameters"]), list(o.op["parameters"])...) {
+		p := s.resolve(v)
+		// path parameters are identified by their position, which is part of the operation
+		if p == nil || p["in"] == "body" || p["in"] == "path" {
+			continue
+		}
+		params[fmt.Sprintf("%s.%s", str(p["in"]), str(p["name"]))] = p
+	}
+	return params
+}
+
+// requestBody returns whether the body is required and its schemas by media type, nil if the operation has no body
+func (s *spec) requestBody(o operation) (bool, map[string]interface{}) {
+	if !s.swagger() {
+		body := s.resolve(o.op["requestBody"])
+		if body == nil {
+			return false, nil
+		}
+		return apidefinition.IsTrue(body["required"]), s.content(body)
+	}
+	for _, v := range append(list(o.item["parameters"]), list(o.op["parameters"])...) {
+		if p := s.resolve(v); p != nil && p["in"] == "body" {
+			return apidefinition.IsTrue(p["required"]), s.mediaTypes(o, "consumes", p["schema"])
+		}
+	}
+	return false, nil
+}
+
+// responses returns the schemas of the responses by status code and media type
+func (s *spec) responses(o operation) map[string]map[string]interface{} {
+	responses := map[string]map[string]interface{}{}
+	for code, v := range object(o.op["responses"]) {
+		r := s.resolve(v)
+		if r == nil {
+			continue
+		}
+		if s.swagger() {
+			responses[code] = s.mediaTypes(o, "produces", r["schema"])
+		} else {
+			responses[code] = s.content(r)
+		}
+	}
+	return responses
+}
+
+// content returns the schemas of the content of an openapi 3 request body or response by media type
+func (s *spec) content(v map[string]interface{}) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	for mt, c := range object(v["content"]) {
+		schemas[mt] = object(c)["schema"]
+	}
+	return schemas
+}
+
+// mediaTypes returns the swagger schema for the consumed or produced media types of the operation
+func (s *spec) mediaTypes(o operation, key string, schema interface{}) map[string]interface{} {
+	types := list(o.op[key])
+	if types == nil {
+		types = list(s.doc[key])
+	}
+	if types == nil {
+		types = []interface{}{"application/json"}
+	}
+	schemas := map[string]interface{}{}
+	if schema == nil {
+		return schemas
+	}
+	for _, t := range types {
+		schemas[str(t)] = schema
+	}
+	return schemas
+}
+
+// resolve follows local references, it returns nil if the value isn't an object or the reference is unknown
+func (s *spec) resolve(v interface{}) map[string]interface{} {
+	m := object(v)
+	for i := 0; i < maxDepth && m != nil; i++ {
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+		m = s.lookup(ref)
+	}
+	return m
+}
+
+func (s *spec) lookup(ref string) map[string]interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var cur interface{} = s.doc
+	for _, p := range strings.Split(ref[2:], "/") {
+		p = strings.ReplaceAll(strings.ReplaceAll(p, "~1", "/"), "~0", "~")
+		cur = object(cur)[p]
+	}
+	return object(cur)
+}
+
+// ref returns the reference of the schema, empty if it is defined inline
+func ref(v interface{}) string {
+	return str(object(v)["$ref"])
+}
+
+// parameterSchema returns the schema of an openapi 3 parameter or the swagger parameter itself
+func parameterSchema(p map[string]interface{}) interface{} {
+	if s, ok := p["schema"]; ok {
+		return s
+	}
+	return p
+}
+
+// schemaType returns the type of the schema, object if it only has properties
+func schemaType(s map[string]interface{}) string {
+	switch t := s["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, v := range t {
+			types = append(types, str(v))
+		}
+		sort.Strings(types)
+		return strings.Join(types, "|")
+	}
+	if s["properties"] != nil {
+		return "object"
+	}
+	return ""
+}
+
+// difference returns the values removed from and added to the list
+func difference(old []interface{}, new []interface{}) (removed []string, added []string) {
+	contains := func(l []interface{}, v interface{}) bool {
+		for _, e := range l {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range old {
+		if !contains(new, v) {
+			removed = append(removed, fmt.Sprint(v))
+		}
+	}
+	for _, v := range new {
+		if !contains(old, v) {
+			added = append(added, fmt.Sprint(v))
+		}
+	}
+	return removed, added
+}
+
+func object(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func list(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
+
+func str(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func set(l []interface{}) map[string]bool {
+	s := map[string]bool{}
+	for _, v := range l {
+		s[str(v)] = true
+	}
+	return s
+}
+
+// number returns the numeric value of a json or yaml number
+func number(v interface{}) (float64, bool) {
+	var f float64
+	_, err := fmt.Sscan(str(v), &f)
+	return f, v != nil && err == nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,124 @@
+package specdiff
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// base is an openapi spec with a list and a create operation of pets
+const base = `{
+  "openapi": "3.0.1",
+  "paths": {
+    "/pets": {
+      "get": {
+        "parameters": [{"name": "limit", "in": "query", "schema": {"type": "integer", "maximum": 100}}],
+        "responses": {"200": {"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Pet"}}}}}}
+      },
+      "post": {
+        "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/NewPet"}}}},
+        "responses": {"201": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}}}
+      }
+    },
+    "/pets/{id}": {"get": {"responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}}}}}
+  },
+  "components": {
+    "schemas": {
+      "Pet": {"type": "object", "required": ["id", "name"], "properties": {"id": {"type": "integer", "format": "int64"}, "name": {"type": "string"}, "status": {"type": "string", "enum": ["available", "sold"]}, "parent": {"$ref": "#/components/schemas/Pet"}}},
+      "NewPet": {"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}, "tag": {"type": "string"}, "kind": {"type": "string", "enum": ["cat", "dog"]}}}
+    }
+  }
+}`
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name         string
+		replace      []string
+		wantBreaking bool
+		wantMessage  string
+	}{
+		{name: "unchanged"},
+		{name: "operation added", replace: []string{`"/pets/{id}": {`, `"/pets/{id}": {"delete": {}, `}, wantMessage: "operation added"},
+		{name: "operation removed", replace: []string{`"get": {"responses"`, `"put": {"responses"`}, wantBreaking: true, wantMessage: "operation removed"},
+		{name: "path parameter renamed", replace: []string{`/pets/{id}`, `/pets/{petId}`}},
+		{name: "optional parameter added", replace: []string{`"parameters": [`, `"parameters": [{"name": "q", "in": "query"}, `}, wantMessage: "optional parameter added"},
+		{name: "required parameter added", replace: []string{`"parameters": [`, `"parameters": [{"name": "q", "in": "query", "required": true}, `}, wantBreaking: true, wantMessage: "required parameter added"},
+		{name: "parameter became required", replace: []string{`"in": "query",`, `"in": "query", "required": true,`}, wantBreaking: true, wantMessage: "parameter became required"},
+		{name: "parameter type narrowed", replace: []string{`"type": "integer", "maximum": 100`, `"type": "integer", "maximum": 50`}, wantBreaking: true, wantMessage: "maximum narrowed"},
+		{name: "parameter type widened", replace: []string{`"type": "integer", "maximum": 100`, `"type": "integer", "maximum": 500`}},
+		{name: "parameter type changed", replace: []string{`"type": "integer", "maximum": 100`, `"type": "string"`}, wantBreaking: true, wantMessage: "type changed from integer to string"},
+		{name: "request body required", replace: []string{`"requestBody": {`, `"requestBody": {"required": true, `}, wantBreaking: true, wantMessage: "request body became required"},
+		{name: "required request property added", replace: []string{`"required": ["name"]`, `"required": ["name", "tag"]`}, wantBreaking: true, wantMessage: "property became required"},
+		{name: "request enum narrowed", replace: []string{`["cat", "dog"]`, `["cat"]`}, wantBreaking: true, wantMessage: "enum values removed: dog"},
+		{name: "request enum widened", replace: []string{`["cat", "dog"]`, `["cat", "dog", "bird"]`}, wantMessage: "enum values changed"},
+		{name: "response enum widened", replace: []string{`["available", "sold"]`, `["available", "sold", "pending"]`}, wantBreaking: true, wantMessage: "enum values added: pending"},
+		{name: "response property removed", replace: []string{`"name": {"type": "string"}, "status"`, `"status"`}, wantBreaking: true, wantMessage: "property removed"},
+		{name: "response property added", replace: []string{`"parent": {`, `"age": {"type": "integer"}, "parent": {`}, wantMessage: "property added"},
+		{name: "response format changed", replace: []string{`"format": "int64"`, `"format": "int32"`}, wantBreaking: true, wantMessage: "format changed from int64 to int32"},
+		{name: "success response removed", replace: []string{`"201": {`, `"202": {`}, wantBreaking: true, wantMessage: "response removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := base
+			if len(tt.replace) > 0 {
+				spec = strings.Replace(base, tt.replace[0], tt.replace[1], 1)
+				if spec == base {
+					t.Fatalf("replacement %q not found", tt.replace[0])
+				}
+			}
+			r, err := Compare([]byte(base), []byte(spec))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Breaking() != tt.wantBreaking {
+				t.Errorf("breaking = %t, want %t: %+v", r.Breaking(), tt.wantBreaking, r.Changes)
+			}
+			if tt.wantMessage == "" && len(r.Changes) > 0 {
+				t.Errorf("unexpected changes %+v", r.Changes)
+			}
+			if tt.wantMessage != "" && (len(r.Changes) == 0 || !strings.Contains(r.Changes[0].Message, tt.wantMessage)) {
+				t.Errorf("changes = %+v, want %s", r.Changes, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestCompareSwagger(t *testing.T) {
+	old := `{"swagger": "2.0", "paths": {"/pets": {"post": {"parameters": [{"name": "body", "in": "body", "schema": {"type": "object", "properties": {"name": {"type": "string"}}}}], "responses": {"200": {"schema": {"type": "string"}}}}}}}`
+	new := "swagger: '2.0'\npaths:\n  /pets:\n    post:\n      parameters:\n      - name: body\n        in: body\n        schema:\n          type: object\n          required: [name]\n          properties:\n            name: {type: string}\n      responses:\n        '200':\n          schema: {type: string}\n"
+	r, err := Compare([]byte(old), []byte(new))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Breaking() || r.Changes[0].Location != "request body application/json.name" {
+		t.Errorf("changes = %+v, want required property name", r.Changes)
+	}
+}
+
+func TestCompareRecursiveSchema(t *testing.T) {
+	// every node references itself twice, without tracking the compared references the
+	// comparison visits 2^depth nodes
+	old := `{"openapi": "3.0.1", "paths": {"/tree": {"get": {"responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Node"}}}}}}}},
+  "components": {"schemas": {"Node": {"type": "object", "properties": {"value": {"type": "string"}, "left": {"$ref": "#/components/schemas/Node"}, "right": {"$ref": "#/components/schemas/Node"}}}}}}`
+	new := strings.Replace(old, `"value": {"type": "string"}`, `"value": {"type": "integer"}`, 1)
+
+	done := make(chan *Report)
+	go func() {
+		r, err := Compare([]byte(old), []byte(new))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- r
+	}()
+	select {
+	case r := <-done:
+		if r == nil {
+			return
+		}
+		if len(r.Changes) != 1 || !r.Changes[0].Breaking || r.Changes[0].Location != "response 200 application/json.value" {
+			t.Errorf("changes = %+v, want the changed type of value once", r.Changes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("comparison of the recursive schema didn't finish")
+	}
+}
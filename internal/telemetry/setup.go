@@ -0,0 +1,131 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// Config defines the exporter of the spans and metrics
+type Config struct {
+	Exporter Exporter
+	// Endpoint is the base url of the otlp http collector, e.g. http://localhost:4318. if empty
+	// the OTEL_EXPORTER_OTLP_* environment variables or the default endpoint are used
+	Endpoint string
+	// Writer of the stdout exporter, os.Stderr if not set
+	Writer io.Writer
+	// Attributes are added to the resource of all spans and metrics
+	Attributes []attribute.KeyValue
+}
+
+// Providers are the tracer and meter providers of a command, the steps are recorded with the providers
+// of their context set by WithProviders
+type Providers struct {
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
+
+	shutdown func(ctx context.Context) error
+}
+
+// Shutdown flushes all spans and metrics, it must be called before the process exits
+func (p *Providers) Shutdown(ctx context.Context) error {
+	if p == nil || p.shutdown == nil {
+		return nil
+	}
+	return p.shutdown(ctx)
+}
+
+// Setup returns the tracer and meter providers exporting to the configured exporter, the global
+// providers are left unchanged. the providers of the exporter none record nothing
+func Setup(ctx context.Context, c Config) (*Providers, error) {
+	if c.Exporter == ExporterNone || c.Exporter == "" {
+		return &Providers{TracerProvider: tracenoop.NewTracerProvider(), MeterProvider: metricnoop.NewMeterProvider()}, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(append(c.Attributes, attribute.String("service.name", "azapim"))...))
+	if err != nil {
+		return nil, err
+	}
+
+	var spans sdktrace.SpanExporter
+	var metrics sdkmetric.Exporter
+	switch c.Exporter {
+	case ExporterStdout:
+		w := c.Writer
+		if w == nil {
+			w = os.Stderr
+		}
+		if spans, err = stdouttrace.New(stdouttrace.WithWriter(w)); err != nil {
+			return nil, err
+		}
+		if metrics, err = stdoutmetric.New(stdoutmetric.WithWriter(w)); err != nil {
+			return nil, err
+		}
+	case ExporterOTLP:
+		traceOpts, metricOpts, err := otlpOptions(c.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if spans, err = otlptracehttp.New(ctx, traceOpts...); err != nil {
+			return nil, err
+		}
+		if metrics, err = otlpmetrichttp.New(ctx, metricOpts...); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown telemetry exporter '%s'", c.Exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spans), sdktrace.WithResource(res))
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metrics)), sdkmetric.WithResource(res))
+	return &Providers{
+		TracerProvider: tp,
+		MeterProvider:  mp,
+		shutdown: func(ctx context.Context) error {
+			// spans are flushed first, the metric reader collects the final values on shutdown
+			return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+		},
+	}, nil
+}
+
+// otlpOptions returns the exporter options for the base url of the collector. the signal paths
+// /v1/traces and /v1/metrics are appended like for OTEL_EXPORTER_OTLP_ENDPOINT
+func otlpOptions(endpoint string) ([]otlptracehttp.Option, []otlpmetrichttp.Option, error) {
+	if endpoint == "" {
+		return nil, nil, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, nil, fmt.Errorf("invalid otlp endpoint '%s', expected a url like http://localhost:4318", endpoint)
+	}
+	traceOpts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(path.Join("/", u.Path, "v1/traces")),
+	}
+	metricOpts := []otlpmetrichttp.Option{
+		otlpmetrichttp.WithEndpoint(u.Host),
+		otlpmetrichttp.WithURLPath(path.Join("/", u.Path, "v1/metrics")),
+	}
+	if u.Scheme != "https" {
+		traceOpts = append(traceOpts, otlptracehttp.WithInsecure())
+		metricOpts = append(metricOpts, otlpmetrichttp.WithInsecure())
+	}
+	return traceOpts, metricOpts, nil
+}
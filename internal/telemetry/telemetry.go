@@ -0,0 +1,165 @@
+// Package telemetry records spans and metrics of the steps of azapim commands with OpenTelemetry.
+// the steps are recorded with the tracer and meter providers of their context, the global providers
+// if the context has none. Setup returns the providers exporting them to stdout or an otlp collector
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/foryouandyourcustomers/azapim/internal/enum"
+)
+
+// instrumentation is the name of the tracer and meter
+const instrumentation = "github.com/foryouandyourcustomers/azapim"
+
+// Metric names
+const (
+	CommandDuration = "azapim.command.duration"
+	StepDuration    = "azapim.step.duration"
+	PollingDuration = "azapim.polling.duration"
+	Retries         = "azapim.retries"
+)
+
+// Attributes of the spans and metrics
+const (
+	CommandKey   = attribute.Key("azapim.command")
+	StepKey      = attribute.Key("azapim.step")
+	OperationKey = attribute.Key("azapim.operation")
+	OutcomeKey   = attribute.Key("azapim.outcome")
+	RetriesKey   = attribute.Key("azapim.retries")
+)
+
+// Command records the span and duration of a cli command, all steps of the command are children of its span
+func Command(ctx context.Context, name string, fn func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	return measure(ctx, name, CommandDuration, "duration of azapim commands", CommandKey.String(name), fn, attrs...)
+}
+
+// Step records the span and duration of a step, e.g. the update of a version set or the import of an api
+func Step(ctx context.Context, name string, fn func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	return measure(ctx, name, StepDuration, "duration of the steps of azapim commands", StepKey.String(name), fn, attrs...)
+}
+
+// Poll records the span and duration of polling a long running operation until it finished
+func Poll(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	return measure(ctx, "poll "+operation, PollingDuration, "duration of polling long running operations", OperationKey.String(operation), fn)
+}
+
+// RecordRetries counts the retries of an operation and adds them to the current span
+func RecordRetries(ctx context.Context, operation string, retries int) {
+	if retries <= 0 {
+		return
+	}
+	trace.SpanFromContext(ctx).SetAttributes(RetriesKey.Int(retries))
+	c, err := meter(ctx).Int64Counter(Retries, metric.WithDescription("retries of requests failing with transient errors"))
+	if err != nil {
+		otel.Handle(err)
+		return
+	}
+	c.Add(ctx, int64(retries), metric.WithAttributes(OperationKey.String(operation)))
+}
+
+// measure executes fn in a span and records its duration in seconds in the histogram
+func measure(ctx context.Context, name string, histogram string, description string, key attribute.KeyValue, fn func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := tracer(ctx).Start(ctx, name, trace.WithAttributes(append(attrs, key)...))
+	defer span.End()
+
+	start := time.Now()
+	err := fn(ctx)
+	d := time.Since(start)
+
+	outcome := Outcome(err)
+	span.SetAttributes(OutcomeKey.String(outcome))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	h, hErr := meter(ctx).Float64Histogram(histogram, metric.WithDescription(description), metric.WithUnit("s"))
+	if hErr != nil {
+		otel.Handle(hErr)
+		return err
+	}
+	h.Record(ctx, d.Seconds(), metric.WithAttributes(key, OutcomeKey.String(outcome)))
+	return err
+}
+
+// Outcome returns "ok", "canceled" or "error" for the error of a step
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+type providersKey struct{}
+
+// WithProviders returns a context whose steps are recorded with the providers, the context is returned
+// unchanged if p is nil
+func WithProviders(ctx context.Context, p *Providers) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, providersKey{}, p)
+}
+
+// providers returns the providers of the context, nil if it has none
+func providers(ctx context.Context) *Providers {
+	p, _ := ctx.Value(providersKey{}).(*Providers)
+	return p
+}
+
+// tracer returns the tracer of the provider of the context, of the global provider if it has none
+func tracer(ctx context.Context) trace.Tracer {
+	if p := providers(ctx); p != nil && p.TracerProvider != nil {
+		return p.TracerProvider.Tracer(instrumentation)
+	}
+	return otel.Tracer(instrumentation)
+}
+
+// meter returns the meter of the provider of the context, of the global provider if it has none.
+// instruments are looked up on every use so they are bound to the provider of the context
+func meter(ctx context.Context) metric.Meter {
+	if p := providers(ctx); p != nil && p.MeterProvider != nil {
+		return p.MeterProvider.Meter(instrumentation)
+	}
+	return otel.Meter(instrumentation)
+}
+
+// Exporter defines where spans and metrics are exported to
+type Exporter string
+
+// Supported exporters
+const (
+	ExporterNone   Exporter = "none"
+	ExporterStdout Exporter = "stdout"
+	ExporterOTLP   Exporter = "otlp"
+)
+
+// Exporters contains all supported exporters
+var Exporters = []Exporter{ExporterNone, ExporterStdout, ExporterOTLP}
+
+// ParseExporter returns the exporter with the given name, none if empty
+func ParseExporter(s string) (Exporter, error) {
+	if s == "" {
+		return ExporterNone, nil
+	}
+	for _, e := range Exporters {
+		if string(e) == s {
+			return e, nil
+		}
+	}
+	return "", fmt.Errorf("unknown telemetry exporter '%s', supported are %s", s, enum.Join(Exporters))
+}
@@ -0,0 +1,114 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSteps(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	metrics := sdkmetric.NewManualReader()
+	ctx := WithProviders(context.Background(), &Providers{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(metrics)),
+	})
+
+	failed := errors.New("failed")
+	err := Command(ctx, "azapim versionedapi create", func(ctx context.Context) error {
+		_ = Step(ctx, "create or update api", func(ctx context.Context) error {
+			RecordRetries(ctx, "create or update api", 2)
+			return Poll(ctx, "api import", func(ctx context.Context) error { return nil })
+		})
+		return Step(ctx, "assign api to product", func(ctx context.Context) error { return failed })
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("err = %v, want the error of the step", err)
+	}
+
+	ended := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range spans.Ended() {
+		ended[s.Name()] = s
+	}
+	command := ended["azapim versionedapi create"]
+	if command == nil || len(ended) != 4 {
+		t.Fatalf("spans = %v", ended)
+	}
+	for _, name := range []string{"create or update api", "assign api to product"} {
+		if ended[name].Parent().SpanID() != command.SpanContext().SpanID() {
+			t.Errorf("span %s is not a child of the command", name)
+		}
+	}
+	if ended["poll api import"].Parent().SpanID() != ended["create or update api"].SpanContext().SpanID() {
+		t.Error("polling span is not a child of its step")
+	}
+	if !hasAttribute(ended["create or update api"].Attributes(), RetriesKey.Int(2)) {
+		t.Errorf("retries not recorded: %v", ended["create or update api"].Attributes())
+	}
+	if s := ended["assign api to product"]; s.Status().Code != codes.Error || !hasAttribute(s.Attributes(), OutcomeKey.String("error")) {
+		t.Errorf("failed step recorded with status %v and attributes %v", s.Status(), s.Attributes())
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := metrics.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	recorded := map[string]bool{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			recorded[m.Name] = true
+		}
+	}
+	for _, name := range []string{CommandDuration, StepDuration, PollingDuration, Retries} {
+		if !recorded[name] {
+			t.Errorf("metric %s not recorded", name)
+		}
+	}
+}
+
+func TestSetupKeepsGlobalProviders(t *testing.T) {
+	tp, mp := otel.GetTracerProvider(), otel.GetMeterProvider()
+	p, err := Setup(context.Background(), Config{Exporter: ExporterStdout, Writer: ioutil.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Shutdown(context.Background())
+	if otel.GetTracerProvider() != tp || otel.GetMeterProvider() != mp {
+		t.Error("setup replaced the global providers")
+	}
+	if p.TracerProvider == tp || p.MeterProvider == mp {
+		t.Error("setup returned the global providers")
+	}
+}
+
+func TestOTLPOptions(t *testing.T) {
+	if _, _, err := otlpOptions("localhost:4318"); err == nil {
+		t.Error("expected error for endpoint without scheme")
+	}
+	traceOpts, metricOpts, err := otlpOptions("https://collector.example.com/otlp")
+	if err != nil || len(traceOpts) != 2 || len(metricOpts) != 2 {
+		t.Errorf("https endpoint returned %d trace and %d metric options, err %v", len(traceOpts), len(metricOpts), err)
+	}
+	traceOpts, _, _ = otlpOptions("http://localhost:4318")
+	if len(traceOpts) != 3 {
+		t.Errorf("http endpoint returned %d trace options, want insecure", len(traceOpts))
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
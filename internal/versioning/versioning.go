@@ -0,0 +1,73 @@
+// Package versioning derives the versions of versioned apis, e.g. v1, v2 or 1.0
+package versioning
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// numbered matches versions with an optional prefix and dot separated numbers
+var numbered = regexp.MustCompile(`^([a-zA-Z]*)(\d+(?:\.\d+)*)$`)
+
+// Next returns the version following the given one after breaking changes. the first number is incremented and the
+// following numbers are reset, e.g. v1 → v2, v1.3 → v2.0 and 2 → 3
+func Next(version string) (string, error) {
+	m := numbered.FindStringSubmatch(version)
+	if m == nil {
+		return "", fmt.Errorf("unable to derive the version following '%s', expected a version like v1, 2 or v1.0", version)
+	}
+	parts := strings.Split(m[2], ".")
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid version '%s': %w", version, err)
+	}
+	parts[0] = strconv.Itoa(major + 1)
+	for i := 1; i < len(parts); i++ {
+		parts[i] = "0"
+	}
+	return m[1] + strings.Join(parts, "."), nil
+}
+
+// Compare returns -1, 0 or 1 if version a is lower than, equal to or higher than b. numbered versions are compared
+// by their numbers and are higher than other versions, which are compared as strings, e.g. dates
+func Compare(a string, b string) int {
+	ma, mb := numbered.FindStringSubmatch(a), numbered.FindStringSubmatch(b)
+	switch {
+	case ma == nil && mb == nil:
+		return strings.Compare(a, b)
+	case ma == nil:
+		return -1
+	case mb == nil:
+		return 1
+	}
+	pa, pb := strings.Split(ma[2], "."), strings.Split(mb[2], ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// Latest returns the highest of the versions, empty if none is given
+func Latest(versions []string) string {
+	latest := ""
+	for i, v := range versions {
+		if i == 0 || Compare(v, latest) > 0 {
+			latest = v
+		}
+	}
+	return latest
+}
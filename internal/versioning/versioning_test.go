@@ -0,0 +1,51 @@
+package versioning
+
+import "testing"
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{version: "v1", want: "v2"},
+		{version: "v9", want: "v10"},
+		{version: "2", want: "3"},
+		{version: "v1.3", want: "v2.0"},
+		{version: "V1.2.3", want: "V2.0.0"},
+		{version: "2021-01-01", wantErr: true},
+		{version: "beta", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := Next(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("next = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatest(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		want     string
+	}{
+		{name: "none"},
+		{name: "numbers", versions: []string{"v2", "v10", "v9"}, want: "v10"},
+		{name: "minor", versions: []string{"v1.10", "v1.9", "v1"}, want: "v1.10"},
+		{name: "dates", versions: []string{"2021-01-01", "2022-06-30", "2021-12-31"}, want: "2022-06-30"},
+		{name: "numbered before others", versions: []string{"beta", "v1"}, want: "v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Latest(tt.versions); got != tt.want {
+				t.Errorf("latest = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
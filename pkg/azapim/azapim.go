@@ -0,0 +1,329 @@
+// Package azapim allows to create or update versioned apis and to backup or restore
+// an Azure API management service. It is the public counterpart of the azapim cli.
+package azapim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
+	"github.com/foryouandyourcustomers/azapim/internal/authentication"
+	"github.com/foryouandyourcustomers/azapim/internal/cloud"
+	"github.com/foryouandyourcustomers/azapim/internal/disasterrecovery"
+	"github.com/foryouandyourcustomers/azapim/internal/discovery"
+	"github.com/foryouandyourcustomers/azapim/internal/preflight"
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+)
+
+// Default polling timeouts of the long running operations
+const (
+	DefaultAPIPollingTimeout              = apimclient.DefaultAPIPollingTimeout
+	DefaultDisasterRecoveryPollingTimeout = apimclient.DefaultDisasterRecoveryPollingTimeout
+)
+
+// Client manages a single Azure API management service
+type Client struct {
+	apim *apimclient.ApimClient
+	auth AuthSettings
+	env  azure.Environment
+}
+
+// Option configures the Client
+type Option func(*Client)
+
+// WithAuthSettings sets the authentication mode and credentials used to create the authorizer.
+// if neither auth settings nor an authorizer are set the login from the az cli is used
+func WithAuthSettings(s AuthSettings) Option {
+	return func(c *Client) {
+		c.auth = s
+	}
+}
+
+// WithAuthorizer sets the authorizer used for all requests against azure. it takes
+// precedence over the auth settings
+func WithAuthorizer(a autorest.Authorizer) Option {
+	return func(c *Client) {
+		c.apim.Authorizer = a
+	}
+}
+
+// WithEnvironment sets the azure environment (cloud) of the api management service, the
+// public cloud if not set. it defines the resource manager endpoint and the token audience
+func WithEnvironment(env azure.Environment) Option {
+	return func(c *Client) {
+		c.env = env
+	}
+}
+
+// WithBaseURI sets the base uri of the azure resource manager, e.g. to use a local test server.
+// it takes precedence over the resource manager endpoint of the environment
+func WithBaseURI(uri string) Option {
+	return func(c *Client) {
+		c.apim.BaseURI = uri
+	}
+}
+
+// WithRetryPolicy sets the retry policy for requests against the api management service.
+// a policy with MaxRetries 0 disables retries
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.apim.Retry = retry.Policy(p)
+	}
+}
+
+// WithPollingTimeouts sets the maximum time to wait for api imports and for backup or restore operations.
+// the defaults are used for timeouts which are not greater than zero
+func WithPollingTimeouts(api time.Duration, disasterRecovery time.Duration) Option {
+	return func(c *Client) {
+		c.apim.APIPollingTimeout = api
+		c.apim.DisasterRecoveryPollingTimeout = disasterRecovery
+	}
+}
+
+// WithConflictStrategy sets how concurrent changes of the version set, api and policy are handled.
+// the version set, api and policy are updated only if their etag didn't change since it was read,
+// by default the update fails otherwise
+func WithConflictStrategy(s ConflictStrategy) Option {
+	return func(c *Client) {
+		c.apim.OnConflict = apimclient.ConflictStrategy(s)
+	}
+}
+
+// New returns a client for the api management service identified by subscription, resource group and name
+func New(subscription string, resourceGroup string, serviceName string, opts ...Option) (*Client, error) {
+	if subscription == "" || resourceGroup == "" || serviceName == "" {
+		return nil, errors.New("subscription, resource group and service name are required")
+	}
+
+	c, err := newClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	c.apim.Subscription = subscription
+	c.apim.ResourceGroup = resourceGroup
+	c.apim.ServiceName = serviceName
+	if err := c.apim.Authenticate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newClient applies the options and creates the authorizer, the service isn't set
+func newClient(opts []Option) (*Client, error) {
+	c := &Client{
+		apim: &apimclient.ApimClient{
+			Retry: retry.DefaultPolicy(),
+		},
+		auth: AuthSettings{Mode: AuthModeAzureCLI},
+		env:  azure.PublicCloud,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	if c.auth.Environment.Name == "" {
+		c.auth.Environment = c.env
+	}
+	if c.apim.BaseURI == "" {
+		c.apim.BaseURI = cloud.ResourceManagerURI(c.env)
+	}
+	if c.apim.Authorizer == nil {
+		a, err := authentication.NewAuthorizer(c.auth.settings())
+		if err != nil {
+			return nil, err
+		}
+		c.apim.Authorizer = a
+	}
+	return c, nil
+}
+
+// ParseServiceID returns the service identified by the resource id
+// /subscriptions/{subscription}/resourceGroups/{resource group}/providers/Microsoft.ApiManagement/service/{name}
+func ParseServiceID(id string) (ServiceID, error) {
+	s, err := discovery.Parse(id)
+	return ServiceID(s), err
+}
+
+// FindService looks up the subscription and resource group of the service by its name. only the subscription
+// is searched if it is set, all subscriptions accessible to the principal otherwise. the options define the
+// authorizer, environment and retry policy of the lookup
+func FindService(ctx context.Context, name string, subscription string, opts ...Option) (ServiceID, error) {
+	c, err := newClient(opts)
+	if err != nil {
+		return ServiceID{}, err
+	}
+	subs, services := discovery.NewClients(c.apim.BaseURI, c.apim.Authorizer)
+	id, err := discovery.Find(ctx, c.apim.Retry, subs, services, name, subscription)
+	return ServiceID(id), wrapError(err)
+}
+
+// Subscription returns the subscription id of the api management service
+func (c *Client) Subscription() string {
+	return c.apim.Subscription
+}
+
+// ResourceGroup returns the resource group of the api management service
+func (c *Client) ResourceGroup() string {
+	return c.apim.ResourceGroup
+}
+
+// ServiceName returns the name of the api management service
+func (c *Client) ServiceName() string {
+	return c.apim.ServiceName
+}
+
+// Environment returns the azure environment of the api management service
+func (c *Client) Environment() azure.Environment {
+	return c.env
+}
+
+// CreateOrUpdateVersionedAPI loads the openapi spec and xml policy of the definition and
+// creates or updates the versioned api, its policy and product assignments. the loaded spec
+// and policy and the defaults are set in the definition
+func (c *Client) CreateOrUpdateVersionedAPI(ctx context.Context, d *Definition) (*DeploymentResult, error) {
+	a := d.definition()
+	r, err := c.deploy(ctx, a)
+	d.update(a)
+	return r, err
+}
+
+// deploy loads the spec and policy of the definition and deploys it
+func (c *Client) deploy(ctx context.Context, a *apidefinition.Definition) (*DeploymentResult, error) {
+	a.SetDefaults()
+	if err := a.GetOpenAPISpec(ctx); err != nil {
+		return nil, wrapError(err)
+	}
+	if err := a.GetXMLPolicy(ctx); err != nil {
+		return nil, wrapError(err)
+	}
+	r, err := c.apim.CreateOrUpdate(ctx, a)
+	return deploymentResult(r), wrapError(err)
+}
+
+// NextVersion loads the openapi spec of the definition, compares it with the latest version of the api and suggests
+// the next version, a new version for breaking changes or a new revision for compatible changes. the initial version
+// is suggested if no version of the api is deployed
+func (c *Client) NextVersion(ctx context.Context, d *Definition, initialVersion string) (*VersionSuggestion, error) {
+	a := d.definition()
+	err := a.GetOpenAPISpec(ctx)
+	d.update(a)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	v, err := c.apim.NextVersion(ctx, a, initialVersion)
+	return versionSuggestion(v), wrapError(err)
+}
+
+// Deprecate adds a deprecation notice to the api version, sets its Deprecation and Sunset response headers
+// and removes it from the products of the deprecation
+func (c *Client) Deprecate(ctx context.Context, d *Deprecation) (*DeprecationResult, error) {
+	r, err := c.apim.Deprecate(ctx, (*apimclient.Deprecation)(d))
+	return (*DeprecationResult)(r), wrapError(err)
+}
+
+// Backup creates a disaster recovery backup of the api management service.
+// if no backup name is given a name based on the service name and the current time is set
+func (c *Client) Backup(ctx context.Context, dr *DisasterRecovery) (*DisasterRecoveryResult, error) {
+	start := time.Now()
+	if dr.BackupName == "" {
+		dr.BackupName = fmt.Sprintf("%s-%d", c.apim.ServiceName, time.Now().Unix())
+	}
+	p, err := c.disasterRecovery(ctx, dr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.apim.Backup(ctx, c.apim.ResourceGroup, c.apim.ServiceName, p.Parameters); err != nil {
+		return nil, wrapError(err)
+	}
+	return c.disasterRecoveryResult("backup", dr, start), nil
+}
+
+// Restore restores the api management service from the given disaster recovery backup
+func (c *Client) Restore(ctx context.Context, dr *DisasterRecovery) (*DisasterRecoveryResult, error) {
+	start := time.Now()
+	if dr.BackupName == "" {
+		return nil, errors.New("backup name is required for restore")
+	}
+	p, err := c.disasterRecovery(ctx, dr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.apim.Restore(ctx, c.apim.ResourceGroup, c.apim.ServiceName, p.Parameters); err != nil {
+		return nil, wrapError(err)
+	}
+	return c.disasterRecoveryResult("restore", dr, start), nil
+}
+
+func (c *Client) disasterRecoveryResult(operation string, dr *DisasterRecovery, start time.Time) *DisasterRecoveryResult {
+	return &DisasterRecoveryResult{
+		Operation:       operation,
+		ServiceName:     c.apim.ServiceName,
+		BackupName:      dr.BackupName,
+		StorageAccount:  dr.StorageAccount,
+		Container:       dr.Container,
+		DurationSeconds: time.Since(start).Round(time.Millisecond).Seconds(),
+	}
+}
+
+// PreflightOptions defines the commands to check and the storage account used for backup and restore
+type PreflightOptions struct {
+	// Commands to check, one of "versionedapi", "backup" or "restore". all commands if empty
+	Commands             []string
+	StorageAccount       string
+	StorageResourceGroup string
+	// Transactional checks the permissions of the rollback of transactional versioned api deployments
+	Transactional bool
+	// History checks the permissions of recording deployments in the history if its storage account is set
+	History HistoryOptions
+	// Lookup checks the permissions of FindService, which looks up the service by its name
+	Lookup bool
+}
+
+// Preflight resolves the authenticated principal, checks that the api management service is provisioned
+// and that the principal has all permissions required by the commands
+func (c *Client) Preflight(ctx context.Context, o PreflightOptions) (*PreflightReport, error) {
+	r, err := preflight.Run(ctx, preflight.Config{
+		Authorizer:            c.apim.Authorizer,
+		Services:              c.apim.ServiceClient,
+		Permissions:           preflight.NewPermissionsClient(c.apim.BaseURI, c.apim.Authorizer),
+		Subscription:          c.apim.Subscription,
+		ResourceGroup:         c.apim.ResourceGroup,
+		ServiceName:           c.apim.ServiceName,
+		Retry:                 c.apim.Retry,
+		StorageAccount:        o.StorageAccount,
+		StorageResourceGroup:  o.StorageResourceGroup,
+		Transactional:         o.Transactional,
+		HistoryResourceGroup:  o.History.ResourceGroup,
+		HistoryStorageAccount: o.History.StorageAccount,
+		Lookup:                o.Lookup,
+		Commands:              o.Commands,
+	})
+	return preflightReport(r), wrapError(err)
+}
+
+// disasterRecovery retrieves the key of the storage account and returns the parameters of the backup or restore
+func (c *Client) disasterRecovery(ctx context.Context, dr *DisasterRecovery) (*apimclient.DisasterRecovery, error) {
+	p := &apimclient.DisasterRecovery{
+		BackupName: dr.BackupName,
+		Storage: disasterrecovery.StorageAccount{
+			ResourceGroup: dr.StorageResourceGroup,
+			AccountName:   dr.StorageAccount,
+			BlobName:      dr.Container,
+			Authorizer:    c.apim.Authorizer,
+			BaseURI:       c.apim.BaseURI,
+			Retry:         c.apim.Retry,
+		},
+	}
+	if err := p.Initialize(ctx, c.apim.Subscription); err != nil {
+		if ctx.Err() != nil {
+			return nil, &InterruptedError{Step: "get storage account key", Err: ctx.Err()}
+		}
+		return nil, wrapError(err)
+	}
+	return p, nil
+}
@@ -0,0 +1,484 @@
+package azapim_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apimtest"
+	"github.com/foryouandyourcustomers/azapim/pkg/azapim"
+)
+
+const (
+	subscription  = "00000000-0000-0000-0000-000000000000"
+	resourceGroup = "apimresourcegroup"
+	serviceName   = "apimservicename"
+)
+
+func newTestClient(t *testing.T) (*azapim.Client, *apimtest.Server) {
+	t.Helper()
+	srv := apimtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddService(subscription, resourceGroup, serviceName)
+
+	c, err := azapim.New(subscription, resourceGroup, serviceName,
+		azapim.WithAuthorizer(autorest.NullAuthorizer{}),
+		azapim.WithBaseURI(srv.URL),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return c, srv
+}
+
+func newDefinition() *azapim.Definition {
+	return &azapim.Definition{
+		APIID:          "httpbin",
+		APIDisplayName: "httpbin api",
+		APIPath:        "/httpbin",
+		APIVersion:     "v1",
+		APIServiceURL:  "https://my.backend.service/httpbin",
+		APIProducts:    []string{"starter"},
+		OpenAPISpec:    `{"openapi": "3.0.1", "info": {"title": "httpbin", "version": "v1"}, "paths": {}}`,
+	}
+}
+
+func TestCreateOrUpdateVersionedAPI(t *testing.T) {
+	c, srv := newTestClient(t)
+	serviceID := apimtest.ServiceID(subscription, resourceGroup, serviceName)
+	srv.AddProduct(serviceID, "starter")
+
+	if _, err := c.CreateOrUpdateVersionedAPI(context.Background(), newDefinition()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, id := range []string{
+		serviceID + "/apiVersionSets/httpbin",
+		serviceID + "/apis/httpbin-v1",
+		serviceID + "/apis/httpbin-v1/policies/policy",
+		serviceID + "/products/starter/apis/httpbin-v1",
+	} {
+		if _, ok := srv.Get(id); !ok {
+			t.Errorf("resource %s not created", id)
+		}
+	}
+
+	polled := false
+	for _, r := range srv.Requests() {
+		if strings.HasPrefix(r, "GET /operations/") {
+			polled = true
+		}
+	}
+	if !polled {
+		t.Error("long running operation of the api import was not polled")
+	}
+
+	api, _ := srv.Get(serviceID + "/apis/httpbin-v1")
+	props := api["properties"].(map[string]interface{})
+	if props["apiVersionSetId"] != serviceID+"/apiVersionSets/httpbin" {
+		t.Errorf("apiVersionSetId = %v", props["apiVersionSetId"])
+	}
+	if props["serviceUrl"] != "https://my.backend.service/httpbin" {
+		t.Errorf("serviceUrl = %v", props["serviceUrl"])
+	}
+}
+
+func TestCreateOrUpdateVersionedAPIUnknownProduct(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	if _, err := c.CreateOrUpdateVersionedAPI(context.Background(), newDefinition()); err == nil {
+		t.Fatal("expected error for unknown product")
+	}
+}
+
+func TestCreateOrUpdateVersionedAPIServerError(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.Fail(http.MethodPut, "/policies/policy", http.StatusBadRequest)
+
+	d := newDefinition()
+	d.APIProducts = nil
+	if _, err := c.CreateOrUpdateVersionedAPI(context.Background(), d); err == nil {
+		t.Fatal("expected error for failing policy update")
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	d := newDefinition()
+	d.OpenAPISpec = ""
+	d.OpenAPISpecPath = filepath.Join(t.TempDir(), "missing.json")
+	_, err := c.CreateOrUpdateVersionedAPI(context.Background(), d)
+	var specErr *azapim.SpecNotFoundError
+	if !errors.As(err, &specErr) || specErr.Path != d.OpenAPISpecPath {
+		t.Errorf("missing spec = %v, want a SpecNotFoundError", err)
+	}
+
+	d = newDefinition()
+	d.XMLPolicyPath = filepath.Join(t.TempDir(), "missing.xml")
+	_, err = c.CreateOrUpdateVersionedAPI(context.Background(), d)
+	var policyErr *azapim.PolicyReadError
+	if !errors.As(err, &policyErr) || policyErr.Path != d.XMLPolicyPath {
+		t.Errorf("missing policy = %v, want a PolicyReadError", err)
+	}
+
+	dr := &azapim.DisasterRecovery{StorageAccount: "missing", StorageResourceGroup: "storagerg", Container: "apim"}
+	_, err = c.Backup(context.Background(), dr)
+	var keyErr *azapim.StorageKeyUnavailableError
+	if !errors.As(err, &keyErr) || keyErr.AccountName != "missing" {
+		t.Errorf("missing storage account = %v, want a StorageKeyUnavailableError", err)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.AddStorageAccount(subscription, "storagerg", "backups", "secretkey")
+
+	dr := &azapim.DisasterRecovery{
+		StorageAccount:       "backups",
+		StorageResourceGroup: "storagerg",
+		Container:            "apim",
+	}
+	if _, err := c.Backup(context.Background(), dr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dr.BackupName == "" {
+		t.Fatal("backup name not set")
+	}
+	b, ok := srv.Backup(dr.BackupName)
+	if !ok {
+		t.Fatalf("backup %s not created", dr.BackupName)
+	}
+	if b["accessKey"] != "secretkey" || b["storageAccount"] != "backups" || b["containerName"] != "apim" {
+		t.Errorf("unexpected backup parameters: %v", b)
+	}
+
+	if _, err := c.Restore(context.Background(), dr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRestoreUnknownBackup(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.AddStorageAccount(subscription, "storagerg", "backups", "secretkey")
+
+	dr := &azapim.DisasterRecovery{
+		BackupName:           "unknown",
+		StorageAccount:       "backups",
+		StorageResourceGroup: "storagerg",
+		Container:            "apim",
+	}
+	if _, err := c.Restore(context.Background(), dr); err == nil {
+		t.Fatal("expected error for unknown backup")
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	c, srv := newTestClient(t)
+	serviceID := apimtest.ServiceID(subscription, resourceGroup, serviceName)
+	storageID := apimtest.StorageAccountID(subscription, "storagerg", "backups")
+	srv.SetPermissions(serviceID, []string{"Microsoft.ApiManagement/service/*"}, []string{"Microsoft.ApiManagement/service/restore/action"})
+
+	r, err := c.Preflight(context.Background(), azapim.PreflightOptions{
+		StorageAccount:       "backups",
+		StorageResourceGroup: "storagerg",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !r.Service.Ready {
+		t.Errorf("service not ready: %+v", r.Service)
+	}
+	if r.OK() {
+		t.Fatal("expected missing permissions")
+	}
+
+	missing := map[string]bool{}
+	for _, g := range r.Gaps() {
+		missing[g.Scope+" "+g.Action] = true
+	}
+	want := []string{
+		serviceID + " Microsoft.ApiManagement/service/restore/action",
+		storageID + " Microsoft.Storage/storageAccounts/listkeys/action",
+	}
+	if len(missing) != len(want) {
+		t.Errorf("gaps = %v, want %v", missing, want)
+	}
+	for _, w := range want {
+		if !missing[w] {
+			t.Errorf("gap %s not reported", w)
+		}
+	}
+}
+
+func TestCreateOrUpdateVersionedAPIRetriesConflicts(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		failures   []int
+		wantErr    bool
+		wantPuts   int
+	}{
+		{"no retries conflict", 0, []int{http.StatusConflict}, true, 1},
+		{"no retries unavailable", 0, []int{http.StatusServiceUnavailable}, true, 1},
+		{"one retry", 1, []int{http.StatusConflict}, false, 2},
+		{"one retry exhausted", 1, []int{http.StatusConflict, http.StatusServiceUnavailable}, true, 2},
+		{"three retries", 3, []int{http.StatusConflict, http.StatusServiceUnavailable, http.StatusConflict}, false, 4},
+		{"three retries exhausted", 3, []int{http.StatusConflict, http.StatusConflict, http.StatusConflict, http.StatusConflict}, true, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := apimtest.NewServer()
+			t.Cleanup(srv.Close)
+			srv.AddService(subscription, resourceGroup, serviceName)
+			srv.Fail(http.MethodPut, "/policies/policy", tt.failures...)
+
+			p := azapim.DefaultRetryPolicy()
+			p.MaxRetries = tt.maxRetries
+			p.BaseDelay = time.Millisecond
+			c, err := azapim.New(subscription, resourceGroup, serviceName,
+				azapim.WithAuthorizer(autorest.NullAuthorizer{}),
+				azapim.WithBaseURI(srv.URL),
+				azapim.WithRetryPolicy(p),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			d := newDefinition()
+			d.APIProducts = nil
+			_, err = c.CreateOrUpdateVersionedAPI(context.Background(), d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			puts := 0
+			for _, r := range srv.Requests() {
+				if strings.HasPrefix(r, http.MethodPut+" ") && strings.HasSuffix(r, "/policies/policy") {
+					puts++
+				}
+			}
+			if puts != tt.wantPuts {
+				t.Errorf("expected %d policy requests, got %d", tt.wantPuts, puts)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateVersionedAPIPollingTimeout(t *testing.T) {
+	srv := apimtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddService(subscription, resourceGroup, serviceName)
+	srv.PendingPolls = 1 << 30
+
+	c, err := azapim.New(subscription, resourceGroup, serviceName,
+		azapim.WithAuthorizer(autorest.NullAuthorizer{}),
+		azapim.WithBaseURI(srv.URL),
+		azapim.WithPollingTimeouts(50*time.Millisecond, 0),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d := newDefinition()
+	d.APIProducts = nil
+	_, err = c.CreateOrUpdateVersionedAPI(context.Background(), d)
+	if err == nil || !strings.Contains(err.Error(), "polling timeout") {
+		t.Fatalf("got %v, want polling timeout error", err)
+	}
+}
+
+func TestCreateOrUpdateVersionedAPICanceled(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.PendingPolls = 1 << 30
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	d := newDefinition()
+	d.APIProducts = nil
+	_, err := c.CreateOrUpdateVersionedAPI(ctx, d)
+
+	var e *azapim.InterruptedError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want interrupted error", err)
+	}
+	if e.Step != "create or update api" {
+		t.Errorf("step = %s", e.Step)
+	}
+}
+
+func TestCreateOrUpdateVersionedAPIConflict(t *testing.T) {
+	for _, strategy := range []azapim.ConflictStrategy{azapim.ConflictFail, azapim.ConflictRetry} {
+		t.Run(string(strategy), func(t *testing.T) {
+			srv := apimtest.NewServer()
+			t.Cleanup(srv.Close)
+			srv.AddService(subscription, resourceGroup, serviceName)
+			c, err := azapim.New(subscription, resourceGroup, serviceName,
+				azapim.WithAuthorizer(autorest.NullAuthorizer{}),
+				azapim.WithBaseURI(srv.URL),
+				azapim.WithConflictStrategy(strategy),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			d := newDefinition()
+			d.APIProducts = nil
+			if _, err := c.CreateOrUpdateVersionedAPI(context.Background(), d); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// the version set is changed by someone else between reading its etag and the update
+			srv.Fail(http.MethodPut, "/apiVersionSets/httpbin", http.StatusPreconditionFailed)
+			d = newDefinition()
+			d.APIProducts = nil
+			_, err = c.CreateOrUpdateVersionedAPI(context.Background(), d)
+
+			var e *azapim.ConflictError
+			if conflict := errors.As(err, &e); conflict != (strategy == azapim.ConflictFail) {
+				t.Fatalf("strategy %s: unexpected error %v", strategy, err)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateVersionedAPITransactional(t *testing.T) {
+	c, srv := newTestClient(t)
+	serviceID := apimtest.ServiceID(subscription, resourceGroup, serviceName)
+	srv.AddProduct(serviceID, "starter")
+	if _, err := c.CreateOrUpdateVersionedAPI(context.Background(), newDefinition()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	apiID := serviceID + "/apis/httpbin-v1"
+	previous, _ := srv.Spec(apiID)
+
+	// the new spec is imported but the assignment to an unknown product fails
+	d := newDefinition()
+	d.Transactional = true
+	d.OpenAPISpec = `{"openapi": "3.0.1", "info": {"title": "httpbin", "version": "v1"}, "paths": {"/get": {}}}`
+	d.APIProducts = []string{"starter", "unknown"}
+	r, err := c.CreateOrUpdateVersionedAPI(context.Background(), d)
+
+	var e *azapim.RollbackError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want rollback error", err)
+	}
+	if len(r.RolledBack) == 0 || len(r.RollbackFailures) > 0 {
+		t.Fatalf("rolled back %v, failures %v", r.RolledBack, r.RollbackFailures)
+	}
+	if spec, _ := srv.Spec(apiID); spec != previous {
+		t.Errorf("spec = %s, want %s", spec, previous)
+	}
+	if _, ok := srv.Get(serviceID + "/products/starter/apis/httpbin-v1"); !ok {
+		t.Error("existing product assignment was removed")
+	}
+}
+
+func TestDeploymentHistoryAndRollback(t *testing.T) {
+	c, srv := newTestClient(t)
+	serviceID := apimtest.ServiceID(subscription, resourceGroup, serviceName)
+	srv.AddProduct(serviceID, "starter")
+	srv.AddStorageAccount(subscription, "historyrg", "history", base64.StdEncoding.EncodeToString([]byte("secretkey")))
+	o := azapim.HistoryOptions{
+		StorageAccount: "history",
+		ResourceGroup:  "historyrg",
+		Endpoint:       srv.URL + apimtest.BlobPrefix + "/history",
+	}
+	apiID := serviceID + "/apis/httpbin-v1"
+
+	var recorded []string
+	for _, title := range []string{"first", "second"} {
+		d := newDefinition()
+		d.OpenAPISpec = fmt.Sprintf(`{"openapi": "3.0.1", "info": {"title": %q, "version": "v1"}, "paths": {}}`, title)
+		if _, err := c.CreateOrUpdateVersionedAPI(context.Background(), d); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r, err := c.RecordDeployment(context.Background(), o, d)
+		if err != nil {
+			t.Fatalf("unexpected error recording the deployment: %v", err)
+		}
+		recorded = append(recorded, r.ID)
+	}
+	h, err := c.History(context.Background(), o, "httpbin", "v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(h.Deployments) != 2 || h.Deployments[0].ID != recorded[0] || h.Deployments[1].ID != recorded[1] {
+		t.Fatalf("history = %+v, want %v", h.Deployments, recorded)
+	}
+	if h.Deployments[0].SpecHash == "" || h.Deployments[0].Spec != "" {
+		t.Errorf("listed deployment should contain the hash but not the spec: %+v", h.Deployments[0])
+	}
+
+	r, err := c.Rollback(context.Background(), o, "httpbin", "v1", recorded[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.RollbackOf != recorded[0] || r.DeploymentID == "" {
+		t.Errorf("rollback of %q recorded as %q", r.RollbackOf, r.DeploymentID)
+	}
+	if spec, _ := srv.Spec(apiID); !strings.Contains(spec, `"first"`) {
+		t.Errorf("spec = %s, want the first spec", spec)
+	}
+
+	h, err = c.History(context.Background(), o, "httpbin", "v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(h.Deployments) != 3 || h.Deployments[2].RollbackOf != recorded[0] {
+		t.Errorf("history = %+v, want the rollback as third deployment", h.Deployments)
+	}
+
+	_, err = c.Rollback(context.Background(), o, "httpbin", "v1", "unknown")
+	if !errors.Is(err, azapim.ErrDeploymentNotFound) {
+		t.Errorf("err = %v, want ErrDeploymentNotFound", err)
+	}
+}
+
+func TestRecordLinkDeployment(t *testing.T) {
+	c, srv := newTestClient(t)
+	serviceID := apimtest.ServiceID(subscription, resourceGroup, serviceName)
+	srv.AddProduct(serviceID, "starter")
+	srv.AddStorageAccount(subscription, "historyrg", "history", base64.StdEncoding.EncodeToString([]byte("secretkey")))
+	o := azapim.HistoryOptions{
+		StorageAccount: "history",
+		ResourceGroup:  "historyrg",
+		Endpoint:       srv.URL + apimtest.BlobPrefix + "/history",
+	}
+	deployed := `{"openapi": "3.0.1", "info": {"title": "deployed", "version": "v1"}, "paths": {}}`
+	content := deployed
+	specs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(specs.Close)
+
+	d := newDefinition()
+	d.OpenAPISpec = ""
+	d.OpenAPISpecPath = specs.URL + "/openapi.json"
+	if _, err := c.CreateOrUpdateVersionedAPI(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := c.RecordDeployment(context.Background(), o, d)
+	if err != nil {
+		t.Fatalf("unexpected error recording the deployment: %v", err)
+	}
+	if d.OpenAPISpec != d.OpenAPISpecPath {
+		t.Errorf("recording changed the deployed definition to %s", d.OpenAPISpec)
+	}
+
+	// the rollback imports the recorded content, not the changed content of the link
+	content = `{"openapi": "3.0.1", "info": {"title": "changed", "version": "v1"}, "paths": {}}`
+	if _, err := c.Rollback(context.Background(), o, "httpbin", "v1", r.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec, _ := srv.Spec(serviceID + "/apis/httpbin-v1"); spec != deployed {
+		t.Errorf("spec = %s, want the deployed content", spec)
+	}
+}
@@ -0,0 +1,208 @@
+package azapim
+
+import (
+	"errors"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
+	"github.com/foryouandyourcustomers/azapim/internal/disasterrecovery"
+	"github.com/foryouandyourcustomers/azapim/internal/discovery"
+	"github.com/foryouandyourcustomers/azapim/internal/specdiff"
+)
+
+// InterruptedError is returned if an operation is canceled or timed out, it names the step which was in progress
+type InterruptedError struct {
+	Step string
+	Err  error
+}
+
+func (e *InterruptedError) Error() string {
+	return (*apimclient.InterruptedError)(e).Error()
+}
+
+// Unwrap returns the error of the context
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// RollbackError is returned if a transactional deployment failed. RolledBack contains the changes which
+// were reverted, Failed the changes which couldn't be reverted
+type RollbackError struct {
+	Err        error
+	RolledBack []string
+	Failed     []string
+}
+
+func (e *RollbackError) Error() string {
+	return (*apimclient.RollbackError)(e).Error()
+}
+
+// Unwrap returns the error of the failed deployment
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// BreakingChangeError is returned if the spec of a definition with FailOnBreaking breaks the clients of the
+// deployed version, it contains the breaking changes and the suggested new version
+type BreakingChangeError struct {
+	APIVersion string
+	// SuggestedVersion is the version to deploy the spec as instead, empty if it can't be derived
+	SuggestedVersion string
+	Changes          []Change
+}
+
+func (e *BreakingChangeError) Error() string {
+	err := &apimclient.BreakingChangeError{APIVersion: e.APIVersion, SuggestedVersion: e.SuggestedVersion}
+	for _, c := range e.Changes {
+		err.Changes = append(err.Changes, specdiff.Change(c))
+	}
+	return err.Error()
+}
+
+// ConflictError is returned if the version set, api or policy was changed concurrently and the update was rejected
+type ConflictError struct {
+	Resource string
+	Err      error
+}
+
+func (e *ConflictError) Error() string {
+	return (*apimclient.ConflictError)(e).Error()
+}
+
+// Unwrap returns the error of the rejected update
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// ServiceNotFoundError is returned by FindService if no service with the name exists in the searched subscriptions
+type ServiceNotFoundError struct {
+	Name          string
+	Subscriptions []string
+}
+
+func (e *ServiceNotFoundError) Error() string {
+	return (*discovery.NotFoundError)(e).Error()
+}
+
+// SpecNotFoundError is returned if the openapi spec can't be loaded from the given path
+type SpecNotFoundError struct {
+	Path string
+	Err  error
+}
+
+func (e *SpecNotFoundError) Error() string {
+	return (*apidefinition.SpecNotFoundError)(e).Error()
+}
+
+// Unwrap returns the underlying error
+func (e *SpecNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// PolicyReadError is returned if the xml policy can't be loaded from the given path
+type PolicyReadError struct {
+	Path string
+	Err  error
+}
+
+func (e *PolicyReadError) Error() string {
+	return (*apidefinition.PolicyReadError)(e).Error()
+}
+
+// Unwrap returns the underlying error
+func (e *PolicyReadError) Unwrap() error {
+	return e.Err
+}
+
+// StorageKeyUnavailableError is returned if no access key can be retrieved for the storage account of
+// the backups or the deployment history
+type StorageKeyUnavailableError struct {
+	AccountName   string
+	ResourceGroup string
+	Err           error
+}
+
+func (e *StorageKeyUnavailableError) Error() string {
+	return (*disasterrecovery.StorageKeyUnavailableError)(e).Error()
+}
+
+// Unwrap returns the underlying error
+func (e *StorageKeyUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// wrappedError is an error of the internal packages. errors.As finds the errors of this package
+// for the internal errors in its chain, errors.Is and errors.As of other errors use the chain as is
+type wrappedError struct {
+	err error
+}
+
+// wrapError returns the error with the errors of this package, nil if err is nil
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &wrappedError{err: err}
+}
+
+func (e *wrappedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *wrappedError) Unwrap() error {
+	return e.err
+}
+
+func (e *wrappedError) As(target interface{}) bool {
+	switch t := target.(type) {
+	case **InterruptedError:
+		var err *apimclient.InterruptedError
+		if errors.As(e.err, &err) {
+			*t = (*InterruptedError)(err)
+			return true
+		}
+	case **RollbackError:
+		var err *apimclient.RollbackError
+		if errors.As(e.err, &err) {
+			*t = &RollbackError{Err: wrapError(err.Err), RolledBack: err.RolledBack, Failed: err.Failed}
+			return true
+		}
+	case **BreakingChangeError:
+		var err *apimclient.BreakingChangeError
+		if errors.As(e.err, &err) {
+			*t = &BreakingChangeError{APIVersion: err.APIVersion, SuggestedVersion: err.SuggestedVersion, Changes: changes(err.Changes)}
+			return true
+		}
+	case **ConflictError:
+		var err *apimclient.ConflictError
+		if errors.As(e.err, &err) {
+			*t = (*ConflictError)(err)
+			return true
+		}
+	case **SpecNotFoundError:
+		var err *apidefinition.SpecNotFoundError
+		if errors.As(e.err, &err) {
+			*t = (*SpecNotFoundError)(err)
+			return true
+		}
+	case **PolicyReadError:
+		var err *apidefinition.PolicyReadError
+		if errors.As(e.err, &err) {
+			*t = (*PolicyReadError)(err)
+			return true
+		}
+	case **StorageKeyUnavailableError:
+		var err *disasterrecovery.StorageKeyUnavailableError
+		if errors.As(e.err, &err) {
+			*t = (*StorageKeyUnavailableError)(err)
+			return true
+		}
+	case **ServiceNotFoundError:
+		var err *discovery.NotFoundError
+		if errors.As(e.err, &err) {
+			*t = (*ServiceNotFoundError)(err)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package azapim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/disasterrecovery"
+	"github.com/foryouandyourcustomers/azapim/internal/history"
+)
+
+// DefaultHistoryContainer is the blob container of the deployment history if none is set
+const DefaultHistoryContainer = "azapim-history"
+
+// DeploymentRecord is a recorded deployment of a versioned api. Spec and Policy are omitted in listings
+type DeploymentRecord struct {
+	ID          string    `json:"id"`
+	APIID       string    `json:"apiId"`
+	APIVersion  string    `json:"apiVersion"`
+	Revision    string    `json:"revision,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	GitSHA      string    `json:"gitSha,omitempty"`
+	RollbackOf  string    `json:"rollbackOf,omitempty"`
+	SpecHash    string    `json:"specHash"`
+	PolicyHash  string    `json:"policyHash"`
+	DisplayName string    `json:"displayName,omitempty"`
+	APIPath     string    `json:"apiPath,omitempty"`
+	ServiceURL  string    `json:"serviceUrl,omitempty"`
+	Products    []string  `json:"products,omitempty"`
+	// Protocols, SubscriptionRequired and Metadata are the optional properties of the api
+	Protocols            []Protocol `json:"protocols,omitempty"`
+	SubscriptionRequired *bool      `json:"subscriptionRequired,omitempty"`
+	Metadata             *Metadata  `json:"metadata,omitempty"`
+	SpecFormat           string     `json:"specFormat,omitempty"`
+	Spec                 string     `json:"spec,omitempty"`
+	PolicyFormat         string     `json:"policyFormat,omitempty"`
+	Policy               string     `json:"policy,omitempty"`
+}
+
+func deploymentRecord(r *history.Record) *DeploymentRecord {
+	return &DeploymentRecord{
+		ID:                   r.ID,
+		APIID:                r.APIID,
+		APIVersion:           r.APIVersion,
+		Revision:             r.Revision,
+		Timestamp:            r.Timestamp,
+		GitSHA:               r.GitSHA,
+		RollbackOf:           r.RollbackOf,
+		SpecHash:             r.SpecHash,
+		PolicyHash:           r.PolicyHash,
+		DisplayName:          r.DisplayName,
+		APIPath:              r.APIPath,
+		ServiceURL:           r.ServiceURL,
+		Products:             r.Products,
+		Protocols:            fromProtocols(r.Protocols),
+		SubscriptionRequired: r.SubscriptionRequired,
+		Metadata:             fromMetadata(r.Metadata),
+		SpecFormat:           r.SpecFormat,
+		Spec:                 r.Spec,
+		PolicyFormat:         r.PolicyFormat,
+		Policy:               r.Policy,
+	}
+}
+
+// DeploymentHistory contains the recorded deployments of an api version
+type DeploymentHistory struct {
+	APIID       string             `json:"apiId"`
+	APIVersion  string             `json:"apiVersion"`
+	Deployments []DeploymentRecord `json:"deployments"`
+}
+
+// Table returns the deployments as table rows
+func (h *DeploymentHistory) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(h.Deployments))
+	for _, d := range h.Deployments {
+		rows = append(rows, []string{d.ID, d.Timestamp.Format(time.RFC3339), d.GitSHA, history.ShortHash(d.SpecHash), history.ShortHash(d.PolicyHash), d.RollbackOf})
+	}
+	return []string{"DEPLOYMENT", "TIMESTAMP", "GIT SHA", "SPEC", "POLICY", "ROLLBACK OF"}, rows
+}
+
+// ErrDeploymentNotFound is returned if a deployment doesn't exist in the history
+var ErrDeploymentNotFound = history.ErrNotFound
+
+// HistoryOptions defines the storage account container storing the deployment history.
+// the deployments of a service are stored below a folder named like the service
+type HistoryOptions struct {
+	StorageAccount string
+	ResourceGroup  string
+	// Container is the blob container, DefaultHistoryContainer if empty
+	Container string
+	// Endpoint of the blob service, derived from the storage account and the environment if empty
+	Endpoint string
+}
+
+// RecordDeployment saves the deployment of the definition in the history. the definition must have
+// been deployed with CreateOrUpdateVersionedAPI, which loads the spec and policy
+func (c *Client) RecordDeployment(ctx context.Context, o HistoryOptions, d *Definition) (*DeploymentRecord, error) {
+	a := d.definition()
+	a.SetDefaults()
+	return c.record(ctx, o, a, "")
+}
+
+// History returns the recorded deployments of the api version, oldest first
+func (c *Client) History(ctx context.Context, o HistoryOptions, apiID string, version string) (*DeploymentHistory, error) {
+	s, err := c.historyStore(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.List(ctx, apiID, version)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	h := &DeploymentHistory{APIID: apiID, APIVersion: version, Deployments: make([]DeploymentRecord, 0, len(records))}
+	for i := range records {
+		h.Deployments = append(h.Deployments, *deploymentRecord(&records[i]))
+	}
+	return h, nil
+}
+
+// Rollback re-applies the spec, policy, service url and products of a recorded deployment of the
+// api version. the rollback is transactional and recorded as a new deployment
+func (c *Client) Rollback(ctx context.Context, o HistoryOptions, apiID string, version string, deploymentID string) (*DeploymentResult, error) {
+	s, err := c.historyStore(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.Get(ctx, apiID, version, deploymentID)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	d := r.Definition()
+	d.Transactional = true
+	res, err := c.deploy(ctx, d)
+	if err != nil {
+		return res, err
+	}
+	res.RollbackOf = r.ID
+
+	rec, err := c.record(ctx, o, d, r.ID)
+	if err != nil {
+		return res, fmt.Errorf("rolled back to deployment %s but unable to record it: %w", r.ID, err)
+	}
+	res.DeploymentID = rec.ID
+	return res, nil
+}
+
+func (c *Client) record(ctx context.Context, o HistoryOptions, d *apidefinition.Definition, rollbackOf string) (*DeploymentRecord, error) {
+	s, err := c.historyStore(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	// the record contains the deployed content, a rollback imports it instead of the current content of a link
+	inline := *d
+	if err := inline.DownloadLinks(ctx); err != nil {
+		return nil, wrapError(err)
+	}
+	r := history.NewRecord(&inline, time.Now())
+	r.RollbackOf = rollbackOf
+	if err := s.Save(ctx, r); err != nil {
+		return nil, wrapError(err)
+	}
+	return deploymentRecord(r), nil
+}
+
+// historyStore retrieves the storage account key and returns the blob store of the history
+func (c *Client) historyStore(ctx context.Context, o HistoryOptions) (history.Store, error) {
+	if o.StorageAccount == "" || o.ResourceGroup == "" {
+		return nil, errors.New("storage account and resource group of the deployment history are required")
+	}
+	if o.Endpoint == "" && c.env.StorageEndpointSuffix == "" {
+		return nil, fmt.Errorf("the environment %s has no storage endpoint suffix, the history endpoint is required", c.env.Name)
+	}
+	container := o.Container
+	if container == "" {
+		container = DefaultHistoryContainer
+	}
+
+	sa := disasterrecovery.StorageAccount{
+		Authorizer:    c.apim.Authorizer,
+		BaseURI:       c.apim.BaseURI,
+		ResourceGroup: o.ResourceGroup,
+		AccountName:   o.StorageAccount,
+		Retry:         c.apim.Retry,
+	}
+	if err := sa.InitializeClient(ctx, c.apim.Subscription); err != nil {
+		if ctx.Err() != nil {
+			return nil, &InterruptedError{Step: "get storage account key", Err: ctx.Err()}
+		}
+		return nil, wrapError(err)
+	}
+	return &history.BlobStore{
+		Account:     o.StorageAccount,
+		Key:         sa.Key,
+		Environment: c.env,
+		Endpoint:    o.Endpoint,
+		Container:   container,
+		Prefix:      c.apim.ServiceName,
+		Retry:       c.apim.Retry,
+	}, nil
+}
@@ -0,0 +1,237 @@
+package azapim
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
+	"github.com/foryouandyourcustomers/azapim/internal/preflight"
+	"github.com/foryouandyourcustomers/azapim/internal/specdiff"
+)
+
+// DeploymentResult contains the resources created or updated for a versioned api
+type DeploymentResult struct {
+	VersionSetID    string   `json:"versionSetId"`
+	APIID           string   `json:"apiId"`
+	APIName         string   `json:"apiName"`
+	APIVersion      string   `json:"apiVersion"`
+	APIRevision     string   `json:"apiRevision"`
+	APIPath         string   `json:"apiPath"`
+	ServiceURL      string   `json:"serviceUrl"`
+	GatewayURL      string   `json:"gatewayUrl,omitempty"`
+	PolicyID        string   `json:"policyId"`
+	Products        []string `json:"products"`
+	Tags            []string `json:"tags,omitempty"`
+	DurationSeconds float64  `json:"durationSeconds"`
+	// Warnings are risks of the deployed configuration, e.g. unencrypted protocols on a public gateway
+	Warnings []string `json:"warnings,omitempty"`
+	// RolledBack and RollbackFailures contain the reverted changes of a failed transactional deployment
+	RolledBack       []string `json:"rolledBack,omitempty"`
+	RollbackFailures []string `json:"rollbackFailures,omitempty"`
+	// DeploymentID is the id of the deployment in the history, RollbackOf the id of the re-applied deployment
+	DeploymentID string `json:"deploymentId,omitempty"`
+	RollbackOf   string `json:"rollbackOf,omitempty"`
+	// Changes are the differences to the spec of the deployed version, compared with FailOnBreaking
+	Changes []Change `json:"changes,omitempty"`
+}
+
+func deploymentResult(r *apimclient.DeploymentResult) *DeploymentResult {
+	if r == nil {
+		return nil
+	}
+	return &DeploymentResult{
+		VersionSetID:     r.VersionSetID,
+		APIID:            r.APIID,
+		APIName:          r.APIName,
+		APIVersion:       r.APIVersion,
+		APIRevision:      r.APIRevision,
+		APIPath:          r.APIPath,
+		ServiceURL:       r.ServiceURL,
+		GatewayURL:       r.GatewayURL,
+		PolicyID:         r.PolicyID,
+		Products:         r.Products,
+		Tags:             r.Tags,
+		DurationSeconds:  r.DurationSeconds,
+		Warnings:         r.Warnings,
+		RolledBack:       r.RolledBack,
+		RollbackFailures: r.RollbackFailures,
+		DeploymentID:     r.DeploymentID,
+		RollbackOf:       r.RollbackOf,
+		Changes:          changes(r.Changes),
+	}
+}
+
+// Change is a single difference between the deployed and the new spec of an api
+type Change struct {
+	Breaking  bool   `json:"breaking"`
+	Operation string `json:"operation,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Message   string `json:"message"`
+}
+
+func changes(c []specdiff.Change) []Change {
+	if c == nil {
+		return nil
+	}
+	r := make([]Change, 0, len(c))
+	for _, v := range c {
+		r = append(r, Change(v))
+	}
+	return r
+}
+
+// DisasterRecoveryResult contains the parameters of a backup or restore
+type DisasterRecoveryResult struct {
+	Operation       string  `json:"operation"`
+	ServiceName     string  `json:"serviceName"`
+	BackupName      string  `json:"backupName"`
+	StorageAccount  string  `json:"storageAccount"`
+	Container       string  `json:"container"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// DeprecationResult contains the changes to a deprecated api version
+type DeprecationResult struct {
+	APIID               string   `json:"apiId"`
+	APIName             string   `json:"apiName"`
+	APIVersion          string   `json:"apiVersion"`
+	DisplayName         string   `json:"displayName"`
+	Deprecated          string   `json:"deprecated"`
+	Sunset              string   `json:"sunset"`
+	PolicyID            string   `json:"policyId"`
+	RemovedFromProducts []string `json:"removedFromProducts"`
+	DurationSeconds     float64  `json:"durationSeconds"`
+}
+
+// Table returns the deprecation as table row
+func (r *DeprecationResult) Table() ([]string, [][]string) {
+	return []string{"API", "VERSION", "DEPRECATED", "SUNSET", "REMOVED FROM PRODUCTS"},
+		[][]string{{r.APIName, r.APIVersion, r.Deprecated, r.Sunset, strings.Join(r.RemovedFromProducts, ",")}}
+}
+
+// VersionSuggestion is the next version of an api derived from the changes of its spec to the latest version
+type VersionSuggestion struct {
+	APIID          string `json:"apiId"`
+	LatestVersion  string `json:"latestVersion,omitempty"`
+	LatestRevision string `json:"latestRevision,omitempty"`
+	NextVersion    string `json:"nextVersion"`
+	// NextRevision is set if the spec is compatible with the latest version and can be deployed as its new revision
+	NextRevision string   `json:"nextRevision,omitempty"`
+	Reason       string   `json:"reason"`
+	Changes      []Change `json:"changes"`
+}
+
+// Table returns the suggestion as table row
+func (v *VersionSuggestion) Table() ([]string, [][]string) {
+	return []string{"API", "LATEST VERSION", "NEXT VERSION", "NEXT REVISION", "REASON"},
+		[][]string{{v.APIID, v.LatestVersion, v.NextVersion, v.NextRevision, v.Reason}}
+}
+
+func versionSuggestion(v *apimclient.VersionSuggestion) *VersionSuggestion {
+	if v == nil {
+		return nil
+	}
+	return &VersionSuggestion{
+		APIID:          v.APIID,
+		LatestVersion:  v.LatestVersion,
+		LatestRevision: v.LatestRevision,
+		NextVersion:    v.NextVersion,
+		NextRevision:   v.NextRevision,
+		Reason:         v.Reason,
+		Changes:        changes(v.Changes),
+	}
+}
+
+// PreflightReport contains the authenticated principal, the state of the service and all permission checks
+type PreflightReport struct {
+	Principal      Principal     `json:"principal"`
+	PrincipalError string        `json:"principalError,omitempty"`
+	Service        ServiceStatus `json:"service"`
+	Checks         []CheckResult `json:"checks"`
+}
+
+// Principal is the identity the client is authenticated as
+type Principal struct {
+	ObjectID      string `json:"objectId,omitempty"`
+	TenantID      string `json:"tenantId,omitempty"`
+	ApplicationID string `json:"applicationId,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Type          string `json:"type,omitempty"`
+}
+
+// ServiceStatus is the state of the api management service
+type ServiceStatus struct {
+	ID                string `json:"id"`
+	ProvisioningState string `json:"provisioningState,omitempty"`
+	Ready             bool   `json:"ready"`
+	Error             string `json:"error,omitempty"`
+}
+
+// CheckResult is the result of a single permission check
+type CheckResult struct {
+	Commands []string `json:"commands"`
+	Action   string   `json:"action"`
+	Scope    string   `json:"scope"`
+	Allowed  bool     `json:"allowed"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// OK returns true if the service is ready and all permissions are granted
+func (r *PreflightReport) OK() bool {
+	return r.Service.Ready && len(r.Gaps()) == 0
+}
+
+// Gaps returns all failed permission checks
+func (r *PreflightReport) Gaps() []CheckResult {
+	gaps := []CheckResult{}
+	for _, c := range r.Checks {
+		if !c.Allowed {
+			gaps = append(gaps, c)
+		}
+	}
+	return gaps
+}
+
+// Table returns the report as table rows for the principal, the service and every permission check
+func (r *PreflightReport) Table() ([]string, [][]string) {
+	rows := [][]string{}
+	if r.PrincipalError != "" {
+		rows = append(rows, []string{"principal", "", "error: " + r.PrincipalError})
+	} else {
+		rows = append(rows, []string{"principal", fmt.Sprintf("%s (%s)", r.Principal.Name, r.Principal.Type), r.Principal.ObjectID})
+	}
+
+	service := r.Service.ProvisioningState
+	if r.Service.Error != "" {
+		service = "error: " + r.Service.Error
+	}
+	rows = append(rows, []string{"service", r.Service.ID, service})
+
+	for _, c := range r.Checks {
+		result := "granted"
+		switch {
+		case c.Error != "":
+			result = "error: " + c.Error
+		case !c.Allowed:
+			result = "missing"
+		}
+		rows = append(rows, []string{"permission", fmt.Sprintf("%s on %s", c.Action, c.Scope), result})
+	}
+	return []string{"CHECK", "SUBJECT", "RESULT"}, rows
+}
+
+func preflightReport(r *preflight.Report) *PreflightReport {
+	if r == nil {
+		return nil
+	}
+	report := &PreflightReport{
+		Principal:      Principal(r.Principal),
+		PrincipalError: r.PrincipalError,
+		Service:        ServiceStatus(r.Service),
+		Checks:         make([]CheckResult, 0, len(r.Checks)),
+	}
+	for _, c := range r.Checks {
+		report.Checks = append(report.Checks, CheckResult(c))
+	}
+	return report
+}
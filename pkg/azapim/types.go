@@ -0,0 +1,401 @@
+package azapim
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/apimanagement/mgmt/2021-08-01/apimanagement"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/foryouandyourcustomers/azapim/internal/apidefinition"
+	"github.com/foryouandyourcustomers/azapim/internal/apimclient"
+	"github.com/foryouandyourcustomers/azapim/internal/authentication"
+	"github.com/foryouandyourcustomers/azapim/internal/discovery"
+	"github.com/foryouandyourcustomers/azapim/internal/retry"
+)
+
+// Definition contains all values required to register or update a versioned api
+type Definition struct {
+	// OpenAPISpecPath is the file or http(s) url of the spec, OpenAPISpec the inline spec used if the path is empty
+	OpenAPISpecPath string
+	OpenAPISpec     string
+	// OpenAPIFormat is the content format of the spec, e.g. openapi+json. it's set when the spec is loaded
+	OpenAPIFormat string
+
+	// XMLPolicyPath is the file or http(s) url of the policy, XMLPolicy the inline policy used if the path is empty
+	XMLPolicyPath string
+	XMLPolicy     string
+	// XMLPolicyFormat is the content format of the policy, e.g. rawxml. it's set when the policy is loaded
+	XMLPolicyFormat string
+
+	APIID          string
+	APIDisplayName string
+	APIVersion     string
+	APIPath        string
+	// APIRevision is the deployed revision of the api version, 1 if empty
+	APIRevision   string
+	APIServiceURL string
+	APIProducts   []string
+
+	// APIProtocols expose the api, https or wss depending on the api type if empty
+	APIProtocols []Protocol
+	// SubscriptionRequired requires a subscription key for calls of the api, true if nil
+	SubscriptionRequired *bool
+	// Metadata are the optional properties of the api
+	Metadata Metadata
+
+	// Transactional restores the prior state of the version set, api, policy and product
+	// assignments if a step of the deployment fails
+	Transactional bool
+	// FailOnBreaking compares the spec with the deployed version and refuses breaking changes
+	FailOnBreaking bool
+
+	// Fetch defines how specs and policies at http(s) urls are imported, FetchLink if empty
+	Fetch FetchMode
+	// FetchFallback imports the link if the download fails in FetchLocal mode
+	FetchFallback bool
+	// Downloader downloads specs and policies in FetchLocal mode, without headers if nil
+	Downloader *Downloader
+
+	// Transform modifies the loaded openapi spec before the import
+	Transform Transform
+}
+
+// Validate checks that the api type supports the protocols and the scheme of the service url and
+// that the revision is a positive number
+func (d *Definition) Validate() error {
+	a := d.definition()
+	if err := a.ValidateProtocols(); err != nil {
+		return wrapError(err)
+	}
+	return wrapError(a.ValidateRevision())
+}
+
+// definition returns the definition of the deployment
+func (d *Definition) definition() *apidefinition.Definition {
+	return &apidefinition.Definition{
+		OpenAPISpecPath:      d.OpenAPISpecPath,
+		OpenAPISpec:          d.OpenAPISpec,
+		OpenAPIFormat:        apimanagement.ContentFormat(d.OpenAPIFormat),
+		XMLPolicyPath:        d.XMLPolicyPath,
+		XMLPolicy:            d.XMLPolicy,
+		XMLPolicyFormat:      apimanagement.PolicyContentFormat(d.XMLPolicyFormat),
+		APIID:                d.APIID,
+		APIDisplayName:       d.APIDisplayName,
+		APIVersion:           d.APIVersion,
+		APIPath:              d.APIPath,
+		APIRevision:          d.APIRevision,
+		APIServiceURL:        d.APIServiceURL,
+		APIProducts:          append([]string(nil), d.APIProducts...),
+		APIProtocols:         protocols(d.APIProtocols),
+		SubscriptionRequired: d.SubscriptionRequired,
+		Metadata:             d.Metadata.metadata(),
+		Transactional:        d.Transactional,
+		FailOnBreaking:       d.FailOnBreaking,
+		Fetch:                apidefinition.FetchMode(d.Fetch),
+		FetchFallback:        d.FetchFallback,
+		Downloader:           (*apidefinition.Downloader)(d.Downloader),
+		Transform:            apidefinition.Transform(d.Transform),
+	}
+}
+
+// update sets the loaded spec and policy and the defaults of the deployed definition
+func (d *Definition) update(a *apidefinition.Definition) {
+	d.OpenAPISpec = a.OpenAPISpec
+	d.OpenAPIFormat = string(a.OpenAPIFormat)
+	d.XMLPolicy = a.XMLPolicy
+	d.XMLPolicyFormat = string(a.XMLPolicyFormat)
+	d.APIRevision = a.APIRevision
+	d.APIProducts = a.APIProducts
+	d.APIProtocols = fromProtocols(a.APIProtocols)
+	d.SubscriptionRequired = a.SubscriptionRequired
+	d.Metadata.APIType = APIType(a.Metadata.APIType)
+}
+
+// Transform modifies the openapi spec of a definition before it is imported
+type Transform struct {
+	// Servers replace the servers of the spec, the first one sets host, base path and schemes of swagger 2.0 specs
+	Servers []string
+	// StripTags removes the operations with one of the tags
+	StripTags []string
+	// StripInternal removes the paths and operations with "x-internal: true"
+	StripInternal bool
+
+	Title        string
+	Description  string
+	ContactName  string
+	ContactEmail string
+	ContactURL   string
+
+	// OperationIDSuffix is appended to every operationId which doesn't end with it
+	OperationIDSuffix string
+}
+
+// Metadata are the optional properties of an api, e.g. its description, type, tags, contact and license
+type Metadata struct {
+	Description string `json:"description,omitempty"`
+	// APIType is http if empty. soap apis are imported from a wsdl instead of the openapi spec, graphql apis
+	// from the endpoint or a schema and websocket apis have no spec
+	APIType APIType `json:"apiType,omitempty"`
+	// SubscriptionKeyHeader and SubscriptionKeyQuery replace the default names of the subscription key
+	// header (Ocp-Apim-Subscription-Key) and query parameter (subscription-key)
+	SubscriptionKeyHeader string `json:"subscriptionKeyHeader,omitempty"`
+	SubscriptionKeyQuery  string `json:"subscriptionKeyQuery,omitempty"`
+	// IsCurrent makes the revision the current revision of the api, the service decides if nil
+	IsCurrent *bool `json:"isCurrent,omitempty"`
+	// Tags are assigned to the api, missing tags are created
+	Tags []string `json:"tags,omitempty"`
+	// ContactName, ContactEmail and ContactURL are the contact of the api
+	ContactName  string `json:"contactName,omitempty"`
+	ContactEmail string `json:"contactEmail,omitempty"`
+	ContactURL   string `json:"contactUrl,omitempty"`
+	// LicenseName and LicenseURL are the license of the api
+	LicenseName       string `json:"licenseName,omitempty"`
+	LicenseURL        string `json:"licenseUrl,omitempty"`
+	TermsOfServiceURL string `json:"termsOfServiceUrl,omitempty"`
+}
+
+func (m *Metadata) metadata() apidefinition.Metadata {
+	return apidefinition.Metadata{
+		Description:           m.Description,
+		APIType:               apimanagement.APIType(m.APIType),
+		SubscriptionKeyHeader: m.SubscriptionKeyHeader,
+		SubscriptionKeyQuery:  m.SubscriptionKeyQuery,
+		IsCurrent:             m.IsCurrent,
+		Tags:                  append([]string(nil), m.Tags...),
+		ContactName:           m.ContactName,
+		ContactEmail:          m.ContactEmail,
+		ContactURL:            m.ContactURL,
+		LicenseName:           m.LicenseName,
+		LicenseURL:            m.LicenseURL,
+		TermsOfServiceURL:     m.TermsOfServiceURL,
+	}
+}
+
+func fromMetadata(m *apidefinition.Metadata) *Metadata {
+	if m == nil {
+		return nil
+	}
+	return &Metadata{
+		Description:           m.Description,
+		APIType:               APIType(m.APIType),
+		SubscriptionKeyHeader: m.SubscriptionKeyHeader,
+		SubscriptionKeyQuery:  m.SubscriptionKeyQuery,
+		IsCurrent:             m.IsCurrent,
+		Tags:                  append([]string(nil), m.Tags...),
+		ContactName:           m.ContactName,
+		ContactEmail:          m.ContactEmail,
+		ContactURL:            m.ContactURL,
+		LicenseName:           m.LicenseName,
+		LicenseURL:            m.LicenseURL,
+		TermsOfServiceURL:     m.TermsOfServiceURL,
+	}
+}
+
+// APIType is the type of an api, http, soap, websocket or graphql
+type APIType string
+
+// Supported api types
+const (
+	APITypeHTTP      APIType = "http"
+	APITypeSoap      APIType = "soap"
+	APITypeWebsocket APIType = "websocket"
+	APITypeGraphQL   APIType = "graphql"
+)
+
+// APITypes are the supported api types
+var APITypes = []APIType{APITypeHTTP, APITypeSoap, APITypeWebsocket, APITypeGraphQL}
+
+// ParseAPIType returns the api type, http if empty
+func ParseAPIType(s string) (APIType, error) {
+	t, err := apidefinition.ParseAPIType(s)
+	return APIType(t), err
+}
+
+// Protocol is a protocol the api is exposed with
+type Protocol string
+
+// Supported protocols, http and https for http, soap and graphql apis, ws and wss for websocket apis
+const (
+	ProtocolHTTPS Protocol = "https"
+	ProtocolHTTP  Protocol = "http"
+	ProtocolWSS   Protocol = "wss"
+	ProtocolWS    Protocol = "ws"
+)
+
+// ParseProtocols returns the comma separated protocols, nil if empty to expose the api with https or wss
+func ParseProtocols(s string) ([]Protocol, error) {
+	p, err := apidefinition.ParseProtocols(s)
+	return fromProtocols(p), err
+}
+
+func protocols(p []Protocol) []apimanagement.Protocol {
+	if p == nil {
+		return nil
+	}
+	r := make([]apimanagement.Protocol, 0, len(p))
+	for _, v := range p {
+		r = append(r, apimanagement.Protocol(v))
+	}
+	return r
+}
+
+func fromProtocols(p []apimanagement.Protocol) []Protocol {
+	if p == nil {
+		return nil
+	}
+	r := make([]Protocol, 0, len(p))
+	for _, v := range p {
+		r = append(r, Protocol(v))
+	}
+	return r
+}
+
+// FetchMode defines how specs and policies at http(s) urls are imported
+type FetchMode string
+
+// Supported fetch modes
+const (
+	// FetchLink lets the api management service download the spec or policy
+	FetchLink FetchMode = "link"
+	// FetchLocal downloads the spec or policy, validates it and uploads it inline
+	FetchLocal FetchMode = "local"
+)
+
+// FetchModes contains all supported fetch modes
+var FetchModes = []FetchMode{FetchLink, FetchLocal}
+
+// ParseFetchMode returns the fetch mode with the given name, link if empty
+func ParseFetchMode(s string) (FetchMode, error) {
+	m, err := apidefinition.ParseFetchMode(s)
+	return FetchMode(m), err
+}
+
+// Downloader downloads specs and policies with FetchLocal
+type Downloader struct {
+	// Client is used for the requests, a client with a timeout of one minute if nil
+	Client *http.Client
+	// Header is added to every request
+	Header http.Header
+	// BearerToken is sent in the authorization header if set
+	BearerToken string
+}
+
+// NewDownloader returns a downloader sending the headers ("Name: value") and the bearer token, trusting
+// the certificates of the pem encoded ca file in addition to the system pool
+func NewDownloader(headers []string, bearerToken string, caFile string) (*Downloader, error) {
+	d, err := apidefinition.NewDownloader(headers, bearerToken, caFile)
+	return (*Downloader)(d), err
+}
+
+// DisasterRecovery contains the storage account and the name of a backup
+type DisasterRecovery struct {
+	// BackupName is the name of the backup, Backup sets a name based on the service name and the current time if empty
+	BackupName           string
+	StorageAccount       string
+	StorageResourceGroup string
+	// Container is the blob container of the backups
+	Container string
+}
+
+// Deprecation marks a deployed version of a versioned api as deprecated
+type Deprecation struct {
+	APIID      string
+	APIVersion string
+	// Sunset is the date the version will be removed
+	Sunset time.Time
+	// Since is the date the version is deprecated, the current date if not set
+	Since time.Time
+	// RemoveFromProducts are the products the version is removed from, so new consumers can't subscribe to it
+	RemoveFromProducts []string
+}
+
+// DateFormat is the format of the deprecation and sunset dates
+const DateFormat = apimclient.DateFormat
+
+// AuthMode defines how the client authenticates against azure
+type AuthMode string
+
+// Supported authentication modes
+const (
+	// AuthModeAzureCLI uses the login of the az cli
+	AuthModeAzureCLI AuthMode = "azcli"
+	// AuthModeServicePrincipalSecret uses a service principal with a client secret
+	AuthModeServicePrincipalSecret AuthMode = "sp-secret"
+	// AuthModeServicePrincipalCertificate uses a service principal with a client certificate
+	AuthModeServicePrincipalCertificate AuthMode = "sp-certificate"
+	// AuthModeManagedIdentity uses the system or user assigned managed identity
+	AuthModeManagedIdentity AuthMode = "msi"
+	// AuthModeFederatedTokenFile uses a service principal with a federated token read from a file
+	AuthModeFederatedTokenFile AuthMode = "federated-token-file"
+)
+
+// AuthSettings contains the authentication mode and the credentials required by the mode.
+// tokens are requested for the environment of the client if the environment isn't set
+type AuthSettings struct {
+	Mode                AuthMode
+	Environment         azure.Environment
+	TenantID            string
+	ClientID            string
+	ClientSecret        string
+	CertificatePath     string
+	CertificatePassword string
+	FederatedTokenFile  string
+}
+
+func (s *AuthSettings) settings() authentication.Settings {
+	return authentication.Settings{
+		Mode:                authentication.Mode(s.Mode),
+		Environment:         s.Environment,
+		TenantID:            s.TenantID,
+		ClientID:            s.ClientID,
+		ClientSecret:        s.ClientSecret,
+		CertificatePath:     s.CertificatePath,
+		CertificatePassword: s.CertificatePassword,
+		FederatedTokenFile:  s.FederatedTokenFile,
+	}
+}
+
+// RetryPolicy defines how often and how long requests failing with transient errors are retried
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt, 0 disables retries
+	MaxRetries int
+	// Timeout limits the total time spent on retries of a single request, 0 means no limit
+	Timeout time.Duration
+	// BaseDelay is the delay before the first retry, it doubles with every retry
+	BaseDelay time.Duration
+	// MaxDelay limits the delay between two retries
+	MaxDelay time.Duration
+	// OnRetry is called before every retry
+	OnRetry func(operation string, attempt int, delay time.Duration, err error)
+}
+
+// DefaultRetryPolicy returns the retry policy used if none is set
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy(retry.DefaultPolicy())
+}
+
+// ConflictStrategy defines how updates of resources changed concurrently by someone else are handled
+type ConflictStrategy string
+
+// Supported conflict strategies
+const (
+	// ConflictFail fails the update if the resource was changed since its etag was read
+	ConflictFail ConflictStrategy = "fail"
+	// ConflictRetry reads the resource again, revalidates it and repeats the update
+	ConflictRetry ConflictStrategy = "retry"
+	// ConflictForce updates the resource unconditionally
+	ConflictForce ConflictStrategy = "force"
+)
+
+// ServiceID identifies an api management service by subscription, resource group and name
+type ServiceID struct {
+	Subscription  string `json:"subscription"`
+	ResourceGroup string `json:"resourceGroup"`
+	Name          string `json:"name"`
+}
+
+// String returns the resource id of the service
+func (id ServiceID) String() string {
+	return discovery.ServiceID(id).String()
+}